@@ -0,0 +1,292 @@
+package azfiletest
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-storage-file-go/azfile"
+)
+
+// The codes of the service's authorization errors that azfile has no constants for.
+const (
+	serviceCodeNoAuthenticationInformation       azfile.ServiceCodeType = "NoAuthenticationInformation"
+	serviceCodeAuthorizationPermissionMismatch   azfile.ServiceCodeType = "AuthorizationPermissionMismatch"
+	serviceCodeAuthorizationProtocolMismatch     azfile.ServiceCodeType = "AuthorizationProtocolMismatch"
+	serviceCodeAuthorizationSourceIPMismatch     azfile.ServiceCodeType = "AuthorizationSourceIPMismatch"
+	serviceCodeAuthorizationServiceMismatch      azfile.ServiceCodeType = "AuthorizationServiceMismatch"
+	serviceCodeAuthorizationResourceTypeMismatch azfile.ServiceCodeType = "AuthorizationResourceTypeMismatch"
+	serviceCodeCannotVerifyCopySource            azfile.ServiceCodeType = "CannotVerifyCopySource"
+)
+
+// authorize checks that a request is signed with the account's key or carries a valid SAS that grants it. s.mutex
+// must be held.
+func (s *Server) authorize(r *request) *serviceError {
+	if authorization := r.Header.Get("Authorization"); authorization != "" {
+		return s.authorizeSharedKey(r, authorization)
+	}
+	if r.query.Get("sig") != "" {
+		return s.authorizeSAS(r)
+	}
+	return newError(http.StatusUnauthorized, serviceCodeNoAuthenticationInformation,
+		"Server failed to authenticate the request. Please refer to the information in the www-authenticate header.")
+}
+
+// authenticationFailed returns the error of a request whose signature isn't valid.
+func authenticationFailed(detail string) *serviceError {
+	return newError(http.StatusForbidden, azfile.ServiceCodeAuthenticationFailed,
+		"Server failed to authenticate the request. Make sure the value of Authorization header is formed correctly including the signature.\n%s", detail)
+}
+
+// authorizeSharedKey checks a request's SharedKey signature.
+// See https://docs.microsoft.com/en-us/rest/api/storageservices/authorize-with-shared-key.
+func (s *Server) authorizeSharedKey(r *request, authorization string) *serviceError {
+	credentials := strings.TrimPrefix(authorization, "SharedKey ")
+	i := strings.LastIndex(credentials, ":")
+	if credentials == authorization || i < 0 {
+		return newError(http.StatusBadRequest, azfile.ServiceCodeInvalidAuthenticationInfo, "The Authorization header %q isn't of the form \"SharedKey account:signature\".", authorization)
+	}
+	if account := credentials[:i]; account != s.accountName {
+		return authenticationFailed("The account " + account + " in the Authorization header isn't the request's account.")
+	}
+	if r.Header.Get("x-ms-date") == "" && r.Header.Get("Date") == "" {
+		return authenticationFailed("Request date header not specified.")
+	}
+	stringToSign := s.sharedKeyStringToSign(r)
+	if !s.validSignature(credentials[i+1:], stringToSign) {
+		return authenticationFailed("The MAC signature found in the HTTP request '" + credentials[i+1:] +
+			"' is not the same as any computed signature. Server used following string to sign: '" + stringToSign + "'.")
+	}
+	return nil
+}
+
+// validSignature returns whether signature, base64-encoded, is the HMAC-SHA256 of stringToSign with the account's key.
+func (s *Server) validSignature(signature string, stringToSign string) bool {
+	got, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	want, _ := base64.StdEncoding.DecodeString(s.credential.ComputeHMACSHA256(stringToSign))
+	return hmac.Equal(got, want)
+}
+
+// sharedKeyStringToSign builds the string that a request's SharedKey signature signs.
+func (s *Server) sharedKeyStringToSign(r *request) string {
+	h := r.Header
+	contentLength := h.Get("Content-Length")
+	if contentLength == "0" {
+		contentLength = ""
+	}
+	date := h.Get("Date")
+	if h.Get("x-ms-date") != "" {
+		date = ""
+	}
+	return strings.Join([]string{
+		r.Method,
+		h.Get("Content-Encoding"),
+		h.Get("Content-Language"),
+		contentLength,
+		h.Get("Content-MD5"),
+		h.Get("Content-Type"),
+		date,
+		h.Get("If-Modified-Since"),
+		h.Get("If-Match"),
+		h.Get("If-None-Match"),
+		h.Get("If-Unmodified-Since"),
+		h.Get("Range"),
+		canonicalizedHeaders(h),
+		s.canonicalizedResource(r.URL),
+	}, "\n")
+}
+
+// canonicalizedHeaders returns a request's x-ms-* headers as SharedKey signatures sign them.
+func canonicalizedHeaders(h http.Header) string {
+	headers := map[string]string{}
+	for k, v := range h {
+		if name := strings.ToLower(strings.TrimSpace(k)); strings.HasPrefix(name, "x-ms-") {
+			headers[name] = strings.Join(v, ",")
+		}
+	}
+	b := &bytes.Buffer{}
+	for i, name := range sortedKeys(headers) {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		b.WriteString(name + ":" + headers[name])
+	}
+	return b.String()
+}
+
+// canonicalizedResource returns a request's URL as SharedKey signatures sign it.
+func (s *Server) canonicalizedResource(u *url.URL) string {
+	b := bytes.NewBufferString("/" + s.accountName)
+	if p := u.EscapedPath(); p != "" {
+		b.WriteString(p)
+	} else {
+		b.WriteByte('/')
+	}
+	params := map[string][]string{}
+	for k, v := range u.Query() {
+		params[strings.ToLower(k)] = append(params[strings.ToLower(k)], v...)
+	}
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		values := params[name]
+		sort.Strings(values)
+		b.WriteString("\n" + name + ":" + strings.Join(values, ","))
+	}
+	return b.String()
+}
+
+// authorizeSAS checks that a request's account or service SAS is valid and grants the request.
+// See https://docs.microsoft.com/en-us/rest/api/storageservices/create-account-sas and
+// https://docs.microsoft.com/en-us/rest/api/storageservices/create-service-sas.
+func (s *Server) authorizeSAS(r *request) *serviceError {
+	q := r.query
+	permissions, start, expiry := q.Get("sp"), q.Get("st"), q.Get("se")
+	resourceType, required := sasRequirement(r)
+
+	var stringToSign string
+	isAccountSAS := q.Get("ss") != "" || q.Get("srt") != ""
+	if isAccountSAS {
+		stringToSign = strings.Join([]string{s.accountName, permissions, q.Get("ss"), q.Get("srt"), start, expiry,
+			q.Get("sip"), q.Get("spr"), q.Get("sv"), ""}, "\n")
+	} else {
+		// The signed resource is the request's, so a SAS of another share or file doesn't match.
+		canonicalName := "/file/" + s.accountName + "/" + r.shareName
+		switch q.Get("sr") {
+		case "s":
+		case "f":
+			if r.restype != "" {
+				return newError(http.StatusForbidden, serviceCodeAuthorizationResourceTypeMismatch, "This request is not authorized to perform this operation using this resource type.")
+			}
+			canonicalName += "/" + r.path
+		default:
+			return authenticationFailed("Signed resource " + q.Get("sr") + " isn't s or f.")
+		}
+		stringToSign = strings.Join([]string{permissions, start, expiry, canonicalName, q.Get("si"), q.Get("sip"),
+			q.Get("spr"), q.Get("sv"), q.Get("rscc"), q.Get("rscd"), q.Get("rsce"), q.Get("rscl"), q.Get("rsct")}, "\n")
+	}
+	if !s.validSignature(q.Get("sig"), stringToSign) {
+		return authenticationFailed("Signature did not match. String to sign used was " + stringToSign)
+	}
+
+	// A service SAS may get its permissions and times from a stored access policy of the share instead.
+	if identifier := q.Get("si"); identifier != "" && !isAccountSAS {
+		policy, ok := s.accessPolicy(r.shareName, identifier)
+		if !ok {
+			return authenticationFailed("Signed identifier " + identifier + " doesn't match any stored access policy.")
+		}
+		for _, field := range []struct {
+			value  *string
+			stored string
+		}{{&permissions, policy.permission}, {&start, policy.start}, {&expiry, policy.expiry}} {
+			if *field.value != "" && field.stored != "" {
+				return authenticationFailed("A field is specified both in the SAS and in the stored access policy " + identifier + ".")
+			}
+			if *field.value == "" {
+				*field.value = field.stored
+			}
+		}
+	}
+
+	now := time.Now()
+	if start != "" {
+		if t, ok := parseSASTime(start); !ok || now.Before(t) {
+			return authenticationFailed("Signed not yet valid or invalid start " + start + ".")
+		}
+	}
+	if t, ok := parseSASTime(expiry); !ok || now.After(t) {
+		return authenticationFailed("Signed expired or invalid expiry " + expiry + ".")
+	}
+	if q.Get("spr") == "https" && !r.secure {
+		return newError(http.StatusForbidden, serviceCodeAuthorizationProtocolMismatch, "This request is not authorized to perform this operation using this protocol.")
+	}
+	if sip := q.Get("sip"); sip != "" && r.remoteIP != nil && !ipInRange(r.remoteIP, sip) {
+		return newError(http.StatusForbidden, serviceCodeAuthorizationSourceIPMismatch, "This request is not authorized to perform this operation using this source IP %s.", r.remoteIP)
+	}
+	if isAccountSAS {
+		if !strings.Contains(q.Get("ss"), "f") {
+			return newError(http.StatusForbidden, serviceCodeAuthorizationServiceMismatch, "This request is not authorized to perform this operation using this service.")
+		}
+		if !strings.Contains(q.Get("srt"), resourceType) {
+			return newError(http.StatusForbidden, serviceCodeAuthorizationResourceTypeMismatch, "This request is not authorized to perform this operation using this resource type.")
+		}
+	} else if resourceType == "s" {
+		return newError(http.StatusForbidden, serviceCodeAuthorizationResourceTypeMismatch, "This request is not authorized to perform this operation using this resource type.")
+	}
+	if !strings.ContainsAny(permissions, required) {
+		return newError(http.StatusForbidden, serviceCodeAuthorizationPermissionMismatch, "This request is not authorized to perform this operation using this permission.")
+	}
+	return nil
+}
+
+// sasRequirement returns the resource type that an account SAS must grant a request, "s" (service), "c" (share) or
+// "o" (directory or file), and the permissions any one of which a SAS must grant it.
+func sasRequirement(r *request) (resourceType string, permissions string) {
+	resourceType = "o"
+	if r.shareName == "" {
+		resourceType = "s"
+	} else if r.restype == "share" || r.restype == "directory" && r.comp == "list" {
+		resourceType = "c"
+	}
+
+	switch {
+	case r.comp == "list" || r.comp == "listhandles":
+		return resourceType, "l"
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		return resourceType, "r"
+	case r.Method == http.MethodDelete:
+		return resourceType, "d"
+	case r.Method == http.MethodPut && r.comp == "":
+		return resourceType, "cw" // Creating a share, directory or file
+	}
+	return resourceType, "w"
+}
+
+// parseSASTime parses a SAS's start or expiry time.
+func parseSASTime(value string) (time.Time, bool) {
+	for _, format := range azfile.SASTimeFormats {
+		if t, err := time.Parse(format, value); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// ipInRange returns whether ip is in a SAS's IP range, an IP address or two separated by a hyphen.
+func ipInRange(ip net.IP, ipRange string) bool {
+	parts := strings.SplitN(ipRange, "-", 2)
+	start, end := net.ParseIP(parts[0]), net.ParseIP(parts[len(parts)-1])
+	if start == nil || end == nil {
+		return false
+	}
+	ip16, start16, end16 := ip.To16(), start.To16(), end.To16()
+	return bytes.Compare(ip16, start16) >= 0 && bytes.Compare(ip16, end16) <= 0
+}
+
+// authorizeCopySource checks that the SAS of the source of a copy to r's share grants reading it. A source in the
+// same account may instead be authorized by the copy's own authorization, if sharedKeyAllowed is true, as it is for
+// Copy File; Put Range From URL requires a SAS.
+func (s *Server) authorizeCopySource(source *request, sharedKeyAllowed bool) *serviceError {
+	if source.query.Get("sig") == "" {
+		if sharedKeyAllowed {
+			return nil
+		}
+		return newError(http.StatusForbidden, serviceCodeCannotVerifyCopySource, "Server failed to authenticate the request. The copy source must carry a SAS.")
+	}
+	if err := s.authorizeSAS(source); err != nil {
+		return newError(http.StatusForbidden, serviceCodeCannotVerifyCopySource, "The copy source isn't authorized: %s", err.message)
+	}
+	return nil
+}
@@ -0,0 +1,1042 @@
+package azfiletest
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-storage-file-go/azfile"
+)
+
+const (
+	// maxFileSize is the size of the largest file, 1TiB, and maxRangeSize that of the largest range that a request
+	// may write, or read with its MD5.
+	maxFileSize  = 1 << 40
+	maxRangeSize = 4 * 1024 * 1024
+
+	// pageSize is the size of the pages in which a file's content is held.
+	pageSize = 64 * 1024
+)
+
+// node is a directory or a file of a share.
+type node struct {
+	name         string // As created, with its case; "" for the root directory
+	isDir        bool
+	id, parentID uint64
+	etag         string
+	lastModified time.Time
+	metadata     map[string]string
+
+	// The SMB properties, with the times formatted with azfile.ISO8601.
+	attributes, creationTime, lastWriteTime, changeTime, permissionKey string
+
+	// The file's content, the ranges written to it, and its HTTP headers, keyed by canonical header name.
+	content content
+	written []azfile.Range // Sorted, non-overlapping, inclusive ranges
+	headers map[string]string
+
+	// The properties of the last copy to the file.
+	copyID, copySource, copyProgress, copyCompletionTime string
+}
+
+// copy returns a deep copy of n, for a snapshot.
+func (n *node) copy() *node {
+	c := *n
+	c.metadata = copyMetadata(n.metadata)
+	c.headers = copyMetadata(n.headers)
+	c.content = n.content.copy()
+	c.written = append([]azfile.Range{}, n.written...)
+	return &c
+}
+
+// content is a file's content, held in pages, which are absent where the content is all zeros, so that large sparse
+// files take little memory.
+type content struct {
+	size  int64
+	pages map[int64][]byte // Keyed by page number
+}
+
+func (c content) copy() content {
+	pages := make(map[int64][]byte, len(c.pages))
+	for i, page := range c.pages {
+		pages[i] = append([]byte{}, page...)
+	}
+	return content{size: c.size, pages: pages}
+}
+
+// readAt reads the content at offset into b, which mustn't extend past the content's end.
+func (c *content) readAt(b []byte, offset int64) {
+	for len(b) > 0 {
+		i, pageOffset := offset/pageSize, offset%pageSize
+		n := int64(len(b))
+		if n > pageSize-pageOffset {
+			n = pageSize - pageOffset
+		}
+		if page, ok := c.pages[i]; ok {
+			copy(b[:n], page[pageOffset:])
+		} else {
+			for j := range b[:n] {
+				b[j] = 0
+			}
+		}
+		b, offset = b[n:], offset+n
+	}
+}
+
+// writeAt writes b to the content at offset, or, if b is nil, zeros the n bytes at offset.
+func (c *content) writeAt(b []byte, offset int64, n int64) {
+	if c.pages == nil {
+		c.pages = map[int64][]byte{}
+	}
+	for n > 0 {
+		i, pageOffset := offset/pageSize, offset%pageSize
+		chunk := n
+		if chunk > pageSize-pageOffset {
+			chunk = pageSize - pageOffset
+		}
+		page, ok := c.pages[i]
+		switch {
+		case b == nil && chunk == pageSize:
+			delete(c.pages, i)
+		case b == nil && ok:
+			for j := pageOffset; j < pageOffset+chunk; j++ {
+				page[j] = 0
+			}
+		case b != nil:
+			if !ok {
+				page = make([]byte, pageSize)
+				c.pages[i] = page
+			}
+			copy(page[pageOffset:], b[:chunk])
+			b = b[chunk:]
+		}
+		offset, n = offset+chunk, n-chunk
+	}
+}
+
+// resize changes the content's size, zeroing any content past the new size that comes back if it grows again.
+func (c *content) resize(size int64) {
+	if size < c.size {
+		c.writeAt(nil, size, (size/pageSize+1)*pageSize-size)
+		for i := range c.pages {
+			if i*pageSize >= size {
+				delete(c.pages, i)
+			}
+		}
+	}
+	c.size = size
+}
+
+// markWritten adds (or, if clear is true, removes) the inclusive range [start, end] to a file's written ranges.
+func (n *node) markWritten(start int64, end int64, clear bool) {
+	result := []azfile.Range{}
+	for _, r := range n.written {
+		if r.End < start || r.Start > end {
+			result = append(result, r)
+			continue
+		}
+		if r.Start < start {
+			result = append(result, azfile.Range{Start: r.Start, End: start - 1})
+		}
+		if r.End > end {
+			result = append(result, azfile.Range{Start: end + 1, End: r.End})
+		}
+	}
+	if !clear {
+		result = append(result, azfile.Range{Start: start, End: end})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Start < result[j].Start })
+	merged := []azfile.Range{}
+	for _, r := range result {
+		if len(merged) > 0 && merged[len(merged)-1].End+1 >= r.Start {
+			if r.End > merged[len(merged)-1].End {
+				merged[len(merged)-1].End = r.End
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	n.written = merged
+}
+
+// handle is an open handle of a directory or a file, as an SMB client has it.
+type handle struct {
+	id, sessionID, clientIP string
+	key                     string // The key of the handle's directory or file in its share's nodes
+	fileID, parentID        uint64
+	openTime                time.Time
+}
+
+// OpenHandle opens a handle of the directory or file at filePath, a share-relative path, in the share shareName, as an
+// SMB client at clientIP would, so that the handle is listed by ListHandles and can be closed by ForceCloseHandles.
+// It returns the handle's ID.
+func (s *Server) OpenHandle(shareName string, filePath string, clientIP string) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	sh, ok := s.shares[shareName]
+	if !ok {
+		return "", fmt.Errorf("the share %q doesn't exist", shareName)
+	}
+	key := nodeKey(filePath)
+	n, ok := sh.nodes[key]
+	if !ok {
+		return "", fmt.Errorf("%q doesn't exist in the share %q", filePath, shareName)
+	}
+	id := s.nextID()
+	sh.handles = append(sh.handles, &handle{id: strconv.FormatUint(id, 10), sessionID: strconv.FormatUint(id<<8, 10),
+		clientIP: clientIP, key: key, fileID: n.id, parentID: n.parentID, openTime: time.Now().UTC()})
+	return strconv.FormatUint(id, 10), nil
+}
+
+// nodeKey returns the key of a share-relative path in its share's nodes.
+func nodeKey(filePath string) string {
+	return strings.ToLower(strings.Trim(filePath, "/"))
+}
+
+// parentKey returns the key of the parent directory of the node with the specified key.
+func parentKey(key string) string {
+	if i := strings.LastIndex(key, "/"); i >= 0 {
+		return key[:i]
+	}
+	return ""
+}
+
+// fullPath returns the share-relative path of the node with the specified key, with the case of its directories' and
+// its own names.
+func (sh *share) fullPath(key string) string {
+	if key == "" {
+		return ""
+	}
+	names := []string{}
+	for k := key; k != ""; k = parentKey(k) {
+		names = append([]string{sh.nodes[k].name}, names...)
+	}
+	return strings.Join(names, "/")
+}
+
+// validPath returns the error of a share-relative path with a name that the service doesn't allow.
+func validPath(filePath string) *serviceError {
+	if len(filePath) > 2048 {
+		return newError(http.StatusBadRequest, azfile.ServiceCodeInvalidResourceName, "The specified path is too long.")
+	}
+	for _, name := range strings.Split(filePath, "/") {
+		if name == "" || len(name) > 255 || strings.ContainsAny(name, "\"\\:|<>*?") || strings.TrimRight(name, ". ") == "" {
+			return newError(http.StatusBadRequest, azfile.ServiceCodeInvalidResourceName, "The specifed resource name contains invalid characters.")
+		}
+	}
+	return nil
+}
+
+// newNode creates a directory or a file in the share, replacing any file at its path. s.mutex must be held.
+func (s *Server) newNode(sh *share, filePath string, isDir bool, parentID uint64) *node {
+	now := time.Now().UTC()
+	n := &node{name: path.Base(filePath), isDir: isDir, id: s.nextID(), parentID: parentID, metadata: map[string]string{},
+		headers: map[string]string{}, attributes: "Archive", creationTime: now.Format(azfile.ISO8601)}
+	if filePath == "" {
+		n.name = ""
+	}
+	if isDir {
+		n.attributes = "Directory"
+	}
+	n.lastWriteTime, n.changeTime = n.creationTime, n.creationTime
+	s.touch(n)
+	sh.nodes[nodeKey(filePath)] = n
+	return n
+}
+
+// touch changes a node's ETag and last modified time. s.mutex must be held.
+func (s *Server) touch(n *node) {
+	n.etag, n.lastModified = s.newETag(), time.Now().UTC()
+}
+
+// lookup returns the directory or file at r's path in sh.
+func lookup(sh *share, r *request, isDir bool) (*node, *serviceError) {
+	key := nodeKey(r.path)
+	if n, ok := sh.nodes[key]; ok && n.isDir == isDir {
+		return n, nil
+	}
+	if parent, ok := sh.nodes[parentKey(key)]; !ok || !parent.isDir {
+		return nil, newError(http.StatusNotFound, azfile.ServiceCodeParentNotFound, "The specified parent path does not exist.")
+	}
+	return nil, newError(http.StatusNotFound, azfile.ServiceCodeResourceNotFound, "The specified resource does not exist.")
+}
+
+// parentOf returns the parent directory of a directory or a file to be created at r's path in sh.
+func parentOf(sh *share, r *request) (*node, *serviceError) {
+	if err := validPath(r.path); err != nil {
+		return nil, err
+	}
+	if parent, ok := sh.nodes[parentKey(nodeKey(r.path))]; ok && parent.isDir {
+		return parent, nil
+	}
+	return nil, newError(http.StatusNotFound, azfile.ServiceCodeParentNotFound, "The specified parent path does not exist.")
+}
+
+// writeNodeHeaders writes the ETag, last modified time and SMB properties of a directory or a file.
+func writeNodeHeaders(w http.ResponseWriter, n *node) {
+	h := w.Header()
+	h.Set("ETag", n.etag)
+	h.Set("Last-Modified", n.lastModified.Format(http.TimeFormat))
+	h.Set("x-ms-file-attributes", n.attributes)
+	h.Set("x-ms-file-creation-time", n.creationTime)
+	h.Set("x-ms-file-last-write-time", n.lastWriteTime)
+	h.Set("x-ms-file-change-time", n.changeTime)
+	h.Set("x-ms-file-id", strconv.FormatUint(n.id, 10))
+	h.Set("x-ms-file-parent-id", strconv.FormatUint(n.parentID, 10))
+	h.Set("x-ms-file-permission-key", n.permissionKey)
+	h.Set("x-ms-request-server-encrypted", "true")
+}
+
+// writeNodeProperties writes all of the properties of a directory or a file, as Get Properties returns them.
+func writeNodeProperties(w http.ResponseWriter, n *node) {
+	writeNodeHeaders(w, n)
+	h := w.Header()
+	h.Del("x-ms-request-server-encrypted")
+	h.Set("x-ms-server-encrypted", "true")
+	writeMetadata(h, n.metadata)
+	if n.isDir {
+		return
+	}
+	h.Set("x-ms-type", "File")
+	h.Set("Content-Type", "application/octet-stream")
+	for k, v := range n.headers {
+		h.Set(k, v)
+	}
+	if n.copyID != "" {
+		h.Set("x-ms-copy-id", n.copyID)
+		h.Set("x-ms-copy-source", n.copySource)
+		h.Set("x-ms-copy-status", string(azfile.CopyStatusSuccess))
+		h.Set("x-ms-copy-progress", n.copyProgress)
+		h.Set("x-ms-copy-completion-time", n.copyCompletionTime)
+	}
+}
+
+// fileAttributes lists the attributes that a directory or a file may have, in the order that the service lists them.
+var fileAttributes = []string{"ReadOnly", "Hidden", "System", "None", "Directory", "Archive", "Temporary", "Offline", "NotContentIndexed", "NoScrubData"}
+
+// parseAttributes parses a request's attributes for a directory or a file, returning them as the service lists them.
+func parseAttributes(value string, isDir bool, creating bool) (string, *serviceError) {
+	set := map[string]bool{}
+	for _, a := range strings.Split(value, "|") {
+		found := false
+		for _, name := range fileAttributes {
+			if strings.EqualFold(strings.TrimSpace(a), name) {
+				set[name], found = true, true
+			}
+		}
+		if !found {
+			return "", newError(http.StatusBadRequest, azfile.ServiceCodeInvalidHeaderValue, "The value for the x-ms-file-attributes header %q isn't valid.", value)
+		}
+	}
+	if set["None"] && len(set) > 1 || set["Directory"] && !isDir {
+		return "", newError(http.StatusBadRequest, azfile.ServiceCodeInvalidHeaderValue, "The value for the x-ms-file-attributes header %q isn't valid.", value)
+	}
+	if set["None"] {
+		delete(set, "None")
+		if creating && !isDir {
+			set["Archive"] = true // Like a file created over SMB, a new file is archived
+		}
+	}
+	if isDir {
+		set["Directory"] = true
+	}
+	attributes := []string{}
+	for _, name := range fileAttributes {
+		if set[name] {
+			attributes = append(attributes, name)
+		}
+	}
+	if len(attributes) == 0 {
+		return "None", nil
+	}
+	return strings.Join(attributes, " | "), nil
+}
+
+// parseFileTime parses a request's creation or last write time, which may also be "now"; "preserve", or no value,
+// keeps the current time.
+func parseFileTime(value string, current string) (string, *serviceError) {
+	switch value {
+	case "", "preserve":
+		return current, nil
+	case "now":
+		return time.Now().UTC().Format(azfile.ISO8601), nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return "", newError(http.StatusBadRequest, azfile.ServiceCodeInvalidHeaderValue, "The value %q of a file time header isn't an ISO 8601 time.", value)
+	}
+	return t.UTC().Format(azfile.ISO8601), nil
+}
+
+// setSMBProperties sets the SMB properties of a directory or a file from a request's headers. Properties without a
+// value are preserved, or, when creating, set to their defaults: times of now, and the permission inherited from the
+// parent directory.
+func (s *Server) setSMBProperties(sh *share, r *request, n *node, creating bool) *serviceError {
+	attributes, creationTime, lastWriteTime, permissionKey := n.attributes, n.creationTime, n.lastWriteTime, n.permissionKey
+	var err *serviceError
+	if value := r.Header.Get("x-ms-file-attributes"); value != "" && value != "preserve" {
+		if attributes, err = parseAttributes(value, n.isDir, creating); err != nil {
+			return err
+		}
+	}
+	if creationTime, err = parseFileTime(r.Header.Get("x-ms-file-creation-time"), creationTime); err != nil {
+		return err
+	}
+	if lastWriteTime, err = parseFileTime(r.Header.Get("x-ms-file-last-write-time"), lastWriteTime); err != nil {
+		return err
+	}
+
+	permission, key := r.Header.Get("x-ms-file-permission"), r.Header.Get("x-ms-file-permission-key")
+	switch {
+	case permission != "" && permission != "preserve" && key != "":
+		return newError(http.StatusBadRequest, azfile.ServiceCodeInvalidHeaderValue, "Only one of x-ms-file-permission and x-ms-file-permission-key may be specified.")
+	case key != "":
+		if _, ok := sh.permissions[key]; !ok {
+			return invalidPermissionKey()
+		}
+		permissionKey = key
+	case permission == "inherit" || creating && (permission == "" || permission == "preserve"):
+		permissionKey = sh.nodes[parentKey(nodeKey(r.path))].permissionKey
+	case permission == "" || permission == "preserve":
+	case len(permission) > maxHeaderPermission:
+		return newError(http.StatusBadRequest, azfile.ServiceCodeInvalidHeaderValue, "The permission is larger than %d bytes; create it with Create Permission and use its key.", maxHeaderPermission)
+	case !validPermission(permission):
+		return newError(http.StatusBadRequest, azfile.ServiceCodeInvalidHeaderValue, "The value for the x-ms-file-permission header isn't a valid SDDL permission.")
+	default:
+		permissionKey = sh.addPermission(permission)
+	}
+
+	n.attributes, n.creationTime, n.lastWriteTime, n.permissionKey = attributes, creationTime, lastWriteTime, permissionKey
+	n.changeTime = time.Now().UTC().Format(azfile.ISO8601)
+	return nil
+}
+
+// serveDirectory serves the operations on a directory. s.mutex must be held.
+func (s *Server) serveDirectory(w http.ResponseWriter, r *request) *serviceError {
+	write := r.Method == http.MethodPut || r.Method == http.MethodDelete
+	sh, err := s.lookupShare(r, write)
+	if err != nil {
+		return err
+	}
+	if r.Method == http.MethodPut && r.comp == "" {
+		return s.createDirectory(w, r, sh)
+	}
+	n, err := lookup(sh, r, true)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case (r.Method == http.MethodGet || r.Method == http.MethodHead) && r.comp == "":
+		writeNodeProperties(w, n)
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodDelete && r.comp == "":
+		key := nodeKey(r.path)
+		if key == "" {
+			return newError(http.StatusBadRequest, azfile.ServiceCodeInvalidURI, "The root directory can't be deleted.")
+		}
+		for other := range sh.nodes {
+			if parentKey(other) == key && other != "" {
+				return newError(http.StatusConflict, azfile.ServiceCodeDirectoryNotEmpty, "The specified directory is not empty.")
+			}
+		}
+		sh.closeHandles(key, "*", false)
+		delete(sh.nodes, key)
+		w.WriteHeader(http.StatusAccepted)
+	case r.Method == http.MethodPut && r.comp == "properties":
+		if err := s.setSMBProperties(sh, r, n, false); err != nil {
+			return err
+		}
+		s.touch(n)
+		writeNodeHeaders(w, n)
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodPut && r.comp == "metadata":
+		if n.metadata, err = readMetadata(r); err != nil {
+			return err
+		}
+		s.touch(n)
+		writeNodeHeaders(w, n)
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodGet && r.comp == "list":
+		return listDirectory(w, r, sh)
+	case r.Method == http.MethodGet && r.comp == "listhandles":
+		return listHandles(w, r, sh)
+	case r.Method == http.MethodPut && r.comp == "forceclosehandles":
+		return forceCloseHandles(w, r, sh)
+	default:
+		return unsupported(r)
+	}
+	return nil
+}
+
+// createDirectory serves Create Directory.
+func (s *Server) createDirectory(w http.ResponseWriter, r *request, sh *share) *serviceError {
+	if _, ok := sh.nodes[nodeKey(r.path)]; ok {
+		return newError(http.StatusConflict, azfile.ServiceCodeResourceAlreadyExists, "The specified resource already exists.")
+	}
+	parent, err := parentOf(sh, r)
+	if err != nil {
+		return err
+	}
+	metadata, err := readMetadata(r)
+	if err != nil {
+		return err
+	}
+	n := &node{isDir: true, attributes: "Directory"}
+	if err := s.setSMBProperties(sh, r, n, true); err != nil {
+		return err
+	}
+	created := s.newNode(sh, r.path, true, parent.id)
+	created.metadata, created.permissionKey, created.attributes = metadata, n.permissionKey, n.attributes
+	if n.creationTime != "" {
+		created.creationTime = n.creationTime
+	}
+	if n.lastWriteTime != "" {
+		created.lastWriteTime = n.lastWriteTime
+	}
+	writeNodeHeaders(w, created)
+	w.WriteHeader(http.StatusCreated)
+	return nil
+}
+
+// listedEntry is a directory or a file in the list of a directory.
+type listedEntry struct {
+	XMLName    xml.Name
+	Name       string `xml:"Name"`
+	Properties *struct {
+		ContentLength int64 `xml:"Content-Length"`
+	} `xml:"Properties,omitempty"`
+}
+
+// listDirectory serves List Directories and Files. The entries are listed by name, and the marker is the next one's.
+func listDirectory(w http.ResponseWriter, r *request, sh *share) *serviceError {
+	maxResults, err := parseMaxResults(r)
+	if err != nil {
+		return err
+	}
+	key, prefix, marker := nodeKey(r.path), r.query.Get("prefix"), r.query.Get("marker")
+	entries := []*node{}
+	for other, n := range sh.nodes {
+		if other != "" && parentKey(other) == key && strings.HasPrefix(n.name, prefix) && n.name >= marker {
+			entries = append(entries, n)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].name < entries[j].name })
+	next := ""
+	if len(entries) > maxResults {
+		next, entries = entries[maxResults].name, entries[:maxResults]
+	}
+
+	result := struct {
+		XMLName         xml.Name      `xml:"EnumerationResults"`
+		ServiceEndpoint string        `xml:"ServiceEndpoint,attr"`
+		ShareName       string        `xml:"ShareName,attr"`
+		ShareSnapshot   string        `xml:"ShareSnapshot,attr,omitempty"`
+		DirectoryPath   string        `xml:"DirectoryPath,attr"`
+		Prefix          string        `xml:"Prefix,omitempty"`
+		Marker          string        `xml:"Marker,omitempty"`
+		MaxResults      string        `xml:"MaxResults,omitempty"`
+		Entries         []listedEntry `xml:"Entries>Entry"`
+		NextMarker      string        `xml:"NextMarker"`
+	}{ServiceEndpoint: r.endpoint, ShareName: sh.name, ShareSnapshot: sh.snapshot, DirectoryPath: sh.fullPath(key),
+		Prefix: prefix, Marker: marker, MaxResults: r.query.Get("maxresults"), NextMarker: next}
+	for _, n := range entries {
+		e := listedEntry{XMLName: xml.Name{Local: "Directory"}, Name: n.name}
+		if !n.isDir {
+			e.XMLName.Local = "File"
+			e.Properties = &struct {
+				ContentLength int64 `xml:"Content-Length"`
+			}{n.content.size}
+		}
+		result.Entries = append(result.Entries, e)
+	}
+	return writeXML(w, result)
+}
+
+// handlesOf returns the handles of the directory or file with the specified key and, if recursive is true, those of
+// the directories and files under it, sorted by ID.
+func (sh *share) handlesOf(key string, recursive bool) []*handle {
+	handles := []*handle{}
+	for _, h := range sh.handles {
+		if h.key == key || recursive && (key == "" || strings.HasPrefix(h.key, key+"/")) {
+			handles = append(handles, h)
+		}
+	}
+	return handles
+}
+
+// closeHandles closes the handle with the specified ID, or all of them if it's "*", of the directory or file with the
+// specified key and, if recursive is true, of the directories and files under it. It returns the number of handles
+// closed.
+func (sh *share) closeHandles(key string, id string, recursive bool) int {
+	closed := map[*handle]bool{}
+	for _, h := range sh.handlesOf(key, recursive) {
+		if id == "*" || h.id == id {
+			closed[h] = true
+		}
+	}
+	remaining := []*handle{}
+	for _, h := range sh.handles {
+		if !closed[h] {
+			remaining = append(remaining, h)
+		}
+	}
+	sh.handles = remaining
+	return len(closed)
+}
+
+// listHandles serves List Handles. The marker is the ID of the next handle.
+func listHandles(w http.ResponseWriter, r *request, sh *share) *serviceError {
+	maxResults, err := parseMaxResults(r)
+	if err != nil {
+		return err
+	}
+	handles := sh.handlesOf(nodeKey(r.path), r.Header.Get("x-ms-recursive") == "true")
+	if sh.snapshot != "" {
+		handles = nil // A snapshot isn't open
+	}
+	if marker, err := strconv.ParseUint(r.query.Get("marker"), 10, 64); err == nil {
+		for len(handles) > 0 && handleID(handles[0]) < marker {
+			handles = handles[1:]
+		}
+	}
+	next := ""
+	if len(handles) > maxResults {
+		next, handles = handles[maxResults].id, handles[:maxResults]
+	}
+
+	type listedHandle struct {
+		HandleID  string `xml:"HandleId"`
+		Path      string `xml:"Path"`
+		FileID    string `xml:"FileId"`
+		ParentID  string `xml:"ParentId"`
+		SessionID string `xml:"SessionId"`
+		ClientIP  string `xml:"ClientIp"`
+		OpenTime  string `xml:"OpenTime"`
+	}
+	result := struct {
+		XMLName    xml.Name       `xml:"EnumerationResults"`
+		Handles    []listedHandle `xml:"Entries>Handle"`
+		NextMarker string         `xml:"NextMarker"`
+	}{NextMarker: next}
+	for _, h := range handles {
+		result.Handles = append(result.Handles, listedHandle{HandleID: h.id, Path: sh.fullPath(h.key),
+			FileID: strconv.FormatUint(h.fileID, 10), ParentID: strconv.FormatUint(h.parentID, 10), SessionID: h.sessionID,
+			ClientIP: h.clientIP, OpenTime: h.openTime.Format(http.TimeFormat)})
+	}
+	return writeXML(w, result)
+}
+
+func handleID(h *handle) uint64 {
+	id, _ := strconv.ParseUint(h.id, 10, 64)
+	return id
+}
+
+// forceCloseHandles serves Force Close Handles, which closes all of the handles at once, so it never returns a marker.
+func forceCloseHandles(w http.ResponseWriter, r *request, sh *share) *serviceError {
+	id := r.Header.Get("x-ms-handle-id")
+	if id == "" {
+		return newError(http.StatusBadRequest, azfile.ServiceCodeMissingRequiredHeader, "The x-ms-handle-id header is required.")
+	}
+	closed := sh.closeHandles(nodeKey(r.path), id, r.Header.Get("x-ms-recursive") == "true")
+	w.Header().Set("x-ms-number-of-handles-closed", strconv.Itoa(closed))
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// serveFile serves the operations on a file. s.mutex must be held.
+func (s *Server) serveFile(w http.ResponseWriter, r *request) *serviceError {
+	write := r.Method == http.MethodPut || r.Method == http.MethodDelete
+	sh, err := s.lookupShare(r, write)
+	if err != nil {
+		return err
+	}
+	if r.Method == http.MethodPut && r.comp == "" {
+		if r.Header.Get("x-ms-copy-source") != "" {
+			return s.startCopy(w, r, sh)
+		}
+		return s.createFile(w, r, sh)
+	}
+	n, err := lookup(sh, r, false)
+	if err != nil {
+		return err
+	}
+	readOnly := strings.Contains(n.attributes, "ReadOnly")
+
+	switch {
+	case r.Method == http.MethodGet && r.comp == "":
+		return download(w, r, n)
+	case r.Method == http.MethodHead && r.comp == "":
+		writeNodeProperties(w, n)
+		w.Header().Set("Content-Length", strconv.FormatInt(n.content.size, 10))
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodDelete && r.comp == "":
+		sh.closeHandles(nodeKey(r.path), "*", false)
+		delete(sh.nodes, nodeKey(r.path))
+		w.WriteHeader(http.StatusAccepted)
+	case r.Method == http.MethodPut && r.comp == "range":
+		if readOnly {
+			return readOnlyAttribute()
+		}
+		return s.putRange(w, r, n)
+	case r.Method == http.MethodGet && r.comp == "rangelist":
+		return listRanges(w, r, n)
+	case r.Method == http.MethodPut && r.comp == "properties":
+		size := n.content.size
+		if value := r.Header.Get("x-ms-content-length"); value != "" {
+			if size, err = parseFileSize(value); err != nil {
+				return err
+			}
+		}
+		if size != n.content.size && readOnly {
+			return readOnlyAttribute()
+		}
+		if err := s.setSMBProperties(sh, r, n, false); err != nil {
+			return err
+		}
+		setHTTPHeaders(r, n)
+		if size < n.content.size {
+			n.markWritten(size, maxFileSize, true)
+		}
+		n.content.resize(size)
+		s.touch(n)
+		writeNodeHeaders(w, n)
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodPut && r.comp == "metadata":
+		if n.metadata, err = readMetadata(r); err != nil {
+			return err
+		}
+		s.touch(n)
+		writeNodeHeaders(w, n)
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodPut && r.comp == "copy":
+		// Copies complete as they start, so none can be aborted.
+		return newError(http.StatusConflict, azfile.ServiceCodeNoPendingCopyOperation, "There is currently no pending copy operation.")
+	case r.Method == http.MethodGet && r.comp == "listhandles":
+		return listHandles(w, r, sh)
+	case r.Method == http.MethodPut && r.comp == "forceclosehandles":
+		return forceCloseHandles(w, r, sh)
+	default:
+		return unsupported(r)
+	}
+	return nil
+}
+
+func readOnlyAttribute() *serviceError {
+	return newError(http.StatusConflict, azfile.ServiceCodeReadOnlyAttribute, "The specified resource is read-only and cannot be modified at this time.")
+}
+
+// parseFileSize parses a file's size.
+func parseFileSize(value string) (int64, *serviceError) {
+	size, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || size < 0 || size > maxFileSize {
+		return 0, newError(http.StatusBadRequest, azfile.ServiceCodeOutOfRangeInput, "The file size %q isn't between 0 and %d.", value, int64(maxFileSize))
+	}
+	return size, nil
+}
+
+// setHTTPHeaders sets the HTTP headers of a file from the x-ms-content-* and x-ms-cache-control headers of a request.
+// Like the service's, it clears the headers that the request doesn't have.
+func setHTTPHeaders(r *request, n *node) {
+	for _, name := range []string{"Content-Type", "Content-Encoding", "Content-Language", "Content-Disposition", "Cache-Control", "Content-MD5"} {
+		if value := r.Header.Get("x-ms-" + name); value != "" {
+			n.headers[name] = value
+		} else {
+			delete(n.headers, name)
+		}
+	}
+}
+
+// createFile serves Create File, which creates a file, or replaces one, without any content.
+func (s *Server) createFile(w http.ResponseWriter, r *request, sh *share) *serviceError {
+	key := nodeKey(r.path)
+	if existing, ok := sh.nodes[key]; ok && existing.isDir {
+		return newError(http.StatusConflict, azfile.ServiceCodeResourceAlreadyExists, "The specified resource already exists.")
+	} else if ok && strings.Contains(existing.attributes, "ReadOnly") {
+		return readOnlyAttribute()
+	}
+	parent, err := parentOf(sh, r)
+	if err != nil {
+		return err
+	}
+	value := r.Header.Get("x-ms-content-length")
+	if value == "" {
+		return newError(http.StatusBadRequest, azfile.ServiceCodeMissingRequiredHeader, "The x-ms-content-length header is required.")
+	}
+	size, err := parseFileSize(value)
+	if err != nil {
+		return err
+	}
+	metadata, err := readMetadata(r)
+	if err != nil {
+		return err
+	}
+	n := &node{attributes: "Archive"}
+	if err := s.setSMBProperties(sh, r, n, true); err != nil {
+		return err
+	}
+
+	created := s.newNode(sh, r.path, false, parent.id)
+	created.metadata, created.permissionKey, created.attributes = metadata, n.permissionKey, n.attributes
+	if n.creationTime != "" {
+		created.creationTime = n.creationTime
+	}
+	if n.lastWriteTime != "" {
+		created.lastWriteTime = n.lastWriteTime
+	}
+	created.content.resize(size)
+	setHTTPHeaders(r, created)
+	writeNodeHeaders(w, created)
+	w.WriteHeader(http.StatusCreated)
+	return nil
+}
+
+// parseRange parses a "bytes=start-end" range; end is -1 if absent.
+func parseRange(value string) (start int64, end int64, ok bool) {
+	if !strings.HasPrefix(value, "bytes=") {
+		return 0, 0, false
+	}
+	parts := strings.SplitN(strings.TrimPrefix(value, "bytes="), "-", 2)
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	start, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, false
+	}
+	end = -1
+	if parts[1] != "" {
+		if end, err = strconv.ParseInt(parts[1], 10, 64); err != nil || end < start {
+			return 0, 0, false
+		}
+	}
+	return start, end, true
+}
+
+// requestRange returns the range of a request's x-ms-range header, or its Range header, which a write requires.
+func requestRange(r *request) (start int64, end int64, ok bool) {
+	value := r.Header.Get("x-ms-range")
+	if value == "" {
+		value = r.Header.Get("Range")
+	}
+	return parseRange(value)
+}
+
+func invalidRange() *serviceError {
+	return newError(http.StatusRequestedRangeNotSatisfiable, azfile.ServiceCodeInvalidRange, "The range specified is invalid for the current size of the resource.")
+}
+
+// putRange serves Put Range, which writes or clears a range of a file, and Put Range From URL, which writes a range
+// of another file to it.
+func (s *Server) putRange(w http.ResponseWriter, r *request, n *node) *serviceError {
+	start, end, ok := requestRange(r)
+	if !ok || end < 0 {
+		return newError(http.StatusBadRequest, azfile.ServiceCodeInvalidHeaderValue, "The value for the x-ms-range header isn't a valid range.")
+	}
+	if end >= n.content.size {
+		return invalidRange()
+	}
+	length := end - start + 1
+
+	switch write := r.Header.Get("x-ms-write"); {
+	case write == "clear":
+		n.content.writeAt(nil, start, length)
+		n.markWritten(start, end, true)
+	case write == "update" && r.Header.Get("x-ms-copy-source") != "":
+		source, err := s.copySource(r, false)
+		if err != nil {
+			return err
+		}
+		sourceStart, sourceEnd, ok := parseRange(r.Header.Get("x-ms-source-range"))
+		if !ok || sourceEnd-sourceStart+1 != length {
+			return newError(http.StatusBadRequest, azfile.ServiceCodeInvalidHeaderValue, "The x-ms-source-range header must be a range as long as the x-ms-range header's.")
+		}
+		if length > maxRangeSize || sourceEnd >= source.content.size {
+			return invalidRange()
+		}
+		b := make([]byte, length)
+		source.content.readAt(b, sourceStart)
+		n.content.writeAt(b, start, length)
+		n.markWritten(start, end, false)
+	case write == "update":
+		if int64(len(r.body)) != length {
+			return newError(http.StatusBadRequest, azfile.ServiceCodeInvalidHeaderValue, "The body is %d bytes long, but the range is %d bytes long.", len(r.body), length)
+		}
+		if length > maxRangeSize {
+			return newError(http.StatusRequestEntityTooLarge, azfile.ServiceCodeRequestBodyTooLarge, "A range may be at most %d bytes long.", maxRangeSize)
+		}
+		if value := r.Header.Get("Content-MD5"); value != "" {
+			sum := md5.Sum(r.body)
+			if value != base64.StdEncoding.EncodeToString(sum[:]) {
+				return newError(http.StatusBadRequest, azfile.ServiceCodeMd5Mismatch, "The MD5 value specified in the request did not match with the MD5 value calculated by the server.")
+			}
+			w.Header().Set("Content-MD5", value)
+		}
+		n.content.writeAt(r.body, start, length)
+		n.markWritten(start, end, false)
+	default:
+		return newError(http.StatusBadRequest, azfile.ServiceCodeInvalidHeaderValue, "The value for the x-ms-write header must be update or clear.")
+	}
+
+	// Like the service's, writing a range changes the file's last write time.
+	n.lastWriteTime = time.Now().UTC().Format(azfile.ISO8601)
+	n.changeTime = n.lastWriteTime
+	s.touch(n)
+	writeNodeHeaders(w, n)
+	w.WriteHeader(http.StatusCreated)
+	return nil
+}
+
+// listRanges serves List Ranges, which lists the ranges written to a file, within its x-ms-range if it has one.
+func listRanges(w http.ResponseWriter, r *request, n *node) *serviceError {
+	start, end := int64(0), n.content.size-1
+	if r.Header.Get("x-ms-range") != "" || r.Header.Get("Range") != "" {
+		var ok bool
+		if start, end, ok = requestRange(r); !ok {
+			return newError(http.StatusBadRequest, azfile.ServiceCodeInvalidHeaderValue, "The value for the x-ms-range header isn't a valid range.")
+		}
+		if end < 0 || end >= n.content.size {
+			end = n.content.size - 1
+		}
+	}
+	ranges := azfile.Ranges{Items: []azfile.Range{}}
+	for _, rg := range n.written {
+		if rg.End < start || rg.Start > end {
+			continue
+		}
+		if rg.Start < start {
+			rg.Start = start
+		}
+		if rg.End > end {
+			rg.End = end
+		}
+		ranges.Items = append(ranges.Items, rg)
+	}
+	writeNodeHeaders(w, n)
+	w.Header().Set("x-ms-content-length", strconv.FormatInt(n.content.size, 10))
+	return writeXML(w, ranges)
+}
+
+// download serves Get File, which gets a file's content, or the range of it in its x-ms-range header.
+func download(w http.ResponseWriter, r *request, n *node) *serviceError {
+	start, end, status := int64(0), n.content.size-1, http.StatusOK
+	if r.Header.Get("x-ms-range") != "" || r.Header.Get("Range") != "" {
+		var ok bool
+		if start, end, ok = requestRange(r); !ok || start >= n.content.size {
+			return invalidRange()
+		}
+		if end < 0 || end >= n.content.size {
+			end = n.content.size - 1
+		}
+		status = http.StatusPartialContent
+	}
+	body := make([]byte, end-start+1)
+	n.content.readAt(body, start)
+
+	writeNodeProperties(w, n)
+	h := w.Header()
+	h.Set("Accept-Ranges", "bytes")
+	if status == http.StatusPartialContent {
+		h.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, n.content.size))
+		if md5Value := h.Get("Content-MD5"); md5Value != "" {
+			h.Set("x-ms-content-md5", md5Value) // The whole file's MD5, while Content-MD5 is the range's
+			h.Del("Content-MD5")
+		}
+	}
+	if r.Header.Get("x-ms-range-get-content-md5") == "true" {
+		if status != http.StatusPartialContent || len(body) > maxRangeSize {
+			return newError(http.StatusBadRequest, azfile.ServiceCodeOutOfRangeInput, "The range's MD5 can only be returned for a range of at most %d bytes.", maxRangeSize)
+		}
+		sum := md5.Sum(body)
+		h.Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+	}
+
+	// A SAS may override the response's headers.
+	for parameter, header := range map[string]string{"rscc": "Cache-Control", "rscd": "Content-Disposition",
+		"rsce": "Content-Encoding", "rscl": "Content-Language", "rsct": "Content-Type"} {
+		if value := r.query.Get(parameter); value != "" {
+			h.Set(header, value)
+		}
+	}
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(status)
+	w.Write(body)
+	return nil
+}
+
+// copySource returns the file that the x-ms-copy-source header of r, a copy, refers to, which must be in the server's
+// account and which the copy must be authorized to read.
+func (s *Server) copySource(r *request, sharedKeyAllowed bool) (*node, *serviceError) {
+	cannotVerify := func(format string, a ...interface{}) *serviceError {
+		return newError(http.StatusNotFound, serviceCodeCannotVerifyCopySource, format, a...)
+	}
+	sourceURL, parseErr := url.Parse(r.Header.Get("x-ms-copy-source"))
+	if parseErr != nil || !sourceURL.IsAbs() {
+		return nil, newError(http.StatusBadRequest, azfile.ServiceCodeInvalidHeaderValue, "The value for the x-ms-copy-source header isn't an absolute URL.")
+	}
+	source, err := s.parseTarget(&http.Request{Method: http.MethodGet, URL: sourceURL, Host: sourceURL.Host, Header: http.Header{}})
+	if err != nil {
+		return nil, cannotVerify("The copy source isn't in this server's account.")
+	}
+	source.secure = sourceURL.Scheme == "https"
+	if err := s.authorizeCopySource(source, sharedKeyAllowed); err != nil {
+		return nil, err
+	}
+	sh, err := s.lookupShare(source, false)
+	if err != nil {
+		return nil, cannotVerify("The copy source's share does not exist.")
+	}
+	n, ok := sh.nodes[nodeKey(source.path)]
+	if !ok || n.isDir || source.path == "" {
+		return nil, cannotVerify("The specified resource does not exist.")
+	}
+	return n, nil
+}
+
+// startCopy serves Copy File. The copy of a file in the server's account completes at once, so the response's copy
+// status is already success.
+func (s *Server) startCopy(w http.ResponseWriter, r *request, sh *share) *serviceError {
+	key := nodeKey(r.path)
+	if existing, ok := sh.nodes[key]; ok && existing.isDir {
+		return newError(http.StatusConflict, azfile.ServiceCodeResourceAlreadyExists, "The specified resource already exists.")
+	} else if ok && strings.Contains(existing.attributes, "ReadOnly") {
+		return readOnlyAttribute()
+	}
+	parent, err := parentOf(sh, r)
+	if err != nil {
+		return err
+	}
+	source, err := s.copySource(r, true)
+	if err != nil {
+		return err
+	}
+	metadata, err := readMetadata(r)
+	if err != nil {
+		return err
+	}
+	if len(metadata) == 0 {
+		metadata = copyMetadata(source.metadata)
+	}
+
+	content, written, headers := source.content.copy(), append([]azfile.Range{}, source.written...), copyMetadata(source.headers)
+	n := s.newNode(sh, r.path, false, parent.id)
+	n.metadata, n.content, n.written, n.headers = metadata, content, written, headers
+	n.permissionKey = parent.permissionKey
+	n.copyID, n.copySource = s.newUUID(), r.Header.Get("x-ms-copy-source")
+	n.copyProgress = fmt.Sprintf("%d/%d", content.size, content.size)
+	n.copyCompletionTime = time.Now().UTC().Format(http.TimeFormat)
+	w.Header().Set("ETag", n.etag)
+	w.Header().Set("Last-Modified", n.lastModified.Format(http.TimeFormat))
+	w.Header().Set("x-ms-copy-id", n.copyID)
+	w.Header().Set("x-ms-copy-status", string(azfile.CopyStatusSuccess))
+	w.WriteHeader(http.StatusAccepted)
+	return nil
+}
@@ -0,0 +1,296 @@
+package azfiletest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+)
+
+// Cassette is a recording of the HTTP requests that pipelines sent, along with the responses that they got, which a
+// Recorder captures and a Replayer serves. Its secrets are redacted: the values of Authorization headers, and the sig
+// query parameters of URLs, both of requests and of header values, such as x-ms-copy-source.
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Interaction is a request of a Cassette, along with its response.
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+// RecordedRequest is a request of an Interaction, including the x-ms-range and Range headers that a Replayer matches
+// requests by; its body isn't recorded.
+type RecordedRequest struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header"`
+}
+
+// RecordedResponse is the response of an Interaction.
+type RecordedResponse struct {
+	StatusCode int         `json:"statusCode"`
+	Header     http.Header `json:"header"`
+	Body       []byte      `json:"body,omitempty"`
+}
+
+// LoadCassette reads the Cassette that Cassette.Save wrote to the file at path.
+func LoadCassette(path string) (*Cassette, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &Cassette{}
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, fmt.Errorf("azfiletest: the cassette %s is invalid: %v", path, err)
+	}
+	return c, nil
+}
+
+// Save writes the Cassette, as JSON, to the file at path.
+func (c *Cassette) Save(path string) error {
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0644)
+}
+
+// Recorder is a pipeline.Factory that sends requests with another sender and records them, along with their
+// responses, in a Cassette. It's meant to be a pipeline's HTTPSender:
+//
+//	recorder := azfiletest.NewRecorder(nil)
+//	p := azfile.NewPipeline(credential, azfile.PipelineOptions{HTTPSender: recorder})
+//	...
+//	err := recorder.Cassette().Save("testdata/cassette.json")
+//
+// The responses' bodies are read in full, and recorded, before they're returned. Requests that fail to get a
+// response aren't recorded.
+type Recorder struct {
+	sender   pipeline.Factory
+	mutex    sync.Mutex
+	cassette Cassette
+}
+
+// NewRecorder creates a Recorder that sends requests with sender, or, if sender is nil, with http.DefaultClient.
+func NewRecorder(sender pipeline.Factory) *Recorder {
+	if sender == nil {
+		sender = newHTTPClientSender(http.DefaultClient)
+	}
+	return &Recorder{sender: sender}
+}
+
+// Cassette returns a copy of the Cassette of the interactions that the Recorder recorded so far.
+func (r *Recorder) Cassette() *Cassette {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return &Cassette{Interactions: append([]Interaction(nil), r.cassette.Interactions...)}
+}
+
+// New creates the recording policy; it implements pipeline.Factory.
+func (r *Recorder) New(next pipeline.Policy, po *pipeline.PolicyOptions) pipeline.Policy {
+	sender := r.sender.New(next, po)
+	return pipeline.PolicyFunc(func(ctx context.Context, request pipeline.Request) (pipeline.Response, error) {
+		response, err := sender.Do(ctx, request)
+		if response == nil || response.Response() == nil {
+			return response, err
+		}
+		httpResponse := response.Response()
+		body, readErr := ioutil.ReadAll(httpResponse.Body)
+		httpResponse.Body.Close()
+		if readErr != nil {
+			return pipeline.NewHTTPResponse(nil), readErr
+		}
+		httpResponse.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		interaction := Interaction{
+			Request: RecordedRequest{
+				Method: request.Method,
+				URL:    redactURL(request.URL.String()),
+				Header: redactHeader(request.Header),
+			},
+			Response: RecordedResponse{
+				StatusCode: httpResponse.StatusCode,
+				Header:     redactHeader(httpResponse.Header),
+				Body:       body,
+			},
+		}
+		r.mutex.Lock()
+		r.cassette.Interactions = append(r.cassette.Interactions, interaction)
+		r.mutex.Unlock()
+		return response, err
+	})
+}
+
+// Replayer is a pipeline.Factory that serves the responses of a Cassette instead of sending requests. It's meant to
+// be a pipeline's HTTPSender:
+//
+//	cassette, err := azfiletest.LoadCassette("testdata/cassette.json")
+//	...
+//	p := azfile.NewPipeline(credential, azfile.PipelineOptions{HTTPSender: azfiletest.NewReplayer(cassette)})
+//
+// A request gets the response of the first interaction not yet replayed whose request matches it: the requests' methods
+// and paths must be equal, as must their x-ms-range and Range headers, which select the range of a ranged request, and
+// their queries, but for the parameters whose values vary from one run to the next: sig, st and se, which SAS tokens
+// created with the current time have, and the retry policy's timeout. A request that no interaction matches fails.
+// Request bodies aren't recorded, so they aren't compared: requests that differ only in their bodies, such as two
+// UploadRange calls of the same range, get their responses in the order of the recording.
+type Replayer struct {
+	mutex        sync.Mutex
+	interactions []Interaction
+	replayed     []bool
+}
+
+// NewReplayer creates a Replayer that serves the responses of the Cassette c.
+func NewReplayer(c *Cassette) *Replayer {
+	return &Replayer{interactions: c.Interactions, replayed: make([]bool, len(c.Interactions))}
+}
+
+// Unplayed returns the number of the Cassette's interactions that the Replayer hasn't replayed.
+func (r *Replayer) Unplayed() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	n := 0
+	for _, replayed := range r.replayed {
+		if !replayed {
+			n++
+		}
+	}
+	return n
+}
+
+// New creates the replaying policy; it implements pipeline.Factory.
+func (r *Replayer) New(next pipeline.Policy, po *pipeline.PolicyOptions) pipeline.Policy {
+	return pipeline.PolicyFunc(func(ctx context.Context, request pipeline.Request) (pipeline.Response, error) {
+		// Like the pipeline's default sender, the policy returns a response without an http.Response when it fails.
+		if err := ctx.Err(); err != nil {
+			return pipeline.NewHTTPResponse(nil), err
+		}
+		interaction, ok := r.replay(request.Method, request.URL, request.Header)
+		if !ok {
+			return pipeline.NewHTTPResponse(nil), fmt.Errorf("azfiletest: the cassette has no response left for %s %s", request.Method, redactURL(request.URL.String()))
+		}
+		recorded := interaction.Response
+		header := cloneHeader(recorded.Header)
+		contentLength := int64(len(recorded.Body))
+		if value := header.Get("Content-Length"); value != "" {
+			contentLength, _ = strconv.ParseInt(value, 10, 64) // A HEAD response has a length, but no body
+		}
+		return pipeline.NewHTTPResponse(&http.Response{
+			Status:        fmt.Sprintf("%d %s", recorded.StatusCode, http.StatusText(recorded.StatusCode)),
+			StatusCode:    recorded.StatusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        header,
+			Body:          ioutil.NopCloser(bytes.NewReader(recorded.Body)),
+			ContentLength: contentLength,
+			Request:       request.Request,
+		}), nil
+	})
+}
+
+// replay marks the first interaction that matches a request as replayed and returns it.
+func (r *Replayer) replay(method string, u *url.URL, header http.Header) (Interaction, bool) {
+	key := matchingKey(method, u, header)
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for i, interaction := range r.interactions {
+		if r.replayed[i] || interaction.Request.Method != method {
+			continue
+		}
+		recordedURL, err := url.Parse(interaction.Request.URL)
+		if err != nil || matchingKey(interaction.Request.Method, recordedURL, interaction.Request.Header) != key {
+			continue
+		}
+		r.replayed[i] = true
+		return interaction, true
+	}
+	return Interaction{}, false
+}
+
+// unmatchedQueryParameters are the query parameters whose values matchingKey ignores.
+var unmatchedQueryParameters = []string{"sig", "st", "se", "timeout"}
+
+// matchingKey returns the key of a request that a recorded request matches if their keys are equal: its method, path
+// and range headers, along with its query, sorted, without the parameters whose values vary from run to run.
+func matchingKey(method string, u *url.URL, header http.Header) string {
+	query := u.Query()
+	for name := range query {
+		for _, unmatched := range unmatchedQueryParameters {
+			if strings.EqualFold(name, unmatched) {
+				delete(query, name)
+			}
+		}
+	}
+	return method + " " + u.EscapedPath() + "?" + query.Encode() + " " + header.Get("x-ms-range") + " " + header.Get("Range")
+}
+
+// redactHeader returns a copy of a header, with the values of its Authorization header, and the sig query parameters
+// of the URLs in its values, redacted.
+func redactHeader(header http.Header) http.Header {
+	redacted := cloneHeader(header)
+	for name, values := range redacted {
+		for i, value := range values {
+			if strings.EqualFold(name, "Authorization") {
+				values[i] = "REDACTED"
+			} else {
+				values[i] = redactURL(value)
+			}
+		}
+	}
+	return redacted
+}
+
+// redactURL redacts the value of the sig query parameter of a URL, like azfile.RedactSigQueryParam does, but
+// without lowercasing its other parameters, whose values, such as share snapshots, may be case-sensitive. A value
+// that isn't a URL with a sig query parameter is returned unchanged.
+func redactURL(value string) string {
+	u, err := url.Parse(value)
+	if err != nil || u.RawQuery == "" {
+		return value
+	}
+	query, _ := url.ParseQuery(u.RawQuery)
+	sigFound := false
+	for name := range query {
+		if strings.EqualFold(name, "sig") {
+			query[name] = []string{"REDACTED"}
+			sigFound = true
+		}
+	}
+	if !sigFound {
+		return value
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+func cloneHeader(header http.Header) http.Header {
+	clone := make(http.Header, len(header))
+	for name, values := range header {
+		clone[name] = append([]string(nil), values...)
+	}
+	return clone
+}
+
+// newHTTPClientSender returns a pipeline.Factory whose policy sends requests with client.
+func newHTTPClientSender(client *http.Client) pipeline.Factory {
+	return pipeline.FactoryFunc(func(next pipeline.Policy, po *pipeline.PolicyOptions) pipeline.PolicyFunc {
+		return func(ctx context.Context, request pipeline.Request) (pipeline.Response, error) {
+			r, err := client.Do(request.WithContext(ctx))
+			if err != nil {
+				err = pipeline.NewError(err, "HTTP request failed")
+			}
+			return pipeline.NewHTTPResponse(r), err
+		}
+	})
+}
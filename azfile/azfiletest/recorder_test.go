@@ -0,0 +1,140 @@
+package azfiletest_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-storage-file-go/azfile"
+	"github.com/Azure/azure-storage-file-go/azfile/azfiletest"
+	chk "gopkg.in/check.v1"
+)
+
+type RecorderSuite struct{}
+
+var _ = chk.Suite(&RecorderSuite{})
+
+// runRecordedOperations runs operations of a share, a snapshot and a file, whose SAS is created with the current time,
+// returning the content that it downloads through the SAS.
+func runRecordedOperations(c *chk.C, server *azfiletest.Server, serviceURL azfile.ServiceURL, o azfile.PipelineOptions) string {
+	ctx := context.Background()
+	shareURL := serviceURL.NewShareURL("share")
+	_, err := shareURL.Create(ctx, azfile.Metadata{}, 0)
+	c.Assert(err, chk.IsNil)
+	fileURL := shareURL.NewRootDirectoryURL().NewFileURL("file")
+	c.Assert(azfile.UploadBufferToAzureFile(ctx, []byte("before"), fileURL, azfile.UploadToAzureFileOptions{}), chk.IsNil)
+	snapshot, err := shareURL.CreateSnapshot(ctx, azfile.Metadata{})
+	c.Assert(err, chk.IsNil)
+	c.Assert(azfile.UploadBufferToAzureFile(ctx, []byte("after!"), fileURL, azfile.UploadToAzureFileOptions{}), chk.IsNil)
+
+	// The same request gets the responses in the order of the recording.
+	properties, err := fileURL.GetProperties(ctx)
+	c.Assert(err, chk.IsNil)
+	c.Assert(properties.ContentLength(), chk.Equals, int64(6))
+
+	sas, err := azfile.FileSASSignatureValues{StartTime: time.Now().Add(-time.Minute), ExpiryTime: time.Now().Add(time.Hour),
+		ShareName: "share", Permissions: "r"}.NewSASQueryParameters(server.Credential())
+	c.Assert(err, chk.IsNil)
+	sasURL := fileURL.WithSnapshot(snapshot.Snapshot()).URL()
+	sasURL.RawQuery += "&" + sas.Encode()
+	response, err := azfile.NewFileURL(sasURL, azfile.NewPipeline(azfile.NewAnonymousCredential(), o)).Download(ctx, 0, azfile.CountToEnd, false)
+	c.Assert(err, chk.IsNil)
+	body, err := ioutil.ReadAll(response.Body(azfile.RetryReaderOptions{}))
+	c.Assert(err, chk.IsNil)
+	return string(body)
+}
+
+func (s *RecorderSuite) TestRecordAndReplay(c *chk.C) {
+	server, ts, _ := startTestServer()
+	recorder := azfiletest.NewRecorder(nil)
+	o := azfile.PipelineOptions{HTTPSender: recorder}
+	c.Assert(runRecordedOperations(c, server, server.ServiceURL(ts.URL, o), o), chk.Equals, "before")
+	ts.Close()
+
+	path := filepath.Join(c.MkDir(), "cassette.json")
+	c.Assert(recorder.Cassette().Save(path), chk.IsNil)
+	b, err := ioutil.ReadFile(path)
+	c.Assert(err, chk.IsNil)
+	c.Assert(strings.Contains(string(b), "SharedKey"), chk.Equals, false)
+	c.Assert(strings.Contains(string(b), "sig=REDACTED"), chk.Equals, true)
+
+	// The replay gets the same responses without a server, although the SAS has other times and signature.
+	cassette, err := azfiletest.LoadCassette(path)
+	c.Assert(err, chk.IsNil)
+	replayer := azfiletest.NewReplayer(cassette)
+	o = azfile.PipelineOptions{HTTPSender: replayer}
+	c.Assert(runRecordedOperations(c, server, server.ServiceURL(ts.URL, o), o), chk.Equals, "before")
+	c.Assert(replayer.Unplayed(), chk.Equals, 0)
+
+	// Once its responses are replayed, a request fails.
+	_, err = server.ServiceURL(ts.URL, o).NewShareURL("share").GetProperties(context.Background())
+	c.Assert(err, chk.ErrorMatches, "(?s).*the cassette has no response left for GET .*/myaccount/share\\?restype=share.*")
+}
+
+func (s *RecorderSuite) TestRedaction(c *chk.C) {
+	server, ts, serviceURL := startTestServer()
+	defer ts.Close()
+	ctx := context.Background()
+	_, fileURL := createTestShare(c, serviceURL, []byte("data"))
+	sas, err := azfile.FileSASSignatureValues{ExpiryTime: time.Now().Add(time.Hour), ShareName: "share", FilePath: "dir/file",
+		Permissions: "r"}.NewSASQueryParameters(server.Credential())
+	c.Assert(err, chk.IsNil)
+	sourceURL := fileURL.URL()
+	sourceURL.RawQuery = sas.Encode()
+
+	recorder := azfiletest.NewRecorder(nil)
+	recordedServiceURL := server.ServiceURL(ts.URL, azfile.PipelineOptions{HTTPSender: recorder})
+	_, err = recordedServiceURL.NewShareURL("share").NewDirectoryURL("dir").NewFileURL("copy").StartCopy(ctx, sourceURL, azfile.Metadata{})
+	c.Assert(err, chk.IsNil)
+
+	interactions := recorder.Cassette().Interactions
+	c.Assert(interactions, chk.HasLen, 1)
+	request := interactions[0].Request
+	c.Assert(request.Header.Get("Authorization"), chk.Equals, "REDACTED")
+	c.Assert(request.Header.Get("x-ms-copy-source"), chk.Matches, ".*sig=REDACTED.*")
+	c.Assert(strings.Contains(request.Header.Get("x-ms-copy-source"), sas.Signature()), chk.Equals, false)
+	c.Assert(interactions[0].Response.StatusCode, chk.Equals, 202)
+}
+
+// downloadInParallel creates a file of data in the share "share" and downloads it in parallel, one 1 KB range at a
+// time, returning what it downloaded.
+func downloadInParallel(c *chk.C, serviceURL azfile.ServiceURL, data []byte) []byte {
+	ctx := context.Background()
+	shareURL := serviceURL.NewShareURL("share")
+	_, err := shareURL.Create(ctx, azfile.Metadata{}, 0)
+	c.Assert(err, chk.IsNil)
+	fileURL := shareURL.NewRootDirectoryURL().NewFileURL("file")
+	c.Assert(azfile.UploadBufferToAzureFile(ctx, data, fileURL, azfile.UploadToAzureFileOptions{RangeSize: 1024, Parallelism: 8}), chk.IsNil)
+	b := make([]byte, len(data))
+	_, err = azfile.DownloadAzureFileToBuffer(ctx, fileURL, b, azfile.DownloadFromAzureFileOptions{RangeSize: 1024, Parallelism: 8})
+	c.Assert(err, chk.IsNil)
+	return b
+}
+
+func (s *RecorderSuite) TestReplayParallelRanges(c *chk.C) {
+	data := make([]byte, 32*1024)
+	for i := range data {
+		data[i] = byte(i / 1024) // Each range has bytes of its own
+	}
+	server, ts, _ := startTestServer()
+	recorder := azfiletest.NewRecorder(nil)
+	c.Assert(downloadInParallel(c, server.ServiceURL(ts.URL, azfile.PipelineOptions{HTTPSender: recorder}), data), chk.DeepEquals, data)
+	ts.Close()
+
+	// The parallel requests are replayed in another order than they were recorded, but each gets its own range.
+	replayer := azfiletest.NewReplayer(recorder.Cassette())
+	c.Assert(downloadInParallel(c, server.ServiceURL(ts.URL, azfile.PipelineOptions{HTTPSender: replayer}), data), chk.DeepEquals, data)
+	c.Assert(replayer.Unplayed(), chk.Equals, 0)
+}
+
+func (s *RecorderSuite) TestLoadInvalidCassette(c *chk.C) {
+	path := filepath.Join(c.MkDir(), "cassette.json")
+	c.Assert(ioutil.WriteFile(path, []byte("{"), 0644), chk.IsNil)
+	_, err := azfiletest.LoadCassette(path)
+	c.Assert(err, chk.ErrorMatches, "azfiletest: the cassette .* is invalid: .*")
+	_, err = azfiletest.LoadCassette(filepath.Join(c.MkDir(), "missing.json"))
+	c.Assert(os.IsNotExist(err), chk.Equals, true)
+}
@@ -0,0 +1,360 @@
+// Package azfiletest provides an in-memory implementation of the Azure Files REST API, for testing code that uses
+// azfile without a storage account.
+//
+// A Server holds the shares of a storage account in memory and serves the parts of the File service's REST API that
+// azfile uses: the service's properties and its list of shares; shares, their snapshots, metadata, quota, access
+// policies, statistics and permissions; directories and files, their metadata, HTTP headers and SMB properties;
+// ranges, copies and handles; and listing with markers. Server is an http.Handler, meant to be served with
+// net/http/httptest:
+//
+//	server := azfiletest.NewServer("myaccount")
+//	ts := httptest.NewServer(server)
+//	defer ts.Close()
+//	serviceURL := server.ServiceURL(ts.URL, azfile.PipelineOptions{})
+//
+// As with the storage emulator, the test server's URL is an IP address, so the account's File service endpoint is
+// IP endpoint style: the account name is the first segment of its path. Requests are authorized like the service
+// authorizes them: they must be signed with the account's shared key, which Server.Credential returns, or carry a
+// SAS signed with it.
+//
+// A Recorder records the requests that a pipeline sends, along with their responses, in a Cassette whose secrets are
+// redacted, and a Replayer serves a Cassette's responses to a pipeline in place of a server, so tests recorded against
+// a storage account, or a Server, can be run offline.
+package azfiletest
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-storage-file-go/azfile"
+)
+
+// maxRequestBody is the size of the largest request body that a Server accepts, that of a 4MiB range.
+const maxRequestBody = 4 * 1024 * 1024
+
+// Server is an in-memory File service of a storage account. A Server is safe for concurrent use.
+type Server struct {
+	accountName string
+	accountKey  string
+	credential  *azfile.SharedKeyCredential
+
+	mutex      sync.Mutex
+	shares     map[string]*share // Keyed by share name
+	properties []byte            // The service's properties, as set with Set File Service Properties
+	lastID     uint64            // The last ID handed out to a request, a file, a handle, an ETag or a copy
+}
+
+// NewServer creates a Server for the storage account accountName, with a random account key.
+func NewServer(accountName string) *Server {
+	key := make([]byte, 64)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	s, err := NewServerWithKey(accountName, base64.StdEncoding.EncodeToString(key))
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// NewServerWithKey creates a Server for the storage account accountName, with the base64-encoded account key
+// accountKey, such as the storage emulator's well-known key.
+func NewServerWithKey(accountName string, accountKey string) (*Server, error) {
+	credential, err := azfile.NewSharedKeyCredential(accountName, accountKey)
+	if err != nil {
+		return nil, err
+	}
+	return &Server{accountName: accountName, accountKey: accountKey, credential: credential, shares: map[string]*share{}}, nil
+}
+
+// AccountName returns the name of the server's storage account.
+func (s *Server) AccountName() string {
+	return s.accountName
+}
+
+// AccountKey returns the base64-encoded key of the server's storage account.
+func (s *Server) AccountKey() string {
+	return s.accountKey
+}
+
+// Credential returns a SharedKeyCredential of the server's account, which signs requests that the server accepts and
+// SASs that it honors.
+func (s *Server) Credential() *azfile.SharedKeyCredential {
+	return s.credential
+}
+
+// Endpoint returns the File service endpoint of the server's account when it's served at baseURL, such as the URL of
+// an httptest.Server: baseURL followed by the account name. It panics if baseURL isn't a valid URL.
+func (s *Server) Endpoint(baseURL string) url.URL {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		panic(err)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.accountName
+	return *u
+}
+
+// ServiceURL creates a ServiceURL for the server's account when it's served at baseURL, such as the URL of an
+// httptest.Server, with a pipeline created by azfile.NewPipeline with o that signs requests with the account's key.
+func (s *Server) ServiceURL(baseURL string, o azfile.PipelineOptions) azfile.ServiceURL {
+	return azfile.NewServiceURL(s.Endpoint(baseURL), azfile.NewPipeline(s.credential, o))
+}
+
+// nextID returns a new ID. s.mutex must be held.
+func (s *Server) nextID() uint64 {
+	s.lastID++
+	return s.lastID
+}
+
+// newETag returns a new ETag. s.mutex must be held.
+func (s *Server) newETag() string {
+	return fmt.Sprintf("\"0x8D7%013X\"", s.nextID())
+}
+
+// newUUID returns a new ID formatted as a UUID, as the service's request and copy IDs are. s.mutex must be held.
+func (s *Server) newUUID() string {
+	return fmt.Sprintf("%08x-0000-4000-8000-%012x", s.lastID+1, s.nextID())
+}
+
+// request is a request to the server, with its target parsed out of its URL.
+type request struct {
+	*http.Request
+	body  []byte
+	query url.Values
+
+	// The request's target: the share, the share's snapshot, and the share-relative path of a directory or file, as
+	// in the URL, without leading or trailing slashes. The share is empty for the service's operations.
+	shareName, snapshot, path string
+	restype, comp             string
+
+	// endpoint is the account's File service endpoint, as the request addressed it.
+	endpoint string
+
+	// secure is whether the request came over HTTPS, and remoteIP is the client's IP address, if known.
+	secure   bool
+	remoteIP net.IP
+}
+
+// ServeHTTP serves a request to the File service of the server's account.
+func (s *Server) ServeHTTP(w http.ResponseWriter, httpRequest *http.Request) {
+	r, err := s.parseRequest(httpRequest) // Before locking, as reading the body may take a while
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	h := w.Header()
+	h.Set("x-ms-request-id", s.newUUID())
+	h.Set("x-ms-version", azfile.ServiceVersion)
+	h.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	if err == nil {
+		err = s.authorize(r)
+	}
+	if err == nil {
+		err = s.serve(w, r)
+	}
+	if err != nil {
+		err.write(w, httpRequest)
+	}
+}
+
+// parseRequest reads the body of a request and parses its target.
+func (s *Server) parseRequest(httpRequest *http.Request) (*request, *serviceError) {
+	r, err := s.parseTarget(httpRequest)
+	if err != nil {
+		return nil, err
+	}
+	if httpRequest.Body != nil {
+		body, readErr := ioutil.ReadAll(io.LimitReader(httpRequest.Body, maxRequestBody+1))
+		if readErr != nil {
+			return nil, newError(http.StatusBadRequest, azfile.ServiceCodeInvalidInput, "The request body couldn't be read: %v.", readErr)
+		}
+		if len(body) > maxRequestBody {
+			return nil, newError(http.StatusRequestEntityTooLarge, azfile.ServiceCodeRequestBodyTooLarge, "The request body is too large and exceeds the maximum permissible limit.")
+		}
+		r.body = body
+	}
+	if host, _, splitErr := net.SplitHostPort(httpRequest.RemoteAddr); splitErr == nil {
+		r.remoteIP = net.ParseIP(host)
+	}
+	r.secure = httpRequest.TLS != nil
+	return r, nil
+}
+
+// parseTarget parses the target of a request, a request to the server or the source of a copy, out of its URL. A
+// request to an IP address addresses the account in the first segment of its path, as with the storage emulator;
+// otherwise, the account is the first label of its host.
+func (s *Server) parseTarget(httpRequest *http.Request) (*request, *serviceError) {
+	u := httpRequest.URL
+	host := httpRequest.Host
+	if host == "" {
+		host = u.Host
+	}
+	scheme := "http"
+	if httpRequest.TLS != nil || u.Scheme == "https" {
+		scheme = "https"
+	}
+
+	p := strings.TrimPrefix(u.Path, "/")
+	var account string
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+	ipEndpointStyle := net.ParseIP(strings.Trim(hostname, "[]")) != nil
+	if ipEndpointStyle {
+		account, p = splitPath(p)
+	} else {
+		account = strings.SplitN(hostname, ".", 2)[0]
+	}
+	if account != s.accountName {
+		return nil, newError(http.StatusBadRequest, azfile.ServiceCodeInvalidURI, "The request URI's account %q isn't this server's account.", account)
+	}
+
+	q := u.Query()
+	r := &request{Request: httpRequest, query: q, restype: q.Get("restype"), comp: q.Get("comp"), snapshot: q.Get("sharesnapshot")}
+	r.shareName, p = splitPath(p)
+	r.path = strings.Trim(p, "/")
+	r.endpoint = scheme + "://" + host + "/"
+	if ipEndpointStyle {
+		r.endpoint += account + "/"
+	}
+	return r, nil
+}
+
+// splitPath splits the first segment off a path.
+func splitPath(p string) (first string, rest string) {
+	if i := strings.Index(p, "/"); i >= 0 {
+		return p[:i], p[i+1:]
+	}
+	return p, ""
+}
+
+// serve serves an authorized request. s.mutex must be held.
+func (s *Server) serve(w http.ResponseWriter, r *request) *serviceError {
+	switch {
+	case r.shareName == "":
+		return s.serveService(w, r)
+	case r.restype == "share":
+		if r.path != "" {
+			return newError(http.StatusBadRequest, azfile.ServiceCodeInvalidQueryParameterValue, "The restype of a directory or file can't be share.")
+		}
+		return s.serveShare(w, r)
+	case r.restype == "directory":
+		return s.serveDirectory(w, r)
+	case r.restype == "":
+		if r.path == "" {
+			if r.comp == "listhandles" || r.comp == "forceclosehandles" {
+				return s.serveDirectory(w, r) // The handles of a share's root directory
+			}
+			return newError(http.StatusBadRequest, azfile.ServiceCodeInvalidURI, "The request URI doesn't name a file.")
+		}
+		return s.serveFile(w, r)
+	}
+	return newError(http.StatusBadRequest, azfile.ServiceCodeInvalidQueryParameterValue, "The restype %q isn't supported.", r.restype)
+}
+
+// serviceError is an error response of the service.
+type serviceError struct {
+	status  int
+	code    azfile.ServiceCodeType
+	message string
+}
+
+func newError(status int, code azfile.ServiceCodeType, format string, a ...interface{}) *serviceError {
+	return &serviceError{status: status, code: code, message: fmt.Sprintf(format, a...)}
+}
+
+// unsupported returns the error of a request for an operation that the server doesn't implement.
+func unsupported(r *request) *serviceError {
+	return newError(http.StatusBadRequest, azfile.ServiceCodeUnsupportedQueryParameter,
+		"The %s operation with restype=%q and comp=%q isn't supported.", r.Method, r.restype, r.comp)
+}
+
+// write writes the error response, whose XML body, like the service's, has the error's code and message.
+func (e *serviceError) write(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("x-ms-error-code", string(e.code))
+	if r.Method == http.MethodHead {
+		w.WriteHeader(e.status)
+		return
+	}
+	message := fmt.Sprintf("%s\nRequestId:%s\nTime:%s", e.message, w.Header().Get("x-ms-request-id"), time.Now().UTC().Format(azfile.ISO8601))
+	body, _ := xml.Marshal(struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string   `xml:"Code"`
+		Message string   `xml:"Message"`
+	}{Code: string(e.code), Message: message})
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(e.status)
+	io.WriteString(w, xml.Header)
+	w.Write(body)
+}
+
+// writeXML writes a successful response with an XML body.
+func writeXML(w http.ResponseWriter, v interface{}) *serviceError {
+	body, err := xml.Marshal(v)
+	if err != nil {
+		return newError(http.StatusInternalServerError, azfile.ServiceCodeInternalError, "The response couldn't be encoded: %v.", err)
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, xml.Header)
+	w.Write(body)
+	return nil
+}
+
+// readMetadata returns the metadata in a request's x-ms-meta-* headers, whose names, like HTTP's, are
+// case-insensitive; the returned names are lowercase.
+func readMetadata(r *request) (map[string]string, *serviceError) {
+	metadata := map[string]string{}
+	for k, v := range r.Header {
+		if lk := strings.ToLower(k); strings.HasPrefix(lk, "x-ms-meta-") {
+			name := strings.TrimPrefix(lk, "x-ms-meta-")
+			if name == "" {
+				return nil, newError(http.StatusBadRequest, azfile.ServiceCodeEmptyMetadataKey, "The key for one of the metadata key-value pairs is empty.")
+			}
+			metadata[name] = strings.Join(v, ",")
+		}
+	}
+	return metadata, nil
+}
+
+// writeMetadata writes metadata as x-ms-meta-* headers.
+func writeMetadata(h http.Header, metadata map[string]string) {
+	for k, v := range metadata {
+		h.Set("x-ms-meta-"+k, v)
+	}
+}
+
+// xmlMetadata marshals metadata as the service lists it, an element for each name.
+type xmlMetadata map[string]string
+
+// MarshalXML implements the xml.Marshaler interface.
+func (m xmlMetadata) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	for _, k := range sortedKeys(m) {
+		if err := e.EncodeElement(m[k], xml.StartElement{Name: xml.Name{Local: k}}); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+// copyMetadata returns a copy of metadata.
+func copyMetadata(metadata map[string]string) map[string]string {
+	c := make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		c[k] = v
+	}
+	return c
+}
@@ -0,0 +1,486 @@
+package azfiletest_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-storage-file-go/azfile"
+	"github.com/Azure/azure-storage-file-go/azfile/azfiletest"
+	chk "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { chk.TestingT(t) }
+
+type ServerSuite struct{}
+
+var _ = chk.Suite(&ServerSuite{})
+
+// startTestServer serves a Server of the account "myaccount" with an httptest.Server, returning them, along with a
+// ServiceURL of the account whose pipeline signs requests with the account's key.
+func startTestServer() (*azfiletest.Server, *httptest.Server, azfile.ServiceURL) {
+	server := azfiletest.NewServer("myaccount")
+	ts := httptest.NewServer(server)
+	return server, ts, server.ServiceURL(ts.URL, azfile.PipelineOptions{})
+}
+
+// createTestShare creates the share "share", with a directory "dir" holding a file "file" of the specified content.
+func createTestShare(c *chk.C, serviceURL azfile.ServiceURL, data []byte) (azfile.ShareURL, azfile.FileURL) {
+	ctx := context.Background()
+	shareURL := serviceURL.NewShareURL("share")
+	_, err := shareURL.Create(ctx, azfile.Metadata{}, 0)
+	c.Assert(err, chk.IsNil)
+	dirURL := shareURL.NewDirectoryURL("dir")
+	_, err = dirURL.Create(ctx, azfile.Metadata{}, azfile.SMBProperties{})
+	c.Assert(err, chk.IsNil)
+	fileURL := dirURL.NewFileURL("file")
+	c.Assert(azfile.UploadBufferToAzureFile(ctx, data, fileURL, azfile.UploadToAzureFileOptions{}), chk.IsNil)
+	return shareURL, fileURL
+}
+
+func serviceCode(err error) azfile.ServiceCodeType {
+	if storageErr, ok := err.(azfile.StorageError); ok {
+		return storageErr.ServiceCode()
+	}
+	return ""
+}
+
+func (s *ServerSuite) TestListSharesWithMarkersAndSnapshots(c *chk.C) {
+	_, ts, serviceURL := startTestServer()
+	defer ts.Close()
+	ctx := context.Background()
+	for _, name := range []string{"share-c", "share-a", "other", "share-b"} {
+		_, err := serviceURL.NewShareURL(name).Create(ctx, azfile.Metadata{"name": name}, 10)
+		c.Assert(err, chk.IsNil)
+	}
+	snapshot, err := serviceURL.NewShareURL("share-b").CreateSnapshot(ctx, azfile.Metadata{})
+	c.Assert(err, chk.IsNil)
+
+	listed := []string{}
+	for marker := (azfile.Marker{}); marker.NotDone(); {
+		response, err := serviceURL.ListSharesSegment(ctx, marker, azfile.ListSharesOptions{Prefix: "share-", MaxResults: 2,
+			Detail: azfile.ListSharesDetail{Metadata: true, Snapshots: true}})
+		c.Assert(err, chk.IsNil)
+		for _, share := range response.ShareItems {
+			c.Assert(share.Metadata["name"], chk.Equals, share.Name)
+			c.Assert(share.Properties.Quota, chk.Equals, int32(10))
+			if share.Snapshot != nil {
+				listed = append(listed, share.Name+"@"+*share.Snapshot)
+			} else {
+				listed = append(listed, share.Name)
+			}
+		}
+		marker = response.NextMarker
+	}
+	c.Assert(listed, chk.DeepEquals, []string{"share-a", "share-b@" + snapshot.Snapshot(), "share-b", "share-c"})
+
+	// A share with snapshots is only deleted along with them.
+	_, err = serviceURL.NewShareURL("share-b").Delete(ctx, azfile.DeleteSnapshotsOptionNone)
+	c.Assert(serviceCode(err), chk.Equals, azfile.ServiceCodeShareHasSnapshots)
+	_, err = serviceURL.NewShareURL("share-b").Delete(ctx, azfile.DeleteSnapshotsOptionInclude)
+	c.Assert(err, chk.IsNil)
+	_, err = serviceURL.NewShareURL("share-b").GetProperties(ctx)
+	c.Assert(serviceCode(err), chk.Equals, azfile.ServiceCodeShareNotFound)
+}
+
+func (s *ServerSuite) TestShareProperties(c *chk.C) {
+	_, ts, serviceURL := startTestServer()
+	defer ts.Close()
+	ctx := context.Background()
+	shareURL, _ := createTestShare(c, serviceURL, make([]byte, 1000))
+
+	_, err := shareURL.SetMetadata(ctx, azfile.Metadata{"foo": "bar"})
+	c.Assert(err, chk.IsNil)
+	_, err = shareURL.SetQuota(ctx, 100)
+	c.Assert(err, chk.IsNil)
+	properties, err := shareURL.GetProperties(ctx)
+	c.Assert(err, chk.IsNil)
+	c.Assert(properties.NewMetadata(), chk.DeepEquals, azfile.Metadata{"foo": "bar"})
+	c.Assert(properties.Quota(), chk.Equals, int32(100))
+
+	stats, err := shareURL.GetStatistics(ctx)
+	c.Assert(err, chk.IsNil)
+	c.Assert(stats.ShareUsageBytes, chk.Equals, int32(1000))
+
+	_, err = shareURL.Create(ctx, azfile.Metadata{}, 0)
+	c.Assert(serviceCode(err), chk.Equals, azfile.ServiceCodeShareAlreadyExists)
+	_, err = serviceURL.NewShareURL("Invalid_Name").Create(ctx, azfile.Metadata{}, 0)
+	c.Assert(serviceCode(err), chk.Equals, azfile.ServiceCodeInvalidResourceName)
+
+	properties2 := azfile.FileServiceProperties{HourMetrics: azfile.MetricProperties{MetricEnabled: true, IncludeAPIs: true,
+		RetentionPolicyEnabled: true, RetentionDays: 7}}
+	_, err = serviceURL.SetProperties(ctx, properties2)
+	c.Assert(err, chk.IsNil)
+	got, err := serviceURL.GetProperties(ctx)
+	c.Assert(err, chk.IsNil)
+	c.Assert(got.HourMetrics, chk.DeepEquals, properties2.HourMetrics)
+	c.Assert(got.MinuteMetrics.MetricEnabled, chk.Equals, false)
+}
+
+func (s *ServerSuite) TestDirectoriesAndListing(c *chk.C) {
+	_, ts, serviceURL := startTestServer()
+	defer ts.Close()
+	ctx := context.Background()
+	shareURL, _ := createTestShare(c, serviceURL, []byte("data"))
+	dirURL := shareURL.NewDirectoryURL("dir")
+	for _, name := range []string{"b", "d", "a"} {
+		_, err := dirURL.NewDirectoryURL(name).Create(ctx, azfile.Metadata{}, azfile.SMBProperties{})
+		c.Assert(err, chk.IsNil)
+	}
+	_, err := dirURL.NewFileURL("c").Create(ctx, 5, azfile.FileHTTPHeaders{}, azfile.Metadata{})
+	c.Assert(err, chk.IsNil)
+
+	listed := []string{}
+	for marker := (azfile.Marker{}); marker.NotDone(); {
+		response, err := dirURL.ListFilesAndDirectoriesSegment(ctx, marker, azfile.ListFilesAndDirectoriesOptions{MaxResults: 2})
+		c.Assert(err, chk.IsNil)
+		c.Assert(response.DirectoryPath, chk.Equals, "dir")
+		for _, d := range response.DirectoryItems {
+			listed = append(listed, d.Name+"/")
+		}
+		for _, f := range response.FileItems {
+			listed = append(listed, f.Name)
+			c.Assert(f.Properties.ContentLength, chk.Equals, map[string]int64{"c": 5, "file": 4}[f.Name])
+		}
+		marker = response.NextMarker
+	}
+	c.Assert(listed, chk.DeepEquals, []string{"a/", "b/", "d/", "c", "file"}) // Directories precede files on each page
+
+	// Names are case-insensitive.
+	_, err = shareURL.NewDirectoryURL("DIR").NewDirectoryURL("A").Create(ctx, azfile.Metadata{}, azfile.SMBProperties{})
+	c.Assert(serviceCode(err), chk.Equals, azfile.ServiceCodeResourceAlreadyExists)
+	_, err = shareURL.NewDirectoryURL("Dir").NewFileURL("FILE").GetProperties(ctx)
+	c.Assert(err, chk.IsNil)
+
+	_, err = dirURL.Delete(ctx)
+	c.Assert(serviceCode(err), chk.Equals, azfile.ServiceCodeDirectoryNotEmpty)
+	_, err = shareURL.NewDirectoryURL("missing").NewFileURL("file").Create(ctx, 0, azfile.FileHTTPHeaders{}, azfile.Metadata{})
+	c.Assert(serviceCode(err), chk.Equals, azfile.ServiceCodeParentNotFound)
+	_, err = dirURL.NewFileURL("bad:name").Create(ctx, 0, azfile.FileHTTPHeaders{}, azfile.Metadata{})
+	c.Assert(serviceCode(err), chk.Equals, azfile.ServiceCodeInvalidResourceName)
+}
+
+func (s *ServerSuite) TestFileRanges(c *chk.C) {
+	_, ts, serviceURL := startTestServer()
+	defer ts.Close()
+	ctx := context.Background()
+	data := make([]byte, 300*1024)
+	for i := range data {
+		data[i] = byte(i%251 + 1)
+	}
+	_, fileURL := createTestShare(c, serviceURL, data)
+
+	downloaded := make([]byte, len(data))
+	_, err := azfile.DownloadAzureFileToBuffer(ctx, fileURL, downloaded, azfile.DownloadFromAzureFileOptions{RangeSize: 64 * 1024})
+	c.Assert(err, chk.IsNil)
+	c.Assert(downloaded, chk.DeepEquals, data)
+
+	// Clearing a range zeros it and removes it from the range list.
+	_, err = fileURL.ClearRange(ctx, 100*1024, 100*1024)
+	c.Assert(err, chk.IsNil)
+	ranges, err := fileURL.GetRangeList(ctx, 0, azfile.CountToEnd)
+	c.Assert(err, chk.IsNil)
+	c.Assert(ranges.Items, chk.DeepEquals, []azfile.Range{{Start: 0, End: 100*1024 - 1}, {Start: 200 * 1024, End: 300*1024 - 1}})
+
+	response, err := fileURL.Download(ctx, 100*1024-10, 20, true)
+	c.Assert(err, chk.IsNil)
+	body, err := ioutil.ReadAll(response.Body(azfile.RetryReaderOptions{}))
+	c.Assert(err, chk.IsNil)
+	expected := append(append([]byte{}, data[100*1024-10:100*1024]...), make([]byte, 10)...)
+	c.Assert(body, chk.DeepEquals, expected)
+	sum := md5.Sum(expected)
+	c.Assert(response.ContentMD5(), chk.DeepEquals, sum[:])
+	c.Assert(response.ContentRange(), chk.Equals, "bytes 102390-102409/307200")
+
+	// Resizing truncates the file and its ranges; growing it again reads zeros.
+	_, err = fileURL.Resize(ctx, 10)
+	c.Assert(err, chk.IsNil)
+	_, err = fileURL.Resize(ctx, 20)
+	c.Assert(err, chk.IsNil)
+	response, err = fileURL.Download(ctx, 0, azfile.CountToEnd, false)
+	c.Assert(err, chk.IsNil)
+	body, _ = ioutil.ReadAll(response.Body(azfile.RetryReaderOptions{}))
+	c.Assert(body, chk.DeepEquals, append(append([]byte{}, data[:10]...), make([]byte, 10)...))
+
+	_, err = fileURL.UploadRange(ctx, 0, bytes.NewReader([]byte("data")), []byte("0123456789abcdef"))
+	c.Assert(serviceCode(err), chk.Equals, azfile.ServiceCodeMd5Mismatch)
+	_, err = fileURL.UploadRange(ctx, 18, bytes.NewReader([]byte("data")), nil)
+	c.Assert(serviceCode(err), chk.Equals, azfile.ServiceCodeInvalidRange)
+	_, err = fileURL.Download(ctx, 20, 10, false)
+	c.Assert(serviceCode(err), chk.Equals, azfile.ServiceCodeInvalidRange)
+}
+
+func (s *ServerSuite) TestLargeSparseFile(c *chk.C) {
+	_, ts, serviceURL := startTestServer()
+	defer ts.Close()
+	ctx := context.Background()
+	shareURL, _ := createTestShare(c, serviceURL, nil)
+	fileURL := shareURL.NewRootDirectoryURL().NewFileURL("large")
+
+	// A 100GiB file only takes the memory of its written ranges.
+	_, err := fileURL.Create(ctx, 100<<30, azfile.FileHTTPHeaders{}, azfile.Metadata{})
+	c.Assert(err, chk.IsNil)
+	_, err = fileURL.UploadRange(ctx, 50<<30, bytes.NewReader([]byte("data")), nil)
+	c.Assert(err, chk.IsNil)
+	response, err := fileURL.Download(ctx, 50<<30-2, 8, false)
+	c.Assert(err, chk.IsNil)
+	body, _ := ioutil.ReadAll(response.Body(azfile.RetryReaderOptions{}))
+	c.Assert(body, chk.DeepEquals, []byte("\x00\x00data\x00\x00"))
+}
+
+func (s *ServerSuite) TestSnapshots(c *chk.C) {
+	_, ts, serviceURL := startTestServer()
+	defer ts.Close()
+	ctx := context.Background()
+	shareURL, fileURL := createTestShare(c, serviceURL, []byte("before"))
+	snapshot, err := shareURL.CreateSnapshot(ctx, azfile.Metadata{"snapshot": "yes"})
+	c.Assert(err, chk.IsNil)
+	c.Assert(azfile.UploadBufferToAzureFile(ctx, []byte("after!"), fileURL, azfile.UploadToAzureFileOptions{}), chk.IsNil)
+
+	snapshotFileURL := fileURL.WithSnapshot(snapshot.Snapshot())
+	response, err := snapshotFileURL.Download(ctx, 0, azfile.CountToEnd, false)
+	c.Assert(err, chk.IsNil)
+	body, _ := ioutil.ReadAll(response.Body(azfile.RetryReaderOptions{}))
+	c.Assert(string(body), chk.Equals, "before")
+	properties, err := shareURL.WithSnapshot(snapshot.Snapshot()).GetProperties(ctx)
+	c.Assert(err, chk.IsNil)
+	c.Assert(properties.NewMetadata(), chk.DeepEquals, azfile.Metadata{"snapshot": "yes"})
+
+	// A snapshot can't be written to, but can be deleted.
+	_, err = snapshotFileURL.Resize(ctx, 0)
+	c.Assert(serviceCode(err), chk.Equals, azfile.ServiceCodeShareSnapshotOperationNotSupported)
+	_, err = shareURL.WithSnapshot(snapshot.Snapshot()).Delete(ctx, azfile.DeleteSnapshotsOptionNone)
+	c.Assert(err, chk.IsNil)
+	_, err = snapshotFileURL.GetProperties(ctx)
+	c.Assert(serviceCode(err), chk.Equals, azfile.ServiceCodeShareNotFound)
+	_, err = shareURL.Delete(ctx, azfile.DeleteSnapshotsOptionNone)
+	c.Assert(err, chk.IsNil)
+}
+
+func (s *ServerSuite) TestPropertiesAndPermissions(c *chk.C) {
+	_, ts, serviceURL := startTestServer()
+	defer ts.Close()
+	ctx := context.Background()
+	shareURL, fileURL := createTestShare(c, serviceURL, []byte("data"))
+
+	permission := "O:BAG:BAD:(A;;FA;;;BA)"
+	created, err := shareURL.CreatePermission(ctx, permission)
+	c.Assert(err, chk.IsNil)
+	got, err := shareURL.GetPermission(ctx, created.FilePermissionKey())
+	c.Assert(err, chk.IsNil)
+	c.Assert(got.Permission, chk.Equals, permission)
+
+	attributes := azfile.FileAttributeReadonly | azfile.FileAttributeHidden
+	creationTime := time.Date(2020, 1, 2, 3, 4, 5, 600, time.UTC)
+	key := created.FilePermissionKey()
+	_, err = fileURL.SetHTTPHeaders(ctx, azfile.FileHTTPHeaders{ContentType: "text/plain", SMBProperties: azfile.SMBProperties{
+		FileAttributes: &attributes, FileCreationTime: &creationTime, PermissionKey: &key}})
+	c.Assert(err, chk.IsNil)
+	_, err = fileURL.SetMetadata(ctx, azfile.Metadata{"foo": "bar"})
+	c.Assert(err, chk.IsNil)
+
+	properties, err := fileURL.GetProperties(ctx)
+	c.Assert(err, chk.IsNil)
+	c.Assert(properties.ContentType(), chk.Equals, "text/plain")
+	c.Assert(properties.ContentLength(), chk.Equals, int64(4))
+	c.Assert(properties.NewMetadata(), chk.DeepEquals, azfile.Metadata{"foo": "bar"})
+	c.Assert(properties.FilePermissionKey(), chk.Equals, key)
+	adapter := azfile.SMBPropertyAdapter{PropertySource: properties}
+	c.Assert(adapter.FileAttributes(), chk.Equals, attributes)
+	c.Assert(adapter.FileCreationTime().Equal(creationTime), chk.Equals, true)
+
+	// A read-only file's content can't be changed.
+	_, err = fileURL.UploadRange(ctx, 0, bytes.NewReader([]byte("DATA")), nil)
+	c.Assert(serviceCode(err), chk.Equals, azfile.ServiceCodeReadOnlyAttribute)
+
+	// New directories and files inherit their parent's permission.
+	dirURL := shareURL.NewDirectoryURL("dir")
+	_, err = dirURL.SetProperties(ctx, azfile.SMBProperties{PermissionKey: &key})
+	c.Assert(err, chk.IsNil)
+	_, err = dirURL.NewFileURL("inherited").Create(ctx, 0, azfile.FileHTTPHeaders{}, azfile.Metadata{})
+	c.Assert(err, chk.IsNil)
+	inherited, err := dirURL.NewFileURL("inherited").GetProperties(ctx)
+	c.Assert(err, chk.IsNil)
+	c.Assert(inherited.FilePermissionKey(), chk.Equals, key)
+	c.Assert(inherited.FileAttributes(), chk.Equals, "Archive")
+}
+
+func (s *ServerSuite) TestHandles(c *chk.C) {
+	server, ts, serviceURL := startTestServer()
+	defer ts.Close()
+	ctx := context.Background()
+	shareURL, fileURL := createTestShare(c, serviceURL, []byte("data"))
+	fileHandle, err := server.OpenHandle("share", "dir/file", "10.0.0.1")
+	c.Assert(err, chk.IsNil)
+	_, err = server.OpenHandle("share", "dir", "10.0.0.2")
+	c.Assert(err, chk.IsNil)
+	_, err = server.OpenHandle("share", "dir/missing", "10.0.0.2")
+	c.Assert(err, chk.NotNil)
+
+	handles, err := fileURL.ListAllHandles(ctx, azfile.ListHandlesOptions{})
+	c.Assert(err, chk.IsNil)
+	c.Assert(handles, chk.HasLen, 1)
+	c.Assert(handles[0].HandleID, chk.Equals, fileHandle)
+	c.Assert(handles[0].Path, chk.Equals, "dir/file")
+	c.Assert(handles[0].ClientIP, chk.Equals, "10.0.0.1")
+
+	rootURL := shareURL.NewRootDirectoryURL()
+	handles, err = rootURL.ListAllHandles(ctx, azfile.ListHandlesOptions{Recursive: true, MaxResults: 1})
+	c.Assert(err, chk.IsNil)
+	c.Assert(handles, chk.HasLen, 2)
+	handles, err = rootURL.ListAllHandles(ctx, azfile.ListHandlesOptions{})
+	c.Assert(err, chk.IsNil)
+	c.Assert(handles, chk.HasLen, 0)
+
+	closed, err := rootURL.ForceCloseAllHandles(ctx, azfile.ForceCloseHandlesOptions{Recursive: true})
+	c.Assert(err, chk.IsNil)
+	c.Assert(closed, chk.Equals, int32(2))
+	handles, err = rootURL.ListAllHandles(ctx, azfile.ListHandlesOptions{Recursive: true})
+	c.Assert(err, chk.IsNil)
+	c.Assert(handles, chk.HasLen, 0)
+}
+
+func (s *ServerSuite) TestCopies(c *chk.C) {
+	server, ts, serviceURL := startTestServer()
+	defer ts.Close()
+	ctx := context.Background()
+	shareURL, fileURL := createTestShare(c, serviceURL, []byte("0123456789"))
+
+	// A copy within the account may be authorized by the copy itself.
+	copyURL := shareURL.NewRootDirectoryURL().NewFileURL("copy")
+	started, err := copyURL.StartCopy(ctx, fileURL.URL(), azfile.Metadata{})
+	c.Assert(err, chk.IsNil)
+	c.Assert(started.CopyStatus(), chk.Equals, azfile.CopyStatusSuccess)
+	properties, err := copyURL.GetProperties(ctx)
+	c.Assert(err, chk.IsNil)
+	c.Assert(properties.CopyID(), chk.Equals, started.CopyID())
+	c.Assert(properties.ContentLength(), chk.Equals, int64(10))
+
+	// Put Range From URL requires the source to carry a SAS.
+	_, err = copyURL.UploadRangeFromURL(ctx, fileURL.URL(), 0, 5, 5)
+	c.Assert(serviceCode(err), chk.Equals, azfile.ServiceCodeType("CannotVerifyCopySource"))
+	sas, err := azfile.FileSASSignatureValues{ExpiryTime: time.Now().Add(time.Hour), ShareName: "share", FilePath: "dir/file",
+		Permissions: azfile.FileSASPermissions{Read: true}.String()}.NewSASQueryParameters(server.Credential())
+	c.Assert(err, chk.IsNil)
+	sourceURL := fileURL.URL()
+	sourceURL.RawQuery = sas.Encode()
+	_, err = copyURL.UploadRangeFromURL(ctx, sourceURL, 0, 5, 5)
+	c.Assert(err, chk.IsNil)
+	response, err := copyURL.Download(ctx, 0, azfile.CountToEnd, false)
+	c.Assert(err, chk.IsNil)
+	body, _ := ioutil.ReadAll(response.Body(azfile.RetryReaderOptions{}))
+	c.Assert(string(body), chk.Equals, "0123401234")
+
+	_, err = copyURL.AbortCopy(ctx, started.CopyID())
+	c.Assert(serviceCode(err), chk.Equals, azfile.ServiceCodeNoPendingCopyOperation)
+}
+
+func (s *ServerSuite) TestSharedKeyAuthorization(c *chk.C) {
+	server, ts, serviceURL := startTestServer()
+	defer ts.Close()
+	ctx := context.Background()
+	createTestShare(c, serviceURL, []byte("data"))
+
+	otherKey, err := azfile.NewSharedKeyCredential("myaccount", azfiletest.NewServer("myaccount").AccountKey())
+	c.Assert(err, chk.IsNil)
+	wrongKeyURL := azfile.NewServiceURL(server.Endpoint(ts.URL), azfile.NewPipeline(otherKey, azfile.PipelineOptions{}))
+	_, err = wrongKeyURL.NewShareURL("share").GetProperties(ctx)
+	c.Assert(serviceCode(err), chk.Equals, azfile.ServiceCodeAuthenticationFailed)
+
+	anonymousURL := azfile.NewServiceURL(server.Endpoint(ts.URL), azfile.NewPipeline(azfile.NewAnonymousCredential(), azfile.PipelineOptions{}))
+	_, err = anonymousURL.NewShareURL("share").GetProperties(ctx)
+	c.Assert(err.(azfile.StorageError).Response().StatusCode, chk.Equals, http.StatusUnauthorized)
+
+	// The connection string of the server's account works too.
+	connectionString := "AccountName=myaccount;AccountKey=" + server.AccountKey() + ";FileEndpoint=" + ts.URL + "/myaccount"
+	connectionStringURL, err := azfile.NewServiceURLFromConnectionString(connectionString, azfile.PipelineOptions{})
+	c.Assert(err, chk.IsNil)
+	_, err = connectionStringURL.NewShareURL("share").GetProperties(ctx)
+	c.Assert(err, chk.IsNil)
+}
+
+// withSAS returns a URL of the server with the SAS's query parameters.
+func withSAS(u azfile.FileURL, sas azfile.SASQueryParameters) azfile.FileURL {
+	sasURL := u.URL()
+	sasURL.RawQuery = sas.Encode()
+	return azfile.NewFileURL(sasURL, azfile.NewPipeline(azfile.NewAnonymousCredential(), azfile.PipelineOptions{}))
+}
+
+func (s *ServerSuite) TestServiceSASAuthorization(c *chk.C) {
+	server, ts, serviceURL := startTestServer()
+	defer ts.Close()
+	ctx := context.Background()
+	shareURL, fileURL := createTestShare(c, serviceURL, []byte("data"))
+	newSAS := func(v azfile.FileSASSignatureValues) azfile.SASQueryParameters {
+		v.ShareName = "share"
+		if v.ExpiryTime.IsZero() {
+			v.ExpiryTime = time.Now().Add(time.Hour)
+		}
+		sas, err := v.NewSASQueryParameters(server.Credential())
+		c.Assert(err, chk.IsNil)
+		return sas
+	}
+
+	readOnly := withSAS(fileURL, newSAS(azfile.FileSASSignatureValues{FilePath: "dir/file", Permissions: "r", ContentType: "text/csv"}))
+	response, err := readOnly.Download(ctx, 0, azfile.CountToEnd, false)
+	c.Assert(err, chk.IsNil)
+	c.Assert(response.ContentType(), chk.Equals, "text/csv")
+	_, err = readOnly.Resize(ctx, 0)
+	c.Assert(serviceCode(err), chk.Equals, azfile.ServiceCodeType("AuthorizationPermissionMismatch"))
+
+	// A file's SAS doesn't authorize other files, while a share's does.
+	otherFileURL := shareURL.NewRootDirectoryURL().NewFileURL("other")
+	_, err = withSAS(otherFileURL, newSAS(azfile.FileSASSignatureValues{FilePath: "dir/file", Permissions: "rcw"})).Create(ctx, 0, azfile.FileHTTPHeaders{}, azfile.Metadata{})
+	c.Assert(serviceCode(err), chk.Equals, azfile.ServiceCodeAuthenticationFailed)
+	_, err = withSAS(otherFileURL, newSAS(azfile.FileSASSignatureValues{Permissions: "rcw"})).Create(ctx, 0, azfile.FileHTTPHeaders{}, azfile.Metadata{})
+	c.Assert(err, chk.IsNil)
+
+	expired := withSAS(fileURL, newSAS(azfile.FileSASSignatureValues{FilePath: "dir/file", Permissions: "r", ExpiryTime: time.Now().Add(-time.Minute)}))
+	_, err = expired.GetProperties(ctx)
+	c.Assert(serviceCode(err), chk.Equals, azfile.ServiceCodeAuthenticationFailed)
+	httpsOnly := withSAS(fileURL, newSAS(azfile.FileSASSignatureValues{FilePath: "dir/file", Permissions: "r", Protocol: azfile.SASProtocolHTTPS}))
+	_, err = httpsOnly.GetProperties(ctx)
+	c.Assert(serviceCode(err), chk.Equals, azfile.ServiceCodeType("AuthorizationProtocolMismatch"))
+
+	// A SAS may get its permissions and expiry from a stored access policy.
+	expiry, permissions := time.Now().Add(time.Hour).UTC().Truncate(time.Second), "r"
+	_, err = shareURL.SetPermissions(ctx, []azfile.SignedIdentifier{{ID: "policy", AccessPolicy: &azfile.AccessPolicy{Expiry: &expiry, Permission: &permissions}}})
+	c.Assert(err, chk.IsNil)
+	sas, err := azfile.FileSASSignatureValues{ShareName: "share", Identifier: "policy"}.NewSASQueryParameters(server.Credential())
+	c.Assert(err, chk.IsNil)
+	_, err = withSAS(fileURL, sas).GetProperties(ctx)
+	c.Assert(err, chk.IsNil)
+	_, err = withSAS(fileURL, sas).Delete(ctx)
+	c.Assert(serviceCode(err), chk.Equals, azfile.ServiceCodeType("AuthorizationPermissionMismatch"))
+}
+
+func (s *ServerSuite) TestAccountSASAuthorization(c *chk.C) {
+	server, ts, serviceURL := startTestServer()
+	defer ts.Close()
+	ctx := context.Background()
+	createTestShare(c, serviceURL, []byte("data"))
+
+	newServiceURL := func(resourceTypes string, permissions string) azfile.ServiceURL {
+		sas, err := azfile.AccountSASSignatureValues{ExpiryTime: time.Now().Add(time.Hour), Services: "f", ResourceTypes: resourceTypes,
+			Permissions: permissions}.NewSASQueryParameters(server.Credential())
+		c.Assert(err, chk.IsNil)
+		u := server.Endpoint(ts.URL)
+		u.RawQuery = sas.Encode()
+		return azfile.NewServiceURL(u, azfile.NewPipeline(azfile.NewAnonymousCredential(), azfile.PipelineOptions{}))
+	}
+
+	response, err := newServiceURL("s", "l").ListSharesSegment(ctx, azfile.Marker{}, azfile.ListSharesOptions{})
+	c.Assert(err, chk.IsNil)
+	c.Assert(response.ShareItems, chk.HasLen, 1)
+	_, err = newServiceURL("s", "l").NewShareURL("share").GetProperties(ctx)
+	c.Assert(serviceCode(err), chk.Equals, azfile.ServiceCodeType("AuthorizationResourceTypeMismatch"))
+	_, err = newServiceURL("sco", "r").NewShareURL("share").NewDirectoryURL("dir").NewFileURL("file").GetProperties(ctx)
+	c.Assert(err, chk.IsNil)
+	_, err = newServiceURL("sco", "r").NewShareURL("share").NewDirectoryURL("dir").NewFileURL("file").Delete(ctx)
+	c.Assert(serviceCode(err), chk.Equals, azfile.ServiceCodeType("AuthorizationPermissionMismatch"))
+}
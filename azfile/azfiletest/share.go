@@ -0,0 +1,492 @@
+package azfiletest
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-storage-file-go/azfile"
+)
+
+const (
+	// defaultShareQuota is the quota, in GiB, of a share created without one, and maxShareQuota the largest quota.
+	defaultShareQuota = 5120
+	maxShareQuota     = 102400
+
+	// defaultPermission is the permission, in SDDL, of a share's root directory.
+	defaultPermission = "O:SYG:SYD:(A;OICI;FA;;;SY)(A;OICI;FA;;;BA)(A;OICI;0x1301bf;;;AU)(A;OICI;0x1200a9;;;BU)"
+
+	// maxHeaderPermission is the size of the largest permission that a request's x-ms-file-permission header may
+	// have; larger ones must be created with Create Permission.
+	maxHeaderPermission = 8 * 1024
+
+	// maxSignedIdentifiers is the number of stored access policies that a share may have.
+	maxSignedIdentifiers = 5
+)
+
+// share is a share or a share's snapshot.
+type share struct {
+	name         string
+	snapshot     string // Empty for the share itself
+	metadata     map[string]string
+	quota        int32
+	etag         string
+	lastModified time.Time
+
+	// nodes holds the share's directories and files, keyed by their lowercase share-relative paths: names are
+	// case-insensitive, but preserve their case. The root directory's path is "".
+	nodes map[string]*node
+
+	// permissions holds the permissions, in SDDL, of the share's directories and files, keyed by permission key.
+	permissions map[string]string
+
+	// Only the share itself has these.
+	signedIdentifiers []azfile.SignedIdentifier
+	snapshots         map[string]*share
+	handles           []*handle // Sorted by ID
+}
+
+// validShareName returns whether name is a valid share name: 3 to 63 lowercase letters, digits and hyphens, each
+// hyphen preceded and followed by a letter or a digit.
+func validShareName(name string) bool {
+	if len(name) < 3 || len(name) > 63 || name[0] == '-' || name[len(name)-1] == '-' || strings.Contains(name, "--") {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// touch changes the share's ETag and last modified time. s.mutex must be held.
+func (s *Server) touchShare(sh *share) {
+	sh.etag, sh.lastModified = s.newETag(), time.Now().UTC()
+}
+
+// lookupShare returns r's share or, if r has a sharesnapshot parameter, its snapshot, which can only be read.
+func (s *Server) lookupShare(r *request, write bool) (*share, *serviceError) {
+	sh, ok := s.shares[r.shareName]
+	if !ok {
+		return nil, newError(http.StatusNotFound, azfile.ServiceCodeShareNotFound, "The specified share does not exist.")
+	}
+	if r.snapshot == "" {
+		return sh, nil
+	}
+	if write {
+		return nil, newError(http.StatusBadRequest, azfile.ServiceCodeShareSnapshotOperationNotSupported, "The operation is not supported on a share snapshot.")
+	}
+	if snapshot, ok := sh.snapshots[r.snapshot]; ok {
+		return snapshot, nil
+	}
+	return nil, newError(http.StatusNotFound, azfile.ServiceCodeShareNotFound, "The specified share snapshot does not exist.")
+}
+
+// storedAccessPolicy is a share's stored access policy, with its times as a SAS has them.
+type storedAccessPolicy struct {
+	permission, start, expiry string
+}
+
+// accessPolicy returns share's stored access policy with the specified identifier. s.mutex must be held.
+func (s *Server) accessPolicy(shareName string, identifier string) (storedAccessPolicy, bool) {
+	sh, ok := s.shares[shareName]
+	if !ok {
+		return storedAccessPolicy{}, false
+	}
+	for _, si := range sh.signedIdentifiers {
+		if si.ID != identifier {
+			continue
+		}
+		p := storedAccessPolicy{}
+		if ap := si.AccessPolicy; ap != nil {
+			if ap.Permission != nil {
+				p.permission = *ap.Permission
+			}
+			if ap.Start != nil {
+				p.start = ap.Start.UTC().Format(azfile.SASTimeFormat)
+			}
+			if ap.Expiry != nil {
+				p.expiry = ap.Expiry.UTC().Format(azfile.SASTimeFormat)
+			}
+		}
+		return p, true
+	}
+	return storedAccessPolicy{}, false
+}
+
+// permissionKey returns the key of a permission, which, like the service's, is the same for the same permission.
+func permissionKey(permission string) string {
+	h1, h2 := fnv.New64(), fnv.New64a()
+	h1.Write([]byte(permission))
+	h2.Write([]byte(permission))
+	return fmt.Sprintf("%d*%d", h1.Sum64(), h2.Sum64())
+}
+
+// addPermission adds a permission to the share, returning its key.
+func (sh *share) addPermission(permission string) string {
+	key := permissionKey(permission)
+	sh.permissions[key] = permission
+	return key
+}
+
+// serveService serves the service's operations. s.mutex must be held.
+func (s *Server) serveService(w http.ResponseWriter, r *request) *serviceError {
+	switch {
+	case r.Method == http.MethodGet && r.comp == "list":
+		return s.listShares(w, r)
+	case r.restype == "service" && r.comp == "properties" && r.Method == http.MethodGet:
+		if s.properties == nil {
+			return writeXML(w, defaultServiceProperties())
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		w.WriteHeader(http.StatusOK)
+		w.Write(s.properties)
+		return nil
+	case r.restype == "service" && r.comp == "properties" && r.Method == http.MethodPut:
+		properties := azfile.StorageServiceProperties{}
+		if err := xml.Unmarshal(r.body, &properties); err != nil {
+			return newError(http.StatusBadRequest, azfile.ServiceCodeInvalidXMLDocument, "XML specified is not syntactically valid: %v.", err)
+		}
+		if properties.HourMetrics == nil || properties.MinuteMetrics == nil {
+			return newError(http.StatusBadRequest, azfile.ServiceCodeMissingRequiredXMLNode, "The HourMetrics and MinuteMetrics are required.")
+		}
+		s.properties = append([]byte(xml.Header), r.body...)
+		w.WriteHeader(http.StatusAccepted)
+		return nil
+	}
+	return unsupported(r)
+}
+
+// defaultServiceProperties returns the service's properties before they're set: metrics disabled and no CORS rules.
+func defaultServiceProperties() azfile.StorageServiceProperties {
+	disabled := func() *azfile.Metrics {
+		return &azfile.Metrics{Version: "1.0", RetentionPolicy: &azfile.RetentionPolicy{}}
+	}
+	return azfile.StorageServiceProperties{HourMetrics: disabled(), MinuteMetrics: disabled(), Cors: []azfile.CorsRule{}}
+}
+
+// listedShare is a share in the list of shares.
+type listedShare struct {
+	Name       string `xml:"Name"`
+	Snapshot   string `xml:"Snapshot,omitempty"`
+	Properties struct {
+		LastModified string `xml:"Last-Modified"`
+		Etag         string `xml:"Etag"`
+		Quota        int32  `xml:"Quota"`
+	} `xml:"Properties"`
+	Metadata xmlMetadata `xml:"Metadata,omitempty"`
+}
+
+// listShares serves List Shares. The shares are listed by name, each after its snapshots if those are included.
+func (s *Server) listShares(w http.ResponseWriter, r *request) *serviceError {
+	include := map[string]bool{}
+	for _, i := range strings.Split(r.query.Get("include"), ",") {
+		include[i] = true
+	}
+	maxResults, err := parseMaxResults(r)
+	if err != nil {
+		return err
+	}
+
+	// The marker is the name of the next share, and the next snapshot's time, or "~", which sorts after all times.
+	type entry struct {
+		key string
+		sh  *share
+	}
+	entries := []entry{}
+	for name, sh := range s.shares {
+		if !strings.HasPrefix(name, r.query.Get("prefix")) {
+			continue
+		}
+		entries = append(entries, entry{name + "/~", sh})
+		if include["snapshots"] {
+			for snapshot, ssh := range sh.snapshots {
+				entries = append(entries, entry{name + "/" + snapshot, ssh})
+			}
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+	marker := r.query.Get("marker")
+	for len(entries) > 0 && entries[0].key < marker {
+		entries = entries[1:]
+	}
+	next := ""
+	if len(entries) > maxResults {
+		next, entries = entries[maxResults].key, entries[:maxResults]
+	}
+
+	result := struct {
+		XMLName         xml.Name      `xml:"EnumerationResults"`
+		ServiceEndpoint string        `xml:"ServiceEndpoint,attr"`
+		Prefix          string        `xml:"Prefix,omitempty"`
+		Marker          string        `xml:"Marker,omitempty"`
+		MaxResults      string        `xml:"MaxResults,omitempty"`
+		Shares          []listedShare `xml:"Shares>Share"`
+		NextMarker      string        `xml:"NextMarker"`
+	}{ServiceEndpoint: r.endpoint, Prefix: r.query.Get("prefix"), Marker: marker, MaxResults: r.query.Get("maxresults"), NextMarker: next}
+	for _, e := range entries {
+		ls := listedShare{Name: e.sh.name, Snapshot: e.sh.snapshot}
+		ls.Properties.LastModified = e.sh.lastModified.Format(http.TimeFormat)
+		ls.Properties.Etag = e.sh.etag
+		ls.Properties.Quota = e.sh.quota
+		if include["metadata"] {
+			ls.Metadata = e.sh.metadata
+		}
+		result.Shares = append(result.Shares, ls)
+	}
+	return writeXML(w, result)
+}
+
+// parseMaxResults parses a list's maxresults parameter; the service lists up to 5000 items.
+func parseMaxResults(r *request) (int, *serviceError) {
+	const max = 5000
+	value := r.query.Get("maxresults")
+	if value == "" {
+		return max, nil
+	}
+	maxResults, err := strconv.Atoi(value)
+	if err != nil || maxResults < 1 {
+		return 0, newError(http.StatusBadRequest, azfile.ServiceCodeOutOfRangeQueryParameterValue, "The maxresults parameter %q isn't a positive number.", value)
+	}
+	if maxResults > max {
+		maxResults = max
+	}
+	return maxResults, nil
+}
+
+// serveShare serves the operations on a share. s.mutex must be held.
+func (s *Server) serveShare(w http.ResponseWriter, r *request) *serviceError {
+	switch {
+	case r.Method == http.MethodPut && r.comp == "":
+		return s.createShare(w, r)
+	case r.Method == http.MethodDelete && r.comp == "":
+		return s.deleteShare(w, r)
+	case r.comp == "filepermission":
+		return s.servePermission(w, r)
+	}
+
+	sh, err := s.lookupShare(r, r.Method == http.MethodPut)
+	if err != nil {
+		return err
+	}
+	h := w.Header()
+	switch {
+	case (r.Method == http.MethodGet || r.Method == http.MethodHead) && (r.comp == "" || r.comp == "metadata"):
+		s.writeShareProperties(w, sh)
+		writeMetadata(h, sh.metadata)
+		h.Set("x-ms-share-quota", strconv.Itoa(int(sh.quota)))
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodPut && r.comp == "metadata":
+		if sh.metadata, err = readMetadata(r); err != nil {
+			return err
+		}
+		s.touchShare(sh)
+		s.writeShareProperties(w, sh)
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodPut && r.comp == "properties":
+		if value := r.Header.Get("x-ms-share-quota"); value != "" {
+			if sh.quota, err = parseQuota(value); err != nil {
+				return err
+			}
+		}
+		s.touchShare(sh)
+		s.writeShareProperties(w, sh)
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodPut && r.comp == "snapshot":
+		return s.createSnapshot(w, r, sh)
+	case r.Method == http.MethodGet && r.comp == "acl":
+		s.writeShareProperties(w, sh)
+		return writeXML(w, azfile.SignedIdentifiers{Items: sh.signedIdentifiers})
+	case r.Method == http.MethodPut && r.comp == "acl":
+		identifiers := azfile.SignedIdentifiers{}
+		if len(r.body) > 0 {
+			if err := xml.Unmarshal(r.body, &identifiers); err != nil {
+				return newError(http.StatusBadRequest, azfile.ServiceCodeInvalidXMLDocument, "XML specified is not syntactically valid: %v.", err)
+			}
+		}
+		if len(identifiers.Items) > maxSignedIdentifiers {
+			return newError(http.StatusBadRequest, azfile.ServiceCodeInvalidXMLDocument, "A share may have at most %d stored access policies.", maxSignedIdentifiers)
+		}
+		sh.signedIdentifiers = identifiers.Items
+		s.touchShare(sh)
+		s.writeShareProperties(w, sh)
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodGet && r.comp == "stats":
+		usage := int64(0)
+		for _, n := range sh.nodes {
+			usage += n.content.size
+		}
+		s.writeShareProperties(w, sh)
+		return writeXML(w, struct {
+			XMLName         xml.Name `xml:"ShareStats"`
+			ShareUsageBytes int64    `xml:"ShareUsageBytes"`
+		}{ShareUsageBytes: usage})
+	default:
+		return unsupported(r)
+	}
+	return nil
+}
+
+// writeShareProperties writes the ETag and last modified time of a share.
+func (s *Server) writeShareProperties(w http.ResponseWriter, sh *share) {
+	w.Header().Set("ETag", sh.etag)
+	w.Header().Set("Last-Modified", sh.lastModified.Format(http.TimeFormat))
+}
+
+// parseQuota parses a share's quota, in GiB.
+func parseQuota(value string) (int32, *serviceError) {
+	quota, err := strconv.Atoi(value)
+	if err != nil || quota < 1 || quota > maxShareQuota {
+		return 0, newError(http.StatusBadRequest, azfile.ServiceCodeInvalidHeaderValue, "The share quota %q isn't between 1 and %d.", value, maxShareQuota)
+	}
+	return int32(quota), nil
+}
+
+// createShare serves Create Share.
+func (s *Server) createShare(w http.ResponseWriter, r *request) *serviceError {
+	if !validShareName(r.shareName) {
+		return newError(http.StatusBadRequest, azfile.ServiceCodeInvalidResourceName, "The specifed resource name contains invalid characters.")
+	}
+	if r.snapshot != "" {
+		return newError(http.StatusBadRequest, azfile.ServiceCodeShareSnapshotOperationNotSupported, "The operation is not supported on a share snapshot.")
+	}
+	if _, ok := s.shares[r.shareName]; ok {
+		return newError(http.StatusConflict, azfile.ServiceCodeShareAlreadyExists, "The specified share already exists.")
+	}
+	metadata, err := readMetadata(r)
+	if err != nil {
+		return err
+	}
+	quota := int32(defaultShareQuota)
+	if value := r.Header.Get("x-ms-share-quota"); value != "" {
+		if quota, err = parseQuota(value); err != nil {
+			return err
+		}
+	}
+
+	sh := &share{name: r.shareName, metadata: metadata, quota: quota, nodes: map[string]*node{},
+		permissions: map[string]string{}, snapshots: map[string]*share{}}
+	s.touchShare(sh)
+	root := s.newNode(sh, "", true, 0)
+	root.permissionKey = sh.addPermission(defaultPermission)
+	s.shares[r.shareName] = sh
+	s.writeShareProperties(w, sh)
+	w.WriteHeader(http.StatusCreated)
+	return nil
+}
+
+// deleteShare serves Delete Share, which deletes a share and, with x-ms-delete-snapshots: include, its snapshots,
+// or, with a sharesnapshot parameter, one snapshot.
+func (s *Server) deleteShare(w http.ResponseWriter, r *request) *serviceError {
+	sh, err := s.lookupShare(r, false)
+	if err != nil {
+		return err
+	}
+	if r.snapshot != "" {
+		delete(s.shares[r.shareName].snapshots, r.snapshot)
+	} else {
+		if len(sh.snapshots) > 0 && r.Header.Get("x-ms-delete-snapshots") != "include" {
+			return newError(http.StatusConflict, azfile.ServiceCodeShareHasSnapshots, "The share has snapshots and the operation requires no snapshots.")
+		}
+		delete(s.shares, r.shareName)
+	}
+	w.WriteHeader(http.StatusAccepted)
+	return nil
+}
+
+// createSnapshot serves Snapshot Share. A snapshot is a copy of the share, with its metadata unless the request has some.
+func (s *Server) createSnapshot(w http.ResponseWriter, r *request, sh *share) *serviceError {
+	metadata, err := readMetadata(r)
+	if err != nil {
+		return err
+	}
+	if len(metadata) == 0 {
+		metadata = copyMetadata(sh.metadata)
+	}
+
+	// Snapshots are named after their time, which must be unique.
+	t := time.Now().UTC()
+	snapshot := t.Format(azfile.ISO8601)
+	for ; sh.snapshots[snapshot] != nil; snapshot = t.Format(azfile.ISO8601) {
+		t = t.Add(100 * time.Nanosecond)
+	}
+
+	ssh := &share{name: sh.name, snapshot: snapshot, metadata: metadata, quota: sh.quota, etag: sh.etag, lastModified: sh.lastModified,
+		nodes: make(map[string]*node, len(sh.nodes)), permissions: make(map[string]string, len(sh.permissions))}
+	for key, n := range sh.nodes {
+		ssh.nodes[key] = n.copy()
+	}
+	for key, permission := range sh.permissions {
+		ssh.permissions[key] = permission
+	}
+	sh.snapshots[snapshot] = ssh
+	s.writeShareProperties(w, sh)
+	w.Header().Set("x-ms-snapshot", snapshot)
+	w.WriteHeader(http.StatusCreated)
+	return nil
+}
+
+// servePermission serves Create Permission and Get Permission, whose bodies are JSON.
+func (s *Server) servePermission(w http.ResponseWriter, r *request) *serviceError {
+	sh, err := s.lookupShare(r, r.Method == http.MethodPut)
+	if err != nil {
+		return err
+	}
+	permission := struct {
+		Permission string `json:"permission"`
+	}{}
+	switch r.Method {
+	case http.MethodPut:
+		if json.Unmarshal(r.body, &permission) != nil || !validPermission(permission.Permission) {
+			return newError(http.StatusBadRequest, azfile.ServiceCodeInvalidInput, "The permission isn't a valid SDDL permission.")
+		}
+		w.Header().Set("x-ms-file-permission-key", sh.addPermission(permission.Permission))
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodGet:
+		var ok bool
+		if permission.Permission, ok = sh.permissions[r.Header.Get("x-ms-file-permission-key")]; !ok {
+			return invalidPermissionKey()
+		}
+		body, _ := json.Marshal(permission)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(body)
+	default:
+		return unsupported(r)
+	}
+	return nil
+}
+
+// validPermission returns whether permission looks like a permission in SDDL: owner, group, DACL and SACL components.
+func validPermission(permission string) bool {
+	if permission == "" {
+		return false
+	}
+	for _, prefix := range []string{"O:", "G:", "D:", "S:"} {
+		if strings.HasPrefix(permission, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func invalidPermissionKey() *serviceError {
+	return newError(http.StatusBadRequest, azfile.ServiceCodeInvalidHeaderValue, "The value for the x-ms-file-permission-key header isn't a permission key of the share.")
+}
+
+// sortedKeys returns the keys of m, sorted.
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -2,6 +2,7 @@ package azfile
 
 import (
 	"context"
+	"crypto/md5"
 	"errors"
 	"fmt"
 	"io"
@@ -9,6 +10,7 @@ import (
 	"bytes"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/Azure/azure-pipeline-go/pipeline"
 )
@@ -19,9 +21,13 @@ const (
 
 	// fileSegmentSize specifies file segment size that file would be splitted into during parallel upload/download
 	fileSegmentSize = 500 * 1024 * 1024
+
+	// md5MismatchRetryCount specifies how many more times a downloaded range is fetched if its data doesn't match its MD5
+	md5MismatchRetryCount = 3
 )
 
-// UploadToAzureFileOptions identifies options used by the UploadBufferToAzureFile and UploadFileToAzureFile functions.
+// UploadToAzureFileOptions identifies options used by the UploadBufferToAzureFile, UploadFileToAzureFile and
+// UploadStreamToAzureFile functions, and by FileWriter.
 type UploadToAzureFileOptions struct {
 	// RangeSize specifies the range size to use in each parallel upload; the default (and maximum size) is FileMaxUploadRangeBytes.
 	RangeSize int64
@@ -37,6 +43,30 @@ type UploadToAzureFileOptions struct {
 
 	// Metadata contains metadata key/value pairs.
 	Metadata Metadata
+
+	// CheckpointPath, if not empty, makes UploadFileToAzureFile resumable: the ranges it uploads are recorded in a journal
+	// at this path, and a later call that uploads the same, unchanged, local file to the same Azure file skips them.
+	// The other upload functions ignore it.
+	CheckpointPath string
+
+	// Sparse, if true, makes UploadBufferToAzureFile and UploadFileToAzureFile skip the ranges that are all zeros, because
+	// the newly created Azure file already reads back as zeros; if the Azure file may hold data in such a range (because the
+	// upload resumes an earlier attempt), the range is cleared with FileURL.ClearRange instead. On Linux, UploadFileToAzureFile
+	// also finds the local file's holes with SEEK_DATA and SEEK_HOLE, so that it doesn't need to read them.
+	Sparse bool
+
+	// TransactionalMD5, if true, sends the MD5 of each range with its UploadRange call, so that the service rejects a
+	// range that was corrupted on its way.
+	TransactionalMD5 bool
+
+	// ComputeContentMD5, if true, computes the MD5 of the whole file and stores it as the file's Content-MD5, replacing
+	// FileHTTPHeaders.ContentMD5. Downloads can then validate the file against it.
+	ComputeContentMD5 bool
+
+	// PreserveSMBProperties, if true, makes UploadFileToAzureFile give the Azure file the local file's last write time,
+	// creation time (on Windows) and attributes, as returned by NewSMBPropertiesFromFileInfo, in place of the times and
+	// attributes in FileHTTPHeaders. The other upload functions ignore it.
+	PreserveSMBProperties bool
 }
 
 // UploadBufferToAzureFile uploads a buffer to an Azure file.
@@ -44,6 +74,13 @@ type UploadToAzureFileOptions struct {
 // The total size to be uploaded should be <= FileMaxSizeInBytes.
 func UploadBufferToAzureFile(ctx context.Context, b []byte,
 	fileURL FileURL, o UploadToAzureFileOptions) error {
+	return uploadBufferToAzureFile(ctx, b, nil, fileURL, o, nil)
+}
+
+// uploadBufferToAzureFile uploads a buffer to an Azure file, skipping the ranges that journal records as already uploaded.
+// journal may be nil. If dataRanges isn't nil, b is known to hold only zeros outside of these ranges.
+func uploadBufferToAzureFile(ctx context.Context, b []byte, dataRanges []Range,
+	fileURL FileURL, o UploadToAzureFileOptions, journal *checkpointJournal) error {
 
 	// 1. Validate parameters, and set defaults.
 	if o.RangeSize < 0 || o.RangeSize > FileMaxUploadRangeBytes {
@@ -60,25 +97,48 @@ func UploadBufferToAzureFile(ctx context.Context, b []byte,
 		parallelism = defaultParallelCount // default parallelism
 	}
 
-	// 2. Try to create the Azure file.
-	_, err := fileURL.Create(ctx, size, o.FileHTTPHeaders, o.Metadata)
-	if err != nil {
-		return err
+	// 2. Try to create the Azure file, unless an earlier attempt at the upload created it.
+	if o.ComputeContentMD5 {
+		sum := md5.Sum(b)
+		o.FileHTTPHeaders.ContentMD5 = sum[:]
+	}
+	if !journal.isResumed() {
+		_, err := fileURL.Create(ctx, size, writableHTTPHeaders(o.FileHTTPHeaders), o.Metadata)
+		if err != nil {
+			return err
+		}
 	}
-	// If size equals to 0, upload nothing and directly return.
+	// If size equals to 0, upload nothing, and only set the properties that the file couldn't be created with.
 	if size == 0 {
-		return nil
+		return setUploadedSMBProperties(ctx, fileURL, o.FileHTTPHeaders)
 	}
 
 	// 3. Prepare and do parallel upload.
-	fileProgress := int64(0)
+	fileProgress := journal.completedBytes()
 	progressLock := &sync.Mutex{}
 
-	return doBatchTransfer(ctx, batchTransferOptions{
+	err := doBatchTransfer(ctx, batchTransferOptions{
 		transferSize: size,
 		chunkSize:    o.RangeSize,
 		parallelism:  parallelism,
 		operation: func(offset int64, curRangeSize int64) error {
+			if journal.isCompleted(offset) {
+				return nil
+			}
+			if o.Sparse && !holdsData(b[offset:offset+curRangeSize], offset, dataRanges) {
+				if o.Progress != nil {
+					progressLock.Lock()
+					fileProgress += curRangeSize
+					o.Progress(fileProgress)
+					progressLock.Unlock()
+				}
+				if !journal.isResumed() {
+					return nil // The range of the newly created file already reads as zeros
+				}
+				_, err := fileURL.ClearRange(ctx, offset, curRangeSize)
+				return err
+			}
+
 			// Prepare to read the proper section of the buffer.
 			var body io.ReadSeeker = bytes.NewReader(b[offset : offset+curRangeSize])
 			if o.Progress != nil {
@@ -94,14 +154,42 @@ func UploadBufferToAzureFile(ctx context.Context, b []byte,
 					})
 			}
 
-			_, err := fileURL.UploadRange(ctx, int64(offset), body, nil)
-			return err
+			var transactionalMD5 []byte
+			if o.TransactionalMD5 {
+				sum := md5.Sum(b[offset : offset+curRangeSize])
+				transactionalMD5 = sum[:]
+			}
+			resp, err := fileURL.UploadRange(ctx, int64(offset), body, transactionalMD5)
+			if err != nil {
+				return err
+			}
+			return journal.complete(offset, curRangeSize, resp.ETag())
 		},
 		operationName: "UploadBufferToAzureFile",
 	})
+	if err != nil {
+		return err
+	}
+
+	// 4. Writing the ranges updated the last write time, so set the requested one again.
+	return setUploadedSMBProperties(ctx, fileURL, o.FileHTTPHeaders)
+}
+
+// setUploadedSMBProperties sets the SMB properties of h that an upload can't create the file with: the last write
+// time, which writing the ranges updates, and the read-only attribute, which stops them being written. Setting
+// them clears the HTTP headers that aren't passed, so all of h is passed again.
+func setUploadedSMBProperties(ctx context.Context, fileURL FileURL, h FileHTTPHeaders) error {
+	if h.FileLastWriteTime == nil && (h.FileAttributes == nil || !h.FileAttributes.Has(FileAttributeReadonly)) {
+		return nil
+	}
+	_, err := fileURL.SetHTTPHeaders(ctx, h)
+	return err
 }
 
 // UploadFileToAzureFile uploads a local file to an Azure file.
+// If o.CheckpointPath is set, the upload resumes an earlier attempt whose journal is at that path, provided that the
+// local file's size and modification time are unchanged and that the Azure file's ETag is one that the attempt's
+// uploads returned; otherwise the upload starts afresh. The journal is removed once the upload succeeds.
 func UploadFileToAzureFile(ctx context.Context, file *os.File,
 	fileURL FileURL, o UploadToAzureFileOptions) error {
 
@@ -117,7 +205,58 @@ func UploadFileToAzureFile(ctx context.Context, file *os.File,
 		}
 		defer m.unmap()
 	}
-	return UploadBufferToAzureFile(ctx, m, fileURL, o)
+	var dataRanges []Range
+	if o.Sparse {
+		dataRanges = findDataRanges(file, stat.Size())
+	}
+	if o.PreserveSMBProperties {
+		sp := NewSMBPropertiesFromFileInfo(stat)
+		sp.PermissionString, sp.PermissionKey = o.FileHTTPHeaders.PermissionString, o.FileHTTPHeaders.PermissionKey
+		o.FileHTTPHeaders.SMBProperties = sp
+	}
+	if o.CheckpointPath == "" {
+		return uploadBufferToAzureFile(ctx, m, dataRanges, fileURL, o, nil)
+	}
+
+	if o.RangeSize < 0 || o.RangeSize > FileMaxUploadRangeBytes {
+		return fmt.Errorf("invalid argument, o.RangeSize must be >= 0 and <= %d, in bytes", FileMaxUploadRangeBytes)
+	}
+	if o.RangeSize == 0 {
+		o.RangeSize = FileMaxUploadRangeBytes
+	}
+	journal, err := openCheckpointJournal(o.CheckpointPath,
+		checkpointHeader{Operation: "upload", FileURL: checkpointFileURL(fileURL), Size: stat.Size(), ModTime: stat.ModTime(), RangeSize: o.RangeSize},
+		func(completed map[int64]checkpointRange) bool {
+			// Resume only if the Azure file was last written by the earlier attempt.
+			p, err := fileURL.GetProperties(ctx)
+			return err == nil && p.ContentLength() == stat.Size() && hasETag(completed, p.ETag())
+		})
+	if err != nil {
+		return err
+	}
+	return journal.finish(uploadBufferToAzureFile(ctx, m, dataRanges, fileURL, o, journal))
+}
+
+// UploadStreamToAzureFile uploads the data read from reader, whose length needn't be known in advance, to an Azure file.
+// The data is read into o.Parallelism buffers of o.RangeSize bytes, which are reused once their UploadRange calls complete,
+// so at most o.Parallelism ranges are uploaded in parallel. The file is created empty, grown with FileURL.Resize as data
+// arrives and finally resized to the exact number of bytes read. If an error occurs, the partially uploaded file is left as it is.
+// Note: o.RangeSize must be >= 0 and <= FileMaxUploadRangeBytes, and if not specified, method will use FileMaxUploadRangeBytes by default.
+func UploadStreamToAzureFile(ctx context.Context, reader io.Reader,
+	fileURL FileURL, o UploadToAzureFileOptions) error {
+
+	if reader == nil {
+		return errors.New("invalid argument, reader can't be nil")
+	}
+	w, err := NewFileWriter(ctx, fileURL, o)
+	if err != nil {
+		return err
+	}
+	if _, err = w.ReadFrom(reader); err != nil {
+		w.abort(err)
+		return err
+	}
+	return w.Close()
 }
 
 // DownloadFromAzureFileOptions identifies options used by the DownloadAzureFileToBuffer and DownloadAzureFileToFile functions.
@@ -133,17 +272,44 @@ type DownloadFromAzureFileOptions struct {
 
 	// Max retry requests used during reading data for each range.
 	MaxRetryRequestsPerRange int
+
+	// CheckpointPath, if not empty, makes DownloadAzureFileToFile resumable: the ranges it downloads are recorded in a
+	// journal at this path, and a later call that downloads the same, unchanged, Azure file to the same local file skips them.
+	// The other download functions ignore it.
+	CheckpointPath string
+
+	// Sparse, if true, makes the download get the Azure file's range list with FileURL.GetRangeList and download only the
+	// ranges that hold data. The holes in between read as zeros; DownloadAzureFileToFile leaves them as sparse regions of
+	// the local file where the file system supports sparse files.
+	Sparse bool
+
+	// TransactionalMD5, if true, has the service return the MD5 of each range it downloads, and checks the range's data
+	// against it; a range that doesn't match is downloaded again, up to 3 more times, before the download fails.
+	// o.RangeSize must then be <= FileMaxUploadRangeBytes, the largest range the service returns an MD5 for.
+	TransactionalMD5 bool
+
+	// ValidateContentMD5, if true, checks the downloaded file against the MD5 stored as the file's Content-MD5 (which
+	// range downloads return as FileContentMD5). Files without a Content-MD5 aren't checked.
+	ValidateContentMD5 bool
+
+	// PreserveLastWriteTime, if true, makes DownloadAzureFileToFile set the local file's modification time to the Azure
+	// file's last write time with os.Chtimes, once the download succeeds. The other download functions ignore it.
+	PreserveLastWriteTime bool
 }
 
-// downloadAzureFileToBuffer downloads an Azure file to a buffer with parallel.
+// downloadAzureFileToBuffer downloads an Azure file to a buffer with parallel, skipping the ranges that journal
+// records as already downloaded. journal may be nil. If o.Sparse is set, the holes are zeroed unless bIsZeroed is true.
 // Note: o.RangeSize must be >= 0.
 func downloadAzureFileToBuffer(ctx context.Context, fileURL FileURL, azfileProperties *FileGetPropertiesResponse,
-	b []byte, o DownloadFromAzureFileOptions) (*FileGetPropertiesResponse, error) {
+	b []byte, bIsZeroed bool, o DownloadFromAzureFileOptions, journal *checkpointJournal) (*FileGetPropertiesResponse, error) {
 
 	// 1. Validate parameters, and set defaults.
 	if o.RangeSize < 0 {
 		return nil, errors.New("invalid argument, o.RangeSize must be >= 0")
 	}
+	if o.TransactionalMD5 && o.RangeSize > FileMaxUploadRangeBytes {
+		return nil, fmt.Errorf("invalid argument, o.RangeSize must be <= %d when o.TransactionalMD5 is set, in bytes", FileMaxUploadRangeBytes)
+	}
 	if o.RangeSize == 0 {
 		o.RangeSize = FileMaxUploadRangeBytes
 	}
@@ -171,36 +337,73 @@ func downloadAzureFileToBuffer(ctx context.Context, fileURL FileURL, azfilePrope
 		parallelism = defaultParallelCount // default parallelism
 	}
 
-	// 2. Prepare and do parallel download.
-	fileProgress := int64(0)
+	// 2. Find the ranges to download.
+	populated := []Range{{Start: 0, End: azfileSize - 1}}
+	if o.Sparse {
+		rangeList, err := fileURL.GetRangeList(ctx, 0, CountToEnd)
+		if err != nil {
+			return nil, err
+		}
+		populated = rangeList.Items
+		if !bIsZeroed {
+			zeroHoles(b[:azfileSize], populated)
+		}
+	}
+	chunks := splitRanges(populated, o.RangeSize)
+
+	// 3. Prepare and do parallel download.
+	fileProgress := journal.completedBytes()
 	progressLock := &sync.Mutex{}
 
 	err := doBatchTransfer(ctx, batchTransferOptions{
-		transferSize: azfileSize,
-		chunkSize:    o.RangeSize,
+		transferSize: int64(len(chunks)),
+		chunkSize:    1, // Each operation downloads one of the chunks
 		parallelism:  parallelism,
-		operation: func(offset int64, curRangeSize int64) error {
-			dr, err := fileURL.Download(ctx, offset, curRangeSize, false)
-			body := dr.Body(RetryReaderOptions{MaxRetryRequests: o.MaxRetryRequestsPerRange})
+		operation: func(chunkIndex int64, _ int64) error {
+			offset, curRangeSize := chunks[chunkIndex].Start, chunks[chunkIndex].End-chunks[chunkIndex].Start+1
+			if journal.isCompleted(offset) {
+				return nil
+			}
+
+			for retry := 0; ; retry++ {
+				dr, err := fileURL.Download(ctx, offset, curRangeSize, o.TransactionalMD5)
+				if err != nil {
+					return err
+				}
+				body := dr.Body(RetryReaderOptions{MaxRetryRequests: o.MaxRetryRequestsPerRange})
 
-			if o.Progress != nil {
 				rangeProgress := int64(0)
-				body = pipeline.NewResponseBodyProgress(
-					body,
-					func(bytesTransferred int64) {
-						diff := bytesTransferred - rangeProgress
-						rangeProgress = bytesTransferred
+				if o.Progress != nil {
+					body = pipeline.NewResponseBodyProgress(
+						body,
+						func(bytesTransferred int64) {
+							diff := bytesTransferred - rangeProgress
+							rangeProgress = bytesTransferred
+							progressLock.Lock()
+							defer progressLock.Unlock()
+							fileProgress += diff
+							o.Progress(fileProgress)
+						})
+				}
+
+				_, err = io.ReadFull(body, b[offset:offset+curRangeSize])
+				body.Close()
+				if err != nil {
+					return err
+				}
+				if o.TransactionalMD5 {
+					if sum := md5.Sum(b[offset : offset+curRangeSize]); !bytes.Equal(sum[:], dr.ContentMD5()) {
+						if retry == md5MismatchRetryCount {
+							return fmt.Errorf("MD5 mismatch, the data of range [%d, %d) doesn't match the MD5 returned by the service", offset, offset+curRangeSize)
+						}
 						progressLock.Lock()
-						defer progressLock.Unlock()
-						fileProgress += diff
-						o.Progress(fileProgress)
-					})
+						fileProgress -= rangeProgress // The range's data is downloaded again
+						progressLock.Unlock()
+						continue
+					}
+				}
+				return journal.complete(offset, curRangeSize, "")
 			}
-
-			_, err = io.ReadFull(body, b[offset:offset+curRangeSize])
-			body.Close()
-
-			return err
 		},
 		operationName: "downloadAzureFileToBuffer",
 	})
@@ -208,17 +411,80 @@ func downloadAzureFileToBuffer(ctx context.Context, fileURL FileURL, azfilePrope
 		return nil, err
 	}
 
+	// 4. Validate the whole file against its MD5, if it has one.
+	if contentMD5 := azfileProperties.ContentMD5(); o.ValidateContentMD5 && len(contentMD5) > 0 {
+		if sum := md5.Sum(b[:azfileSize]); !bytes.Equal(sum[:], contentMD5) {
+			journal.forget() // Resuming would only download the ranges that didn't match again
+			return nil, errors.New("MD5 mismatch, the downloaded data doesn't match the file's Content-MD5")
+		}
+	}
+
 	return azfileProperties, nil
 }
 
+// holdsData reports whether b, which is at offset in the source, holds any data; it doesn't read b if dataRanges,
+// when not nil, shows that b is in a hole.
+func holdsData(b []byte, offset int64, dataRanges []Range) bool {
+	if dataRanges != nil {
+		overlaps := false
+		for _, r := range dataRanges {
+			if r.Start < offset+int64(len(b)) && r.End >= offset {
+				overlaps = true
+				break
+			}
+		}
+		if !overlaps {
+			return false
+		}
+	}
+	for _, v := range b {
+		if v != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// splitRanges splits each of the inclusive ranges into ranges of at most rangeSize bytes.
+func splitRanges(ranges []Range, rangeSize int64) []Range {
+	chunks := []Range{}
+	for _, r := range ranges {
+		for start := r.Start; start <= r.End; start += rangeSize {
+			end := start + rangeSize - 1
+			if end > r.End {
+				end = r.End
+			}
+			chunks = append(chunks, Range{Start: start, End: end})
+		}
+	}
+	return chunks
+}
+
+// zeroHoles zeroes the bytes of b that aren't in any of populated, which are sorted, non-overlapping, inclusive ranges.
+func zeroHoles(b []byte, populated []Range) {
+	holeStart := int64(0)
+	for _, r := range append(populated, Range{Start: int64(len(b)), End: int64(len(b))}) {
+		if r.Start > holeStart {
+			hole := b[holeStart:r.Start]
+			for i := range hole {
+				hole[i] = 0
+			}
+		}
+		holeStart = r.End + 1
+	}
+}
+
 // DownloadAzureFileToBuffer downloads an Azure file to a buffer with parallel.
 func DownloadAzureFileToBuffer(ctx context.Context, fileURL FileURL,
 	b []byte, o DownloadFromAzureFileOptions) (*FileGetPropertiesResponse, error) {
-	return downloadAzureFileToBuffer(ctx, fileURL, nil, b, o)
+	return downloadAzureFileToBuffer(ctx, fileURL, nil, b, false, o, nil)
 }
 
 // DownloadAzureFileToFile downloads an Azure file to a local file.
 // The file would be created if it doesn't exist, and would be truncated if the size doesn't match.
+// If o.CheckpointPath is set, the download resumes an earlier attempt whose journal is at that path, provided that the
+// Azure file's size and ETag are unchanged and that the local file still has the Azure file's size; otherwise the
+// download starts afresh. The journal is removed once the download succeeds.
 // Note: file can't be nil.
 func DownloadAzureFileToFile(ctx context.Context, fileURL FileURL, file *os.File, o DownloadFromAzureFileOptions) (*FileGetPropertiesResponse, error) {
 	// 1. Validate parameters.
@@ -238,23 +504,66 @@ func DownloadAzureFileToFile(ctx context.Context, fileURL FileURL, file *os.File
 	if err != nil {
 		return nil, err
 	}
-	if stat.Size() != azfileSize {
-		if err = file.Truncate(azfileSize); err != nil {
+	var journal *checkpointJournal
+	if o.CheckpointPath != "" {
+		if o.RangeSize < 0 {
+			return nil, errors.New("invalid argument, o.RangeSize must be >= 0")
+		}
+		if o.RangeSize == 0 {
+			o.RangeSize = FileMaxUploadRangeBytes
+		}
+		operation := "download"
+		if o.Sparse {
+			operation = "sparse download" // The ranges of a sparse download are split differently
+		}
+		journal, err = openCheckpointJournal(o.CheckpointPath,
+			checkpointHeader{Operation: operation, FileURL: checkpointFileURL(fileURL), Size: azfileSize, ETag: azfileProperties.ETag(), RangeSize: o.RangeSize},
+			func(completed map[int64]checkpointRange) bool {
+				return stat.Size() == azfileSize // A local file of another size can't hold the earlier attempt's ranges
+			})
+		if err != nil {
 			return nil, err
 		}
 	}
+	localSize := stat.Size()
+	if o.Sparse && !journal.isResumed() && localSize != 0 {
+		// Empty the file first, so that the holes, which aren't downloaded, read as zeros.
+		if err = file.Truncate(0); err != nil {
+			return nil, journal.finish(err)
+		}
+		localSize = 0
+	}
+	if localSize != azfileSize {
+		if err = file.Truncate(azfileSize); err != nil {
+			return nil, journal.finish(err)
+		}
+	}
 
 	// 4. Set mmap and call DownloadAzureFileToBuffer, in this case file size should be > 0.
 	m := mmf{} // Default to an empty slice; used for 0-size file
 	if azfileSize > 0 {
 		m, err = newMMF(file, true, 0, int(azfileSize))
 		if err != nil {
-			return nil, err
+			return nil, journal.finish(err)
 		}
-		defer m.unmap()
 	}
 
-	return downloadAzureFileToBuffer(ctx, fileURL, azfileProperties, m, o)
+	azfileProperties, err = downloadAzureFileToBuffer(ctx, fileURL, azfileProperties, m, o.Sparse, o, journal)
+	if azfileSize > 0 {
+		m.unmap() // Before the modification time is set, which writing through the mapping could update
+	}
+	if err = journal.finish(err); err != nil {
+		return nil, err
+	}
+
+	// 5. Set the local file's modification time, which writing it updated.
+	if o.PreserveLastWriteTime && azfileProperties.FileLastWriteTime() != "" {
+		lastWriteTime := (&SMBPropertyAdapter{PropertySource: azfileProperties}).FileLastWriteTime()
+		if err = os.Chtimes(file.Name(), time.Now(), lastWriteTime); err != nil {
+			return nil, err
+		}
+	}
+	return azfileProperties, nil
 }
 
 // BatchTransferOptions identifies options used by doBatchTransfer.
@@ -267,29 +576,47 @@ type batchTransferOptions struct {
 }
 
 // doBatchTransfer helps to execute operations in a batch manner.
+// Once an operation fails, no more operations are started, and doBatchTransfer returns the first failure only after
+// the operations already in progress have returned, so that the caller can safely release what they use.
 func doBatchTransfer(ctx context.Context, o batchTransferOptions) error {
 	// Prepare and do parallel operations.
 	numChunks := ((o.transferSize - 1) / o.chunkSize) + 1
 	operationChannel := make(chan func() error, o.parallelism) // Create the channel that release 'parallelism' goroutines concurrently
-	operationResponseChannel := make(chan error, numChunks)    // Holds each response
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
+	var resultLock sync.Mutex // Guards firstErr and completed
+	var firstErr error
+	completed := int64(0)
+	record := func(err error) {
+		resultLock.Lock()
+		defer resultLock.Unlock()
+		if err == nil {
+			completed++
+		} else if firstErr == nil {
+			firstErr = err
+			cancel() // As soon as any operation fails, cancel all remaining operation calls
+		}
+	}
+
 	// Create the goroutines that process each operation (in parallel).
+	var operations sync.WaitGroup
 	for g := uint16(0); g < o.parallelism; g++ {
-		//grIndex := g
+		operations.Add(1)
 		go func() {
+			defer operations.Done()
 			for f := range operationChannel {
-				//fmt.Printf("[%s] gr-%d start action\n", o.operationName, grIndex)
-				err := f()
-				operationResponseChannel <- err
-				//fmt.Printf("[%s] gr-%d end action\n", o.operationName, grIndex)
+				if ctx.Err() != nil {
+					continue // Drain the operations that were queued before the cancellation, without starting them
+				}
+				record(f())
 			}
 		}()
 	}
 
 	curChunkSize := o.chunkSize
-	// Add each chunk's operation to the channel.
+	// Add each chunk's operation to the channel, until the operations are cancelled.
+dispatch:
 	for chunkIndex := int64(0); chunkIndex < numChunks; chunkIndex++ {
 		if chunkIndex == numChunks-1 { // Last chunk
 			curChunkSize = o.transferSize - (int64(chunkIndex) * o.chunkSize) // Remove size of all transferred chunks from total
@@ -297,19 +624,20 @@ func doBatchTransfer(ctx context.Context, o batchTransferOptions) error {
 		offset := int64(chunkIndex) * o.chunkSize
 
 		closureChunkSize := curChunkSize
-		operationChannel <- func() error {
+		select {
+		case operationChannel <- func() error {
 			return o.operation(offset, closureChunkSize)
+		}:
+		case <-ctx.Done():
+			break dispatch
 		}
 	}
 	close(operationChannel)
 
-	// Wait for the operations to complete.
-	for chunkIndex := int64(0); chunkIndex < numChunks; chunkIndex++ {
-		responseError := <-operationResponseChannel
-		if responseError != nil {
-			cancel()             // As soon as any operation fails, cancel all remaining operation calls
-			return responseError // No need to process anymore responses
-		}
+	// Wait for the operations in progress to complete.
+	operations.Wait()
+	if firstErr == nil && completed < numChunks {
+		return ctx.Err() // The caller's context was cancelled, so some operations were never started
 	}
-	return nil
+	return firstErr
 }
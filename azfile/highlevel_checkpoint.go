@@ -0,0 +1,199 @@
+package azfile
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// checkpointVersion is written to each checkpoint journal so that journals written by other versions aren't misread.
+const checkpointVersion = 1
+
+// checkpointHeader is the first line of a checkpoint journal. It identifies the transfer that the journal belongs to,
+// so that the journal is only used to resume the same transfer from the same, unchanged, source.
+type checkpointHeader struct {
+	Version   int       `json:"version"`
+	Operation string    `json:"operation"`
+	FileURL   string    `json:"fileURL"` // Without any SAS, so that a refreshed SAS doesn't prevent resuming
+	Size      int64     `json:"size"`
+	ModTime   time.Time `json:"modTime"` // The local file's modification time, for uploads
+	ETag      ETag      `json:"etag"`    // The Azure file's ETag, for downloads
+	RangeSize int64     `json:"rangeSize"`
+}
+
+// checkpointRange is written as a line of the journal once a range has been transferred.
+type checkpointRange struct {
+	Offset int64 `json:"offset"`
+	Count  int64 `json:"count"`
+	ETag   ETag  `json:"etag,omitempty"` // The Azure file's ETag after the range was uploaded, for uploads
+}
+
+// checkpointJournal records the ranges of a transfer that have completed, one line each, so that a transfer that
+// failed, or whose process crashed, can skip them when it's restarted. Lines are appended as ranges complete but aren't
+// synced, so the journal survives a crash of the process but not necessarily one of the operating system.
+type checkpointJournal struct {
+	path      string
+	file      *os.File
+	lock      sync.Mutex
+	completed map[int64]checkpointRange
+
+	// resumed is true if the journal's ranges came from an earlier attempt at the transfer.
+	resumed bool
+}
+
+// checkpointFileURL returns the URL that identifies fileURL in a checkpoint header.
+func checkpointFileURL(fileURL FileURL) string {
+	parts := NewFileURLParts(fileURL.URL())
+	parts.SAS = SASQueryParameters{}
+	u := parts.URL()
+	return u.String()
+}
+
+// openCheckpointJournal opens the journal at path. If the file holds the journal of the transfer described by header,
+// and canResume agrees that the transfer's destination still holds the ranges it records, those ranges are treated as
+// completed; otherwise the journal is started afresh.
+func openCheckpointJournal(path string, header checkpointHeader,
+	canResume func(completed map[int64]checkpointRange) bool) (*checkpointJournal, error) {
+
+	header.Version = checkpointVersion
+	j := &checkpointJournal{path: path, completed: map[int64]checkpointRange{}}
+	if completed, ok := readCheckpointJournal(path, header); ok && canResume(completed) {
+		j.completed, j.resumed = completed, true
+	}
+
+	// The journal is rewritten rather than appended to, which also drops a partial line left by a crash.
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	j.file = file
+	if err = j.writeLine(header); err != nil {
+		file.Close()
+		return nil, err
+	}
+	for _, r := range j.completed {
+		if err = j.writeLine(r); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return j, nil
+}
+
+// readCheckpointJournal reads the ranges recorded by the journal at path, if it exists and belongs to the transfer
+// described by header. Reading stops at the first line that can't be parsed, which is a line a crash cut short.
+func readCheckpointJournal(path string, header checkpointHeader) (map[int64]checkpointRange, bool) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, false
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	existing := checkpointHeader{}
+	if !scanner.Scan() || json.Unmarshal(scanner.Bytes(), &existing) != nil ||
+		existing.Version != header.Version || existing.Operation != header.Operation || existing.FileURL != header.FileURL ||
+		existing.Size != header.Size || !existing.ModTime.Equal(header.ModTime) || existing.ETag != header.ETag ||
+		existing.RangeSize != header.RangeSize {
+		return nil, false
+	}
+	completed := map[int64]checkpointRange{}
+	for scanner.Scan() {
+		r := checkpointRange{}
+		if json.Unmarshal(scanner.Bytes(), &r) != nil {
+			break
+		}
+		completed[r.Offset] = r
+	}
+	return completed, true
+}
+
+func (j *checkpointJournal) writeLine(v interface{}) error {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = j.file.Write(append(line, '\n'))
+	return err
+}
+
+// isResumed reports whether the journal's ranges came from an earlier attempt at the transfer.
+func (j *checkpointJournal) isResumed() bool {
+	return j != nil && j.resumed
+}
+
+// isCompleted reports whether the range at offset was completed by an earlier attempt at the transfer.
+func (j *checkpointJournal) isCompleted(offset int64) bool {
+	if j == nil {
+		return false
+	}
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	_, ok := j.completed[offset]
+	return ok
+}
+
+// completedBytes returns the number of bytes transferred by earlier attempts at the transfer.
+func (j *checkpointJournal) completedBytes() int64 {
+	if j == nil {
+		return 0
+	}
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	total := int64(0)
+	for _, r := range j.completed {
+		total += r.Count
+	}
+	return total
+}
+
+// complete records that a range has been transferred.
+func (j *checkpointJournal) complete(offset int64, count int64, etag ETag) error {
+	if j == nil {
+		return nil
+	}
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	r := checkpointRange{Offset: offset, Count: count, ETag: etag}
+	j.completed[offset] = r
+	return j.writeLine(r)
+}
+
+// forget drops the ranges recorded so far, for a transfer whose result turned out to be bad, so that the next attempt
+// starts afresh.
+func (j *checkpointJournal) forget() error {
+	if j == nil {
+		return nil
+	}
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.completed = map[int64]checkpointRange{}
+	return j.file.Truncate(0) // Without its header line, the journal doesn't match any transfer
+}
+
+// hasETag reports whether etag was returned by one of the uploads recorded in completed.
+func hasETag(completed map[int64]checkpointRange, etag ETag) bool {
+	for _, r := range completed {
+		if r.ETag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// finish closes the journal, removing it if the transfer succeeded so that the next transfer starts afresh.
+func (j *checkpointJournal) finish(transferErr error) error {
+	if j == nil {
+		return transferErr
+	}
+	err := j.file.Close()
+	if transferErr != nil {
+		return transferErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Remove(j.path)
+}
@@ -0,0 +1,275 @@
+package azfile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+)
+
+// CopyAzureFileToAzureFileOptions identifies options used by the CopyAzureFileToAzureFile function.
+type CopyAzureFileToAzureFileOptions struct {
+	// RangeSize specifies the range size to use in each parallel copy; the default (and maximum size) is FileMaxUploadRangeBytes.
+	RangeSize int64
+
+	// Progress is a function that is invoked as each range is copied, with the number of bytes copied so far.
+	Progress pipeline.ProgressReceiver
+
+	// Parallelism indicates the maximum number of ranges to copy in parallel. If 0(default) is provided, 5 parallelism will be used by default.
+	Parallelism uint16
+}
+
+// CopyAzureFileToAzureFile copies an Azure file to another Azure file, which is created or replaced. Unlike
+// FileURL.StartCopy, which the service completes asynchronously (see WaitForCopy), it copies the source's ranges in parallel with
+// FileURL.UploadRangeFromURL and returns once they're all copied. Only the ranges that the source's range list reports as
+// holding data are copied. The destination gets the source's HTTP headers, metadata and SMB properties (attributes,
+// creation and last write times, and permission).
+// The service reads the ranges from source's URL itself, so that URL must authorize reading the source, with a SAS for
+// example, whatever the credential in source's pipeline. If an error occurs, the partially copied destination is left as it is.
+// Note: o.RangeSize must be >= 0 and <= FileMaxUploadRangeBytes, and if not specified, method will use FileMaxUploadRangeBytes by default.
+func CopyAzureFileToAzureFile(ctx context.Context, source FileURL, destination FileURL, o CopyAzureFileToAzureFileOptions) error {
+	// 1. Validate parameters, and set defaults.
+	if o.RangeSize < 0 || o.RangeSize > FileMaxUploadRangeBytes {
+		return fmt.Errorf("invalid argument, o.RangeSize must be >= 0 and <= %d, in bytes", FileMaxUploadRangeBytes)
+	}
+	if o.RangeSize == 0 {
+		o.RangeSize = FileMaxUploadRangeBytes
+	}
+
+	parallelism := o.Parallelism
+	if parallelism == 0 {
+		parallelism = defaultParallelCount // default parallelism
+	}
+
+	// 2. Get the source's properties, and create the destination with them.
+	props, err := source.GetProperties(ctx)
+	if err != nil {
+		return err
+	}
+	h, err := copiedHTTPHeaders(ctx, props, source, destination)
+	if err != nil {
+		return err
+	}
+	if _, err = destination.Create(ctx, props.ContentLength(), writableHTTPHeaders(h), props.NewMetadata()); err != nil {
+		return err
+	}
+
+	// 3. Find the ranges that hold data, and copy them in parallel.
+	rangeList, err := source.GetRangeList(ctx, 0, CountToEnd)
+	if err != nil {
+		return err
+	}
+	chunks := splitRanges(rangeList.Items, o.RangeSize)
+	if len(chunks) > 0 {
+		sourceURL := source.URL()
+		copied := int64(0)
+		progressLock := &sync.Mutex{}
+		err = doBatchTransfer(ctx, batchTransferOptions{
+			transferSize: int64(len(chunks)),
+			chunkSize:    1, // Each operation copies one of the chunks
+			parallelism:  parallelism,
+			operation: func(chunkIndex int64, _ int64) error {
+				offset, count := chunks[chunkIndex].Start, chunks[chunkIndex].End-chunks[chunkIndex].Start+1
+				if _, err := destination.UploadRangeFromURL(ctx, sourceURL, offset, offset, count); err != nil {
+					return err
+				}
+				if o.Progress != nil {
+					progressLock.Lock()
+					defer progressLock.Unlock()
+					copied += count
+					o.Progress(copied)
+				}
+				return nil
+			},
+			operationName: "CopyAzureFileToAzureFile",
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// 4. Writing the ranges updated the destination's last write time, so set the source's again, along with the
+	// attributes, which may make the destination read-only now that it's written.
+	_, err = destination.SetHTTPHeaders(ctx, h)
+	return err
+}
+
+// copiedHTTPHeaders returns the HTTP headers and SMB properties with which destination is created as a copy of the source
+// whose properties are props. The source's permission is referred to by a key that's only valid within its share, so if
+// destination is in another share, the permission is created there too.
+func copiedHTTPHeaders(ctx context.Context, props *FileGetPropertiesResponse, source FileURL, destination FileURL) (FileHTTPHeaders, error) {
+	h := props.NewHTTPHeaders()
+	if s := props.FileAttributes(); s != "" {
+		attributes := ParseFileAttributeFlagsString(s)
+		h.FileAttributes = &attributes
+	}
+	if s := props.FileCreationTime(); s != "" {
+		if err := h.SetISO8601CreationTime(s); err != nil {
+			return FileHTTPHeaders{}, err
+		}
+	}
+	if s := props.FileLastWriteTime(); s != "" {
+		if err := h.SetISO8601WriteTime(s); err != nil {
+			return FileHTTPHeaders{}, err
+		}
+	}
+
+	permissionKey := props.FilePermissionKey()
+	if permissionKey == "" {
+		return h, nil
+	}
+	if !inSameShare(source, destination) {
+		sourceShare, destinationShare := shareURLOfFile(source), shareURLOfFile(destination)
+		permission, err := sourceShare.GetPermission(ctx, permissionKey)
+		if err != nil {
+			return FileHTTPHeaders{}, err
+		}
+		created, err := destinationShare.CreatePermission(ctx, permission.Permission)
+		if err != nil {
+			return FileHTTPHeaders{}, err
+		}
+		if permissionKey = created.FilePermissionKey(); permissionKey == "" {
+			return FileHTTPHeaders{}, errors.New("the service didn't return the key of the destination's permission")
+		}
+	}
+	h.PermissionKey = &permissionKey
+	return h, nil
+}
+
+// writableHTTPHeaders returns h without the read-only attribute, so that ranges can be written to the file it's created
+// with. The attribute is set with the rest of h once they're written.
+func writableHTTPHeaders(h FileHTTPHeaders) FileHTTPHeaders {
+	if h.FileAttributes != nil && h.FileAttributes.Has(FileAttributeReadonly) {
+		attributes := h.FileAttributes.Remove(FileAttributeReadonly)
+		h.FileAttributes = &attributes
+	}
+	return h
+}
+
+// inSameShare reports whether the two files are in the same share (or in snapshots of it).
+func inSameShare(f1 FileURL, f2 FileURL) bool {
+	p1, p2 := NewFileURLParts(f1.URL()), NewFileURLParts(f2.URL())
+	return p1.Host == p2.Host && p1.IPEndpointStyleInfo == p2.IPEndpointStyleInfo && p1.ShareName == p2.ShareName
+}
+
+// shareURLOfFile returns the URL of the share, rather than of a snapshot of it, that holds the file. The URL keeps the
+// file URL's SAS, if any.
+func shareURLOfFile(f FileURL) ShareURL {
+	parts := NewFileURLParts(f.URL())
+	parts.DirectoryOrFilePath, parts.ShareSnapshot = "", ""
+	return NewShareURL(parts.URL(), f.fileClient.Pipeline())
+}
+
+// WaitForCopyOptions identifies options used by the WaitForCopy function.
+type WaitForCopyOptions struct {
+	// PollInterval is the time to wait between the first two polls of the copy's status; the wait doubles after each
+	// poll, up to MaxPollInterval. The defaults are 1 second and 1 minute.
+	PollInterval    time.Duration
+	MaxPollInterval time.Duration
+
+	// Progress, if not nil, is invoked after each poll with the number of bytes copied so far and the size of the source.
+	Progress func(bytesCopied int64, totalBytes int64)
+}
+
+// CopyFailedError is returned by WaitForCopy when a copy fails or is aborted.
+type CopyFailedError struct {
+	CopyID string
+
+	// Status is CopyStatusFailed or CopyStatusAborted.
+	Status CopyStatusType
+
+	// StatusDescription is the service's description of the status, which says why a copy failed.
+	StatusDescription string
+}
+
+// Error implements the error interface.
+func (e *CopyFailedError) Error() string {
+	return fmt.Sprintf("copy %s %s: %s", e.CopyID, e.Status, e.StatusDescription)
+}
+
+// ParseCopyProgress parses the "bytes copied/total bytes" form of a copy's progress, as returned by
+// FileGetPropertiesResponse.CopyProgress.
+func ParseCopyProgress(progress string) (bytesCopied int64, totalBytes int64, err error) {
+	parts := strings.Split(progress, "/")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid copy progress %q", progress)
+	}
+	if bytesCopied, err = strconv.ParseInt(parts[0], 10, 64); err != nil {
+		return 0, 0, fmt.Errorf("invalid copy progress %q", progress)
+	}
+	if totalBytes, err = strconv.ParseInt(parts[1], 10, 64); err != nil {
+		return 0, 0, fmt.Errorf("invalid copy progress %q", progress)
+	}
+	return bytesCopied, totalBytes, nil
+}
+
+// WaitForCopy waits for the copy to fileURL whose ID is copyID, which FileURL.StartCopy started, to complete, polling
+// the file's properties with an exponential backoff. It returns the file's properties once the copy succeeds, and a
+// *CopyFailedError if it fails or is aborted. If ctx is cancelled (or its deadline passes) while the copy is pending,
+// the copy is aborted with FileURL.AbortCopy, and ctx's error is returned.
+func WaitForCopy(ctx context.Context, fileURL FileURL, copyID string, o WaitForCopyOptions) (*FileGetPropertiesResponse, error) {
+	if o.PollInterval <= 0 {
+		o.PollInterval = time.Second
+	}
+	if o.MaxPollInterval <= 0 {
+		o.MaxPollInterval = time.Minute
+	}
+
+	interval := o.PollInterval
+	for {
+		props, err := fileURL.GetProperties(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, abortCopy(fileURL, copyID, ctx.Err())
+			}
+			return nil, err
+		}
+		if props.CopyID() != copyID {
+			return nil, fmt.Errorf("the file's last copy is %q rather than %q; another copy or a write replaced it", props.CopyID(), copyID)
+		}
+		if o.Progress != nil {
+			if bytesCopied, totalBytes, err := ParseCopyProgress(props.CopyProgress()); err == nil {
+				o.Progress(bytesCopied, totalBytes)
+			}
+		}
+
+		switch status := props.CopyStatus(); status {
+		case CopyStatusSuccess:
+			return props, nil
+		case CopyStatusFailed, CopyStatusAborted:
+			return nil, &CopyFailedError{CopyID: copyID, Status: status, StatusDescription: props.CopyStatusDescription()}
+		}
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, abortCopy(fileURL, copyID, ctx.Err())
+		case <-timer.C:
+		}
+		if interval *= 2; interval > o.MaxPollInterval {
+			interval = o.MaxPollInterval
+		}
+	}
+}
+
+// abortCopyTimeout bounds the call that aborts a copy whose wait was cancelled.
+const abortCopyTimeout = 30 * time.Second
+
+// abortCopy aborts a copy whose wait was cancelled with ctxErr. ctx can't be used any more, so the abort gets a
+// context of its own, bounded by abortCopyTimeout. It returns ctxErr, unless the abort fails for another reason than
+// the copy having ended meanwhile.
+func abortCopy(fileURL FileURL, copyID string, ctxErr error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), abortCopyTimeout)
+	defer cancel()
+	_, err := fileURL.AbortCopy(ctx, copyID)
+	if stgErr, ok := asStorageError(err); err == nil || (ok && stgErr.ServiceCode() == ServiceCodeNoPendingCopyOperation) {
+		return ctxErr
+	}
+	return err
+}
@@ -0,0 +1,416 @@
+package azfile
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DeleteDirectoryRecursiveOptions identifies options used by the DirectoryURL's DeleteRecursive method.
+type DeleteDirectoryRecursiveOptions struct {
+	// Parallelism indicates the maximum number of files (or directories at the same depth) to delete in parallel.
+	// If 0(default) is provided, 5 parallelism will be used by default.
+	Parallelism uint16
+
+	// ForceCloseHandles indicates that every SMB handle open in the directory's subtree should be force-closed
+	// before anything is deleted. Without this, files held open by SMB clients fail with ServiceCodeCannotDeleteFileOrDirectory.
+	ForceCloseHandles bool
+}
+
+// DeletePathFailure describes a file or directory that DeleteRecursive could not remove.
+type DeletePathFailure struct {
+	// Path is the path of the file or directory, relative to the share's root.
+	Path string
+
+	// IsDirectory is true if Path refers to a directory.
+	IsDirectory bool
+
+	// Err is the error returned by the service (or pipeline) when deleting Path.
+	Err error
+}
+
+// DeleteRecursiveError is returned by DeleteRecursive when one or more files or directories could not be removed.
+type DeleteRecursiveError struct {
+	// Failures lists every path that could not be removed; files first, then directories from the deepest up.
+	Failures []DeletePathFailure
+}
+
+// Error implements the error interface's Error method to return a string representation of the error.
+func (e *DeleteRecursiveError) Error() string {
+	b := &bytes.Buffer{}
+	fmt.Fprintf(b, "failed to delete %d path(s):\n", len(e.Failures))
+	for _, f := range e.Failures {
+		reason := ""
+		if stErr, ok := f.Err.(StorageError); ok && stErr.ServiceCode() != ServiceCodeNone {
+			reason = string(stErr.ServiceCode()) // The full StorageError text is far too long to repeat per path
+		} else {
+			reason = f.Err.Error()
+		}
+		fmt.Fprintf(b, "   %s: %s\n", f.Path, reason)
+	}
+	return b.String()
+}
+
+// DeleteRecursive removes the directory along with every file and subdirectory beneath it.
+// Files are deleted in parallel first, then directories are deleted from the deepest level up.
+// A DirectoryURL referring to the share's root directory is emptied but not itself deleted.
+// Failures do not stop the operation; once everything possible has been removed, a *DeleteRecursiveError
+// listing every path that could not be removed is returned. Errors listing the tree are returned as-is.
+func (d DirectoryURL) DeleteRecursive(ctx context.Context, o DeleteDirectoryRecursiveOptions) error {
+	parallelism := o.Parallelism
+	if parallelism == 0 {
+		parallelism = defaultParallelCount // default parallelism
+	}
+
+	// 1. Release anything SMB clients have open, so that the deletes below don't fail on it.
+	if o.ForceCloseHandles {
+		if _, err := d.ForceCloseAllHandles(ctx, ForceCloseHandlesOptions{Recursive: true}); err != nil {
+			return err
+		}
+	}
+
+	// 2. Enumerate the subtree.
+	rootPath := strings.TrimSuffix(NewFileURLParts(d.URL()).DirectoryOrFilePath, "/")
+	type dirEntry struct {
+		url   DirectoryURL
+		path  string
+		depth int
+	}
+	type fileEntry struct {
+		url  FileURL
+		path string
+	}
+	dirs := []dirEntry{{url: d, path: rootPath, depth: 0}}
+	files := []fileEntry{}
+	for i := 0; i < len(dirs); i++ { // dirs grows as subdirectories are discovered
+		parent := dirs[i]
+		for marker := (Marker{}); marker.NotDone(); {
+			resp, err := parent.url.ListFilesAndDirectoriesSegment(ctx, marker, ListFilesAndDirectoriesOptions{})
+			if err != nil {
+				return err
+			}
+			marker = resp.NextMarker
+			for _, f := range resp.FileItems {
+				files = append(files, fileEntry{url: parent.url.NewFileURL(f.Name), path: joinSharePath(parent.path, f.Name)})
+			}
+			for _, sub := range resp.DirectoryItems {
+				dirs = append(dirs, dirEntry{url: parent.url.NewDirectoryURL(sub.Name), path: joinSharePath(parent.path, sub.Name), depth: parent.depth + 1})
+			}
+		}
+	}
+	if rootPath == "" {
+		dirs = dirs[1:] // The share's root directory can't be deleted
+	}
+
+	failures := []DeletePathFailure{}
+	failuresLock := &sync.Mutex{}
+	recordFailure := func(path string, isDirectory bool, err error) {
+		if stErr, ok := err.(StorageError); ok && stErr.ServiceCode() == ServiceCodeResourceNotFound {
+			return // Somebody else already deleted it
+		}
+		failuresLock.Lock()
+		defer failuresLock.Unlock()
+		failures = append(failures, DeletePathFailure{Path: path, IsDirectory: isDirectory, Err: err})
+	}
+
+	// 3. Delete all files in parallel.
+	operations := make([]func(), 0, len(files))
+	for _, f := range files {
+		f := f
+		operations = append(operations, func() {
+			if _, err := f.url.Delete(ctx); err != nil {
+				recordFailure(f.path, false, err)
+			}
+		})
+	}
+	doParallel(parallelism, operations)
+
+	// 4. Delete directories bottom-up; directories at the same depth are independent of each other.
+	sort.SliceStable(dirs, func(i, j int) bool { return dirs[i].depth > dirs[j].depth })
+	for start := 0; start < len(dirs); {
+		end := start
+		operations = operations[:0]
+		for ; end < len(dirs) && dirs[end].depth == dirs[start].depth; end++ {
+			dir := dirs[end]
+			operations = append(operations, func() {
+				if _, err := dir.url.Delete(ctx); err != nil {
+					recordFailure(dir.path, true, err)
+				}
+			})
+		}
+		doParallel(parallelism, operations)
+		start = end
+	}
+
+	if len(failures) > 0 {
+		return &DeleteRecursiveError{Failures: failures}
+	}
+	return nil
+}
+
+// SkipDir is used as a return value from a WalkFunc to indicate that the directory named in the call is to be skipped.
+// When returned for a file, the remaining entries of the file's parent directory are skipped instead.
+// It is not returned as an error by Walk.
+var SkipDir = errors.New("skip this directory")
+
+// WalkEntry describes a file or directory visited by Walk.
+type WalkEntry struct {
+	// Path is the entry's path relative to the share's root, using '/' as the separator.
+	Path string
+
+	// Name is the entry's name within its parent directory.
+	Name string
+
+	// IsDirectory is true if the entry is a directory.
+	IsDirectory bool
+
+	// Depth is 1 for entries directly inside the directory being walked, 2 for their children, and so on.
+	Depth int
+
+	// Properties contains the file properties returned by the listing; it is nil for directories.
+	Properties *FileProperty
+}
+
+// WalkFunc is the type of the function called by Walk to visit each file or directory.
+//
+// If listing a directory fails, the function is called a second time for that directory with a non-nil err.
+// Returning SkipDir (or nil) from that call continues the walk with the next directory; returning any other
+// error stops the walk.
+//
+// If the function returns SkipDir for a directory, Walk does not descend into it. Any other non-nil error
+// stops the walk and Walk returns that error.
+type WalkFunc func(entry WalkEntry, err error) error
+
+// WalkOptions identifies options used by the DirectoryURL's and ShareURL's Walk methods.
+type WalkOptions struct {
+	// Prefix restricts the walk to entries whose path, relative to the directory being walked, starts with Prefix.
+	// For example, "logs/2020-" visits everything in the "logs" directory whose name starts with "2020-"; directories
+	// that merely lead towards the prefix, like "logs" here, are descended into but not visited themselves.
+	Prefix string
+
+	// MaxDepth limits how deep the walk descends; 1 visits only the entries directly inside the directory being walked.
+	// If 0(default) is provided, the walk is not limited.
+	MaxDepth int
+
+	// Parallelism indicates the maximum number of directories to list in parallel. If 0(default) is provided, 5 parallelism will be used by default.
+	Parallelism uint16
+}
+
+// Walk walks the file tree rooted at the directory, calling fn for each file and directory beneath it; the directory
+// itself is not visited. Listing markers are followed automatically, and subdirectories are listed concurrently.
+// Calls to fn never overlap, so fn needn't be goroutine-safe. A directory's listing segments are visited in the order
+// the service returns them, and the entries of each segment in lexicographic order; a directory is always visited
+// before its contents, but the order in which different directories are visited is not deterministic.
+func (d DirectoryURL) Walk(ctx context.Context, o WalkOptions, fn WalkFunc) error {
+	parallelism := o.Parallelism
+	if parallelism == 0 {
+		parallelism = defaultParallelCount // default parallelism
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w := &walker{ctx: ctx, cancel: cancel, o: o, fn: fn}
+	w.queueCond = sync.NewCond(&w.queueLock)
+	rootPath := strings.TrimSuffix(NewFileURLParts(d.URL()).DirectoryOrFilePath, "/")
+	w.enqueue(walkerDirectory{d: d, dir: WalkEntry{Path: rootPath, IsDirectory: true}})
+
+	wg := &sync.WaitGroup{}
+	for i := uint16(0); i < parallelism; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.work()
+		}()
+	}
+	wg.Wait()
+	return w.err
+}
+
+// Walk walks the file tree of the share, calling fn for each file and directory in it.
+// See DirectoryURL's Walk method for details.
+func (s ShareURL) Walk(ctx context.Context, o WalkOptions, fn WalkFunc) error {
+	return s.NewRootDirectoryURL().Walk(ctx, o, fn)
+}
+
+// walker holds the state shared by the workers of a single Walk.
+type walker struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	o      WalkOptions
+	fn     WalkFunc
+
+	queueLock sync.Mutex // Guards queue and pending
+	queueCond *sync.Cond // Signaled when a directory is queued, or when pending drops to 0
+	queue     []walkerDirectory
+	pending   int // The number of directories queued or being walked
+
+	fnLock sync.Mutex // Serializes calls to fn and guards err
+	err    error
+}
+
+// walkerDirectory is a directory waiting to be walked; see walkDirectory.
+type walkerDirectory struct {
+	d       DirectoryURL
+	dir     WalkEntry
+	relPath string
+}
+
+// work walks queued directories until none are left, either queued or being walked by another worker.
+func (w *walker) work() {
+	for {
+		w.queueLock.Lock()
+		for len(w.queue) == 0 && w.pending > 0 {
+			w.queueCond.Wait()
+		}
+		if len(w.queue) == 0 {
+			w.queueLock.Unlock()
+			return
+		}
+		next := w.queue[0]
+		w.queue = w.queue[1:]
+		w.queueLock.Unlock()
+
+		w.walkDirectory(next.d, next.dir, next.relPath)
+
+		w.queueLock.Lock()
+		w.pending--
+		if w.pending == 0 {
+			w.queueCond.Broadcast() // Let the idle workers return
+		}
+		w.queueLock.Unlock()
+	}
+}
+
+// enqueue queues a directory for a worker to walk.
+func (w *walker) enqueue(next walkerDirectory) {
+	w.queueLock.Lock()
+	defer w.queueLock.Unlock()
+	w.queue = append(w.queue, next)
+	w.pending++
+	w.queueCond.Signal()
+}
+
+// visit calls the user's function, unless the walk has already been stopped, and reports whether the walk should
+// continue along with whether the entry (or the rest of its directory) should be skipped.
+func (w *walker) visit(entry WalkEntry, err error) (proceed, skip bool) {
+	w.fnLock.Lock()
+	defer w.fnLock.Unlock()
+	if w.err != nil {
+		return false, true
+	}
+	switch fnErr := w.fn(entry, err); fnErr {
+	case nil:
+		return true, false
+	case SkipDir:
+		return true, true
+	default:
+		w.err = fnErr
+		w.cancel() // Abandon any listing still in flight
+		return false, true
+	}
+}
+
+func (w *walker) stopped() bool {
+	w.fnLock.Lock()
+	defer w.fnLock.Unlock()
+	return w.err != nil
+}
+
+// walkDirectory lists the directory described by dir, whose path relative to the walk's root is relPath, and visits its
+// entries, queuing each subdirectory that should be walked.
+func (w *walker) walkDirectory(d DirectoryURL, dir WalkEntry, relPath string) {
+	listOptions := ListFilesAndDirectoriesOptions{Prefix: w.listingPrefix(relPath)}
+	for marker := (Marker{}); marker.NotDone(); {
+		if w.stopped() {
+			return
+		}
+		resp, err := d.ListFilesAndDirectoriesSegment(w.ctx, marker, listOptions)
+		if err != nil {
+			w.visit(dir, err) // A failure listing the walk's root is reported with Depth 0
+			return
+		}
+		marker = resp.NextMarker
+
+		entries := make([]WalkEntry, 0, len(resp.FileItems)+len(resp.DirectoryItems))
+		for _, f := range resp.FileItems {
+			entries = append(entries, WalkEntry{Name: f.Name, Properties: f.Properties})
+		}
+		for _, sub := range resp.DirectoryItems {
+			entries = append(entries, WalkEntry{Name: sub.Name, IsDirectory: true})
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+
+		for _, entry := range entries {
+			entryRelPath := joinSharePath(relPath, entry.Name)
+			descend := entry.IsDirectory && strings.HasPrefix(w.o.Prefix, entryRelPath+"/")
+			if !strings.HasPrefix(entryRelPath, w.o.Prefix) && !descend {
+				continue // Neither matches the prefix, nor can contain anything that does
+			}
+			entry.Path = joinSharePath(dir.Path, entry.Name)
+			entry.Depth = dir.Depth + 1
+
+			if !descend { // Directories that merely lead towards the prefix aren't visited themselves
+				proceed, skip := w.visit(entry, nil)
+				if !proceed {
+					return
+				}
+				if skip {
+					if entry.IsDirectory {
+						continue
+					}
+					return // SkipDir on a file skips the rest of its directory
+				}
+			}
+			if entry.IsDirectory && (w.o.MaxDepth == 0 || entry.Depth < w.o.MaxDepth) {
+				w.enqueue(walkerDirectory{d: d.NewDirectoryURL(entry.Name), dir: entry, relPath: entryRelPath})
+			}
+		}
+	}
+}
+
+// listingPrefix returns the prefix to send to the service when listing the directory at relPath, so that
+// only entries that match (or lead towards) the walk's prefix are returned.
+func (w *walker) listingPrefix(relPath string) string {
+	rest := w.o.Prefix
+	if relPath != "" {
+		if !strings.HasPrefix(rest, relPath+"/") {
+			return "" // The directory is already inside the prefix
+		}
+		rest = rest[len(relPath)+1:]
+	}
+	if i := strings.Index(rest, "/"); i >= 0 {
+		return rest[:i] // Only the directory leading towards the prefix is wanted
+	}
+	return rest
+}
+
+// joinSharePath joins a share-relative directory path and an entry name.
+func joinSharePath(dir, name string) string {
+	if dir == "" {
+		return name
+	}
+	return dir + "/" + name
+}
+
+// doParallel runs every operation, with at most parallelism of them running at once, and waits for all of them to finish.
+// Unlike doBatchTransfer, a failing operation does not prevent the remaining operations from running.
+func doParallel(parallelism uint16, operations []func()) {
+	wg := &sync.WaitGroup{}
+	semaphore := make(chan struct{}, parallelism)
+	for _, operation := range operations {
+		semaphore <- struct{}{}
+		wg.Add(1)
+		go func(operation func()) {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+			operation()
+		}(operation)
+	}
+	wg.Wait()
+}
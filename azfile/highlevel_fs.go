@@ -0,0 +1,381 @@
+//go:build go1.16
+// +build go1.16
+
+package azfile
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"sort"
+	"time"
+)
+
+// ShareFSOptions identifies options used by the io/fs adapter returned by NewShareFS and NewDirectoryFS.
+type ShareFSOptions struct {
+	// Download configures how ReadFile downloads whole files; reads through an opened file also use its MaxRetryRequestsPerRange.
+	Download DownloadFromAzureFileOptions
+}
+
+// ShareFS is a read-only view of a share (or of a directory within it) that implements fs.FS, fs.ReadDirFS,
+// fs.StatFS and fs.ReadFileFS. Reads are served by range downloads, so opening a large file is cheap.
+// Create the ShareFS from a ShareURL returned by ShareURL.WithSnapshot for a point-in-time view.
+type ShareFS struct {
+	ctx  context.Context
+	root DirectoryURL
+	o    ShareFSOptions
+}
+
+var (
+	_ fs.ReadDirFS   = (*ShareFS)(nil)
+	_ fs.StatFS      = (*ShareFS)(nil)
+	_ fs.ReadFileFS  = (*ShareFS)(nil)
+	_ fs.ReadDirFile = (*shareFSDir)(nil)
+	_ io.ReaderAt    = (*shareFSFile)(nil)
+	_ io.Seeker      = (*shareFSFile)(nil)
+)
+
+// NewShareFS returns a read-only fs.FS rooted at the share's root directory. All of the requests it issues use ctx,
+// because the io/fs interfaces don't accept a context of their own.
+func NewShareFS(ctx context.Context, shareURL ShareURL, o ShareFSOptions) *ShareFS {
+	return NewDirectoryFS(ctx, shareURL.NewRootDirectoryURL(), o)
+}
+
+// NewDirectoryFS returns a read-only fs.FS rooted at the specified directory.
+func NewDirectoryFS(ctx context.Context, directoryURL DirectoryURL, o ShareFSOptions) *ShareFS {
+	return &ShareFS{ctx: ctx, root: directoryURL, o: o}
+}
+
+// Open opens the named file or directory. Directories implement fs.ReadDirFile, and files implement io.ReaderAt and io.Seeker.
+func (s *ShareFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return &shareFSDir{fsys: s, name: name, directoryURL: s.root}, nil
+	}
+
+	fileURL := s.root.NewFileURL(name)
+	fileProperties, err := fileURL.GetProperties(s.ctx)
+	if err == nil {
+		return &shareFSFile{fsys: s, name: name, fileURL: fileURL, info: newShareFSFileInfo(name, fileProperties)}, nil
+	}
+	if !IsNotFound(err) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: toFSError(err)}
+	}
+
+	// The name doesn't refer to a file; it may refer to a directory.
+	directoryURL := s.root.NewDirectoryURL(name)
+	directoryProperties, err := directoryURL.GetProperties(s.ctx)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: toFSError(err)}
+	}
+	return &shareFSDir{fsys: s, name: name, directoryURL: directoryURL, info: newShareFSDirInfo(name, directoryProperties)}, nil
+}
+
+// Stat returns a FileInfo describing the named file or directory.
+func (s *ShareFS) Stat(name string) (fs.FileInfo, error) {
+	f, err := s.Open(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: errors.Unwrap(err)}
+	}
+	defer f.Close()
+	return f.Stat()
+}
+
+// ReadDir reads the named directory and returns its entries sorted by name.
+func (s *ShareFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	d := &shareFSDir{fsys: s, name: name, directoryURL: s.root}
+	if name != "." {
+		d.directoryURL = s.root.NewDirectoryURL(name)
+	}
+	entries, err := d.ReadDir(-1)
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, err
+}
+
+// ReadFile downloads the whole named file in parallel and returns its contents.
+func (s *ShareFS) ReadFile(name string) ([]byte, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: fs.ErrInvalid}
+	}
+	fileURL := s.root.NewFileURL(name)
+	fileProperties, err := fileURL.GetProperties(s.ctx)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: toFSError(err)}
+	}
+	b := make([]byte, fileProperties.ContentLength())
+	if _, err = downloadAzureFileToBuffer(s.ctx, fileURL, fileProperties, b, true, s.o.Download, nil); err != nil {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: toFSError(err)}
+	}
+	return b, nil
+}
+
+// toFSError maps the storage errors that have an io/fs equivalent to that equivalent so that errors.Is works as
+// callers of an fs.FS expect; other errors are returned unchanged.
+func toFSError(err error) error {
+	if IsNotFound(err) {
+		return fs.ErrNotExist
+	}
+	if stgErr, ok := asStorageError(err); ok && storageErrorStatus(stgErr) == http.StatusForbidden {
+		return fs.ErrPermission
+	}
+	return err
+}
+
+// shareFSFileInfo implements fs.FileInfo. Sys returns the *FileGetPropertiesResponse or *DirectoryGetPropertiesResponse.
+type shareFSFileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+	sys     interface{}
+}
+
+func newShareFSFileInfo(name string, p *FileGetPropertiesResponse) *shareFSFileInfo {
+	return &shareFSFileInfo{name: path.Base(name), size: p.ContentLength(), mode: 0444,
+		modTime: lastWriteTime(p.FileLastWriteTime(), p.LastModified()), sys: p}
+}
+
+func newShareFSDirInfo(name string, p *DirectoryGetPropertiesResponse) *shareFSFileInfo {
+	return &shareFSFileInfo{name: path.Base(name), mode: fs.ModeDir | 0555,
+		modTime: lastWriteTime(p.FileLastWriteTime(), p.LastModified()), sys: p}
+}
+
+// lastWriteTime prefers the SMB last write time, which is what SMB clients see, over the service's Last-Modified time.
+func lastWriteTime(fileLastWriteTime string, lastModified time.Time) time.Time {
+	if t, err := time.Parse(ISO8601, fileLastWriteTime); err == nil {
+		return t
+	}
+	return lastModified
+}
+
+func (i *shareFSFileInfo) Name() string       { return i.name }
+func (i *shareFSFileInfo) Size() int64        { return i.size }
+func (i *shareFSFileInfo) Mode() fs.FileMode  { return i.mode }
+func (i *shareFSFileInfo) ModTime() time.Time { return i.modTime }
+func (i *shareFSFileInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i *shareFSFileInfo) Sys() interface{}   { return i.sys }
+
+// shareFSFile is an opened file. Sequential reads stream a single download from the current offset to the end of
+// the file; ReadAt issues a range download per call, so it may be used concurrently.
+type shareFSFile struct {
+	fsys    *ShareFS
+	name    string
+	fileURL FileURL
+	info    *shareFSFileInfo
+	offset  int64
+	body    io.ReadCloser // The download serving sequential reads, or nil
+	closed  bool
+}
+
+func (f *shareFSFile) Stat() (fs.FileInfo, error) {
+	if f.closed {
+		return nil, &fs.PathError{Op: "stat", Path: f.name, Err: fs.ErrClosed}
+	}
+	return f.info, nil
+}
+
+func (f *shareFSFile) Read(p []byte) (int, error) {
+	if f.closed {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: fs.ErrClosed}
+	}
+	if f.offset >= f.info.size {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if f.body == nil {
+		dr, err := f.fileURL.Download(f.fsys.ctx, f.offset, f.info.size-f.offset, false)
+		if err != nil {
+			return 0, &fs.PathError{Op: "read", Path: f.name, Err: toFSError(err)}
+		}
+		f.body = dr.Body(RetryReaderOptions{MaxRetryRequests: f.fsys.o.Download.MaxRetryRequestsPerRange})
+	}
+	n, err := f.body.Read(p)
+	f.offset += int64(n)
+	if err == io.EOF {
+		f.body.Close()
+		f.body = nil
+		if f.offset < f.info.size {
+			err = io.ErrUnexpectedEOF
+		}
+	}
+	return n, err
+}
+
+func (f *shareFSFile) ReadAt(p []byte, off int64) (int, error) {
+	if f.closed {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: fs.ErrClosed}
+	}
+	if off < 0 {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: fs.ErrInvalid}
+	}
+	if off >= f.info.size {
+		return 0, io.EOF
+	}
+	count := int64(len(p))
+	if count == 0 {
+		return 0, nil
+	}
+	if count > f.info.size-off {
+		count = f.info.size - off
+	}
+	dr, err := f.fileURL.Download(f.fsys.ctx, off, count, false)
+	if err != nil {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: toFSError(err)}
+	}
+	body := dr.Body(RetryReaderOptions{MaxRetryRequests: f.fsys.o.Download.MaxRetryRequestsPerRange})
+	defer body.Close()
+	n, err := io.ReadFull(body, p[:count])
+	if err == nil && count < int64(len(p)) {
+		err = io.EOF // ReadAt must report why it read fewer than len(p) bytes
+	}
+	return n, err
+}
+
+func (f *shareFSFile) Seek(offset int64, whence int) (int64, error) {
+	if f.closed {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrClosed}
+	}
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.info.size
+	default:
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+	}
+	if offset != f.offset && f.body != nil {
+		f.body.Close()
+		f.body = nil
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *shareFSFile) Close() error {
+	if f.closed {
+		return &fs.PathError{Op: "close", Path: f.name, Err: fs.ErrClosed}
+	}
+	f.closed = true
+	if f.body != nil {
+		f.body.Close()
+		f.body = nil
+	}
+	return nil
+}
+
+// shareFSDir is an opened directory. Its entries are listed a segment at a time as ReadDir asks for them.
+type shareFSDir struct {
+	fsys         *ShareFS
+	name         string
+	directoryURL DirectoryURL
+	info         *shareFSFileInfo // Fetched lazily for the root directory
+	marker       Marker
+	listed       bool          // true once the first segment has been listed
+	pending      []fs.DirEntry // Entries listed but not yet returned
+	closed       bool
+}
+
+func (d *shareFSDir) Stat() (fs.FileInfo, error) {
+	if d.closed {
+		return nil, &fs.PathError{Op: "stat", Path: d.name, Err: fs.ErrClosed}
+	}
+	if d.info == nil {
+		p, err := d.directoryURL.GetProperties(d.fsys.ctx)
+		if err != nil {
+			return nil, &fs.PathError{Op: "stat", Path: d.name, Err: toFSError(err)}
+		}
+		d.info = newShareFSDirInfo(d.name, p)
+	}
+	return d.info, nil
+}
+
+func (d *shareFSDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: errors.New("is a directory")}
+}
+
+// ReadDir returns the next n entries in the order the service lists them, or all of the remaining entries if n <= 0.
+func (d *shareFSDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if d.closed {
+		return nil, &fs.PathError{Op: "readdir", Path: d.name, Err: fs.ErrClosed}
+	}
+	for (n <= 0 || len(d.pending) < n) && (!d.listed || d.marker.NotDone()) {
+		lr, err := d.directoryURL.ListFilesAndDirectoriesSegment(d.fsys.ctx, d.marker, ListFilesAndDirectoriesOptions{})
+		if err != nil {
+			return nil, &fs.PathError{Op: "readdir", Path: d.name, Err: toFSError(err)}
+		}
+		d.listed = true
+		d.marker = lr.NextMarker
+		for _, dirItem := range lr.DirectoryItems {
+			d.pending = append(d.pending, &shareFSDirEntry{fsys: d.fsys, dir: d.name, name: dirItem.Name, isDir: true})
+		}
+		for _, fileItem := range lr.FileItems {
+			d.pending = append(d.pending, &shareFSDirEntry{fsys: d.fsys, dir: d.name, name: fileItem.Name})
+		}
+	}
+
+	entries := d.pending
+	if n > 0 && len(entries) > n {
+		entries = entries[:n]
+	}
+	d.pending = d.pending[len(entries):]
+	if n > 0 && len(entries) == 0 {
+		return entries, io.EOF
+	}
+	return entries, nil
+}
+
+func (d *shareFSDir) Close() error {
+	if d.closed {
+		return &fs.PathError{Op: "close", Path: d.name, Err: fs.ErrClosed}
+	}
+	d.closed = true
+	return nil
+}
+
+// shareFSDirEntry implements fs.DirEntry; Info gets the entry's properties when it's called.
+type shareFSDirEntry struct {
+	fsys  *ShareFS
+	dir   string
+	name  string
+	isDir bool
+}
+
+func (e *shareFSDirEntry) Name() string { return e.name }
+func (e *shareFSDirEntry) IsDir() bool  { return e.isDir }
+
+func (e *shareFSDirEntry) Type() fs.FileMode {
+	if e.isDir {
+		return fs.ModeDir
+	}
+	return 0
+}
+
+func (e *shareFSDirEntry) Info() (fs.FileInfo, error) {
+	name := path.Join(e.dir, e.name)
+	if e.isDir {
+		p, err := e.fsys.root.NewDirectoryURL(name).GetProperties(e.fsys.ctx)
+		if err != nil {
+			return nil, &fs.PathError{Op: "stat", Path: name, Err: toFSError(err)}
+		}
+		return newShareFSDirInfo(name, p), nil
+	}
+	p, err := e.fsys.root.NewFileURL(name).GetProperties(e.fsys.ctx)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: toFSError(err)}
+	}
+	return newShareFSFileInfo(name, p), nil
+}
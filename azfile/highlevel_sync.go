@@ -0,0 +1,428 @@
+package azfile
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// syncTimePrecision is the precision of the last write times kept by the service; times that are equal to this
+// precision are considered equal.
+const syncTimePrecision = 100 * time.Nanosecond
+
+// SyncOptions identifies options used by the SyncDirectoryToAzureDirectory and SyncAzureDirectoryToDirectory functions.
+type SyncOptions struct {
+	// DeleteExtra, if true, deletes the files and directories at the destination that don't exist at the source.
+	DeleteExtra bool
+
+	// DryRun, if true, only plans the sync: the actions that the sync would take are returned, but none is taken.
+	DryRun bool
+
+	// Parallelism indicates the maximum number of files to compare, transfer or delete in parallel.
+	// If 0(default) is provided, 5 parallelism will be used by default.
+	Parallelism uint16
+
+	// Upload contains the options used to upload each file. Its Progress and CheckpointPath are ignored.
+	Upload UploadToAzureFileOptions
+
+	// Download contains the options used to download each file. Its Progress and CheckpointPath are ignored.
+	Download DownloadFromAzureFileOptions
+}
+
+// SyncActionType identifies what a SyncAction does.
+type SyncActionType string
+
+const (
+	// SyncActionCreateDirectory creates a directory at the destination.
+	SyncActionCreateDirectory SyncActionType = "createDirectory"
+
+	// SyncActionUpload uploads a local file to the Azure directory.
+	SyncActionUpload SyncActionType = "upload"
+
+	// SyncActionDownload downloads an Azure file to the local directory.
+	SyncActionDownload SyncActionType = "download"
+
+	// SyncActionDelete deletes a file, or a directory and everything beneath it, at the destination.
+	SyncActionDelete SyncActionType = "delete"
+)
+
+// SyncAction describes an action that a sync takes, or would take in a dry run, at the destination.
+type SyncAction struct {
+	Type SyncActionType
+
+	// Path is the path of the file or directory relative to the directories being synced, using '/' as the separator.
+	// It's "" for the destination directory itself, which is created if it doesn't exist.
+	Path string
+
+	// IsDirectory is true if Path refers to a directory.
+	IsDirectory bool
+
+	// Size is the number of bytes to transfer, for uploads and downloads.
+	Size int64
+}
+
+// SyncFailure describes an action of a sync that failed.
+type SyncFailure struct {
+	Action SyncAction
+	Err    error
+}
+
+// SyncError is returned by SyncDirectoryToAzureDirectory and SyncAzureDirectoryToDirectory when one or more actions failed.
+type SyncError struct {
+	// Failures lists every action that failed.
+	Failures []SyncFailure
+}
+
+// Error implements the error interface's Error method to return a string representation of the error.
+func (e *SyncError) Error() string {
+	b := &bytes.Buffer{}
+	fmt.Fprintf(b, "%d sync action(s) failed:\n", len(e.Failures))
+	for _, f := range e.Failures {
+		reason := ""
+		if stErr, ok := f.Err.(StorageError); ok && stErr.ServiceCode() != ServiceCodeNone {
+			reason = string(stErr.ServiceCode()) // The full StorageError text is far too long to repeat per path
+		} else {
+			reason = f.Err.Error()
+		}
+		fmt.Fprintf(b, "   %s %s: %s\n", f.Action.Type, f.Action.Path, reason)
+	}
+	return b.String()
+}
+
+// SyncDirectoryToAzureDirectory makes the Azure directory a mirror of the local directory: the local files that don't
+// exist in the Azure directory, or whose size or last write time differs from the Azure file's, are uploaded, and with
+// o.DeleteExtra, the Azure files and directories that don't exist locally are deleted. Uploaded files get the local
+// file's modification time as their last write time, so that they compare as unchanged until the local file changes.
+// Only regular files and directories are synced; symbolic links and other special files are ignored.
+// It returns the actions taken, or with o.DryRun, the actions that would be taken. Failing actions don't stop the sync;
+// once every action has been attempted, a *SyncError listing the failures is returned. Errors listing either directory,
+// or comparing their files, are returned as-is, before any action is taken; a missing destination directory is created,
+// but a missing source directory is an error.
+func SyncDirectoryToAzureDirectory(ctx context.Context, localPath string, d DirectoryURL, o SyncOptions) ([]SyncAction, error) {
+	return newSyncer(localPath, d, true, o).sync(ctx)
+}
+
+// SyncAzureDirectoryToDirectory makes the local directory a mirror of the Azure directory: the Azure files that don't
+// exist in the local directory, or whose size or last write time differs from the local file's, are downloaded, and
+// with o.DeleteExtra, the local files and directories that don't exist in the Azure directory are deleted. Downloaded
+// files get the Azure file's last write time as their modification time.
+// See SyncDirectoryToAzureDirectory for the other details, which are the same in both directions.
+func SyncAzureDirectoryToDirectory(ctx context.Context, d DirectoryURL, localPath string, o SyncOptions) ([]SyncAction, error) {
+	return newSyncer(localPath, d, false, o).sync(ctx)
+}
+
+// syncEntry describes a file or directory of one side of a sync.
+type syncEntry struct {
+	isDirectory   bool
+	size          int64
+	lastWriteTime time.Time // Zero for Azure files, whose listing doesn't include it
+}
+
+// syncer holds the state of a single sync.
+type syncer struct {
+	localPath string
+	d         DirectoryURL
+	upload    bool // Whether the local directory is the source
+	o         SyncOptions
+}
+
+func newSyncer(localPath string, d DirectoryURL, upload bool, o SyncOptions) *syncer {
+	if o.Parallelism == 0 {
+		o.Parallelism = defaultParallelCount // default parallelism
+	}
+	o.Upload.Progress, o.Upload.CheckpointPath = nil, ""
+	o.Download.Progress, o.Download.CheckpointPath = nil, ""
+	o.Download.PreserveLastWriteTime = true // Which later syncs compare
+	return &syncer{localPath: localPath, d: d, upload: upload, o: o}
+}
+
+func (s *syncer) sync(ctx context.Context) ([]SyncAction, error) {
+	// 1. List both directories.
+	local, localExists, err := s.listLocal()
+	if err != nil {
+		return nil, err
+	}
+	if !localExists && s.upload {
+		return nil, &os.PathError{Op: "sync", Path: s.localPath, Err: os.ErrNotExist}
+	}
+	azure, azureExists, err := s.listAzure(ctx)
+	if err != nil {
+		return nil, err
+	}
+	source, destination, destinationExists := local, azure, azureExists
+	if !s.upload {
+		source, destination, destinationExists = azure, local, localExists
+	}
+
+	// 2. Plan the actions.
+	plan, err := s.plan(ctx, source, destination, destinationExists)
+	if err != nil || s.o.DryRun {
+		return plan, err
+	}
+
+	// 3. Take them: the destination directory is created first, then the deletes (which also make way for files
+	// replacing directories and vice versa) and the creation of directories (parents first), then the transfers.
+	failures := []SyncFailure{}
+	failuresLock := &sync.Mutex{}
+	take := func(a SyncAction) {
+		if err := s.take(ctx, a); err != nil {
+			failuresLock.Lock()
+			defer failuresLock.Unlock()
+			failures = append(failures, SyncFailure{Action: a, Err: err})
+		}
+	}
+	remaining := plan
+	if len(remaining) > 0 && remaining[0].Path == "" {
+		take(remaining[0])
+		if len(failures) > 0 {
+			return plan, &SyncError{Failures: failures} // Nothing else can succeed
+		}
+		remaining = remaining[1:]
+	}
+	operations := []func(){}
+	for len(remaining) > 0 && remaining[0].Type == SyncActionDelete {
+		a := remaining[0]
+		operations = append(operations, func() { take(a) })
+		remaining = remaining[1:]
+	}
+	doParallel(s.o.Parallelism, operations)
+	for len(remaining) > 0 && remaining[0].Type == SyncActionCreateDirectory {
+		take(remaining[0])
+		remaining = remaining[1:]
+	}
+	operations = operations[:0]
+	for _, a := range remaining {
+		a := a
+		operations = append(operations, func() { take(a) })
+	}
+	doParallel(s.o.Parallelism, operations)
+
+	if len(failures) > 0 {
+		sort.Slice(failures, func(i, j int) bool { return failures[i].Action.Path < failures[j].Action.Path })
+		return plan, &SyncError{Failures: failures}
+	}
+	return plan, nil
+}
+
+// listLocal lists the local directory's tree, keyed by path relative to it, and reports whether the directory exists.
+func (s *syncer) listLocal() (map[string]syncEntry, bool, error) {
+	if _, err := os.Stat(s.localPath); os.IsNotExist(err) {
+		return map[string]syncEntry{}, false, nil
+	}
+	entries := map[string]syncEntry{}
+	err := filepath.Walk(s.localPath, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(s.localPath, p)
+		if err != nil || relPath == "." {
+			return err
+		}
+		switch {
+		case info.IsDir():
+			entries[filepath.ToSlash(relPath)] = syncEntry{isDirectory: true}
+		case info.Mode().IsRegular():
+			entries[filepath.ToSlash(relPath)] = syncEntry{size: info.Size(), lastWriteTime: info.ModTime()}
+		}
+		return nil
+	})
+	return entries, true, err
+}
+
+// listAzure lists the Azure directory's tree, keyed by path relative to it, and reports whether the directory exists.
+// A missing directory is only reported as such when it's the destination; as the source, its not found error is returned.
+func (s *syncer) listAzure(ctx context.Context) (map[string]syncEntry, bool, error) {
+	rootPath := strings.TrimSuffix(NewFileURLParts(s.d.URL()).DirectoryOrFilePath, "/")
+	entries := map[string]syncEntry{}
+	exists := true
+	err := s.d.Walk(ctx, WalkOptions{Parallelism: s.o.Parallelism}, func(entry WalkEntry, err error) error {
+		if err != nil {
+			if entry.Depth == 0 && IsNotFound(err) && s.upload {
+				exists = false
+				return nil
+			}
+			return err
+		}
+		relPath := entry.Path
+		if rootPath != "" {
+			relPath = entry.Path[len(rootPath)+1:]
+		}
+		if entry.IsDirectory {
+			entries[relPath] = syncEntry{isDirectory: true}
+		} else {
+			entries[relPath] = syncEntry{size: entry.Properties.ContentLength}
+		}
+		return nil
+	})
+	return entries, exists, err
+}
+
+// plan returns the actions that make destination a mirror of source.
+func (s *syncer) plan(ctx context.Context, source map[string]syncEntry, destination map[string]syncEntry,
+	destinationExists bool) ([]SyncAction, error) {
+
+	transferType := SyncActionUpload
+	if !s.upload {
+		transferType = SyncActionDownload
+	}
+	var creates, deletes, transfers []SyncAction
+	if !destinationExists {
+		creates = append(creates, SyncAction{Type: SyncActionCreateDirectory, IsDirectory: true})
+	}
+
+	// 1. Go through the source's entries; those whose destination has the same size have their times compared later.
+	deleted := map[string]bool{} // The destination's directories that are deleted
+	sizeMatches := []string{}
+	for _, p := range sortedSyncPaths(source) {
+		src := source[p]
+		dst, exists := destination[p]
+		if exists && dst.isDirectory != src.isDirectory {
+			deletes = append(deletes, SyncAction{Type: SyncActionDelete, Path: p, IsDirectory: dst.isDirectory})
+			deleted[p] = dst.isDirectory
+			exists = false
+		}
+		switch {
+		case src.isDirectory && !exists:
+			creates = append(creates, SyncAction{Type: SyncActionCreateDirectory, Path: p, IsDirectory: true})
+		case src.isDirectory:
+		case !exists || dst.size != src.size:
+			transfers = append(transfers, SyncAction{Type: transferType, Path: p, Size: src.size})
+		default:
+			sizeMatches = append(sizeMatches, p)
+		}
+	}
+
+	// 2. Compare the last write times of the files of the same size; the Azure files' times need their properties.
+	changed := make([]bool, len(sizeMatches))
+	errs := make([]error, len(sizeMatches))
+	operations := make([]func(), 0, len(sizeMatches))
+	for i, p := range sizeMatches {
+		i, p := i, p
+		operations = append(operations, func() {
+			props, err := s.d.NewFileURL(p).GetProperties(ctx)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			localTime, azureTime := source[p].lastWriteTime, (&SMBPropertyAdapter{PropertySource: props}).FileLastWriteTime()
+			if !s.upload {
+				localTime = destination[p].lastWriteTime
+			}
+			changed[i] = !localTime.Truncate(syncTimePrecision).Equal(azureTime.Truncate(syncTimePrecision))
+		})
+	}
+	doParallel(s.o.Parallelism, operations)
+	for i, p := range sizeMatches {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		if changed[i] {
+			transfers = append(transfers, SyncAction{Type: transferType, Path: p, Size: source[p].size})
+		}
+	}
+	sort.Slice(transfers, func(i, j int) bool { return transfers[i].Path < transfers[j].Path })
+
+	// 3. Delete the destination's extra entries, except those already deleted along with a directory.
+	if s.o.DeleteExtra {
+		for _, p := range sortedSyncPaths(destination) {
+			if _, inSource := source[p]; inSource || inDeletedDirectory(p, deleted) {
+				continue
+			}
+			deletes = append(deletes, SyncAction{Type: SyncActionDelete, Path: p, IsDirectory: destination[p].isDirectory})
+			deleted[p] = destination[p].isDirectory
+		}
+		sort.Slice(deletes, func(i, j int) bool { return deletes[i].Path < deletes[j].Path })
+	}
+
+	plan := make([]SyncAction, 0, len(creates)+len(deletes)+len(transfers))
+	if len(creates) > 0 && creates[0].Path == "" {
+		plan, creates = append(plan, creates[0]), creates[1:]
+	}
+	plan = append(plan, deletes...)
+	plan = append(plan, creates...)
+	return append(plan, transfers...), nil
+}
+
+// take takes an action of the plan.
+func (s *syncer) take(ctx context.Context, a SyncAction) error {
+	localPath := filepath.Join(s.localPath, filepath.FromSlash(a.Path))
+	switch {
+	case a.Type == SyncActionCreateDirectory && s.upload:
+		d := s.d
+		if a.Path != "" {
+			d = s.d.NewDirectoryURL(a.Path)
+		}
+		_, err := d.Create(ctx, Metadata{}, SMBProperties{})
+		return err
+	case a.Type == SyncActionCreateDirectory:
+		return os.MkdirAll(localPath, 0777)
+	case a.Type == SyncActionDelete && s.upload && a.IsDirectory:
+		return s.d.NewDirectoryURL(a.Path).DeleteRecursive(ctx, DeleteDirectoryRecursiveOptions{Parallelism: s.o.Parallelism})
+	case a.Type == SyncActionDelete && s.upload:
+		_, err := s.d.NewFileURL(a.Path).Delete(ctx)
+		return err
+	case a.Type == SyncActionDelete:
+		return os.RemoveAll(localPath)
+	case a.Type == SyncActionUpload:
+		return s.uploadFile(ctx, localPath, s.d.NewFileURL(a.Path))
+	default:
+		return s.downloadFile(ctx, s.d.NewFileURL(a.Path), localPath)
+	}
+}
+
+// uploadFile uploads a local file, and gives the Azure file the local file's modification time as its last write time.
+func (s *syncer) uploadFile(ctx context.Context, localPath string, fileURL FileURL) error {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	o := s.o.Upload
+	lastWriteTime := stat.ModTime()
+	o.FileHTTPHeaders.FileLastWriteTime = &lastWriteTime
+	return UploadFileToAzureFile(ctx, file, fileURL, o)
+}
+
+// downloadFile downloads an Azure file, and gives the local file the Azure file's last write time as its modification time.
+func (s *syncer) downloadFile(ctx context.Context, fileURL FileURL, localPath string) error {
+	file, err := os.OpenFile(localPath, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return err
+	}
+	_, err = DownloadAzureFileToFile(ctx, fileURL, file, s.o.Download)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// sortedSyncPaths returns the paths of entries, sorted so that each directory comes before what's beneath it.
+func sortedSyncPaths(entries map[string]syncEntry) []string {
+	paths := make([]string, 0, len(entries))
+	for p := range entries {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// inDeletedDirectory reports whether p is beneath one of the deleted paths that are directories.
+func inDeletedDirectory(p string, deleted map[string]bool) bool {
+	for dir := path.Dir(p); dir != "."; dir = path.Dir(dir) {
+		if deleted[dir] {
+			return true
+		}
+	}
+	return false
+}
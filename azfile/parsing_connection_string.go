@@ -0,0 +1,170 @@
+package azfile
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+const (
+	// developmentStorageAccountName and developmentStorageAccountKey are the well-known credentials of the storage emulator.
+	developmentStorageAccountName = "devstoreaccount1"
+	developmentStorageAccountKey  = "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw=="
+
+	// developmentStorageHost is the host of the development storage, unless DevelopmentStorageProxyUri says otherwise, and
+	// developmentStorageFilePort is the port its File endpoint is assumed to have. Neither the storage emulator nor
+	// Azurite has a File service, so the port is only a placeholder for a server that provides one.
+	developmentStorageHost     = "127.0.0.1"
+	developmentStorageFilePort = "10004"
+)
+
+// ConnectionString holds the parts of an Azure Storage connection string that the File service uses.
+type ConnectionString struct {
+	// AccountName is the storage account's name.
+	AccountName string
+
+	// FileEndpoint is the URL of the account's File service, without a SAS.
+	FileEndpoint url.URL
+
+	// Credential is the account key's credential, or nil if the connection string has a SAS instead.
+	Credential *SharedKeyCredential
+
+	// SAS is the connection string's shared access signature, or nil if it has an account key instead.
+	SAS *SASQueryParameters
+}
+
+// ParseConnectionString parses an Azure Storage connection string, such as
+// "DefaultEndpointsProtocol=https;AccountName=myaccount;AccountKey=...;EndpointSuffix=core.windows.net".
+// The File service endpoint is FileEndpoint if it's given, and otherwise made of DefaultEndpointsProtocol (https by
+// default), AccountName and EndpointSuffix (core.windows.net by default). The connection string must have either an
+// AccountKey, along with AccountName, or a SharedAccessSignature. "UseDevelopmentStorage=true" stands for the storage
+// emulator's well-known account. As neither the emulator nor Azurite has a File service, its File endpoint is then
+// FileEndpoint if it's given, and otherwise a placeholder at port 10004 of DevelopmentStorageProxyUri's host
+// (http://127.0.0.1:10004/devstoreaccount1 by default). Settings that the File service doesn't use, such as
+// BlobEndpoint, are ignored.
+func ParseConnectionString(connectionString string) (ConnectionString, error) {
+	settings := map[string]string{}
+	for _, setting := range strings.Split(connectionString, ";") {
+		if strings.TrimSpace(setting) == "" {
+			continue
+		}
+		i := strings.Index(setting, "=")
+		if i <= 0 {
+			return ConnectionString{}, fmt.Errorf("invalid connection string setting %q, it must be of the form key=value", setting)
+		}
+		settings[strings.ToLower(strings.TrimSpace(setting[:i]))] = strings.TrimSpace(setting[i+1:])
+	}
+
+	if strings.EqualFold(settings["usedevelopmentstorage"], "true") {
+		settings["accountname"] = developmentStorageAccountName
+		settings["accountkey"] = developmentStorageAccountKey
+		if settings["fileendpoint"] == "" {
+			endpoint, err := developmentStorageFileEndpoint(settings["developmentstorageproxyuri"])
+			if err != nil {
+				return ConnectionString{}, err
+			}
+			settings["fileendpoint"] = endpoint
+		}
+	}
+
+	cs := ConnectionString{AccountName: settings["accountname"]}
+	if endpoint := settings["fileendpoint"]; endpoint != "" {
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return ConnectionString{}, err
+		}
+		if u.Scheme == "" || u.Host == "" {
+			return ConnectionString{}, fmt.Errorf("invalid FileEndpoint %q, it must be an absolute URL", endpoint)
+		}
+		cs.FileEndpoint = *u
+		if cs.AccountName == "" {
+			cs.AccountName = accountNameOfEndpoint(*u)
+		}
+	} else {
+		if cs.AccountName == "" {
+			return ConnectionString{}, errors.New("the connection string has neither FileEndpoint nor AccountName")
+		}
+		protocol, suffix := settings["defaultendpointsprotocol"], settings["endpointsuffix"]
+		if protocol == "" {
+			protocol = "https"
+		}
+		if suffix == "" {
+			suffix = "core.windows.net"
+		}
+		cs.FileEndpoint = url.URL{Scheme: protocol, Host: cs.AccountName + ".file." + suffix}
+	}
+
+	accountKey, sas := settings["accountkey"], strings.TrimPrefix(settings["sharedaccesssignature"], "?")
+	switch {
+	case accountKey != "" && sas != "":
+		return ConnectionString{}, errors.New("the connection string has both AccountKey and SharedAccessSignature")
+	case accountKey != "":
+		if cs.AccountName == "" {
+			return ConnectionString{}, errors.New("the connection string has AccountKey without AccountName")
+		}
+		credential, err := NewSharedKeyCredential(cs.AccountName, accountKey)
+		if err != nil {
+			return ConnectionString{}, err
+		}
+		cs.Credential = credential
+	case sas != "":
+		values, err := url.ParseQuery(sas)
+		if err != nil {
+			return ConnectionString{}, err
+		}
+		p := newSASQueryParameters(values, false)
+		cs.SAS = &p
+	default:
+		return ConnectionString{}, errors.New("the connection string has neither AccountKey nor SharedAccessSignature")
+	}
+	return cs, nil
+}
+
+// developmentStorageFileEndpoint returns the placeholder File endpoint of the development storage, on the host of
+// proxyURI if it's not empty.
+func developmentStorageFileEndpoint(proxyURI string) (string, error) {
+	u := url.URL{Scheme: "http", Host: developmentStorageHost}
+	if proxyURI != "" {
+		proxy, err := url.Parse(proxyURI)
+		if err != nil {
+			return "", err
+		}
+		if proxy.Scheme == "" || proxy.Host == "" {
+			return "", fmt.Errorf("invalid DevelopmentStorageProxyUri %q, it must be an absolute URL", proxyURI)
+		}
+		u.Scheme, u.Host = proxy.Scheme, proxy.Hostname()
+	}
+	u.Host = net.JoinHostPort(u.Host, developmentStorageFilePort)
+	u.Path = "/" + developmentStorageAccountName
+	return u.String(), nil
+}
+
+// accountNameOfEndpoint returns the account name of a File service endpoint: the first path segment of an IP endpoint
+// style URL, and the first label of the host otherwise.
+func accountNameOfEndpoint(u url.URL) string {
+	if parts := NewFileURLParts(u); parts.IPEndpointStyleInfo.AccountName != "" {
+		return parts.IPEndpointStyleInfo.AccountName
+	}
+	if isIPEndpointStyle(u.Host) {
+		return ""
+	}
+	return strings.SplitN(u.Hostname(), ".", 2)[0]
+}
+
+// NewServiceURLFromConnectionString creates a ServiceURL from an Azure Storage connection string, as parsed by
+// ParseConnectionString, with a pipeline created by NewPipeline with o. The pipeline signs the requests with the
+// account key, or the URL carries the SAS.
+func NewServiceURLFromConnectionString(connectionString string, o PipelineOptions) (ServiceURL, error) {
+	cs, err := ParseConnectionString(connectionString)
+	if err != nil {
+		return ServiceURL{}, err
+	}
+	if cs.Credential != nil {
+		return NewServiceURL(cs.FileEndpoint, NewPipeline(cs.Credential, o)), nil
+	}
+	u := cs.FileEndpoint
+	u.RawQuery = cs.SAS.Encode()
+	return NewServiceURL(u, NewPipeline(NewAnonymousCredential(), o)), nil
+}
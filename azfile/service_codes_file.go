@@ -23,6 +23,9 @@ const (
 	// Or File or directory path has too many subdirectories (400).
 	ServiceCodeInvalidFileOrDirectoryPathName ServiceCodeType = "InvalidFileOrDirectoryPathName"
 
+	// There is currently no pending copy operation (409).
+	ServiceCodeNoPendingCopyOperation ServiceCodeType = "NoPendingCopyOperation"
+
 	// The specified parent path does not exist (404).
 	ServiceCodeParentNotFound ServiceCodeType = "ParentNotFound"
 
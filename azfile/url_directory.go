@@ -165,3 +165,42 @@ func (d DirectoryURL) ListFilesAndDirectoriesSegment(ctx context.Context, marker
 	prefix, maxResults := o.pointers()
 	return d.directoryClient.ListFilesAndDirectoriesSegment(ctx, prefix, nil, marker.Val, maxResults, nil)
 }
+
+// ListHandlesSegment returns a single segment of the SMB handles open on the directory starting from the specified Marker.
+// Set o.Recursive to also include handles open on the directory's files and subdirectories.
+// Use an empty Marker to start enumeration from the beginning. After getting a segment, process it, and then call
+// ListHandlesSegment again (passing the the previously-returned NextMarker) to get the next segment.
+// For more information, see https://docs.microsoft.com/en-us/rest/api/storageservices/list-handles.
+func (d DirectoryURL) ListHandlesSegment(ctx context.Context, marker Marker, o ListHandlesOptions) (*ListHandlesResponse, error) {
+	maxResults, shareSnapshot, recursive := o.pointers()
+	return d.directoryClient.ListHandles(ctx, marker.Val, maxResults, nil, shareSnapshot, recursive)
+}
+
+// ListAllHandles pages through ListHandlesSegment and returns every SMB handle open on the directory.
+func (d DirectoryURL) ListAllHandles(ctx context.Context, o ListHandlesOptions) ([]HandleItem, error) {
+	return listAllHandles(func(marker Marker) (*ListHandlesResponse, error) {
+		return d.ListHandlesSegment(ctx, marker, o)
+	})
+}
+
+// ForceCloseHandles closes the SMB handle identified by handleID, or every handle open on the directory if handleID is HandleIDAll.
+// Set o.Recursive to also close handles open on the directory's files and subdirectories.
+// The service may not finish closing handles in a single call; when the returned Marker() is non-empty, call
+// ForceCloseHandles again passing it to continue. Use ForceCloseAllHandles to have this done for you.
+// For more information, see https://docs.microsoft.com/en-us/rest/api/storageservices/force-close-handles.
+func (d DirectoryURL) ForceCloseHandles(ctx context.Context, handleID string, marker Marker, o ForceCloseHandlesOptions) (*DirectoryForceCloseHandlesResponse, error) {
+	shareSnapshot, recursive := o.pointers()
+	return d.directoryClient.ForceCloseHandles(ctx, handleID, nil, marker.Val, shareSnapshot, recursive)
+}
+
+// ForceCloseAllHandles closes every SMB handle open on the directory (and, if o.Recursive is set, beneath it),
+// continuing until the service reports that the close is complete, and returns the number of handles that were closed.
+func (d DirectoryURL) ForceCloseAllHandles(ctx context.Context, o ForceCloseHandlesOptions) (int32, error) {
+	return forceCloseAllHandles(func(marker Marker) (int32, string, error) {
+		resp, err := d.ForceCloseHandles(ctx, HandleIDAll, marker, o)
+		if err != nil {
+			return 0, "", err
+		}
+		return resp.NumberOfHandlesClosed(), resp.Marker(), nil
+	})
+}
@@ -158,6 +158,13 @@ func (f FileURL) GetProperties(ctx context.Context) (*FileGetPropertiesResponse,
 // SetHTTPHeaders sets file's system properties.
 // For more information, see https://docs.microsoft.com/rest/api/storageservices/set-file-properties.
 func (f FileURL) SetHTTPHeaders(ctx context.Context, h FileHTTPHeaders) (*FileSetHTTPHeadersResponse, error) {
+	return f.setHTTPHeaders(ctx, nil, h)
+}
+
+// setHTTPHeaders sets file's system properties and, if length is not nil, resizes the file in the same call.
+// The service clears the HTTP headers that a Set File Properties call doesn't specify, which includes Resize's calls,
+// so uploaders that grow a file with Resize use this to restore the headers at the end.
+func (f FileURL) setHTTPHeaders(ctx context.Context, length *int64, h FileHTTPHeaders) (*FileSetHTTPHeadersResponse, error) {
 	permStr, permKey, fileAttr, fileCreateTime, FileLastWriteTime, err := h.selectSMBPropertyValues(false, defaultPreserveString, defaultPreserveString, defaultPreserveString)
 
 	if err != nil {
@@ -165,7 +172,7 @@ func (f FileURL) SetHTTPHeaders(ctx context.Context, h FileHTTPHeaders) (*FileSe
 	}
 
 	return f.fileClient.SetHTTPHeaders(ctx, fileAttr, fileCreateTime, FileLastWriteTime, nil,
-		nil, &h.ContentType, &h.ContentEncoding, &h.ContentLanguage, &h.CacheControl, h.ContentMD5,
+		length, &h.ContentType, &h.ContentEncoding, &h.ContentLanguage, &h.CacheControl, h.ContentMD5,
 		&h.ContentDisposition, permStr, permKey)
 }
 
@@ -229,3 +236,118 @@ func (f FileURL) ClearRange(ctx context.Context, offset int64, count int64) (*Fi
 func (f FileURL) GetRangeList(ctx context.Context, offset int64, count int64) (*Ranges, error) {
 	return f.fileClient.GetRangeList(ctx, nil, nil, httpRange{offset: offset, count: count}.pointers())
 }
+
+// HandleIDAll is the handle ID that matches every handle open on a file or directory.
+// Pass it to ForceCloseHandles to close all handles at once.
+const HandleIDAll = "*"
+
+// ListHandlesOptions defines options available when calling ListHandlesSegment and ListAllHandles.
+type ListHandlesOptions struct {
+	MaxResults    int32  // 0 means unspecified
+	ShareSnapshot string // No sharesnapshot query parameter is produced if ""; a snapshot already in the URL is used otherwise
+
+	// Recursive indicates that handles open on the directory's files and subdirectories should be listed too.
+	// It is only honored by DirectoryURL.
+	Recursive bool
+}
+
+func (o *ListHandlesOptions) pointers() (maxResults *int32, shareSnapshot *string, recursive *bool) {
+	if o.MaxResults != 0 {
+		maxResults = &o.MaxResults
+	}
+	if o.ShareSnapshot != "" {
+		shareSnapshot = &o.ShareSnapshot
+	}
+	if o.Recursive {
+		recursive = &o.Recursive
+	}
+	return
+}
+
+// ForceCloseHandlesOptions defines options available when calling ForceCloseHandles and ForceCloseAllHandles.
+type ForceCloseHandlesOptions struct {
+	ShareSnapshot string // No sharesnapshot query parameter is produced if ""; a snapshot already in the URL is used otherwise
+
+	// Recursive indicates that handles open on the directory's files and subdirectories should be closed too.
+	// It is only honored by DirectoryURL.
+	Recursive bool
+}
+
+func (o *ForceCloseHandlesOptions) pointers() (shareSnapshot *string, recursive *bool) {
+	if o.ShareSnapshot != "" {
+		shareSnapshot = &o.ShareSnapshot
+	}
+	if o.Recursive {
+		recursive = &o.Recursive
+	}
+	return
+}
+
+// ListHandlesSegment returns a single segment of the SMB handles open on the file starting from the specified Marker.
+// Use an empty Marker to start enumeration from the beginning. After getting a segment, process it, and then call
+// ListHandlesSegment again (passing the the previously-returned NextMarker) to get the next segment.
+// For more information, see https://docs.microsoft.com/en-us/rest/api/storageservices/list-handles.
+func (f FileURL) ListHandlesSegment(ctx context.Context, marker Marker, o ListHandlesOptions) (*ListHandlesResponse, error) {
+	maxResults, shareSnapshot, _ := o.pointers()
+	return f.fileClient.ListHandles(ctx, marker.Val, maxResults, nil, shareSnapshot)
+}
+
+// ListAllHandles pages through ListHandlesSegment and returns every SMB handle open on the file.
+func (f FileURL) ListAllHandles(ctx context.Context, o ListHandlesOptions) ([]HandleItem, error) {
+	return listAllHandles(func(marker Marker) (*ListHandlesResponse, error) {
+		return f.ListHandlesSegment(ctx, marker, o)
+	})
+}
+
+// ForceCloseHandles closes the SMB handle identified by handleID, or every handle open on the file if handleID is HandleIDAll.
+// The service may not finish closing handles in a single call; when the returned Marker() is non-empty, call
+// ForceCloseHandles again passing it to continue. Use ForceCloseAllHandles to have this done for you.
+// For more information, see https://docs.microsoft.com/en-us/rest/api/storageservices/force-close-handles.
+func (f FileURL) ForceCloseHandles(ctx context.Context, handleID string, marker Marker, o ForceCloseHandlesOptions) (*FileForceCloseHandlesResponse, error) {
+	shareSnapshot, _ := o.pointers()
+	return f.fileClient.ForceCloseHandles(ctx, handleID, nil, marker.Val, shareSnapshot)
+}
+
+// ForceCloseAllHandles closes every SMB handle open on the file, continuing until the service reports that
+// the close is complete, and returns the number of handles that were closed.
+func (f FileURL) ForceCloseAllHandles(ctx context.Context, o ForceCloseHandlesOptions) (int32, error) {
+	return forceCloseAllHandles(func(marker Marker) (int32, string, error) {
+		resp, err := f.ForceCloseHandles(ctx, HandleIDAll, marker, o)
+		if err != nil {
+			return 0, "", err
+		}
+		return resp.NumberOfHandlesClosed(), resp.Marker(), nil
+	})
+}
+
+// listAllHandles drives a ListHandles segment function until the service returns an empty NextMarker.
+func listAllHandles(listSegment func(marker Marker) (*ListHandlesResponse, error)) ([]HandleItem, error) {
+	handles := []HandleItem{}
+	for marker := (Marker{}); marker.NotDone(); {
+		resp, err := listSegment(marker)
+		if err != nil {
+			return nil, err
+		}
+		handles = append(handles, resp.HandleList...)
+		next := resp.NextMarker
+		marker = Marker{Val: &next}
+	}
+	return handles, nil
+}
+
+// forceCloseAllHandles drives a ForceCloseHandles function until the service returns an empty marker,
+// summing the number of closed handles reported by each call.
+func forceCloseAllHandles(closeSegment func(marker Marker) (closed int32, nextMarker string, err error)) (int32, error) {
+	total := int32(0)
+	for marker := (Marker{}); marker.NotDone(); {
+		closed, next, err := closeSegment(marker)
+		if err != nil {
+			return total, err
+		}
+		if closed > 0 { // The service omits x-ms-number-of-handles-closed on some responses, which is reported as -1
+			total += closed
+		}
+		marker = Marker{Val: &next}
+	}
+	return total, nil
+}
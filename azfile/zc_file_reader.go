@@ -0,0 +1,242 @@
+package azfile
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+const (
+	// defaultFileReaderBlockSize specifies the default size of the blocks a FileReader downloads and caches.
+	defaultFileReaderBlockSize = 4 * 1024 * 1024
+
+	// defaultFileReaderCacheBlocks specifies the default number of blocks a FileReader caches.
+	defaultFileReaderCacheBlocks = 8
+)
+
+// FileReaderOptions identifies options used by NewFileReader.
+type FileReaderOptions struct {
+	// BlockSize specifies the size of the blocks that are downloaded and cached; each block is downloaded with one range request.
+	// If 0(default) is provided, 4MB will be used.
+	BlockSize int64
+
+	// CacheBlocks specifies the maximum number of blocks kept in memory; the least recently used block is evicted first.
+	// If 0(default) is provided, 8 blocks will be cached.
+	CacheBlocks int
+
+	// ReadAhead specifies how many blocks after the current one are downloaded in the background by Read, so that
+	// sequential reads rarely wait for the network. ReadAt never reads ahead. If 0(default) is provided, nothing is read ahead.
+	ReadAhead int
+
+	// RetryReaderOptionsPerBlock is used when downloading each block.
+	RetryReaderOptionsPerBlock RetryReaderOptions
+}
+
+// FileReader provides random access to an Azure file by implementing io.ReaderAt, io.ReadSeeker and io.Closer. Data
+// is downloaded in blocks which are cached, so that formats that read a few small regions of a large file (zip archives,
+// parquet footers, SQLite databases) can be opened in place.
+// ReadAt may be called concurrently; Read and Seek share the reader's offset and must not be.
+// The file's size is fetched by NewFileReader; the reader doesn't notice if the file changes afterwards.
+type FileReader struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	fileURL FileURL
+	o       FileReaderOptions
+	size    int64
+	offset  int64 // Used by Read and Seek
+
+	lock   sync.Mutex
+	blocks map[int64]*list.Element // Block index -> element of lru whose Value is a *fileReaderBlock
+	lru    *list.List              // Most recently used block at the front
+	closed bool
+}
+
+// fileReaderBlock is a cached block. done is closed once data or err is set.
+type fileReaderBlock struct {
+	index int64
+	data  []byte
+	err   error
+	done  chan struct{}
+}
+
+// NewFileReader creates a FileReader for the specified file. All the requests issued by the reader use ctx,
+// and are cancelled when the reader is closed.
+func NewFileReader(ctx context.Context, fileURL FileURL, o FileReaderOptions) (*FileReader, error) {
+	if o.BlockSize < 0 || o.CacheBlocks < 0 || o.ReadAhead < 0 {
+		return nil, errors.New("invalid argument, o.BlockSize, o.CacheBlocks and o.ReadAhead must be >= 0")
+	}
+	if o.BlockSize == 0 {
+		o.BlockSize = defaultFileReaderBlockSize
+	}
+	if o.CacheBlocks == 0 {
+		o.CacheBlocks = defaultFileReaderCacheBlocks
+	}
+	if o.CacheBlocks < o.ReadAhead+1 {
+		o.CacheBlocks = o.ReadAhead + 1 // Otherwise blocks read ahead would evict the current block
+	}
+
+	p, err := fileURL.GetProperties(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	return &FileReader{ctx: ctx, cancel: cancel, fileURL: fileURL, o: o, size: p.ContentLength(),
+		blocks: map[int64]*list.Element{}, lru: list.New()}, nil
+}
+
+// Size returns the size of the file, in bytes.
+func (r *FileReader) Size() int64 {
+	return r.size
+}
+
+// ReadAt reads len(p) bytes starting at offset off, downloading the blocks that aren't cached.
+func (r *FileReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("invalid argument, offset must be >= 0")
+	}
+	return r.readAt(p, off, 0)
+}
+
+func (r *FileReader) readAt(p []byte, off int64, readAhead int) (int, error) {
+	if off >= r.size {
+		return 0, io.EOF
+	}
+	n := 0
+	for n < len(p) && off < r.size {
+		index := off / r.o.BlockSize
+		b, err := r.block(index)
+		if err != nil {
+			return n, err
+		}
+		for i := int64(1); i <= int64(readAhead); i++ {
+			if (index+i)*r.o.BlockSize >= r.size {
+				break
+			}
+			if _, err := r.startBlock(index + i); err != nil {
+				return n, err
+			}
+		}
+		copied := copy(p[n:], b.data[off-index*r.o.BlockSize:])
+		n += copied
+		off += int64(copied)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// block returns the block with the specified index, waiting for it to be downloaded if necessary.
+func (r *FileReader) block(index int64) (*fileReaderBlock, error) {
+	b, err := r.startBlock(index)
+	if err != nil {
+		return nil, err
+	}
+	<-b.done
+	if b.err != nil {
+		// Forget the failed block so that a later read tries to download it again.
+		r.lock.Lock()
+		if e, ok := r.blocks[index]; ok && e.Value.(*fileReaderBlock) == b {
+			r.lru.Remove(e)
+			delete(r.blocks, index)
+		}
+		r.lock.Unlock()
+		return nil, b.err
+	}
+	return b, nil
+}
+
+// startBlock returns the block with the specified index, starting its download if it isn't cached.
+func (r *FileReader) startBlock(index int64) (*fileReaderBlock, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.closed {
+		return nil, errors.New("read on closed FileReader")
+	}
+	if e, ok := r.blocks[index]; ok {
+		r.lru.MoveToFront(e)
+		return e.Value.(*fileReaderBlock), nil
+	}
+
+	b := &fileReaderBlock{index: index, done: make(chan struct{})}
+	r.blocks[index] = r.lru.PushFront(b)
+	for r.lru.Len() > r.o.CacheBlocks {
+		// Readers already waiting for an evicted block still get it once it's downloaded.
+		evicted := r.lru.Remove(r.lru.Back()).(*fileReaderBlock)
+		delete(r.blocks, evicted.index)
+	}
+
+	go func() {
+		defer close(b.done)
+		b.data, b.err = r.download(index)
+	}()
+	return b, nil
+}
+
+func (r *FileReader) download(index int64) ([]byte, error) {
+	offset := index * r.o.BlockSize
+	count := r.o.BlockSize
+	if offset+count > r.size {
+		count = r.size - offset
+	}
+	dr, err := r.fileURL.Download(r.ctx, offset, count, false)
+	if err != nil {
+		return nil, err
+	}
+	body := dr.Body(r.o.RetryReaderOptionsPerBlock)
+	defer body.Close()
+	data := make([]byte, count)
+	if _, err = io.ReadFull(body, data); err != nil {
+		return nil, fmt.Errorf("downloading range [%d, %d) of the file: %w", offset, offset+count, err)
+	}
+	return data, nil
+}
+
+// Read reads up to len(p) bytes from the current offset, reading o.ReadAhead blocks ahead in the background.
+func (r *FileReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	n, err := r.readAt(p, r.offset, r.o.ReadAhead)
+	r.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil // Report io.EOF on the next call, as readers usually do
+	}
+	return n, err
+}
+
+// Seek sets the offset for the next Read. Seeking past the end of the file is allowed; Read then returns io.EOF.
+func (r *FileReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errors.New("invalid argument, whence is invalid")
+	}
+	if offset < 0 {
+		return 0, errors.New("invalid argument, negative position")
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+// Close cancels any downloads in progress and releases the cached blocks.
+func (r *FileReader) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	r.cancel()
+	r.blocks = nil
+	r.lru.Init()
+	return nil
+}
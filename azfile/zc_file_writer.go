@@ -0,0 +1,242 @@
+package azfile
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"sync"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+)
+
+// FileWriter is an io.WriteCloser that uploads the data written to it to an Azure file. Data is buffered into
+// o.RangeSize ranges, which are uploaded in parallel in the background while more data is written; the file is grown
+// with FileURL.Resize as needed. Close uploads the last partial range, trims the file to the number of bytes written and
+// sets o.FileHTTPHeaders. A FileWriter must not be used concurrently.
+type FileWriter struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	fileURL FileURL
+	o       UploadToAzureFileOptions
+
+	buffers  chan []byte // Buffers that aren't being uploaded; nil until first needed
+	buffer   []byte      // The range being filled, or nil
+	offset   int64       // The offset of buffer in the file
+	fileSize int64       // The size the file has been resized to
+	fileMD5  hash.Hash   // The MD5 of the data flushed so far, if o.ComputeContentMD5 is set
+	closed   bool
+
+	uploads      sync.WaitGroup
+	errLock      sync.Mutex
+	err          error // The first error, which cancels ctx
+	progressLock sync.Mutex
+	progress     int64
+}
+
+var _ io.ReaderFrom = (*FileWriter)(nil)
+
+// NewFileWriter creates (or overwrites) the Azure file as an empty file with o.Metadata, and returns a FileWriter that
+// writes to it. At most o.Parallelism ranges are uploaded in parallel, each from its own buffer of o.RangeSize bytes.
+// Note: o.RangeSize must be >= 0 and <= FileMaxUploadRangeBytes, and if not specified, method will use FileMaxUploadRangeBytes by default.
+func NewFileWriter(ctx context.Context, fileURL FileURL, o UploadToAzureFileOptions) (*FileWriter, error) {
+	// 1. Validate parameters, and set defaults.
+	if o.RangeSize < 0 || o.RangeSize > FileMaxUploadRangeBytes {
+		return nil, fmt.Errorf("invalid argument, o.RangeSize must be >= 0 and <= %d, in bytes", FileMaxUploadRangeBytes)
+	}
+	if o.RangeSize == 0 {
+		o.RangeSize = FileMaxUploadRangeBytes
+	}
+	if o.Parallelism == 0 {
+		o.Parallelism = defaultParallelCount // default parallelism
+	}
+
+	// 2. Try to create the Azure file; its size isn't known yet.
+	if _, err := fileURL.Create(ctx, 0, writableHTTPHeaders(o.FileHTTPHeaders), o.Metadata); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	w := &FileWriter{ctx: ctx, cancel: cancel, fileURL: fileURL, o: o, buffers: make(chan []byte, o.Parallelism)}
+	if o.ComputeContentMD5 {
+		w.fileMD5 = md5.New()
+	}
+	// Buffers are allocated the first time they're needed, so that short writes don't allocate all of them.
+	for i := uint16(0); i < o.Parallelism; i++ {
+		w.buffers <- nil
+	}
+	return w, nil
+}
+
+// Write buffers p, uploading each range once it's full. It returns the first error that occurred so far, if any.
+func (w *FileWriter) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		if err := w.acquireBuffer(); err != nil {
+			return n, err
+		}
+		copied := copy(w.buffer[len(w.buffer):cap(w.buffer)], p)
+		w.buffer = w.buffer[:len(w.buffer)+copied]
+		n += copied
+		p = p[copied:]
+		if err := w.flushIfFull(); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// ReadFrom reads from r until io.EOF, directly into the writer's buffers. io.Copy uses it, avoiding a copy of the data.
+func (w *FileWriter) ReadFrom(r io.Reader) (int64, error) {
+	total := int64(0)
+	for {
+		if err := w.acquireBuffer(); err != nil {
+			return total, err
+		}
+		n, readErr := io.ReadFull(r, w.buffer[len(w.buffer):cap(w.buffer)])
+		w.buffer = w.buffer[:len(w.buffer)+n]
+		total += int64(n)
+		if err := w.flushIfFull(); err != nil {
+			return total, err
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			return total, nil // The stream has ended
+		}
+		if readErr != nil {
+			return total, readErr
+		}
+	}
+}
+
+// Close uploads any buffered data, waits for all uploads to complete, trims the file to its final size and sets the
+// file's HTTP headers. It returns the first error that occurred while writing, if any.
+func (w *FileWriter) Close() error {
+	if w.closed {
+		return errors.New("FileWriter is already closed")
+	}
+	w.closed = true
+	defer w.cancel()
+
+	if len(w.buffer) > 0 && w.firstError() == nil {
+		w.flush()
+	}
+	w.uploads.Wait()
+	if err := w.firstError(); err != nil {
+		return err
+	}
+
+	// The service clears the HTTP headers when the file is resized, so they're set along with the final size.
+	if w.fileMD5 != nil {
+		w.o.FileHTTPHeaders.ContentMD5 = w.fileMD5.Sum(nil)
+	}
+	_, err := w.fileURL.setHTTPHeaders(w.ctx, &w.offset, w.o.FileHTTPHeaders)
+	return err
+}
+
+// abort stops the writer after an error that occurred outside of it, waiting for the uploads in progress to end.
+func (w *FileWriter) abort(err error) {
+	w.closed = true
+	w.fail(err)
+	w.uploads.Wait()
+}
+
+func (w *FileWriter) fail(err error) {
+	w.errLock.Lock()
+	defer w.errLock.Unlock()
+	if w.err == nil {
+		w.err = err
+		w.cancel() // As soon as any operation fails, cancel all remaining operation calls
+	}
+}
+
+func (w *FileWriter) firstError() error {
+	w.errLock.Lock()
+	defer w.errLock.Unlock()
+	return w.err
+}
+
+// acquireBuffer makes sure that w.buffer has room for more data, waiting for an upload to complete if all of the
+// buffers are in use.
+func (w *FileWriter) acquireBuffer() error {
+	if w.closed {
+		return errors.New("write on closed FileWriter")
+	}
+	if err := w.firstError(); err != nil {
+		return err
+	}
+	if w.buffer != nil {
+		return nil
+	}
+	select {
+	case w.buffer = <-w.buffers:
+	case <-w.ctx.Done():
+		w.fail(w.ctx.Err())
+		return w.firstError()
+	}
+	if w.buffer == nil {
+		w.buffer = make([]byte, 0, w.o.RangeSize)
+	}
+	return nil
+}
+
+func (w *FileWriter) flushIfFull() error {
+	if len(w.buffer) < cap(w.buffer) {
+		return nil
+	}
+	w.flush()
+	return w.firstError()
+}
+
+// flush grows the file if necessary, and starts uploading w.buffer in the background.
+func (w *FileWriter) flush() {
+	if end := w.offset + int64(len(w.buffer)); end > w.fileSize {
+		// Double the size so that the file is resized O(log n) times rather than once per range.
+		newSize := 2 * w.fileSize
+		if newSize < end || newSize > FileMaxSizeInBytes {
+			newSize = end
+		}
+		if _, err := w.fileURL.Resize(w.ctx, newSize); err != nil {
+			w.fail(err)
+			return
+		}
+		w.fileSize = newSize
+	}
+
+	offset, buffer := w.offset, w.buffer
+	if w.fileMD5 != nil {
+		w.fileMD5.Write(buffer) // Ranges are flushed in order
+	}
+	w.offset += int64(len(buffer))
+	w.buffer = nil
+	w.uploads.Add(1)
+	go func() {
+		defer w.uploads.Done()
+		defer func() { w.buffers <- buffer[:0] }()
+
+		var body io.ReadSeeker = bytes.NewReader(buffer)
+		if w.o.Progress != nil {
+			rangeProgress := int64(0)
+			body = pipeline.NewRequestBodyProgress(body,
+				func(bytesTransferred int64) {
+					diff := bytesTransferred - rangeProgress
+					rangeProgress = bytesTransferred
+					w.progressLock.Lock()
+					defer w.progressLock.Unlock()
+					w.progress += diff
+					w.o.Progress(w.progress)
+				})
+		}
+
+		var transactionalMD5 []byte
+		if w.o.TransactionalMD5 {
+			sum := md5.Sum(buffer)
+			transactionalMD5 = sum[:]
+		}
+		if _, err := w.fileURL.UploadRange(w.ctx, offset, body, transactionalMD5); err != nil {
+			w.fail(err)
+		}
+	}()
+}
@@ -0,0 +1,50 @@
+//go:build linux
+// +build linux
+
+package azfile
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// The whence values of lseek(2) that find data and holes; golang.org/x/sys/unix doesn't define them in the version used.
+const (
+	seekData = 3
+	seekHole = 4
+)
+
+// findDataRanges returns the ranges of the local file that hold data, using SEEK_DATA and SEEK_HOLE so that holes
+// are found without reading them. It returns nil if the file system can't tell, in which case all of the file may hold data.
+func findDataRanges(file *os.File, size int64) []Range {
+	fd := int(file.Fd())
+	current, err := unix.Seek(fd, 0, os.SEEK_CUR)
+	if err != nil {
+		return nil
+	}
+	defer unix.Seek(fd, current, os.SEEK_SET) // Restore the caller's offset
+
+	ranges := []Range{}
+	for offset := int64(0); offset < size; {
+		start, err := unix.Seek(fd, offset, seekData)
+		if err == unix.ENXIO {
+			break // There's no data after offset
+		}
+		if err != nil {
+			return nil
+		}
+		end, err := unix.Seek(fd, start, seekHole)
+		if err != nil {
+			return nil
+		}
+		if end > size {
+			end = size
+		}
+		if end > start {
+			ranges = append(ranges, Range{Start: start, End: end - 1})
+		}
+		offset = end
+	}
+	return ranges
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package azfile
+
+import (
+	"os"
+)
+
+// findDataRanges returns nil, meaning that all of the file may hold data, because holes can only be found on Linux.
+func findDataRanges(file *os.File, size int64) []Range {
+	return nil
+}
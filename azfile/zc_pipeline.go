@@ -17,6 +17,20 @@ type PipelineOptions struct {
 
 	// Telemetry configures the built-in telemetry policy behavior.
 	Telemetry TelemetryOptions
+
+	// Throttle configures the built-in throttle policy, which limits the rate of requests and of the bytes in their bodies.
+	// The policy is only added to the pipeline if Throttle has a RateLimiter.
+	Throttle ThrottleOptions
+
+	// CircuitBreaker, if not nil, fails the requests to a host fast while too many of them fail. See NewCircuitBreaker.
+	CircuitBreaker *CircuitBreaker
+
+	// FaultInjection configures the fault injection policy, which injects failures into requests for testing. The
+	// policy is only added to the pipeline if FaultInjection has faults.
+	FaultInjection FaultInjectionOptions
+
+	// HTTPSender, if not nil, sends the pipeline's HTTP requests in place of its default HTTP client.
+	HTTPSender pipeline.Factory
 }
 
 // NewPipeline creates a Pipeline using the specified credentials and options.
@@ -28,6 +42,12 @@ func NewPipeline(c Credential, o PipelineOptions) pipeline.Pipeline {
 		NewUniqueRequestIDPolicyFactory(),
 		NewRetryPolicyFactory(o.Retry),
 	}
+	if o.CircuitBreaker != nil {
+		f = append(f, o.CircuitBreaker) // Ahead of the throttle policy, so that failing fast doesn't wait
+	}
+	if o.Throttle.Upload != nil || o.Throttle.Download != nil || len(o.Throttle.Requests) > 0 {
+		f = append(f, NewThrottlePolicyFactory(o.Throttle))
+	}
 
 	if _, ok := c.(*anonymousCredentialPolicyFactory); !ok {
 		// For AnonymousCredential, we optimize out the policy factory since it doesn't do anything
@@ -38,7 +58,10 @@ func NewPipeline(c Credential, o PipelineOptions) pipeline.Pipeline {
 	f = append(f,
 		NewRequestLogPolicyFactory(o.RequestLog),
 		pipeline.MethodFactoryMarker()) // indicates at what stage in the pipeline the method factory is invoked
+	if len(o.FaultInjection.Faults) > 0 {
+		// Closest to the wire, so that the method factory and the other policies handle the injected failures
+		f = append(f, NewFaultInjectionPolicyFactory(o.FaultInjection))
+	}
 
-
-	return pipeline.NewPipeline(f, pipeline.Options{HTTPSender: nil, Log: o.Log})
+	return pipeline.NewPipeline(f, pipeline.Options{HTTPSender: o.HTTPSender, Log: o.Log})
 }
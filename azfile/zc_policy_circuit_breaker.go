@@ -0,0 +1,287 @@
+package azfile
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+)
+
+// CircuitState is the state of a host's circuit in a CircuitBreaker. See the CircuitState* constants.
+type CircuitState int32
+
+const (
+	// CircuitStateClosed lets requests through, counting their failures.
+	CircuitStateClosed CircuitState = 0
+
+	// CircuitStateOpen fails requests fast, with a *CircuitOpenError, without sending them.
+	CircuitStateOpen CircuitState = 1
+
+	// CircuitStateHalfOpen lets a few probe requests through, which close the circuit if they succeed and open it
+	// again if any of them fails; the other requests fail fast meanwhile.
+	CircuitStateHalfOpen CircuitState = 2
+)
+
+// String returns the state's name.
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitStateClosed:
+		return "closed"
+	case CircuitStateOpen:
+		return "open"
+	case CircuitStateHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("CircuitState(%d)", int32(s))
+}
+
+// CircuitBreakerOptions configures a CircuitBreaker's behavior.
+type CircuitBreakerOptions struct {
+	// FailureRatio is the ratio of failed requests to all of the requests in a Window at which a host's circuit opens.
+	// A request fails if it gets a 5xx response or times out. A value of zero means that you accept our default of 0.5.
+	FailureRatio float64
+
+	// MinRequests is the number of requests that a Window must have before its failures can open the circuit, so
+	// that a few failures among few requests don't open it. A value of zero means that you accept our default of 10.
+	MinRequests int32
+
+	// Window is the period over which the requests and their failures are counted; the counts start again after
+	// each one. A value of zero means that you accept our default of 30 seconds.
+	Window time.Duration
+
+	// OpenDuration is how long a circuit stays open before it's half-open. A value of zero means that you accept our
+	// default of 30 seconds.
+	OpenDuration time.Duration
+
+	// HalfOpenRequests is the number of probe requests that a half-open circuit lets through, all of which must
+	// succeed for it to close. A value of zero means that you accept our default of 1.
+	HalfOpenRequests int32
+
+	// OnStateChange, if not nil, is invoked when a host's circuit changes state, after the circuit breaker is unlocked,
+	// so it may log through a pipeline or send requests through the circuit breaker. The calls are made one at a time,
+	// in the order of the changes, by the goroutine of a request that made a change.
+	OnStateChange func(host string, from CircuitState, to CircuitState)
+}
+
+func (o CircuitBreakerOptions) defaults() CircuitBreakerOptions {
+	if o.FailureRatio <= 0 {
+		o.FailureRatio = 0.5
+	}
+	if o.MinRequests <= 0 {
+		o.MinRequests = 10
+	}
+	if o.Window <= 0 {
+		o.Window = 30 * time.Second
+	}
+	if o.OpenDuration <= 0 {
+		o.OpenDuration = 30 * time.Second
+	}
+	if o.HalfOpenRequests <= 0 {
+		o.HalfOpenRequests = 1
+	}
+	return o
+}
+
+// CircuitOpenError is returned, without the request being sent, for a request to a host whose circuit is open (or
+// half-open, with all of its probes in progress). It isn't a net.Error, so the retry policy doesn't retry it.
+type CircuitOpenError struct {
+	Host string
+
+	// RetryAt is when the circuit is half-open and lets a probe through; it's the zero time while probes are in progress.
+	RetryAt time.Time
+}
+
+// Error implements the error interface.
+func (e *CircuitOpenError) Error() string {
+	if e.RetryAt.IsZero() {
+		return fmt.Sprintf("the circuit of %s is half-open and its probe requests are in progress", e.Host)
+	}
+	return fmt.Sprintf("the circuit of %s is open until %s", e.Host, e.RetryAt.Format(time.RFC3339))
+}
+
+// CircuitBreaker is a pipeline.Factory whose policy keeps a circuit for each host that requests go to. When too many
+// requests to a host fail, its circuit opens and the requests to it fail fast, so that a degraded account isn't
+// loaded with the retries of every request; later, probe requests find out whether the host has recovered. Pass it
+// in PipelineOptions.CircuitBreaker, where it's closer to the wire than the retry policy, so that each try counts; it can be
+// shared by several pipelines.
+type CircuitBreaker struct {
+	o        CircuitBreakerOptions
+	mutex    sync.Mutex
+	circuits map[string]*circuit
+
+	// The state changes that OnStateChange hasn't been invoked for yet, and whether a goroutine is invoking it.
+	changes   []circuitStateChange
+	notifying bool
+}
+
+// circuitStateChange is a change of a host's circuit's state, which OnStateChange is invoked for.
+type circuitStateChange struct {
+	host     string
+	from, to CircuitState
+}
+
+// circuit is the state of a host's circuit.
+type circuit struct {
+	state CircuitState
+
+	// The counts of the current window, while closed.
+	windowStart        time.Time
+	requests, failures int32
+
+	// When the circuit opened, while open.
+	openedAt time.Time
+
+	// The probes in progress and those that succeeded, while half-open.
+	probes, probeSuccesses int32
+}
+
+// NewCircuitBreaker creates a CircuitBreaker configured using the specified options.
+func NewCircuitBreaker(o CircuitBreakerOptions) *CircuitBreaker {
+	return &CircuitBreaker{o: o.defaults(), circuits: map[string]*circuit{}}
+}
+
+// State returns the state of host's circuit.
+func (b *CircuitBreaker) State(host string) CircuitState {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if c, ok := b.circuits[host]; ok {
+		if c.state == CircuitStateOpen && time.Since(c.openedAt) >= b.o.OpenDuration {
+			return CircuitStateHalfOpen // As the next request will find it
+		}
+		return c.state
+	}
+	return CircuitStateClosed
+}
+
+// New creates the circuit breaker policy; it implements pipeline.Factory.
+func (b *CircuitBreaker) New(next pipeline.Policy, po *pipeline.PolicyOptions) pipeline.Policy {
+	return pipeline.PolicyFunc(func(ctx context.Context, request pipeline.Request) (pipeline.Response, error) {
+		host := request.URL.Host
+		probe, err := b.allow(host)
+		b.notify()
+		if err != nil {
+			return nil, err
+		}
+		response, err := next.Do(ctx, request)
+		b.record(host, probe, ctx, response, err)
+		b.notify()
+		return response, err
+	})
+}
+
+// allow returns whether a request to host may be sent, and whether it's a probe of a half-open circuit.
+func (b *CircuitBreaker) allow(host string) (bool, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	c, ok := b.circuits[host]
+	if !ok {
+		c = &circuit{windowStart: time.Now()}
+		b.circuits[host] = c
+	}
+
+	now := time.Now()
+	switch c.state {
+	case CircuitStateClosed:
+		if now.Sub(c.windowStart) >= b.o.Window {
+			c.windowStart, c.requests, c.failures = now, 0, 0
+		}
+		return false, nil
+	case CircuitStateOpen:
+		if retryAt := c.openedAt.Add(b.o.OpenDuration); now.Before(retryAt) {
+			return false, &CircuitOpenError{Host: host, RetryAt: retryAt}
+		}
+		b.setState(host, c, CircuitStateHalfOpen)
+		c.probes, c.probeSuccesses = 0, 0
+	}
+	if c.probes >= b.o.HalfOpenRequests {
+		return false, &CircuitOpenError{Host: host}
+	}
+	c.probes++
+	return true, nil
+}
+
+// record counts the outcome of a request to host that allow let through.
+func (b *CircuitBreaker) record(host string, probe bool, ctx context.Context, response pipeline.Response, err error) {
+	failed, counted := requestFailed(ctx, response, err)
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	c := b.circuits[host]
+
+	switch {
+	case probe && c.state == CircuitStateHalfOpen:
+		c.probes--
+		switch {
+		case !counted: // The probe's slot is freed for another one
+		case failed:
+			b.open(host, c)
+		default:
+			if c.probeSuccesses++; c.probeSuccesses >= b.o.HalfOpenRequests {
+				b.setState(host, c, CircuitStateClosed)
+				c.windowStart, c.requests, c.failures = time.Now(), 0, 0
+			}
+		}
+	case !probe && c.state == CircuitStateClosed && counted:
+		c.requests++
+		if failed {
+			c.failures++
+		}
+		if c.requests >= b.o.MinRequests && float64(c.failures) >= b.o.FailureRatio*float64(c.requests) {
+			b.open(host, c)
+		}
+	} // The outcomes of requests sent before the circuit last changed state don't count
+}
+
+// open opens c. b.mutex must be held.
+func (b *CircuitBreaker) open(host string, c *circuit) {
+	b.setState(host, c, CircuitStateOpen)
+	c.openedAt = time.Now()
+}
+
+// setState changes c's state, and queues the change for notify. b.mutex must be held.
+func (b *CircuitBreaker) setState(host string, c *circuit, state CircuitState) {
+	if b.o.OnStateChange != nil {
+		b.changes = append(b.changes, circuitStateChange{host: host, from: c.state, to: state})
+	}
+	c.state = state
+}
+
+// notify invokes OnStateChange for the queued state changes, unless another goroutine is already invoking it, in which
+// case that goroutine invokes it for them too. b.mutex must not be held.
+func (b *CircuitBreaker) notify() {
+	b.mutex.Lock()
+	if b.notifying {
+		b.mutex.Unlock()
+		return
+	}
+	b.notifying = true
+	for len(b.changes) > 0 {
+		change := b.changes[0]
+		b.changes = b.changes[1:]
+		b.mutex.Unlock()
+		b.o.OnStateChange(change.host, change.from, change.to)
+		b.mutex.Lock()
+	}
+	b.notifying = false
+	b.mutex.Unlock()
+}
+
+// requestFailed returns whether a request's outcome is a failure, a 5xx response or a timeout, and whether it counts
+// at all: a request whose context was cancelled by the caller says nothing about the host.
+func requestFailed(ctx context.Context, response pipeline.Response, err error) (failed bool, counted bool) {
+	if ctx.Err() == context.Canceled {
+		return false, false
+	}
+	if httpResponse, _ := tryResponse(response, err); httpResponse != nil {
+		return httpResponse.StatusCode >= 500, true
+	}
+	if err == context.DeadlineExceeded || ctx.Err() == context.DeadlineExceeded {
+		return true, true
+	}
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return true, true
+	}
+	return false, err == nil // Other errors, such as invalid requests, aren't the host's
+}
@@ -0,0 +1,303 @@
+package azfile
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+)
+
+// FaultType is a kind of failure that the fault injection policy injects. See the FaultType* constants.
+type FaultType int32
+
+const (
+	// FaultTypeServiceError responds to the request, without sending it, with an error response like the service's,
+	// of Fault.StatusCode and Fault.ServiceCode.
+	FaultTypeServiceError FaultType = 0
+
+	// FaultTypeConnectionReset fails the request, without sending it, with the error of a connection reset by the peer.
+	FaultTypeConnectionReset FaultType = 1
+
+	// FaultTypeTimeout holds the request, without sending it, until its try times out, or Fault.Delay elapses if it
+	// isn't zero, and then fails it with a timeout error.
+	FaultTypeTimeout FaultType = 2
+
+	// FaultTypeSlowBody sends the request, and then delays each read of its response's body by Fault.Delay.
+	FaultTypeSlowBody FaultType = 3
+
+	// FaultTypeTruncatedBody sends the request, and then fails the read of its response's body with
+	// io.ErrUnexpectedEOF once Fault.TruncateAfter bytes of it are read, as if the connection were closed.
+	FaultTypeTruncatedBody FaultType = 4
+)
+
+// String returns the fault type's name.
+func (t FaultType) String() string {
+	switch t {
+	case FaultTypeServiceError:
+		return "service error"
+	case FaultTypeConnectionReset:
+		return "connection reset"
+	case FaultTypeTimeout:
+		return "timeout"
+	case FaultTypeSlowBody:
+		return "slow body"
+	case FaultTypeTruncatedBody:
+		return "truncated body"
+	}
+	return fmt.Sprintf("FaultType(%d)", int32(t))
+}
+
+// Fault describes a failure to inject, and which requests get it.
+type Fault struct {
+	// Type is the kind of failure.
+	Type FaultType
+
+	// Operations, if not empty, are the operation types whose requests the fault applies to, as
+	// ThrottleOptions.Requests describes them: "GET" downloads, "PUT range" uploads or clears a range and so on.
+	Operations []string
+
+	// Sequence, if not empty, are the numbers of the requests that get the fault, counting from 1 the requests that it
+	// applies to: {1, 2} fails the first two of them and lets the others through. Each try of a request counts.
+	Sequence []int32
+
+	// Probability is the probability that a request gets the fault, from 0 to 1. A value of zero means that each
+	// request the fault applies to gets it.
+	Probability float64
+
+	// StatusCode is the status of FaultTypeServiceError's response. A value of zero means that you accept our default
+	// of 500 (Internal Server Error).
+	StatusCode int
+
+	// ServiceCode is the error code of FaultTypeServiceError's response. If it's empty, it's ServiceCodeServerBusy for
+	// a 503 and ServiceCodeInternalError otherwise.
+	ServiceCode ServiceCodeType
+
+	// Delay is how long FaultTypeSlowBody delays each read, and how long FaultTypeTimeout holds a request. For
+	// FaultTypeSlowBody, a value of zero means that you accept our default of 1 second.
+	Delay time.Duration
+
+	// TruncateAfter is the number of bytes of FaultTypeTruncatedBody's response body that are read before it fails.
+	TruncateAfter int64
+}
+
+// applies returns whether the fault applies to the requests of an operation type.
+func (f *Fault) applies(operationType string) bool {
+	if len(f.Operations) == 0 {
+		return true
+	}
+	for _, o := range f.Operations {
+		if o == operationType {
+			return true
+		}
+	}
+	return false
+}
+
+// FaultInjectionOptions configures the fault injection policy's behavior.
+type FaultInjectionOptions struct {
+	// Faults are the failures to inject. A request gets the first of them that it's picked for, if any; it isn't
+	// counted by the faults that follow that one.
+	Faults []Fault
+
+	// Seed seeds the random numbers that pick the requests for the faults with a Probability, so that the same
+	// requests get the same faults from one run to the next. A value of zero seeds them with the current time.
+	Seed int64
+
+	// OnFault, if not nil, is invoked with each fault that's injected, along with its request.
+	OnFault func(f Fault, request *http.Request)
+}
+
+// NewFaultInjectionPolicyFactory creates a factory that can create fault injection policy objects, which inject
+// failures into requests as o specifies, so that the resilience of code that uses azfile, along with that of the
+// retry policy and of RetryReader, can be tested. The policy should be the closest to the wire, so that the other
+// policies handle the failures as if the service or network had caused them; PipelineOptions.FaultInjection puts it
+// there. The policies created by a factory count requests together.
+func NewFaultInjectionPolicyFactory(o FaultInjectionOptions) pipeline.Factory {
+	seed := o.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	injector := &faultInjector{o: o, counts: make([]int32, len(o.Faults)), random: rand.New(rand.NewSource(seed))}
+	return pipeline.FactoryFunc(func(next pipeline.Policy, po *pipeline.PolicyOptions) pipeline.PolicyFunc {
+		return func(ctx context.Context, request pipeline.Request) (pipeline.Response, error) {
+			f := injector.pick(request)
+			if f == nil {
+				return next.Do(ctx, request)
+			}
+			if o.OnFault != nil {
+				o.OnFault(*f, request.Request)
+			}
+
+			switch f.Type {
+			case FaultTypeServiceError:
+				return pipeline.NewHTTPResponse(newFaultResponse(f, request.Request)), nil
+			case FaultTypeConnectionReset:
+				err := &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}
+				return pipeline.NewHTTPResponse(nil), newFaultError(request, err)
+			case FaultTypeTimeout:
+				var elapsed <-chan time.Time
+				if f.Delay > 0 {
+					timer := time.NewTimer(f.Delay)
+					defer timer.Stop()
+					elapsed = timer.C
+				}
+				select {
+				case <-ctx.Done():
+				case <-elapsed:
+				}
+				return pipeline.NewHTTPResponse(nil), newFaultError(request, context.DeadlineExceeded)
+			}
+
+			response, err := next.Do(ctx, request)
+			if response == nil || response.Response() == nil || response.Response().Body == nil {
+				return response, err
+			}
+			switch f.Type {
+			case FaultTypeSlowBody:
+				delay := f.Delay
+				if delay <= 0 {
+					delay = time.Second
+				}
+				response.Response().Body = &slowReadCloser{ctx: ctx, body: response.Response().Body, delay: delay}
+			case FaultTypeTruncatedBody:
+				response.Response().Body = &truncatedReadCloser{body: response.Response().Body, remaining: f.TruncateAfter}
+			}
+			return response, err
+		}
+	})
+}
+
+// faultInjector picks the requests that get faults; it's shared by the policies of a factory.
+type faultInjector struct {
+	o      FaultInjectionOptions
+	mutex  sync.Mutex
+	counts []int32 // The number of requests that each fault applied to so far
+	random *rand.Rand
+}
+
+// pick returns the fault that the request gets, or nil if it gets none.
+func (i *faultInjector) pick(request pipeline.Request) *Fault {
+	operationType := throttledOperationType(request)
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	for n := range i.o.Faults {
+		f := &i.o.Faults[n]
+		if !f.applies(operationType) {
+			continue
+		}
+		i.counts[n]++
+		if len(f.Sequence) > 0 && !containsInt32(f.Sequence, i.counts[n]) {
+			continue
+		}
+		if f.Probability > 0 && i.random.Float64() >= f.Probability {
+			continue
+		}
+		return f
+	}
+	return nil
+}
+
+func containsInt32(values []int32, value int32) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// newFaultResponse returns the error response of a FaultTypeServiceError fault, which has the service's error body
+// and x-ms-error-code header.
+func newFaultResponse(f *Fault, request *http.Request) *http.Response {
+	statusCode := f.StatusCode
+	if statusCode == 0 {
+		statusCode = http.StatusInternalServerError
+	}
+	serviceCode := f.ServiceCode
+	if serviceCode == "" {
+		serviceCode = ServiceCodeInternalError
+		if statusCode == http.StatusServiceUnavailable {
+			serviceCode = ServiceCodeServerBusy
+		}
+	}
+	body := []byte(fmt.Sprintf("<?xml version=\"1.0\" encoding=\"utf-8\"?><Error><Code>%s</Code><Message>The fault injection policy injected the error.</Message></Error>", serviceCode))
+	if request.Method == http.MethodHead {
+		body = nil
+	}
+	header := http.Header{}
+	header.Set("Content-Type", "application/xml")
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	header.Set("x-ms-error-code", string(serviceCode))
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode)),
+		StatusCode:    statusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       request,
+	}
+}
+
+// newFaultError returns the error of a request that failed with err, as the pipeline's default HTTP client returns it.
+func newFaultError(request pipeline.Request, err error) error {
+	return pipeline.NewError(&url.Error{Op: request.Method, URL: request.URL.String(), Err: err}, "HTTP request failed")
+}
+
+// slowReadCloser delays each read of a response body.
+type slowReadCloser struct {
+	ctx   context.Context
+	body  io.ReadCloser
+	delay time.Duration
+}
+
+func (r *slowReadCloser) Read(p []byte) (int, error) {
+	timer := time.NewTimer(r.delay)
+	defer timer.Stop()
+	select {
+	case <-r.ctx.Done():
+		return 0, r.ctx.Err()
+	case <-timer.C:
+	}
+	return r.body.Read(p)
+}
+
+func (r *slowReadCloser) Close() error {
+	return r.body.Close()
+}
+
+// truncatedReadCloser fails the read of a response body once the bytes that remain are read.
+type truncatedReadCloser struct {
+	body      io.ReadCloser
+	remaining int64
+}
+
+func (r *truncatedReadCloser) Read(p []byte) (int, error) {
+	if r.remaining <= 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+	n, err := r.body.Read(p)
+	r.remaining -= int64(n)
+	return n, err
+}
+
+func (r *truncatedReadCloser) Close() error {
+	return r.body.Close()
+}
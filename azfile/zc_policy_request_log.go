@@ -8,6 +8,7 @@ import (
 	"net/url"
 	"runtime"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/Azure/azure-pipeline-go/pipeline"
@@ -18,6 +19,99 @@ type RequestLogOptions struct {
 	// LogWarningIfTryOverThreshold logs a warning if a tried operation takes longer than the specified
 	// duration (-1=no logging; 0=default threshold).
 	LogWarningIfTryOverThreshold time.Duration
+
+	// Logger, if not nil, gets a structured RequestLogRecord of each try in place of the free-form text that the policy
+	// otherwise logs through the pipeline's LogOptions. The text of the tries that fail or are slow is still written
+	// with pipeline.ForceLog. NewSlogRequestLogger creates a Logger that logs with log/slog.
+	Logger RequestLogger
+
+	// SampleEvery maps a log level to a number N, so that only the first of every N records of that level is passed
+	// to Logger, and the requests that succeed don't flood the logs. The levels that it doesn't map, or maps to 0 or
+	// 1, aren't sampled. For example, {pipeline.LogInfo: 100} passes 1 in 100 successful tries, along with every try
+	// that's slow or fails.
+	SampleEvery map[pipeline.LogLevel]uint32
+}
+
+// RequestLogRecord is the structured record of a try of a request that the request log policy passes to
+// RequestLogOptions.Logger.
+type RequestLogRecord struct {
+	// Level is the level that the policy would log the try's text at: LogError if the try failed or got an error
+	// status, LogWarning if it was slow, and LogInfo otherwise.
+	Level pipeline.LogLevel
+
+	// Try is the number of the try, starting at 1.
+	Try int32
+
+	Method string
+
+	// URL is the request's URL, with the value of its sig query parameter redacted.
+	URL string
+
+	// StatusCode is the response's status, or 0 if the try got no response.
+	StatusCode int
+
+	// ServiceCode is the response's x-ms-error-code header, if any.
+	ServiceCode ServiceCodeType
+
+	// RequestID is the response's x-ms-request-id header, by which the service identifies the request, and
+	// ClientRequestID is the request's x-ms-client-request-id header.
+	RequestID       string
+	ClientRequestID string
+
+	// TryDuration is how long the try took to get its response, and OperationDuration how long the operation has
+	// taken since its first try.
+	TryDuration       time.Duration
+	OperationDuration time.Duration
+
+	// Slow is true if TryDuration exceeds RequestLogOptions.LogWarningIfTryOverThreshold.
+	Slow bool
+
+	// BytesSent is the length of the request's body, and BytesReceived the length of the response's body, as its
+	// Content-Length header gives it; either is -1 if it's unknown.
+	BytesSent     int64
+	BytesReceived int64
+
+	// Err is the error of the try, if any: a StorageError if it got an error response, whose fields are those of the
+	// response, or the error that it failed with if it got no response, whose StatusCode is 0.
+	Err error
+}
+
+// RequestLogger gets the structured records of the request log policy. Its LogRequest method is invoked with the
+// context of the try, and must be safe for concurrent use.
+type RequestLogger interface {
+	LogRequest(ctx context.Context, record RequestLogRecord)
+}
+
+// RequestLoggerFunc is a function that implements RequestLogger.
+type RequestLoggerFunc func(ctx context.Context, record RequestLogRecord)
+
+// LogRequest calls f(ctx, record).
+func (f RequestLoggerFunc) LogRequest(ctx context.Context, record RequestLogRecord) {
+	f(ctx, record)
+}
+
+// requestLogSampler counts the records of each level that RequestLogOptions.SampleEvery samples, for all of the
+// policies that a factory creates.
+type requestLogSampler struct {
+	every  map[pipeline.LogLevel]uint32
+	counts map[pipeline.LogLevel]*uint32
+}
+
+func newRequestLogSampler(every map[pipeline.LogLevel]uint32) *requestLogSampler {
+	s := &requestLogSampler{every: every, counts: map[pipeline.LogLevel]*uint32{}}
+	for level := range every {
+		s.counts[level] = new(uint32)
+	}
+	return s
+}
+
+// sample returns whether a record of level is logged.
+func (s *requestLogSampler) sample(level pipeline.LogLevel) bool {
+	every := s.every[level]
+	if every <= 1 {
+		return true
+	}
+	return (atomic.AddUint32(s.counts[level], 1)-1)%every == 0
 }
 
 func (o RequestLogOptions) defaults() RequestLogOptions {
@@ -32,6 +126,7 @@ func (o RequestLogOptions) defaults() RequestLogOptions {
 // NewRequestLogPolicyFactory creates a RequestLogPolicyFactory object configured using the specified options.
 func NewRequestLogPolicyFactory(o RequestLogOptions) pipeline.Factory {
 	o = o.defaults() // Force defaults to be calculated
+	sampler := newRequestLogSampler(o.SampleEvery)
 	return pipeline.FactoryFunc(func(next pipeline.Policy, po *pipeline.PolicyOptions) pipeline.PolicyFunc {
 		// These variables are per-policy; shared by multiple calls to Do
 		var try int32
@@ -40,7 +135,7 @@ func NewRequestLogPolicyFactory(o RequestLogOptions) pipeline.Factory {
 			try++ // The first try is #1 (not #0)
 
 			// Log the outgoing request as informational
-			if po.ShouldLog(pipeline.LogInfo) {
+			if o.Logger == nil && po.ShouldLog(pipeline.LogInfo) {
 				b := &bytes.Buffer{}
 				fmt.Fprintf(b, "==> OUTGOING REQUEST (Try=%d)\n", try)
 				pipeline.WriteRequestWithResponse(b, prepareRequestForLogging(request), nil, nil)
@@ -73,7 +168,21 @@ func NewRequestLogPolicyFactory(o RequestLogOptions) pipeline.Factory {
 				logLevel, forceLog = pipeline.LogError, true
 			}
 
-			if shouldLog := po.ShouldLog(logLevel); forceLog || shouldLog {
+			if o.Logger != nil {
+				if sampler.sample(logLevel) {
+					record := newRequestLogRecord(request, response, err)
+					record.Level, record.Try = logLevel, try
+					record.TryDuration, record.OperationDuration = tryDuration, opDuration
+					record.Slow = o.LogWarningIfTryOverThreshold > 0 && tryDuration > o.LogWarningIfTryOverThreshold
+					o.Logger.LogRequest(ctx, record)
+				}
+				if !forceLog {
+					return response, err
+				}
+			}
+
+			// With a Logger, the text is only force logged
+			if shouldLog := o.Logger == nil && po.ShouldLog(logLevel); forceLog || shouldLog {
 				// We're going to log this; build the string to log
 				b := &bytes.Buffer{}
 				slow := ""
@@ -109,10 +218,39 @@ func NewRequestLogPolicyFactory(o RequestLogOptions) pipeline.Factory {
 	})
 }
 
+// newRequestLogRecord returns the record of a try with the fields that its request and response give.
+func newRequestLogRecord(request pipeline.Request, response pipeline.Response, err error) RequestLogRecord {
+	record := RequestLogRecord{
+		Method:          request.Method,
+		URL:             prepareRequestForLogging(request).URL.String(),
+		ClientRequestID: request.Header.Get("x-ms-client-request-id"),
+		BytesSent:       request.ContentLength,
+		BytesReceived:   -1,
+		Err:             err,
+	}
+	if request.Body == nil || request.Body == http.NoBody {
+		record.BytesSent = 0
+	}
+	var httpResponse *http.Response
+	if response != nil {
+		httpResponse = response.Response()
+	}
+	if stgErr, ok := err.(StorageError); ok && httpResponse == nil {
+		httpResponse = stgErr.Response() // The error of an error response, which the method's responder returns
+	}
+	if httpResponse != nil {
+		record.StatusCode = httpResponse.StatusCode
+		record.ServiceCode = ServiceCodeType(httpResponse.Header.Get("x-ms-error-code"))
+		record.RequestID = httpResponse.Header.Get("x-ms-request-id")
+		record.BytesReceived = httpResponse.ContentLength
+	}
+	return record
+}
+
 // RedactSigQueryParam redacts the 'sig' query parameter in URL's raw query to protect secret.
 func RedactSigQueryParam(rawQuery string) (bool, string) {
 	rawQuery = strings.ToLower(rawQuery) // lowercase the string so we can look for ?sig= and &sig=
-	sigFound := strings.Contains(rawQuery, "?sig=")
+	sigFound := strings.HasPrefix(rawQuery, "sig=") || strings.Contains(rawQuery, "?sig=")
 	if !sigFound {
 		sigFound = strings.Contains(rawQuery, "&sig=")
 		if !sigFound {
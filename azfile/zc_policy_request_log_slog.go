@@ -0,0 +1,56 @@
+//go:build go1.21
+// +build go1.21
+
+package azfile
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+)
+
+// NewSlogRequestLogger creates a RequestLogger that logs each record with logger, as the message "azfile request"
+// whose attributes are the record's fields: try, method, url, status, serviceCode, requestID, clientRequestID,
+// duration, operationDuration, slow, bytesSent, bytesReceived and, if the try got no response, error. The record's
+// level is mapped to slog's: LogDebug to LevelDebug, LogInfo to LevelInfo, LogWarning to LevelWarn, and the others to
+// LevelError; the records whose levels logger doesn't enable aren't built.
+func NewSlogRequestLogger(logger *slog.Logger) RequestLogger {
+	return RequestLoggerFunc(func(ctx context.Context, record RequestLogRecord) {
+		level := slogLevel(record.Level)
+		if !logger.Enabled(ctx, level) {
+			return
+		}
+		attrs := []slog.Attr{
+			slog.Int("try", int(record.Try)),
+			slog.String("method", record.Method),
+			slog.String("url", record.URL),
+			slog.Int("status", record.StatusCode),
+			slog.String("serviceCode", string(record.ServiceCode)),
+			slog.String("requestID", record.RequestID),
+			slog.String("clientRequestID", record.ClientRequestID),
+			slog.Duration("duration", record.TryDuration),
+			slog.Duration("operationDuration", record.OperationDuration),
+			slog.Bool("slow", record.Slow),
+			slog.Int64("bytesSent", record.BytesSent),
+			slog.Int64("bytesReceived", record.BytesReceived),
+		}
+		if record.Err != nil && record.StatusCode == 0 { // An error response's fields already describe it
+			attrs = append(attrs, slog.String("error", record.Err.Error()))
+		}
+		logger.LogAttrs(ctx, level, "azfile request", attrs...)
+	})
+}
+
+// slogLevel returns the slog level of a pipeline log level.
+func slogLevel(level pipeline.LogLevel) slog.Level {
+	switch level {
+	case pipeline.LogDebug:
+		return slog.LevelDebug
+	case pipeline.LogInfo:
+		return slog.LevelInfo
+	case pipeline.LogWarning:
+		return slog.LevelWarn
+	}
+	return slog.LevelError
+}
@@ -52,11 +52,74 @@ type RetryOptions struct {
 
 	// MaxRetryDelay specifies the maximum delay allowed before retrying an operation (0=default).
 	// If you specify 0, then you must also specify 0 for RetryDelay.
+	// It also caps the delays that the service asks for with the Retry-After and x-ms-retry-after-ms headers.
 	MaxRetryDelay time.Duration
+
+	// RetryReadsFromSecondaryHost specifies whether the retry policy should retry a read operation against another host.
+	// If RetryReadsFromSecondaryHost is "" (the default) then operations are not retried against another host.
+	// Otherwise the retries of GET and HEAD requests alternate between the primary host and this one, which is usually
+	// the account's read-access geo-redundant (RA-GRS) endpoint, such as "account-secondary.file.core.windows.net".
+	// If the secondary returns 404 (Not Found), the file may not have been replicated yet, so only the primary is
+	// tried after that. RetryDecision.Host tells which host each try went to.
+	// NOTE: Before setting this field, make sure you understand the issues around reading stale & potentially-inconsistent
+	// data at this webpage: https://docs.microsoft.com/en-us/azure/storage/common/storage-designing-ha-apps-with-ragrs
+	RetryReadsFromSecondaryHost string
+
+	// Classifier, if not nil, decides whether the outcome of each try is retried, ahead of the built-in rules.
+	Classifier RetryClassifier
+
+	// OnRetry, if not nil, is invoked after each try with the decision whether to retry it.
+	OnRetry func(RetryDecision)
+}
+
+// RetryClassification is a RetryClassifier's decision about the outcome of a try. See the RetryClassification* constants.
+type RetryClassification int32
+
+const (
+	// RetryClassificationDefault leaves the decision to the retry policy's built-in rules.
+	RetryClassificationDefault RetryClassification = 0
+
+	// RetryClassificationRetry retries the try, if any tries are left.
+	RetryClassificationRetry RetryClassification = 1
+
+	// RetryClassificationNoRetry returns the try's response and error as they are.
+	RetryClassificationNoRetry RetryClassification = 2
+)
+
+// RetryClassifier classifies the outcome of a try, given the HTTP status code of its response (0 if there's none),
+// the service code of its error (empty unless err is a StorageError) and its error, which is a net.Error if the
+// request didn't get a response. The retry policy doesn't call it once the operation's context is done.
+type RetryClassifier func(statusCode int, serviceCode ServiceCodeType, err error) RetryClassification
+
+// RetryDecision describes the retry policy's decision about the outcome of a try, as passed to RetryOptions.OnRetry.
+type RetryDecision struct {
+	// Try is the number of the try, starting at 1.
+	Try int32
+
+	// Host is the host that the try went to, which is RetryOptions.RetryReadsFromSecondaryHost for a try against the secondary.
+	Host string
+
+	// StatusCode is the HTTP status code of the try's response, or 0 if it didn't get one.
+	StatusCode int
+
+	// ServiceCode is the service code of the try's error, if it's a StorageError.
+	ServiceCode ServiceCodeType
+
+	// Err is the try's error, if any.
+	Err error
+
+	// Retry tells whether the operation is tried again. It's false if the outcome is retriable but no tries are left.
+	Retry bool
+
+	// Reason describes why the outcome is or isn't retriable.
+	Reason string
+
+	// Delay is the time waited before the next try, if Retry is true.
+	Delay time.Duration
 }
 
 func (o RetryOptions) retryReadsFromSecondaryHost() string {
-	return ""
+	return o.RetryReadsFromSecondaryHost
 }
 
 func (o RetryOptions) defaults() RetryOptions {
@@ -138,6 +201,10 @@ func NewRetryPolicyFactory(o RetryOptions) pipeline.Factory {
 			//    For a primary wait ((2 ^ primaryTries - 1) * delay * random(0.8, 1.2)
 			//    If secondary gets a 404, don't fail, retry but future retries are only against the primary
 			//    When retrying against a secondary, ignore the retry count and wait (.1 second * random(0.8, 1.2))
+			//    A throttled try (503 or ServerBusy) always waits at least the primary's delay, and any try waits at least
+			//    as long as the service asks for with Retry-After or x-ms-retry-after-ms, up to MaxRetryDelay
+			delay := time.Duration(0) // The 1st try has no delay; each retry decides the delay of the next try
+			var primaryErr error      // The error of the last try against the primary, if it failed
 			for try := int32(1); try <= o.MaxTries; try++ {
 				logf("\n=====> Try=%d\n", try)
 
@@ -146,14 +213,18 @@ func NewRetryPolicyFactory(o RetryOptions) pipeline.Factory {
 				// Select the correct host and delay
 				if tryingPrimary {
 					primaryTry++
-					delay := o.calcDelay(primaryTry)
 					logf("Primary try=%d, Delay=%v\n", primaryTry, delay)
-					time.Sleep(delay) // The 1st try returns 0 delay
 				} else {
-					// For casts and rounding - be careful, as per https://github.com/golang/go/issues/20757
-					delay := time.Duration(float32(time.Second) * (rand.Float32()/2 + 0.8))
 					logf("Secondary try=%d, Delay=%v\n", try-primaryTry, delay)
-					time.Sleep(delay) // Delay with some jitter before trying secondary
+				}
+				if delay > 0 {
+					timer := time.NewTimer(delay)
+					select {
+					case <-ctx.Done():
+						timer.Stop()
+						return nil, ctx.Err() // The last try's response was already discarded
+					case <-timer.C:
+					}
 				}
 
 				// Clone the original request to ensure that each try starts with the original (unmutated) request.
@@ -198,16 +269,33 @@ func NewRetryPolicyFactory(o RetryOptions) pipeline.Factory {
 				}*/
 				logf("Err=%v, response=%v\n", err, response)
 
+				httpResponse, serviceCode := tryResponse(response, err)
+				statusCode := 0
+				if httpResponse != nil {
+					statusCode = httpResponse.StatusCode
+				}
+				throttled := statusCode == http.StatusServiceUnavailable || serviceCode == ServiceCodeServerBusy
+
 				action := "" // This MUST get changed within the switch code below
+				classification := RetryClassificationDefault
+				if o.Classifier != nil && ctx.Err() == nil {
+					classification = o.Classifier(statusCode, serviceCode, err)
+				}
 				switch {
 				case ctx.Err() != nil:
 					action = "NoRetry: Op timeout"
-				case !tryingPrimary && response != nil && response.Response() != nil && response.Response().StatusCode == http.StatusNotFound:
+				case classification == RetryClassificationRetry:
+					action = "Retry: Classifier"
+				case classification == RetryClassificationNoRetry:
+					action = "NoRetry: Classifier"
+				case !tryingPrimary && statusCode == http.StatusNotFound:
 					// If attempt was against the secondary & it returned a StatusNotFound (404), then
 					// the resource was not found. This may be due to replication delay. So, in this
 					// case, we'll never try the secondary again for this operation.
 					considerSecondary = false
 					action = "Retry: Secondary URL returned 404"
+				case err != nil && throttled:
+					action = "Retry: Server busy"
 				case err != nil:
 					// NOTE: Protocol Responder returns non-nil if REST API returns invalid status code for the invoked operation.
 					// Use ServiceCode to verify if the error is related to storage service-side,
@@ -243,8 +331,30 @@ func NewRetryPolicyFactory(o RetryOptions) pipeline.Factory {
 				}
 
 				logf("Action=%s\n", action)
-				// fmt.Println(action + "\n") // This is where we could log the retry operation; action is why we're retrying
-				if action[0] != 'R' { // Retry only if action starts with 'R'
+				if tryingPrimary {
+					primaryErr = err
+				}
+				retry := action[0] == 'R' && try < o.MaxTries // Retry only if action starts with 'R'
+				if retry {
+					delay = o.retryDelay(primaryTry, !considerSecondary || ((try+1)%2 == 1), throttled, httpResponse)
+				} else {
+					delay = 0 // There's no next try to wait for
+				}
+				if o.OnRetry != nil {
+					o.OnRetry(RetryDecision{Try: try, Host: requestCopy.URL.Host, StatusCode: statusCode, ServiceCode: serviceCode, Err: err, Retry: retry, Reason: action, Delay: delay})
+				}
+				if !retry {
+					if !tryingPrimary && statusCode == http.StatusNotFound && primaryErr != nil {
+						// The secondary's 404 may only be due to replication delay, so report why the primary failed instead,
+						// after flushing the 404's body to avoid leaking its TCP connection.
+						if response != nil && response.Response() != nil && response.Response().Body != nil {
+							body := response.Response().Body
+							io.Copy(ioutil.Discard, body)
+							body.Close()
+						}
+						tryCancel()
+						return nil, primaryErr
+					}
 					if err != nil {
 						tryCancel() // If we're returning an error, cancel this current/last per-retry timeout context
 					} else {
@@ -277,6 +387,64 @@ func NewRetryPolicyFactory(o RetryOptions) pipeline.Factory {
 	})
 }
 
+// retryDelay returns the delay before the try that follows the try that got httpResponse (which may be nil), given
+// the number of tries against the primary so far and whether the next try is against the primary.
+func (o RetryOptions) retryDelay(primaryTry int32, nextTryingPrimary bool, throttled bool, httpResponse *http.Response) time.Duration {
+	delay := time.Duration(0)
+	if nextTryingPrimary || throttled { // The secondary doesn't relieve a busy server of the retries
+		delay = o.calcDelay(primaryTry + 1)
+	} else {
+		// For casts and rounding - be careful, as per https://github.com/golang/go/issues/20757
+		delay = time.Duration(float32(time.Second) * (rand.Float32()/2 + 0.8)) // Delay with some jitter before trying secondary
+	}
+	if retryAfter, ok := retryAfterHint(httpResponse); ok && retryAfter > delay {
+		delay = retryAfter
+	}
+	if delay > o.MaxRetryDelay {
+		delay = o.MaxRetryDelay
+	}
+	return delay
+}
+
+// retryAfterHint returns the delay that the service asked for in httpResponse's x-ms-retry-after-ms, retry-after-ms or
+// Retry-After header (which holds either seconds or an HTTP date).
+func retryAfterHint(httpResponse *http.Response) (time.Duration, bool) {
+	if httpResponse == nil {
+		return 0, false
+	}
+	for _, header := range []string{"x-ms-retry-after-ms", "retry-after-ms"} {
+		if ms, err := strconv.ParseInt(httpResponse.Header.Get(header), 10, 64); err == nil && ms >= 0 {
+			return time.Duration(ms) * time.Millisecond, true
+		}
+	}
+	retryAfter := httpResponse.Header.Get("Retry-After")
+	if seconds, err := strconv.ParseInt(retryAfter, 10, 64); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if t, err := http.ParseTime(retryAfter); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// tryResponse returns the HTTP response of a try, whether it's in response or in err, and err's service code.
+func tryResponse(response pipeline.Response, err error) (*http.Response, ServiceCodeType) {
+	var httpResponse *http.Response
+	if response != nil {
+		httpResponse = response.Response()
+	}
+	if stgErr, ok := err.(StorageError); ok {
+		if httpResponse == nil {
+			httpResponse = stgErr.Response()
+		}
+		return httpResponse, stgErr.ServiceCode()
+	}
+	return httpResponse, ""
+}
+
 // contextCancelReadCloser helps to invoke context's cancelFunc properly when the ReadCloser is closed.
 type contextCancelReadCloser struct {
 	cf   context.CancelFunc
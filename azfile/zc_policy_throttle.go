@@ -0,0 +1,180 @@
+package azfile
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+)
+
+// ThrottleOptions configures the throttle policy's behavior. A pipeline's limiters can be shared with other pipelines,
+// so that all of them together stay under the limits, and the limits can be changed with RateLimiter.SetLimit while
+// requests are in progress.
+type ThrottleOptions struct {
+	// Upload, if not nil, limits the bytes per second sent in request bodies, such as those of UploadRange, by all of
+	// the requests that use it, including the parallel ones of UploadFileToAzureFile and the other high-level functions.
+	Upload *RateLimiter
+
+	// Download, if not nil, limits the bytes per second read from response bodies, such as those of Download. Use the
+	// same RateLimiter as Upload to limit the bytes sent and received together.
+	Download *RateLimiter
+
+	// Requests limits the requests per second of each operation type. An operation's type is its HTTP method followed,
+	// if its request has one, by a space and the comp query parameter: "PUT" creates a file or directory, "PUT range"
+	// uploads or clears a range, "GET" downloads, "GET list" lists a directory and so on. The limiter with the key "*",
+	// if any, limits all of the requests.
+	Requests map[string]*RateLimiter
+}
+
+// NewThrottlePolicyFactory creates a factory that can create throttle policy objects, which limit the rate of
+// requests and of the bytes in their bodies as o specifies. Each try of a request is throttled, so the policy should
+// be closer to the wire than the retry policy.
+func NewThrottlePolicyFactory(o ThrottleOptions) pipeline.Factory {
+	return pipeline.FactoryFunc(func(next pipeline.Policy, po *pipeline.PolicyOptions) pipeline.PolicyFunc {
+		return func(ctx context.Context, request pipeline.Request) (pipeline.Response, error) {
+			operationType := throttledOperationType(request)
+			for _, key := range []string{"*", operationType} {
+				if err := o.Requests[key].WaitN(ctx, 1); err != nil {
+					return nil, err
+				}
+			}
+
+			if o.Upload != nil && request.Body != nil && request.Body != http.NoBody {
+				request = request.Copy() // So that the caller's request keeps its own body
+				request.Body = &throttledReadCloser{ctx: ctx, body: request.Body, limiter: o.Upload}
+			}
+			response, err := next.Do(ctx, request)
+			if o.Download != nil && response != nil && response.Response() != nil && response.Response().Body != nil {
+				response.Response().Body = &throttledReadCloser{ctx: ctx, body: response.Response().Body, limiter: o.Download}
+			}
+			return response, err
+		}
+	})
+}
+
+// throttledOperationType returns the operation type of the request, as ThrottleOptions.Requests describes it.
+func throttledOperationType(request pipeline.Request) string {
+	if comp := request.URL.Query().Get("comp"); comp != "" {
+		return request.Method + " " + comp
+	}
+	return request.Method
+}
+
+// throttledReadCloser limits the rate at which a request or response body is read. The bytes are waited for once
+// they're read, before they're passed on, so that for a response body, the connection's flow control holds back the
+// rest of the data meanwhile.
+type throttledReadCloser struct {
+	ctx     context.Context
+	body    io.ReadCloser
+	limiter *RateLimiter
+}
+
+func (r *throttledReadCloser) Read(p []byte) (int, error) {
+	// Read at most a second's worth of bytes, so that the bytes are spread over time instead of sent in a burst.
+	if limit := r.limiter.Limit(); limit > 0 && int64(len(p)) > limit {
+		p = p[:limit]
+	}
+	n, err := r.body.Read(p)
+	if n > 0 {
+		if waitErr := r.limiter.WaitN(r.ctx, int64(n)); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+func (r *throttledReadCloser) Close() error {
+	return r.body.Close()
+}
+
+// RateLimiter is a token bucket that limits the rate of events, such as bytes transferred or requests sent, to a
+// number per second. The bucket holds a second's worth of events, so that events that follow a pause can burst up
+// to that many. It's safe for concurrent use.
+type RateLimiter struct {
+	mutex  sync.Mutex
+	limit  int64
+	tokens float64 // Negative when the events waited for so far outrun the limit
+	last   time.Time
+}
+
+// NewRateLimiter creates a RateLimiter that allows limit events per second; a limit <= 0 allows any number of them.
+func NewRateLimiter(limit int64) *RateLimiter {
+	l := &RateLimiter{}
+	l.SetLimit(limit)
+	return l
+}
+
+// Limit returns the number of events allowed per second, or 0 if any number of them is allowed. A nil RateLimiter allows any number of them.
+func (l *RateLimiter) Limit() int64 {
+	if l == nil {
+		return 0
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.limit
+}
+
+// SetLimit changes the number of events allowed per second; a limit <= 0 allows any number of them. The waits that
+// are already in progress keep the durations that the previous limit gave them.
+func (l *RateLimiter) SetLimit(limit int64) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := time.Now()
+	if limit <= 0 {
+		l.limit, l.tokens = 0, 0
+		return
+	}
+	if l.limit <= 0 {
+		l.tokens = float64(limit) // Start with a full bucket
+	} else {
+		l.refill(now)
+	}
+	l.limit, l.last = limit, now
+	if l.tokens > float64(limit) {
+		l.tokens = float64(limit)
+	}
+}
+
+// WaitN waits until n events are allowed, or until ctx is done, in which case it returns ctx's error. The n events
+// are counted even so. A nil RateLimiter allows any number of events.
+func (l *RateLimiter) WaitN(ctx context.Context, n int64) error {
+	if l == nil {
+		return nil
+	}
+	l.mutex.Lock()
+	if l.limit <= 0 {
+		l.mutex.Unlock()
+		return nil
+	}
+	l.refill(time.Now())
+	l.tokens -= float64(n)
+	wait := time.Duration(0)
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / float64(l.limit) * float64(time.Second))
+	}
+	l.mutex.Unlock()
+	if wait == 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// refill adds the tokens accumulated since the last refill, up to a second's worth. l.mutex must be held.
+func (l *RateLimiter) refill(now time.Time) {
+	l.tokens += now.Sub(l.last).Seconds() * float64(l.limit)
+	if l.tokens > float64(l.limit) {
+		l.tokens = float64(l.limit)
+	}
+	l.last = now
+}
@@ -0,0 +1,19 @@
+package azfile
+
+import (
+	"os"
+)
+
+// NewSMBPropertiesFromFileInfo returns the SMB properties that carry a local file's times and attributes over to an
+// Azure file: its modification time becomes the last write time and, on Windows, which records it, its creation time
+// the creation time. On Windows the attributes are the file's own; elsewhere, where files have no such attributes, a
+// name that starts with '.' maps to FileAttributeHidden and a mode without write permission to FileAttributeReadonly.
+// The permission is left unset.
+func NewSMBPropertiesFromFileInfo(info os.FileInfo) SMBProperties {
+	lastWriteTime, attributes := info.ModTime(), fileInfoAttributes(info)
+	sp := SMBProperties{FileAttributes: &attributes, FileLastWriteTime: &lastWriteTime}
+	if creationTime, ok := fileInfoCreationTime(info); ok {
+		sp.FileCreationTime = &creationTime
+	}
+	return sp
+}
@@ -0,0 +1,28 @@
+//go:build !windows
+// +build !windows
+
+package azfile
+
+import (
+	"os"
+	"strings"
+	"time"
+)
+
+// fileInfoAttributes derives attributes from the file's name and mode: dotfiles are hidden, and files that nobody may
+// write to are read-only.
+func fileInfoAttributes(info os.FileInfo) FileAttributeFlags {
+	attributes := FileAttributeNone
+	if strings.HasPrefix(info.Name(), ".") {
+		attributes = attributes.Add(FileAttributeHidden)
+	}
+	if info.Mode().Perm()&0222 == 0 {
+		attributes = attributes.Add(FileAttributeReadonly)
+	}
+	return attributes
+}
+
+// fileInfoCreationTime reports that the file's creation time isn't known, so the service sets it when the Azure file is created.
+func fileInfoCreationTime(info os.FileInfo) (time.Time, bool) {
+	return time.Time{}, false
+}
@@ -0,0 +1,27 @@
+//go:build windows
+// +build windows
+
+package azfile
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// fileInfoAttributes returns the file's attributes, whose values FileAttributeFlags shares. The attributes that the
+// service doesn't support, such as FILE_ATTRIBUTE_DIRECTORY, aren't sent, because FileAttributeFlags.String omits them.
+func fileInfoAttributes(info os.FileInfo) FileAttributeFlags {
+	if d, ok := info.Sys().(*syscall.Win32FileAttributeData); ok {
+		return FileAttributeFlags(d.FileAttributes)
+	}
+	return FileAttributeNone
+}
+
+// fileInfoCreationTime returns the file's creation time.
+func fileInfoCreationTime(info os.FileInfo) (time.Time, bool) {
+	if d, ok := info.Sys().(*syscall.Win32FileAttributeData); ok {
+		return time.Unix(0, d.CreationTime.Nanoseconds()), true
+	}
+	return time.Time{}, false
+}
@@ -52,6 +52,31 @@ func (e *storageError) ServiceCode() ServiceCodeType {
 	return e.serviceCode
 }
 
+// Details returns the details of the service's error response; see StorageErrorDetails.
+func (e *storageError) Details() map[string]string {
+	return e.details
+}
+
+// StorageErrorDetails returns the details of the StorageError that err is, or wraps: the elements of the service's XML
+// error response other than its Message, keyed by their names, such as Code and AuthenticationErrorDetail. It returns
+// nil if err isn't a StorageError, or if the response had no body, as HEAD responses don't. The caller may examine the
+// details but should not modify them.
+func StorageErrorDetails(err error) map[string]string {
+	stgErr, ok := asStorageError(err)
+	if !ok {
+		return nil
+	}
+	if detailed, ok := stgErr.(interface{ Details() map[string]string }); ok {
+		return detailed.Details()
+	}
+	return nil
+}
+
+// Unwrap returns the error that caused the storage error, if any, so that errors.Is and errors.As can examine it.
+func (e *storageError) Unwrap() error {
+	return e.ErrorNode.Cause()
+}
+
 // Error implements the error interface's Error method to return a string representation of the error.
 func (e *storageError) Error() string {
 	b := &bytes.Buffer{}
@@ -95,10 +120,13 @@ func (e *storageError) UnmarshalXML(d *xml.Decoder, start xml.StartElement) (err
 		case xml.StartElement:
 			tokName = tt.Name.Local
 			break
+		case xml.EndElement:
+			tokName = "" // So that the whitespace between elements isn't taken for their values
 		case xml.CharData:
 			switch tokName {
 			case "Message":
 				e.description = string(tt)
+			case "": // The whitespace between elements
 			default:
 				if e.details == nil {
 					e.details = map[string]string{}
@@ -110,6 +138,107 @@ func (e *storageError) UnmarshalXML(d *xml.Decoder, start xml.StartElement) (err
 	return nil
 }
 
+// asStorageError returns the StorageError in err's chain, if any. The chain is followed both through the Unwrap
+// methods of the errors in it, as errors.As does, and through the Cause methods of the pipeline's errors.
+func asStorageError(err error) (StorageError, bool) {
+	for err != nil {
+		if stgErr, ok := err.(StorageError); ok {
+			return stgErr, true
+		}
+		switch e := err.(type) {
+		case interface{ Unwrap() error }:
+			err = e.Unwrap()
+		case interface{ Cause() error }:
+			err = e.Cause()
+		default:
+			err = nil
+		}
+	}
+	return nil, false
+}
+
+// storageErrorStatus returns the HTTP status code of a StorageError's response, or 0 if it has none.
+func storageErrorStatus(stgErr StorageError) int {
+	if response := stgErr.Response(); response != nil {
+		return response.StatusCode
+	}
+	return 0
+}
+
+// IsNotFound reports whether err, or an error that it wraps, is the service saying that a share, directory or file
+// doesn't exist.
+func IsNotFound(err error) bool {
+	stgErr, ok := asStorageError(err)
+	if !ok {
+		return false
+	}
+	switch stgErr.ServiceCode() {
+	case ServiceCodeResourceNotFound, ServiceCodeParentNotFound, ServiceCodeShareNotFound:
+		return true
+	}
+	// HEAD responses have no body, so fall back to the status code.
+	return storageErrorStatus(stgErr) == http.StatusNotFound
+}
+
+// IsAlreadyExists reports whether err, or an error that it wraps, is the service saying that a share, directory or
+// file that's being created already exists.
+func IsAlreadyExists(err error) bool {
+	stgErr, ok := asStorageError(err)
+	if !ok {
+		return false
+	}
+	switch stgErr.ServiceCode() {
+	case ServiceCodeResourceAlreadyExists, ServiceCodeShareAlreadyExists:
+		return true
+	}
+	return false
+}
+
+// IsConflict reports whether err, or an error that it wraps, is a response of the service with the status 409
+// (Conflict), such as ShareBeingDeleted or, for a share that's being created, ShareAlreadyExists.
+func IsConflict(err error) bool {
+	stgErr, ok := asStorageError(err)
+	return ok && storageErrorStatus(stgErr) == http.StatusConflict
+}
+
+// IsThrottled reports whether err, or an error that it wraps, is the service saying that it's too busy to serve the
+// request: a 503 (Service Unavailable) response or ServerBusy. The retry policy retries such requests.
+func IsThrottled(err error) bool {
+	stgErr, ok := asStorageError(err)
+	return ok && (storageErrorStatus(stgErr) == http.StatusServiceUnavailable || stgErr.ServiceCode() == ServiceCodeServerBusy)
+}
+
+// IsAuthFailure reports whether err, or an error that it wraps, is the service refusing to authenticate or authorize
+// the request: a 401 (Unauthorized) or 403 (Forbidden) response, such as AuthenticationFailed. The error's
+// AuthenticationErrorDetail detail, if any, says why.
+func IsAuthFailure(err error) bool {
+	stgErr, ok := asStorageError(err)
+	if !ok {
+		return false
+	}
+	switch stgErr.ServiceCode() {
+	case ServiceCodeAuthenticationFailed, ServiceCodeInvalidAuthenticationInfo, ServiceCodeInsufficientAccountPermissions:
+		return true
+	}
+	status := storageErrorStatus(stgErr)
+	return status == http.StatusUnauthorized || status == http.StatusForbidden
+}
+
+// IsLockConflict reports whether err, or an error that it wraps, is the service saying that a file is locked by an
+// SMB client: FileLockConflict, when a range is locked, or SharingViolation, when a handle's sharing mode denies the
+// operation.
+func IsLockConflict(err error) bool {
+	stgErr, ok := asStorageError(err)
+	if !ok {
+		return false
+	}
+	switch stgErr.ServiceCode() {
+	case ServiceCodeFileLockConflict, ServiceCodeSharingViolation:
+		return true
+	}
+	return false
+}
+
 // Make it clear that a panic occurred due to sanity checks failing
 // This means the user should correct the programming errors
 func sanityCheckFailed(msg string) {
@@ -0,0 +1,218 @@
+package azfile
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	chk "gopkg.in/check.v1"
+)
+
+type checkpointSuite struct{}
+
+var _ = chk.Suite(&checkpointSuite{})
+
+// failRangeOnce makes the first request for the range starting at offset fail.
+func failRangeOnce(mock *mockFileService, method string, offset string) {
+	failed := false
+	mock.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if !failed && r.Method == method && strings.HasPrefix(r.Header.Get("x-ms-range"), "bytes="+offset+"-") {
+			failed = true
+			mockError(w, http.StatusInternalServerError, ServiceCodeInternalError)
+			return true
+		}
+		return false
+	}
+}
+
+// failRangeMidTransfer makes the third range request with method fail at once, and holds the others for a while so
+// that some of them are still in flight when the transfer fails.
+func failRangeMidTransfer(mock *mockFileService, method string) {
+	count := int32(0)
+	mock.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Method != method || r.Header.Get("x-ms-range") == "" {
+			return false
+		}
+		if atomic.AddInt32(&count, 1) == 3 {
+			mockError(w, http.StatusInternalServerError, ServiceCodeInternalError)
+			return true
+		}
+		time.Sleep(20 * time.Millisecond)
+		return false
+	}
+}
+
+func createTestLocalFile(c *chk.C, size int) (*os.File, []byte) {
+	_, data := getRandomDataAndReader(size)
+	name := filepath.Join(c.MkDir(), "local")
+	c.Assert(ioutil.WriteFile(name, data, 0644), chk.IsNil)
+	file, err := os.Open(name)
+	c.Assert(err, chk.IsNil)
+	return file, data
+}
+
+func (s *checkpointSuite) TestUploadResumesAfterFailure(c *chk.C) {
+	fileURL, mock := newTestMockFileURL("file")
+	file, data := createTestLocalFile(c, 10*1024)
+	defer file.Close()
+	o := UploadToAzureFileOptions{RangeSize: 1024, Parallelism: 1, CheckpointPath: filepath.Join(c.MkDir(), "upload.journal")}
+
+	failRangeOnce(mock, http.MethodPut, "5120")
+	c.Assert(UploadFileToAzureFile(context.Background(), file, fileURL, o), chk.NotNil)
+	_, err := os.Stat(o.CheckpointPath)
+	c.Assert(err, chk.IsNil)
+	uploaded := mock.requestCount("PUT /share/file?comp=range")
+
+	progress := int64(0)
+	o.Progress = func(bytesTransferred int64) { progress = bytesTransferred }
+	c.Assert(UploadFileToAzureFile(context.Background(), file, fileURL, o), chk.IsNil)
+	c.Assert(mock.file("file").data, chk.DeepEquals, data)
+	c.Assert(mock.requestCount("PUT /share/file?comp=range")-uploaded, chk.Equals, 5)                         // Ranges 5 to 9
+	c.Assert(mock.requestCount("PUT /share/file?")-mock.requestCount("PUT /share/file?comp="), chk.Equals, 1) // Created once
+	c.Assert(progress, chk.Equals, int64(len(data)))
+
+	_, err = os.Stat(o.CheckpointPath)
+	c.Assert(os.IsNotExist(err), chk.Equals, true)
+}
+
+func (s *checkpointSuite) TestUploadRestartsWhenSourceChanged(c *chk.C) {
+	fileURL, mock := newTestMockFileURL("file")
+	file, data := createTestLocalFile(c, 4*1024)
+	defer file.Close()
+	o := UploadToAzureFileOptions{RangeSize: 1024, Parallelism: 1, CheckpointPath: filepath.Join(c.MkDir(), "upload.journal")}
+
+	failRangeOnce(mock, http.MethodPut, "2048")
+	c.Assert(UploadFileToAzureFile(context.Background(), file, fileURL, o), chk.NotNil)
+	uploaded := mock.requestCount("PUT /share/file?comp=range")
+
+	c.Assert(os.Chtimes(file.Name(), time.Now(), time.Now().Add(time.Hour)), chk.IsNil)
+	c.Assert(UploadFileToAzureFile(context.Background(), file, fileURL, o), chk.IsNil)
+	c.Assert(mock.file("file").data, chk.DeepEquals, data)
+	c.Assert(mock.requestCount("PUT /share/file?comp=range")-uploaded, chk.Equals, 4)
+}
+
+func (s *checkpointSuite) TestUploadRestartsWhenDestinationChanged(c *chk.C) {
+	fileURL, mock := newTestMockFileURL("file")
+	file, data := createTestLocalFile(c, 4*1024)
+	defer file.Close()
+	o := UploadToAzureFileOptions{RangeSize: 1024, Parallelism: 1, CheckpointPath: filepath.Join(c.MkDir(), "upload.journal")}
+
+	failRangeOnce(mock, http.MethodPut, "2048")
+	c.Assert(UploadFileToAzureFile(context.Background(), file, fileURL, o), chk.NotNil)
+	uploaded := mock.requestCount("PUT /share/file?comp=range")
+
+	mock.addFile("file", make([]byte, 4*1024)) // Someone else overwrote the file
+	c.Assert(UploadFileToAzureFile(context.Background(), file, fileURL, o), chk.IsNil)
+	c.Assert(mock.file("file").data, chk.DeepEquals, data)
+	c.Assert(mock.requestCount("PUT /share/file?comp=range")-uploaded, chk.Equals, 4)
+}
+
+func (s *checkpointSuite) TestUploadWaitsForRangesInFlightAfterFailure(c *chk.C) {
+	fileURL, mock := newTestMockFileURL("file")
+	file, data := createTestLocalFile(c, 16*1024)
+	defer file.Close()
+	o := UploadToAzureFileOptions{RangeSize: 1024, Parallelism: 4, CheckpointPath: filepath.Join(c.MkDir(), "upload.journal")}
+
+	failRangeMidTransfer(mock, http.MethodPut)
+	c.Assert(UploadFileToAzureFile(context.Background(), file, fileURL, o), chk.NotNil)
+	uploaded := mock.requestCount("PUT /share/file?comp=range")
+	c.Assert(uploaded <= int(o.Parallelism), chk.Equals, true) // No range was started after the failure
+	time.Sleep(50 * time.Millisecond)
+	c.Assert(mock.requestCount("PUT /share/file?comp=range"), chk.Equals, uploaded) // Nor left running
+
+	// Every range written before the failure was recorded, so the upload resumes instead of restarting.
+	mock.intercept = nil
+	c.Assert(UploadFileToAzureFile(context.Background(), file, fileURL, o), chk.IsNil)
+	c.Assert(mock.file("file").data, chk.DeepEquals, data)
+	c.Assert(mock.requestCount("PUT /share/file?comp=range"), chk.Equals, 16)                                 // Each range was written once
+	c.Assert(mock.requestCount("PUT /share/file?")-mock.requestCount("PUT /share/file?comp="), chk.Equals, 1) // Created once
+}
+
+func (s *checkpointSuite) TestDownloadWaitsForRangesInFlightAfterFailure(c *chk.C) {
+	fileURL, mock := newTestMockFileURL("file")
+	_, data := getRandomDataAndReader(16 * 1024)
+	mock.addFile("file", data)
+	dir := c.MkDir()
+	o := DownloadFromAzureFileOptions{RangeSize: 1024, Parallelism: 4, CheckpointPath: filepath.Join(dir, "download.journal")}
+
+	file, err := os.Create(filepath.Join(dir, "local"))
+	c.Assert(err, chk.IsNil)
+	defer file.Close()
+	failRangeMidTransfer(mock, http.MethodGet)
+	_, err = DownloadAzureFileToFile(context.Background(), fileURL, file, o)
+	c.Assert(err, chk.NotNil)
+	downloaded := mock.requestCount("GET /share/file")
+	c.Assert(downloaded <= int(o.Parallelism), chk.Equals, true)
+	time.Sleep(50 * time.Millisecond)
+	c.Assert(mock.requestCount("GET /share/file"), chk.Equals, downloaded)
+
+	mock.intercept = nil
+	_, err = DownloadAzureFileToFile(context.Background(), fileURL, file, o)
+	c.Assert(err, chk.IsNil)
+	c.Assert(mock.requestCount("GET /share/file"), chk.Equals, 16)
+	local, err := ioutil.ReadFile(file.Name())
+	c.Assert(err, chk.IsNil)
+	c.Assert(local, chk.DeepEquals, data)
+}
+
+func (s *checkpointSuite) TestDownloadResumesAfterCrash(c *chk.C) {
+	fileURL, mock := newTestMockFileURL("file")
+	_, data := getRandomDataAndReader(10 * 1024)
+	mock.addFile("file", data)
+	dir := c.MkDir()
+	o := DownloadFromAzureFileOptions{RangeSize: 1024, Parallelism: 1, CheckpointPath: filepath.Join(dir, "download.journal")}
+
+	file, err := os.Create(filepath.Join(dir, "local"))
+	c.Assert(err, chk.IsNil)
+	defer file.Close()
+	failRangeOnce(mock, http.MethodGet, "5120")
+	_, err = DownloadAzureFileToFile(context.Background(), fileURL, file, o)
+	c.Assert(err, chk.NotNil)
+	downloaded := mock.requestCount("GET /share/file")
+
+	// Simulate a crash while a line was being written.
+	journal, err := os.OpenFile(o.CheckpointPath, os.O_APPEND|os.O_WRONLY, 0644)
+	c.Assert(err, chk.IsNil)
+	_, err = journal.WriteString(`{"offset":61`)
+	c.Assert(err, chk.IsNil)
+	journal.Close()
+
+	_, err = DownloadAzureFileToFile(context.Background(), fileURL, file, o)
+	c.Assert(err, chk.IsNil)
+	c.Assert(mock.requestCount("GET /share/file")-downloaded, chk.Equals, 5)
+	local, err := ioutil.ReadFile(file.Name())
+	c.Assert(err, chk.IsNil)
+	c.Assert(local, chk.DeepEquals, data)
+	_, err = os.Stat(o.CheckpointPath)
+	c.Assert(os.IsNotExist(err), chk.Equals, true)
+}
+
+func (s *checkpointSuite) TestDownloadRestartsWhenSourceChanged(c *chk.C) {
+	fileURL, mock := newTestMockFileURL("file")
+	_, data := getRandomDataAndReader(4 * 1024)
+	mock.addFile("file", data)
+	dir := c.MkDir()
+	o := DownloadFromAzureFileOptions{RangeSize: 1024, Parallelism: 1, CheckpointPath: filepath.Join(dir, "download.journal")}
+
+	file, err := os.Create(filepath.Join(dir, "local"))
+	c.Assert(err, chk.IsNil)
+	defer file.Close()
+	failRangeOnce(mock, http.MethodGet, "2048")
+	_, err = DownloadAzureFileToFile(context.Background(), fileURL, file, o)
+	c.Assert(err, chk.NotNil)
+	downloaded := mock.requestCount("GET /share/file")
+
+	_, data = getRandomDataAndReader(4 * 1024)
+	mock.addFile("file", data)
+	_, err = DownloadAzureFileToFile(context.Background(), fileURL, file, o)
+	c.Assert(err, chk.IsNil)
+	c.Assert(mock.requestCount("GET /share/file")-downloaded, chk.Equals, 4)
+	local, err := ioutil.ReadFile(file.Name())
+	c.Assert(err, chk.IsNil)
+	c.Assert(local, chk.DeepEquals, data)
+}
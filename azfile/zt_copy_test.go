@@ -0,0 +1,212 @@
+package azfile
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+
+	chk "gopkg.in/check.v1"
+)
+
+type copySuite struct{}
+
+var _ = chk.Suite(&copySuite{})
+
+// newTestSiblingFileURL returns the URL of another file in the share of fileURL, which uses the same pipeline.
+func newTestSiblingFileURL(fileURL FileURL, name string) FileURL {
+	parts := NewFileURLParts(fileURL.URL())
+	parts.DirectoryOrFilePath = name
+	return NewFileURL(parts.URL(), fileURL.fileClient.Pipeline())
+}
+
+func (s *copySuite) TestCopyCarriesOverDataAndProperties(c *chk.C) {
+	populated := []Range{{Start: 0, End: 99}, {Start: 5000, End: 7047}, {Start: 20000, End: 20479}}
+	source, _, data := newTestSparseFile(32*1024, populated)
+	ctx := context.Background()
+
+	attributes := FileAttributeReadonly | FileAttributeArchive
+	created, written := time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC), time.Date(2020, 6, 7, 8, 9, 10, 0, time.UTC)
+	_, err := source.SetHTTPHeaders(ctx, FileHTTPHeaders{ContentType: "text/plain", CacheControl: "no-cache",
+		SMBProperties: SMBProperties{FileAttributes: &attributes, FileCreationTime: &created, FileLastWriteTime: &written}})
+	c.Assert(err, chk.IsNil)
+	_, err = source.SetMetadata(ctx, Metadata{"origin": "test"})
+	c.Assert(err, chk.IsNil)
+	destination := newTestSiblingFileURL(source, "copy")
+
+	progress := int64(0)
+	err = CopyAzureFileToAzureFile(ctx, source, destination, CopyAzureFileToAzureFileOptions{RangeSize: 1024,
+		Progress: func(bytesTransferred int64) { progress = bytesTransferred }})
+	c.Assert(err, chk.IsNil)
+	c.Assert(progress, chk.Equals, int64(100+2048+480))
+
+	props, err := destination.GetProperties(ctx)
+	c.Assert(err, chk.IsNil)
+	c.Assert(props.ContentLength(), chk.Equals, int64(len(data)))
+	c.Assert(props.ContentType(), chk.Equals, "text/plain")
+	c.Assert(props.CacheControl(), chk.Equals, "no-cache")
+	c.Assert(props.NewMetadata(), chk.DeepEquals, Metadata{"origin": "test"})
+	adapter := SMBPropertyAdapter{PropertySource: props}
+	c.Assert(adapter.FileAttributes(), chk.Equals, attributes)
+	c.Assert(adapter.FileCreationTime().Equal(created), chk.Equals, true)
+	c.Assert(adapter.FileLastWriteTime().Equal(written), chk.Equals, true)
+
+	b := make([]byte, len(data))
+	_, err = DownloadAzureFileToBuffer(ctx, destination, b, DownloadFromAzureFileOptions{})
+	c.Assert(err, chk.IsNil)
+	c.Assert(b, chk.DeepEquals, data)
+	rangeList, err := destination.GetRangeList(ctx, 0, CountToEnd)
+	c.Assert(err, chk.IsNil)
+	c.Assert(rangeList.Items, chk.DeepEquals, populated)
+}
+
+func (s *copySuite) TestCopyOfEmptyFile(c *chk.C) {
+	source, mock := newTestMockFileURL("empty")
+	mock.addFile("empty", nil)
+	destination := newTestSiblingFileURL(source, "copy")
+
+	c.Assert(CopyAzureFileToAzureFile(context.Background(), source, destination, CopyAzureFileToAzureFileOptions{}), chk.IsNil)
+	c.Assert(mock.file("copy").data, chk.HasLen, 0)
+	c.Assert(mock.requestCount("PUT /share/copy?comp=range"), chk.Equals, 0)
+}
+
+func (s *copySuite) TestCopyNegativeMissingSource(c *chk.C) {
+	source, mock := newTestMockFileURL("missing")
+	destination := newTestSiblingFileURL(source, "copy")
+
+	err := CopyAzureFileToAzureFile(context.Background(), source, destination, CopyAzureFileToAzureFileOptions{})
+	c.Assert(err, chk.NotNil)
+	c.Assert(err.(StorageError).ServiceCode(), chk.Equals, ServiceCodeResourceNotFound)
+	c.Assert(mock.requestCount("PUT /share/copy"), chk.Equals, 0)
+}
+
+func (s *copySuite) TestCopyNegativeInvalidRangeSize(c *chk.C) {
+	source, _ := newTestMockFileURL("file")
+	err := CopyAzureFileToAzureFile(context.Background(), source, source, CopyAzureFileToAzureFileOptions{RangeSize: FileMaxUploadRangeBytes + 1})
+	c.Assert(err, chk.ErrorMatches, "invalid argument.*")
+}
+
+func (s *copySuite) TestInSameShare(c *chk.C) {
+	f := func(s string) FileURL {
+		u, _ := url.Parse(s)
+		return NewFileURL(*u, NewPipeline(NewAnonymousCredential(), PipelineOptions{}))
+	}
+	c.Assert(inSameShare(f("https://a.file.core.windows.net/share/x"), f("https://a.file.core.windows.net/share/d/y?sharesnapshot=2020-01-01T00:00:00.0000000Z")), chk.Equals, true)
+	c.Assert(inSameShare(f("https://a.file.core.windows.net/share/x"), f("https://a.file.core.windows.net/other/x")), chk.Equals, false)
+	c.Assert(inSameShare(f("https://a.file.core.windows.net/share/x"), f("https://b.file.core.windows.net/share/x")), chk.Equals, false)
+}
+
+func (s *copySuite) TestCopyToAnotherShareCreatesPermission(c *chk.C) {
+	source, mock := newTestMockFileURL("file")
+	mock.addFile("file", []byte("data"))
+	ctx := context.Background()
+	created, err := shareURLOfFile(source).CreatePermission(ctx, "O:BAG:BAD:(A;;FA;;;BA)")
+	c.Assert(err, chk.IsNil)
+	key := created.FilePermissionKey()
+	_, err = source.SetHTTPHeaders(ctx, FileHTTPHeaders{SMBProperties: SMBProperties{PermissionKey: &key}})
+	c.Assert(err, chk.IsNil)
+
+	u, _ := url.Parse("https://account.file.core.windows.net/other/copy")
+	destination := NewFileURL(*u, source.fileClient.Pipeline())
+	c.Assert(CopyAzureFileToAzureFile(ctx, source, destination, CopyAzureFileToAzureFileOptions{}), chk.IsNil)
+
+	props, err := destination.GetProperties(ctx)
+	c.Assert(err, chk.IsNil)
+	c.Assert(props.FilePermissionKey(), chk.Not(chk.Equals), key) // The source's key isn't valid in the other share
+	permission, err := shareURLOfFile(destination).GetPermission(ctx, props.FilePermissionKey())
+	c.Assert(err, chk.IsNil)
+	c.Assert(permission.Permission, chk.Equals, "O:BAG:BAD:(A;;FA;;;BA)")
+}
+
+// startTestCopy starts copying a file of 3000 bytes with StartCopy, returning the destination and the copy's ID.
+func startTestCopy(c *chk.C) (FileURL, *mockFileService, string) {
+	source, mock := newTestMockFileURL("source")
+	_, data := getRandomDataAndReader(3000)
+	mock.addFile("source", data)
+	destination := newTestSiblingFileURL(source, "copy")
+	resp, err := destination.StartCopy(context.Background(), source.URL(), Metadata{})
+	c.Assert(err, chk.IsNil)
+	c.Assert(resp.CopyStatus(), chk.Equals, CopyStatusPending)
+	return destination, mock, resp.CopyID()
+}
+
+func (s *copySuite) TestWaitForCopyReportsProgressUntilSuccess(c *chk.C) {
+	destination, mock, copyID := startTestCopy(c)
+	polls := 0
+	mock.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Method == http.MethodHead {
+			polls++
+			if polls == 2 {
+				mock.setCopyState("copy", CopyStatusPending, "1000/3000", "")
+			} else if polls == 3 {
+				mock.setCopyState("copy", CopyStatusSuccess, "3000/3000", "")
+			}
+		}
+		return false
+	}
+
+	progress := [][2]int64{}
+	props, err := WaitForCopy(context.Background(), destination, copyID, WaitForCopyOptions{PollInterval: time.Millisecond,
+		Progress: func(bytesCopied int64, totalBytes int64) {
+			progress = append(progress, [2]int64{bytesCopied, totalBytes})
+		}})
+	c.Assert(err, chk.IsNil)
+	c.Assert(props.CopyStatus(), chk.Equals, CopyStatusSuccess)
+	c.Assert(progress, chk.DeepEquals, [][2]int64{{0, 3000}, {1000, 3000}, {3000, 3000}})
+}
+
+func (s *copySuite) TestWaitForCopyNegativeFailed(c *chk.C) {
+	destination, mock, copyID := startTestCopy(c)
+	mock.setCopyState("copy", CopyStatusFailed, "1000/3000", "500 InternalError \"Copy failed.\"")
+
+	_, err := WaitForCopy(context.Background(), destination, copyID, WaitForCopyOptions{})
+	c.Assert(err, chk.FitsTypeOf, &CopyFailedError{})
+	failed := err.(*CopyFailedError)
+	c.Assert(failed.CopyID, chk.Equals, copyID)
+	c.Assert(failed.Status, chk.Equals, CopyStatusFailed)
+	c.Assert(failed.StatusDescription, chk.Equals, "500 InternalError \"Copy failed.\"")
+}
+
+func (s *copySuite) TestWaitForCopyAbortsWhenCancelled(c *chk.C) {
+	destination, mock, copyID := startTestCopy(c)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// Cancel while waiting to poll again.
+	_, err := WaitForCopy(ctx, destination, copyID, WaitForCopyOptions{PollInterval: time.Hour,
+		Progress: func(int64, int64) { cancel() }})
+	c.Assert(err, chk.Equals, context.Canceled)
+	c.Assert(mock.requestCount("PUT /share/copy?comp=copy&copyid="+copyID), chk.Equals, 1)
+	c.Assert(mock.file("copy").copyStatus, chk.Equals, string(CopyStatusAborted))
+}
+
+func (s *copySuite) TestWaitForCopyWhenCancelledAfterCopyEnded(c *chk.C) {
+	destination, mock, copyID := startTestCopy(c)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// The copy can't be aborted any more, which isn't an error of its own.
+	_, err := WaitForCopy(ctx, destination, copyID, WaitForCopyOptions{PollInterval: time.Hour,
+		Progress: func(int64, int64) {
+			mock.setCopyState("copy", CopyStatusSuccess, "3000/3000", "")
+			cancel()
+		}})
+	c.Assert(err, chk.Equals, context.Canceled)
+	c.Assert(mock.requestCount("PUT /share/copy?comp=copy"), chk.Equals, 1)
+}
+
+func (s *copySuite) TestWaitForCopyNegativeReplacedCopy(c *chk.C) {
+	destination, _, _ := startTestCopy(c)
+	_, err := WaitForCopy(context.Background(), destination, "another", WaitForCopyOptions{})
+	c.Assert(err, chk.ErrorMatches, ".*another copy or a write replaced it")
+}
+
+func (s *copySuite) TestParseCopyProgress(c *chk.C) {
+	bytesCopied, totalBytes, err := ParseCopyProgress("1024/4096")
+	c.Assert(err, chk.IsNil)
+	c.Assert(bytesCopied, chk.Equals, int64(1024))
+	c.Assert(totalBytes, chk.Equals, int64(4096))
+
+	for _, invalid := range []string{"", "1024", "a/4096", "1024/b", "1/2/3"} {
+		_, _, err = ParseCopyProgress(invalid)
+		c.Assert(err, chk.NotNil)
+	}
+}
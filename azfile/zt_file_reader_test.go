@@ -0,0 +1,177 @@
+package azfile
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	chk "gopkg.in/check.v1"
+)
+
+type fileReaderSuite struct{}
+
+var _ = chk.Suite(&fileReaderSuite{})
+
+func newTestFileReader(c *chk.C, size int, o FileReaderOptions) (*FileReader, []byte, *mockFileService) {
+	fileURL, mock := newTestMockFileURL("file")
+	_, data := getRandomDataAndReader(size)
+	mock.addFile("file", data)
+	r, err := NewFileReader(context.Background(), fileURL, o)
+	c.Assert(err, chk.IsNil)
+	return r, data, mock
+}
+
+func (s *fileReaderSuite) TestFileReaderReadAt(c *chk.C) {
+	r, data, _ := newTestFileReader(c, 10000, FileReaderOptions{BlockSize: 1024})
+	defer r.Close()
+	c.Assert(r.Size(), chk.Equals, int64(10000))
+
+	for _, t := range []struct{ off, length int }{{0, 1}, {1000, 100}, {1023, 2}, {0, 10000}, {5000, 3000}, {9999, 1}} {
+		b := make([]byte, t.length)
+		n, err := r.ReadAt(b, int64(t.off))
+		c.Assert(err, chk.IsNil)
+		c.Assert(n, chk.Equals, t.length)
+		c.Assert(b, chk.DeepEquals, data[t.off:t.off+t.length])
+	}
+
+	b := make([]byte, 100)
+	n, err := r.ReadAt(b, 9950)
+	c.Assert(n, chk.Equals, 50)
+	c.Assert(err, chk.Equals, io.EOF)
+	c.Assert(b[:n], chk.DeepEquals, data[9950:])
+
+	n, err = r.ReadAt(b, 10000)
+	c.Assert(n, chk.Equals, 0)
+	c.Assert(err, chk.Equals, io.EOF)
+}
+
+func (s *fileReaderSuite) TestFileReaderCachesBlocks(c *chk.C) {
+	r, _, mock := newTestFileReader(c, 4096, FileReaderOptions{BlockSize: 1024, CacheBlocks: 2})
+	defer r.Close()
+	b := make([]byte, 10)
+
+	r.ReadAt(b, 0)
+	r.ReadAt(b, 100)
+	r.ReadAt(b, 1100)
+	c.Assert(mock.requestCount("GET /share/file"), chk.Equals, 2)
+
+	r.ReadAt(b, 2100) // Evicts block 0, the least recently used
+	r.ReadAt(b, 1200)
+	c.Assert(mock.requestCount("GET /share/file"), chk.Equals, 3)
+	r.ReadAt(b, 0)
+	c.Assert(mock.requestCount("GET /share/file"), chk.Equals, 4)
+}
+
+func (s *fileReaderSuite) TestFileReaderConcurrentReadAt(c *chk.C) {
+	r, data, mock := newTestFileReader(c, 64*1024, FileReaderOptions{BlockSize: 4096, CacheBlocks: 16})
+	defer r.Close()
+	wg := sync.WaitGroup{}
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for off := g * 100; off+1000 <= len(data); off += 3000 {
+				b := make([]byte, 1000)
+				n, err := r.ReadAt(b, int64(off))
+				c.Check(err, chk.IsNil)
+				c.Check(b[:n], chk.DeepEquals, data[off:off+1000])
+			}
+		}(g)
+	}
+	wg.Wait()
+	c.Assert(mock.requestCount("GET /share/file"), chk.Equals, 16) // Each block is downloaded once
+}
+
+func (s *fileReaderSuite) TestFileReaderReadSeekReadsAhead(c *chk.C) {
+	r, data, mock := newTestFileReader(c, 10*1024, FileReaderOptions{BlockSize: 1024, ReadAhead: 3})
+	defer r.Close()
+
+	b := make([]byte, 10)
+	_, err := io.ReadFull(r, b)
+	c.Assert(err, chk.IsNil)
+	c.Assert(b, chk.DeepEquals, data[:10])
+	for deadline := time.Now().Add(5 * time.Second); mock.requestCount("GET /share/file") < 4 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	c.Assert(mock.requestCount("GET /share/file"), chk.Equals, 4)
+
+	pos, err := r.Seek(-24, io.SeekEnd)
+	c.Assert(err, chk.IsNil)
+	c.Assert(pos, chk.Equals, int64(len(data)-24))
+	rest, err := ioutil.ReadAll(r)
+	c.Assert(err, chk.IsNil)
+	c.Assert(rest, chk.DeepEquals, data[len(data)-24:])
+
+	r.Seek(0, io.SeekStart)
+	all, err := ioutil.ReadAll(r)
+	c.Assert(err, chk.IsNil)
+	c.Assert(all, chk.DeepEquals, data)
+
+	_, err = r.Seek(-1, io.SeekStart)
+	c.Assert(err, chk.NotNil)
+}
+
+func (s *fileReaderSuite) TestFileReaderOpensZipInPlace(c *chk.C) {
+	archive := &bytes.Buffer{}
+	zw := zip.NewWriter(archive)
+	for _, name := range []string{"a.txt", "b.txt"} {
+		w, _ := zw.Create(name)
+		w.Write(bytes.Repeat([]byte(name), 10000))
+	}
+	c.Assert(zw.Close(), chk.IsNil)
+
+	fileURL, mock := newTestMockFileURL("archive.zip")
+	mock.addFile("archive.zip", archive.Bytes())
+	r, err := NewFileReader(context.Background(), fileURL, FileReaderOptions{BlockSize: 512})
+	c.Assert(err, chk.IsNil)
+	defer r.Close()
+
+	zr, err := zip.NewReader(r, r.Size())
+	c.Assert(err, chk.IsNil)
+	c.Assert(zr.File, chk.HasLen, 2)
+	f, err := zr.File[1].Open()
+	c.Assert(err, chk.IsNil)
+	content, err := ioutil.ReadAll(f)
+	c.Assert(err, chk.IsNil)
+	c.Assert(content, chk.DeepEquals, bytes.Repeat([]byte("b.txt"), 10000))
+}
+
+func (s *fileReaderSuite) TestFileReaderRetriesFailedBlock(c *chk.C) {
+	r, data, mock := newTestFileReader(c, 2048, FileReaderOptions{BlockSize: 1024})
+	defer r.Close()
+	failures := 1
+	mock.intercept = func(w http.ResponseWriter, req *http.Request) bool {
+		if req.Method == http.MethodGet && failures > 0 {
+			failures--
+			mockError(w, http.StatusInternalServerError, ServiceCodeInternalError)
+			return true
+		}
+		return false
+	}
+
+	b := make([]byte, 10)
+	_, err := r.ReadAt(b, 0)
+	c.Assert(err, chk.NotNil)
+	_, err = r.ReadAt(b, 0)
+	c.Assert(err, chk.IsNil)
+	c.Assert(b, chk.DeepEquals, data[:10])
+}
+
+func (s *fileReaderSuite) TestFileReaderNegativeClosed(c *chk.C) {
+	r, _, _ := newTestFileReader(c, 2048, FileReaderOptions{})
+	c.Assert(r.Close(), chk.IsNil)
+	_, err := r.ReadAt(make([]byte, 1), 0)
+	c.Assert(err, chk.NotNil)
+}
+
+func (s *fileReaderSuite) TestFileReaderNegativeMissingFile(c *chk.C) {
+	fileURL, _ := newTestMockFileURL("missing")
+	_, err := NewFileReader(context.Background(), fileURL, FileReaderOptions{})
+	c.Assert(err, chk.NotNil)
+	c.Assert(err.(StorageError).Response().StatusCode, chk.Equals, http.StatusNotFound)
+}
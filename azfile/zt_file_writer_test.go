@@ -0,0 +1,103 @@
+package azfile
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	chk "gopkg.in/check.v1"
+)
+
+type fileWriterSuite struct{}
+
+var _ = chk.Suite(&fileWriterSuite{})
+
+func (s *fileWriterSuite) TestFileWriterWithEncoders(c *chk.C) {
+	fileURL, mock := newTestMockFileURL("data.json.gz")
+	w, err := NewFileWriter(context.Background(), fileURL, UploadToAzureFileOptions{RangeSize: 1024, Parallelism: 2,
+		FileHTTPHeaders: FileHTTPHeaders{ContentType: "application/json", ContentEncoding: "gzip"},
+		Metadata:        Metadata{"source": "test"}})
+	c.Assert(err, chk.IsNil)
+
+	values := make([]int, 10000)
+	for i := range values {
+		values[i] = i * i
+	}
+	gw := gzip.NewWriter(w)
+	c.Assert(json.NewEncoder(gw).Encode(values), chk.IsNil)
+	c.Assert(gw.Close(), chk.IsNil)
+	c.Assert(w.Close(), chk.IsNil)
+
+	f := mock.file("data.json.gz")
+	gr, err := gzip.NewReader(bytes.NewReader(f.data))
+	c.Assert(err, chk.IsNil)
+	decoded := []int{}
+	c.Assert(json.NewDecoder(gr).Decode(&decoded), chk.IsNil)
+	c.Assert(decoded, chk.DeepEquals, values)
+
+	// The headers survive the resizes done while writing.
+	c.Assert(f.headers, chk.DeepEquals, map[string]string{"Content-Type": "application/json", "Content-Encoding": "gzip"})
+	c.Assert(f.metadata, chk.DeepEquals, map[string]string{"source": "test"})
+}
+
+func (s *fileWriterSuite) TestFileWriterCopy(c *chk.C) {
+	for _, size := range []int{0, 1, 4096, 5000} {
+		fileURL, mock := newTestMockFileURL("file")
+		_, data := getRandomDataAndReader(size)
+		w, err := NewFileWriter(context.Background(), fileURL, UploadToAzureFileOptions{RangeSize: 1024})
+		c.Assert(err, chk.IsNil)
+
+		// Mix Write and ReadFrom (which io.Copy uses) so that ranges straddle the two.
+		half := size / 2
+		n, err := w.Write(data[:half])
+		c.Assert(err, chk.IsNil)
+		c.Assert(n, chk.Equals, half)
+		copied, err := io.Copy(w, ioutil.NopCloser(bytes.NewReader(data[half:])))
+		c.Assert(err, chk.IsNil)
+		c.Assert(copied, chk.Equals, int64(size-half))
+		c.Assert(w.Close(), chk.IsNil)
+
+		c.Assert(mock.file("file").data, chk.DeepEquals, append([]byte{}, data...))
+	}
+}
+
+func (s *fileWriterSuite) TestFileWriterNegativeUploadError(c *chk.C) {
+	fileURL, mock := newTestMockFileURL("file")
+	mock.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if r.URL.Query().Get("comp") == "range" {
+			mockError(w, http.StatusForbidden, ServiceCodeAuthenticationFailed)
+			return true
+		}
+		return false
+	}
+	w, err := NewFileWriter(context.Background(), fileURL, UploadToAzureFileOptions{RangeSize: 1024})
+	c.Assert(err, chk.IsNil)
+
+	// The failure surfaces from a later Write, or at the latest from Close.
+	_, data := getRandomDataAndReader(10 * 1024)
+	w.Write(data)
+	err = w.Close()
+	c.Assert(err, chk.NotNil)
+	c.Assert(err.(StorageError).ServiceCode(), chk.Equals, ServiceCodeAuthenticationFailed)
+}
+
+func (s *fileWriterSuite) TestFileWriterNegativeClosed(c *chk.C) {
+	fileURL, _ := newTestMockFileURL("file")
+	w, err := NewFileWriter(context.Background(), fileURL, UploadToAzureFileOptions{})
+	c.Assert(err, chk.IsNil)
+	c.Assert(w.Close(), chk.IsNil)
+	_, err = w.Write([]byte("a"))
+	c.Assert(err, chk.NotNil)
+	c.Assert(w.Close(), chk.NotNil)
+}
+
+func (s *fileWriterSuite) TestFileWriterNegativeCreateFails(c *chk.C) {
+	fileURL, _ := newTestMockFileURL("missing/file")
+	_, err := NewFileWriter(context.Background(), fileURL, UploadToAzureFileOptions{})
+	c.Assert(err, chk.NotNil)
+	c.Assert(err.(StorageError).ServiceCode(), chk.Equals, ServiceCodeParentNotFound)
+}
@@ -0,0 +1,67 @@
+package azfile
+
+import (
+	"errors"
+
+	chk "gopkg.in/check.v1"
+)
+
+type handlesSuite struct{}
+
+var _ = chk.Suite(&handlesSuite{})
+
+func (s *handlesSuite) TestListAllHandlesFollowsNextMarker(c *chk.C) {
+	segments := []ListHandlesResponse{
+		{HandleList: []HandleItem{{HandleID: "1"}, {HandleID: "2"}}, NextMarker: "m1"},
+		{HandleList: []HandleItem{{HandleID: "3"}}, NextMarker: "m2"},
+		{NextMarker: ""},
+	}
+	markers := []*string{}
+	call := 0
+	handles, err := listAllHandles(func(marker Marker) (*ListHandlesResponse, error) {
+		markers = append(markers, marker.Val)
+		resp := segments[call]
+		call++
+		return &resp, nil
+	})
+
+	c.Assert(err, chk.IsNil)
+	c.Assert(handles, chk.HasLen, 3)
+	c.Assert(handles[2].HandleID, chk.Equals, "3")
+	c.Assert(markers, chk.HasLen, 3)
+	c.Assert(markers[0], chk.IsNil)
+	c.Assert(*markers[1], chk.Equals, "m1")
+	c.Assert(*markers[2], chk.Equals, "m2")
+}
+
+func (s *handlesSuite) TestForceCloseAllHandlesSumsClosedCounts(c *chk.C) {
+	type result struct {
+		closed int32
+		marker string
+	}
+	results := []result{{3, "m1"}, {-1, "m2"}, {2, ""}}
+	call := 0
+	total, err := forceCloseAllHandles(func(marker Marker) (int32, string, error) {
+		r := results[call]
+		call++
+		return r.closed, r.marker, nil
+	})
+
+	c.Assert(err, chk.IsNil)
+	c.Assert(call, chk.Equals, 3)
+	c.Assert(total, chk.Equals, int32(5))
+}
+
+func (s *handlesSuite) TestForceCloseAllHandlesStopsOnError(c *chk.C) {
+	call := 0
+	total, err := forceCloseAllHandles(func(marker Marker) (int32, string, error) {
+		call++
+		if call == 2 {
+			return 0, "", errors.New("boom")
+		}
+		return 4, "next", nil
+	})
+
+	c.Assert(err, chk.ErrorMatches, "boom")
+	c.Assert(total, chk.Equals, int32(4))
+}
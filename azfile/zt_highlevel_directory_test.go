@@ -0,0 +1,195 @@
+package azfile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	chk "gopkg.in/check.v1"
+)
+
+type highLevelDirectorySuite struct{}
+
+var _ = chk.Suite(&highLevelDirectorySuite{})
+
+// testListingHandler serves directory listings for an in-memory tree, one entry per page so that markers are exercised.
+type testListingHandler struct {
+	dirs  map[string][]string // share-relative directory path -> names; names of subdirectories end with '/'
+	mutex sync.Mutex
+	lists []string // directories listed, in order
+}
+
+func (h *testListingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	dirPath := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/share"), "/")
+	names, ok := h.dirs[dirPath]
+	if !ok {
+		w.Header().Set("x-ms-error-code", string(ServiceCodeResourceNotFound))
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	h.mutex.Lock()
+	h.lists = append(h.lists, dirPath)
+	h.mutex.Unlock()
+
+	q := r.URL.Query()
+	filtered := []string{}
+	for _, n := range names {
+		if strings.HasPrefix(n, q.Get("prefix")) {
+			filtered = append(filtered, n)
+		}
+	}
+	index := 0
+	fmt.Sscanf(q.Get("marker"), "%d", &index)
+	body := "<EnumerationResults><Entries>"
+	if index < len(filtered) {
+		if n := filtered[index]; strings.HasSuffix(n, "/") {
+			body += "<Directory><Name>" + strings.TrimSuffix(n, "/") + "</Name></Directory>"
+		} else {
+			body += "<File><Name>" + n + "</Name><Properties><Content-Length>" + fmt.Sprint(len(n)) + "</Content-Length></Properties></File>"
+		}
+	}
+	body += "</Entries><NextMarker>"
+	if index+1 < len(filtered) {
+		body += fmt.Sprint(index + 1)
+	}
+	body += "</NextMarker></EnumerationResults>"
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(body))
+}
+
+func newTestWalkShare() (ShareURL, *testListingHandler) {
+	h := &testListingHandler{dirs: map[string][]string{
+		"":             {"a.txt", "b/", "c.txt", "logs/"},
+		"b":            {"x.txt", "y/"},
+		"b/y":          {"z.txt"},
+		"logs":         {"2019-12.log", "2020-01.log", "2020-02/"},
+		"logs/2020-02": {"01.log"},
+	}}
+	u, _ := url.Parse("https://account.file.core.windows.net/share")
+	return NewShareURL(*u, newTestHandlerPipeline(h)), h
+}
+
+func walkAndCollect(c *chk.C, d DirectoryURL, o WalkOptions, fn WalkFunc) []string {
+	paths := []string{}
+	err := d.Walk(context.Background(), o, func(entry WalkEntry, err error) error {
+		c.Assert(err, chk.IsNil)
+		suffix := ""
+		if entry.IsDirectory {
+			suffix = "/"
+			c.Assert(entry.Properties, chk.IsNil)
+		} else {
+			c.Assert(entry.Properties.ContentLength, chk.Equals, int64(len(entry.Name)))
+		}
+		paths = append(paths, entry.Path+suffix)
+		if fn != nil {
+			return fn(entry, err)
+		}
+		return nil
+	})
+	c.Assert(err, chk.IsNil)
+	sort.Strings(paths)
+	return paths
+}
+
+func (s *highLevelDirectorySuite) TestWalkVisitsEverything(c *chk.C) {
+	shareURL, _ := newTestWalkShare()
+	paths := walkAndCollect(c, shareURL.NewRootDirectoryURL(), WalkOptions{}, nil)
+	c.Assert(paths, chk.DeepEquals, []string{"a.txt", "b/", "b/x.txt", "b/y/", "b/y/z.txt", "c.txt",
+		"logs/", "logs/2019-12.log", "logs/2020-01.log", "logs/2020-02/", "logs/2020-02/01.log"})
+}
+
+func (s *highLevelDirectorySuite) TestWalkSubdirectoryReportsSharePaths(c *chk.C) {
+	shareURL, _ := newTestWalkShare()
+	paths := walkAndCollect(c, shareURL.NewDirectoryURL("b"), WalkOptions{Parallelism: 1}, nil)
+	c.Assert(paths, chk.DeepEquals, []string{"b/x.txt", "b/y/", "b/y/z.txt"})
+}
+
+func (s *highLevelDirectorySuite) TestWalkMaxDepth(c *chk.C) {
+	shareURL, h := newTestWalkShare()
+	paths := walkAndCollect(c, shareURL.NewRootDirectoryURL(), WalkOptions{MaxDepth: 1}, nil)
+	c.Assert(paths, chk.DeepEquals, []string{"a.txt", "b/", "c.txt", "logs/"})
+	c.Assert(h.lists, chk.DeepEquals, []string{"", "", "", ""}) // One request per page of the root only
+}
+
+func (s *highLevelDirectorySuite) TestWalkSkipDir(c *chk.C) {
+	shareURL, _ := newTestWalkShare()
+	paths := walkAndCollect(c, shareURL.NewRootDirectoryURL(), WalkOptions{}, func(entry WalkEntry, err error) error {
+		if entry.Path == "b" || entry.Path == "logs/2019-12.log" {
+			return SkipDir
+		}
+		return nil
+	})
+	c.Assert(paths, chk.DeepEquals, []string{"a.txt", "b/", "c.txt", "logs/", "logs/2019-12.log"})
+}
+
+func (s *highLevelDirectorySuite) TestWalkPrefix(c *chk.C) {
+	shareURL, h := newTestWalkShare()
+	paths := walkAndCollect(c, shareURL.NewRootDirectoryURL(), WalkOptions{Prefix: "logs/2020-"}, nil)
+	c.Assert(paths, chk.DeepEquals, []string{"logs/2020-01.log", "logs/2020-02/", "logs/2020-02/01.log"})
+	for _, listed := range h.lists {
+		c.Assert(listed == "" || strings.HasPrefix(listed, "logs"), chk.Equals, true)
+	}
+
+	paths = walkAndCollect(c, shareURL.NewRootDirectoryURL(), WalkOptions{Prefix: "b"}, nil)
+	c.Assert(paths, chk.DeepEquals, []string{"b/", "b/x.txt", "b/y/", "b/y/z.txt"})
+}
+
+func (s *highLevelDirectorySuite) TestWalkStopsOnError(c *chk.C) {
+	shareURL, _ := newTestWalkShare()
+	stop := errors.New("stop")
+	visited := 0
+	err := shareURL.Walk(context.Background(), WalkOptions{}, func(entry WalkEntry, err error) error {
+		visited++
+		if entry.Path == "b/x.txt" {
+			return stop
+		}
+		return nil
+	})
+	c.Assert(err, chk.Equals, stop)
+	c.Assert(visited < 11, chk.Equals, true)
+}
+
+func (s *highLevelDirectorySuite) TestWalkListingError(c *chk.C) {
+	shareURL, _ := newTestWalkShare()
+	failed := []string{}
+	err := shareURL.NewDirectoryURL("missing").Walk(context.Background(), WalkOptions{}, func(entry WalkEntry, err error) error {
+		c.Assert(err, chk.NotNil)
+		c.Assert(err.(StorageError).ServiceCode(), chk.Equals, ServiceCodeResourceNotFound)
+		failed = append(failed, entry.Path)
+		return nil
+	})
+	c.Assert(err, chk.IsNil)
+	c.Assert(failed, chk.DeepEquals, []string{"missing"})
+}
+
+func (s *highLevelDirectorySuite) TestWalkUsesFixedWorkers(c *chk.C) {
+	// Walking a wide directory mustn't start a goroutine per subdirectory.
+	h := &testListingHandler{dirs: map[string][]string{"": {}}}
+	for i := 0; i < 100; i++ {
+		name := fmt.Sprintf("dir%03d", i)
+		h.dirs[""] = append(h.dirs[""], name+"/")
+		h.dirs[name] = []string{"file.txt"}
+	}
+	maxGoroutines := 0
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.mutex.Lock()
+		if n := runtime.NumGoroutine(); n > maxGoroutines {
+			maxGoroutines = n
+		}
+		h.mutex.Unlock()
+		h.ServeHTTP(w, r)
+	})
+	u, _ := url.Parse("https://account.file.core.windows.net/share")
+	shareURL := NewShareURL(*u, newTestHandlerPipeline(handler))
+
+	before := runtime.NumGoroutine()
+	paths := walkAndCollect(c, shareURL.NewRootDirectoryURL(), WalkOptions{Parallelism: 2}, nil)
+	c.Assert(paths, chk.HasLen, 200)
+	c.Assert(maxGoroutines-before <= 10, chk.Equals, true, chk.Commentf("%d goroutines were started", maxGoroutines-before))
+}
@@ -0,0 +1,102 @@
+//go:build go1.16
+// +build go1.16
+
+package azfile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing/fstest"
+
+	chk "gopkg.in/check.v1"
+)
+
+type highLevelFSSuite struct{}
+
+var _ = chk.Suite(&highLevelFSSuite{})
+
+func newTestFSShare() (ShareURL, *mockFileService) {
+	s := newMockFileService()
+	s.addFile("a.txt", []byte("hello, world"))
+	s.addFile("empty.txt", nil)
+	s.addFile("dir/b.txt", []byte(strings.Repeat("0123456789", 100)))
+	s.addFile("dir/sub/c.txt", []byte("c"))
+	s.addDir("emptydir")
+	u, _ := url.Parse("https://account.file.core.windows.net/share")
+	return NewShareURL(*u, newTestHandlerPipeline(s)), s
+}
+
+func (s *highLevelFSSuite) TestShareFSPassesFSTest(c *chk.C) {
+	shareURL, _ := newTestFSShare()
+	fsys := NewShareFS(context.Background(), shareURL, ShareFSOptions{})
+	err := fstest.TestFS(fsys, "a.txt", "empty.txt", "dir/b.txt", "dir/sub/c.txt", "emptydir")
+	c.Assert(err, chk.IsNil)
+}
+
+func (s *highLevelFSSuite) TestDirectoryFSPassesFSTest(c *chk.C) {
+	shareURL, _ := newTestFSShare()
+	fsys := NewDirectoryFS(context.Background(), shareURL.NewDirectoryURL("dir"), ShareFSOptions{})
+	c.Assert(fstest.TestFS(fsys, "b.txt", "sub/c.txt"), chk.IsNil)
+}
+
+func (s *highLevelFSSuite) TestShareFSReadsRanges(c *chk.C) {
+	shareURL, mock := newTestFSShare()
+	fsys := NewShareFS(context.Background(), shareURL, ShareFSOptions{})
+	f, err := fsys.Open("dir/b.txt")
+	c.Assert(err, chk.IsNil)
+	defer f.Close()
+
+	b := make([]byte, 5)
+	n, err := f.(io.ReaderAt).ReadAt(b, 997)
+	c.Assert(n, chk.Equals, 3)
+	c.Assert(err, chk.Equals, io.EOF)
+	c.Assert(string(b[:n]), chk.Equals, "789")
+
+	_, err = f.(io.Seeker).Seek(-4, io.SeekEnd)
+	c.Assert(err, chk.IsNil)
+	rest, err := io.ReadAll(f)
+	c.Assert(err, chk.IsNil)
+	c.Assert(string(rest), chk.Equals, "6789")
+	c.Assert(mock.requestCount("GET /share/dir/b.txt"), chk.Equals, 2)
+}
+
+func (s *highLevelFSSuite) TestShareFSErrors(c *chk.C) {
+	shareURL, _ := newTestFSShare()
+	fsys := NewShareFS(context.Background(), shareURL, ShareFSOptions{})
+
+	_, err := fsys.Open("missing.txt")
+	c.Assert(errors.Is(err, fs.ErrNotExist), chk.Equals, true)
+	_, err = fsys.Stat("missing/c.txt")
+	c.Assert(errors.Is(err, fs.ErrNotExist), chk.Equals, true)
+	_, err = fsys.ReadDir("missing")
+	c.Assert(errors.Is(err, fs.ErrNotExist), chk.Equals, true)
+	_, err = fsys.ReadFile("dir")
+	c.Assert(errors.Is(err, fs.ErrNotExist), chk.Equals, true)
+	_, err = fsys.Open("/a.txt")
+	c.Assert(errors.Is(err, fs.ErrInvalid), chk.Equals, true)
+
+	// Storage errors are mapped even when they're wrapped.
+	forbidden := testStorageError(http.StatusForbidden, ServiceCodeAuthenticationFailed)
+	c.Assert(toFSError(forbidden), chk.Equals, fs.ErrPermission)
+	c.Assert(toFSError(fmt.Errorf("reading: %w", forbidden)), chk.Equals, fs.ErrPermission)
+}
+
+func (s *highLevelFSSuite) TestShareFSUsesSnapshot(c *chk.C) {
+	shareURL, mock := newTestFSShare()
+	snapshot := "2020-01-01T00:00:00.0000000Z"
+	fsys := NewShareFS(context.Background(), shareURL.WithSnapshot(snapshot), ShareFSOptions{})
+	b, err := fs.ReadFile(fsys, "a.txt")
+	c.Assert(err, chk.IsNil)
+	c.Assert(string(b), chk.Equals, "hello, world")
+
+	c.Assert(len(mock.requests) > 0, chk.Equals, true)
+	for _, r := range mock.requests {
+		c.Assert(strings.Contains(r, "sharesnapshot="+url.QueryEscape(snapshot)), chk.Equals, true)
+	}
+}
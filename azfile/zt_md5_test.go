@@ -0,0 +1,174 @@
+package azfile
+
+import (
+	"context"
+	"crypto/md5"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	chk "gopkg.in/check.v1"
+)
+
+type md5Suite struct{}
+
+var _ = chk.Suite(&md5Suite{})
+
+// corruptingResponseWriter flips the bits of the first byte of each body write, as a faulty network path might.
+type corruptingResponseWriter struct {
+	http.ResponseWriter
+}
+
+func (w corruptingResponseWriter) Write(b []byte) (int, error) {
+	corrupted := append([]byte{}, b...)
+	if len(corrupted) > 0 {
+		corrupted[0] ^= 0xff
+	}
+	return w.ResponseWriter.Write(corrupted)
+}
+
+// corruptRange makes the next `times` downloads of the range starting at offset of the file at filePath return corrupted data.
+func corruptRange(mock *mockFileService, filePath string, offset string, times int) {
+	mock.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Method == http.MethodGet && strings.HasPrefix(r.Header.Get("x-ms-range"), "bytes="+offset+"-") && times > 0 {
+			times--
+			mock.mutex.Lock()
+			defer mock.mutex.Unlock()
+			mock.requests = append(mock.requests, r.Method+" "+r.URL.Path+"?"+r.URL.RawQuery)
+			mock.serveFile(corruptingResponseWriter{w}, r, filePath, "")
+			return true
+		}
+		return false
+	}
+}
+
+func (s *md5Suite) TestUploadBufferSendsMD5s(c *chk.C) {
+	fileURL, mock := newTestMockFileURL("file")
+	_, data := getRandomDataAndReader(10 * 1024)
+	rangesWithMD5 := int32(0)
+	mock.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if r.URL.Query().Get("comp") == "range" && r.Header.Get("Content-MD5") != "" {
+			atomic.AddInt32(&rangesWithMD5, 1) // Ranges are uploaded in parallel
+		}
+		return false
+	}
+
+	err := UploadBufferToAzureFile(context.Background(), data, fileURL, UploadToAzureFileOptions{RangeSize: 1024, TransactionalMD5: true, ComputeContentMD5: true,
+		FileHTTPHeaders: FileHTTPHeaders{ContentType: "application/octet-stream", ContentMD5: []byte("replaced")}})
+	c.Assert(err, chk.IsNil)
+	c.Assert(atomic.LoadInt32(&rangesWithMD5), chk.Equals, int32(10))
+	sum := md5.Sum(data)
+	f := mock.file("file")
+	c.Assert(f.data, chk.DeepEquals, data)
+	c.Assert(f.contentMD5, chk.DeepEquals, sum[:])
+	c.Assert(f.headers["Content-Type"], chk.Equals, "application/octet-stream")
+}
+
+func (s *md5Suite) TestFileWriterSendsMD5s(c *chk.C) {
+	fileURL, mock := newTestMockFileURL("file")
+	_, data := getRandomDataAndReader(5*1024 + 7)
+	rangesWithMD5 := int32(0)
+	mock.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if r.URL.Query().Get("comp") == "range" && r.Header.Get("Content-MD5") != "" {
+			atomic.AddInt32(&rangesWithMD5, 1) // Ranges are uploaded in parallel
+		}
+		return false
+	}
+
+	w, err := NewFileWriter(context.Background(), fileURL, UploadToAzureFileOptions{RangeSize: 1024, TransactionalMD5: true, ComputeContentMD5: true})
+	c.Assert(err, chk.IsNil)
+	for i := 0; i < len(data); i += 100 {
+		end := i + 100
+		if end > len(data) {
+			end = len(data)
+		}
+		_, err = w.Write(data[i:end])
+		c.Assert(err, chk.IsNil)
+	}
+	c.Assert(w.Close(), chk.IsNil)
+	c.Assert(atomic.LoadInt32(&rangesWithMD5), chk.Equals, int32(6))
+	sum := md5.Sum(data)
+	c.Assert(mock.file("file").contentMD5, chk.DeepEquals, sum[:])
+}
+
+func (s *md5Suite) TestDownloadRefetchesCorruptRange(c *chk.C) {
+	fileURL, mock := newTestMockFileURL("file")
+	_, data := getRandomDataAndReader(4 * 1024)
+	mock.addFile("file", data)
+	corruptRange(mock, "file", "1024", 2)
+	progress := int64(0)
+
+	b := make([]byte, len(data))
+	_, err := DownloadAzureFileToBuffer(context.Background(), fileURL, b, DownloadFromAzureFileOptions{RangeSize: 1024, Parallelism: 1, TransactionalMD5: true,
+		Progress: func(bytesTransferred int64) { progress = bytesTransferred }})
+	c.Assert(err, chk.IsNil)
+	c.Assert(b, chk.DeepEquals, data)
+	c.Assert(mock.requestCount("GET /share/file?"), chk.Equals, 6) // 4 ranges, one of them fetched twice more
+	c.Assert(progress, chk.Equals, int64(len(data)))
+}
+
+func (s *md5Suite) TestDownloadNegativePersistentCorruption(c *chk.C) {
+	fileURL, mock := newTestMockFileURL("file")
+	_, data := getRandomDataAndReader(4 * 1024)
+	mock.addFile("file", data)
+	corruptRange(mock, "file", "2048", md5MismatchRetryCount+1)
+
+	_, err := DownloadAzureFileToBuffer(context.Background(), fileURL, make([]byte, len(data)), DownloadFromAzureFileOptions{RangeSize: 1024, TransactionalMD5: true})
+	c.Assert(err, chk.ErrorMatches, "MD5 mismatch.*")
+}
+
+func (s *md5Suite) TestDownloadNegativeRangeTooLargeForMD5(c *chk.C) {
+	fileURL, mock := newTestMockFileURL("file")
+	mock.addFile("file", []byte("data"))
+	_, err := DownloadAzureFileToBuffer(context.Background(), fileURL, make([]byte, 4), DownloadFromAzureFileOptions{RangeSize: FileMaxUploadRangeBytes + 1, TransactionalMD5: true})
+	c.Assert(err, chk.ErrorMatches, "invalid argument.*")
+}
+
+func (s *md5Suite) TestDownloadValidatesContentMD5(c *chk.C) {
+	fileURL, mock := newTestMockFileURL("file")
+	_, data := getRandomDataAndReader(3 * 1024)
+	mock.addFile("file", data)
+	o := DownloadFromAzureFileOptions{RangeSize: 1024, ValidateContentMD5: true}
+
+	// A file without a Content-MD5 isn't checked.
+	b := make([]byte, len(data))
+	_, err := DownloadAzureFileToBuffer(context.Background(), fileURL, b, o)
+	c.Assert(err, chk.IsNil)
+
+	sum := md5.Sum(data)
+	_, err = fileURL.SetHTTPHeaders(context.Background(), FileHTTPHeaders{ContentMD5: sum[:]})
+	c.Assert(err, chk.IsNil)
+	_, err = DownloadAzureFileToBuffer(context.Background(), fileURL, b, o)
+	c.Assert(err, chk.IsNil)
+	c.Assert(b, chk.DeepEquals, data)
+
+	_, err = fileURL.SetHTTPHeaders(context.Background(), FileHTTPHeaders{ContentMD5: []byte("0123456789abcdef")})
+	c.Assert(err, chk.IsNil)
+	_, err = DownloadAzureFileToBuffer(context.Background(), fileURL, b, o)
+	c.Assert(err, chk.ErrorMatches, "MD5 mismatch.*")
+}
+
+func (s *md5Suite) TestResumedDownloadStartsAfreshAfterContentMD5Mismatch(c *chk.C) {
+	fileURL, mock := newTestMockFileURL("file")
+	_, data := getRandomDataAndReader(4 * 1024)
+	mock.addFile("file", data)
+	_, err := fileURL.SetHTTPHeaders(context.Background(), FileHTTPHeaders{ContentMD5: []byte("0123456789abcdef")})
+	c.Assert(err, chk.IsNil)
+	dir := c.MkDir()
+	o := DownloadFromAzureFileOptions{RangeSize: 1024, ValidateContentMD5: true, CheckpointPath: filepath.Join(dir, "download.journal")}
+
+	file, err := os.Create(filepath.Join(dir, "local"))
+	c.Assert(err, chk.IsNil)
+	defer file.Close()
+	_, err = DownloadAzureFileToFile(context.Background(), fileURL, file, o)
+	c.Assert(err, chk.ErrorMatches, "MD5 mismatch.*")
+	downloaded := mock.requestCount("GET /share/file?")
+
+	// Without the check, the next attempt downloads all of the ranges again rather than trusting the journal.
+	o.ValidateContentMD5 = false
+	_, err = DownloadAzureFileToFile(context.Background(), fileURL, file, o)
+	c.Assert(err, chk.IsNil)
+	c.Assert(mock.requestCount("GET /share/file?")-downloaded, chk.Equals, 4)
+}
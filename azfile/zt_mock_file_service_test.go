@@ -0,0 +1,714 @@
+package azfile
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+)
+
+// newTestHandlerPipeline creates a pipeline whose requests are served in-process by handler rather than going to the wire,
+// after passing through the policies that factories create.
+func newTestHandlerPipeline(handler http.Handler, factories ...pipeline.Factory) pipeline.Pipeline {
+	return pipeline.NewPipeline(append(factories, pipeline.MethodFactoryMarker()), pipeline.Options{HTTPSender: newTestHandlerSender(handler)})
+}
+
+// newTestHandlerSender creates a pipeline sender whose requests are served in-process by handler, for
+// PipelineOptions.HTTPSender.
+func newTestHandlerSender(handler http.Handler) pipeline.Factory {
+	return pipeline.FactoryFunc(func(next pipeline.Policy, po *pipeline.PolicyOptions) pipeline.PolicyFunc {
+		return func(ctx context.Context, request pipeline.Request) (pipeline.Response, error) {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request.Request)
+			response := recorder.Result()
+			response.Request = request.Request
+			return pipeline.NewHTTPResponse(response), nil
+		}
+	})
+}
+
+// mockFileService is a small in-memory implementation of the parts of the File REST API used by the
+// high-level helpers. It serves a single share (whatever the share's name is) and is meant to be used with
+// newTestHandlerPipeline, so that the helpers can be tested without a storage account.
+type mockFileService struct {
+	mutex sync.Mutex
+	nodes map[string]*mockNode // Keyed by share-relative path; "" is the root directory
+	etag  int
+
+	// permissions holds the permissions created with Create Permission, keyed by share name and then by permission key.
+	// Unlike the nodes, they're kept per share, so that a key is only valid in the share it was created in.
+	permissions map[string]map[string]string
+
+	// intercept, if set, is called before each request is served; returning true means it has written the response.
+	intercept func(w http.ResponseWriter, r *http.Request) bool
+	requests  []string // "METHOD path?query" of every request served
+}
+
+type mockNode struct {
+	isDir         bool
+	data          []byte
+	written       []Range // Sorted, non-overlapping, inclusive ranges holding data
+	metadata      map[string]string
+	headers       map[string]string // Content-Type and friends, keyed by canonical header name
+	contentMD5    []byte
+	attributes    string
+	creationTime  string
+	writeTime     string
+	permissionKey string
+	lastModified  time.Time
+	etag          string
+
+	// The state of the last copy to the file, which tests change with setCopyState.
+	copyID, copyStatus, copyProgress, copyStatusDescription string
+}
+
+func newMockFileService() *mockFileService {
+	s := &mockFileService{nodes: map[string]*mockNode{}, permissions: map[string]map[string]string{}}
+	s.nodes[""] = s.newNode(true)
+	return s
+}
+
+func (s *mockFileService) newNode(isDir bool) *mockNode {
+	now := time.Now().UTC()
+	n := &mockNode{isDir: isDir, metadata: map[string]string{}, headers: map[string]string{},
+		attributes: "Archive", creationTime: now.Format(ISO8601), writeTime: now.Format(ISO8601)}
+	if isDir {
+		n.attributes = "Directory"
+	}
+	s.touch(n)
+	return n
+}
+
+func (s *mockFileService) touch(n *mockNode) {
+	s.etag++
+	n.etag = fmt.Sprintf("\"0x%X\"", s.etag)
+	n.lastModified = time.Now().UTC()
+}
+
+// addFile creates a file (and any missing parent directories) with the specified contents.
+func (s *mockFileService) addFile(filePath string, data []byte) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.addDirLocked(path.Dir(filePath))
+	n := s.newNode(false)
+	n.data = append([]byte{}, data...)
+	if len(data) > 0 {
+		n.written = []Range{{Start: 0, End: int64(len(data)) - 1}}
+	}
+	s.nodes[filePath] = n
+}
+
+// setCopyState sets the state of the last copy to a file.
+func (s *mockFileService) setCopyState(filePath string, status CopyStatusType, progress string, description string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	n := s.nodes[filePath]
+	n.copyStatus, n.copyProgress, n.copyStatusDescription = string(status), progress, description
+}
+
+// copySource returns the node of a file of the mock service that source, a URL, refers to.
+func (s *mockFileService) copySource(source string) (*mockNode, bool) {
+	sourceURL, _ := url.Parse(source)
+	sourcePath := strings.TrimPrefix(sourceURL.Path, "/")
+	sourcePath = strings.Trim(sourcePath[strings.Index(sourcePath+"/", "/")+1:], "/") // Strip the share
+	n, ok := s.nodes[sourcePath]
+	return n, ok
+}
+
+// setWrittenRanges replaces the ranges that GetRangeList reports as holding data for a file.
+func (s *mockFileService) setWrittenRanges(filePath string, ranges []Range) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.nodes[filePath].written = append([]Range{}, ranges...)
+}
+
+// addDir creates a directory along with any missing parents.
+func (s *mockFileService) addDir(dirPath string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.addDirLocked(dirPath)
+}
+
+func (s *mockFileService) addDirLocked(dirPath string) {
+	if dirPath == "." || dirPath == "" {
+		return
+	}
+	s.addDirLocked(path.Dir(dirPath))
+	if _, ok := s.nodes[dirPath]; !ok {
+		s.nodes[dirPath] = s.newNode(true)
+	}
+}
+
+// file returns a copy of a file's contents, or nil if it doesn't exist.
+func (s *mockFileService) file(filePath string) *mockNode {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	n, ok := s.nodes[filePath]
+	if !ok || n.isDir {
+		return nil
+	}
+	c := *n
+	c.data = append([]byte{}, n.data...)
+	c.written = append([]Range{}, n.written...)
+	return &c
+}
+
+func (s *mockFileService) requestCount(prefix string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	count := 0
+	for _, r := range s.requests {
+		if strings.HasPrefix(r, prefix) {
+			count++
+		}
+	}
+	return count
+}
+
+func (s *mockFileService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.intercept != nil && s.intercept(w, r) {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path+"?"+r.URL.RawQuery)
+
+	// The first path segment is the share; the rest is the share-relative path.
+	p := strings.TrimPrefix(r.URL.Path, "/")
+	share := p
+	if i := strings.Index(p, "/"); i >= 0 {
+		share, p = p[:i], strings.Trim(p[i+1:], "/")
+	} else {
+		p = ""
+	}
+	q := r.URL.Query()
+	if q.Get("restype") == "share" && q.Get("comp") == "filepermission" {
+		s.servePermission(w, r, share)
+	} else if q.Get("restype") == "directory" {
+		s.serveDirectory(w, r, p, q.Get("comp"))
+	} else {
+		s.serveFile(w, r, p, q.Get("comp"))
+	}
+}
+
+func mockError(w http.ResponseWriter, status int, code ServiceCodeType) {
+	w.Header().Set("x-ms-error-code", string(code))
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<?xml version=\"1.0\" encoding=\"utf-8\"?><Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
+
+func (s *mockFileService) lookup(w http.ResponseWriter, p string, isDir bool) *mockNode {
+	n, ok := s.nodes[p]
+	if !ok || n.isDir != isDir {
+		if _, parentOK := s.nodes[path.Dir(p)]; !parentOK && path.Dir(p) != "." {
+			mockError(w, http.StatusNotFound, ServiceCodeParentNotFound)
+		} else {
+			mockError(w, http.StatusNotFound, ServiceCodeResourceNotFound)
+		}
+		return nil
+	}
+	return n
+}
+
+func (s *mockFileService) writeProperties(w http.ResponseWriter, n *mockNode) {
+	h := w.Header()
+	h.Set("ETag", n.etag)
+	h.Set("Last-Modified", n.lastModified.Format(http.TimeFormat))
+	h.Set("x-ms-request-id", "00000000-0000-0000-0000-000000000000")
+	h.Set("x-ms-file-attributes", n.attributes)
+	h.Set("x-ms-file-creation-time", n.creationTime)
+	h.Set("x-ms-file-last-write-time", n.writeTime)
+	if n.permissionKey != "" {
+		h.Set("x-ms-file-permission-key", n.permissionKey)
+	}
+	if n.copyID != "" {
+		h.Set("x-ms-copy-id", n.copyID)
+		h.Set("x-ms-copy-status", n.copyStatus)
+		h.Set("x-ms-copy-progress", n.copyProgress)
+		h.Set("x-ms-copy-status-description", n.copyStatusDescription)
+	}
+	for k, v := range n.metadata {
+		h.Set("x-ms-meta-"+k, v)
+	}
+	if !n.isDir {
+		h.Set("x-ms-type", "File")
+		for k, v := range n.headers {
+			h.Set(k, v)
+		}
+		if n.contentMD5 != nil {
+			h.Set("Content-MD5", base64.StdEncoding.EncodeToString(n.contentMD5))
+		}
+	}
+}
+
+func (s *mockFileService) setSMBProperties(r *http.Request, n *mockNode) {
+	for header, field := range map[string]*string{"x-ms-file-attributes": &n.attributes,
+		"x-ms-file-creation-time": &n.creationTime, "x-ms-file-last-write-time": &n.writeTime} {
+		switch v := r.Header.Get(header); v {
+		case "", "preserve":
+		case "now":
+			*field = time.Now().UTC().Format(ISO8601)
+		default:
+			*field = v
+		}
+	}
+	if key := r.Header.Get("x-ms-file-permission-key"); key != "" {
+		n.permissionKey = key
+	}
+}
+
+// servePermission serves Create Permission and Get Permission requests for share.
+func (s *mockFileService) servePermission(w http.ResponseWriter, r *http.Request, share string) {
+	permission := struct {
+		Permission string `json:"permission"`
+	}{}
+	switch r.Method {
+	case http.MethodPut:
+		if json.NewDecoder(r.Body).Decode(&permission) != nil || permission.Permission == "" {
+			mockError(w, http.StatusBadRequest, ServiceCodeInvalidInput)
+			return
+		}
+		if s.permissions[share] == nil {
+			s.permissions[share] = map[string]string{}
+		}
+		key := fmt.Sprintf("%s-%d", share, len(s.permissions[share]))
+		s.permissions[share][key] = permission.Permission
+		w.Header().Set("x-ms-file-permission-key", key)
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodGet:
+		var ok bool
+		if permission.Permission, ok = s.permissions[share][r.Header.Get("x-ms-file-permission-key")]; !ok {
+			mockError(w, http.StatusNotFound, ServiceCodeResourceNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(permission)
+	default:
+		mockError(w, http.StatusMethodNotAllowed, ServiceCodeUnsupportedHTTPVerb)
+	}
+}
+
+func readMetadata(r *http.Request, n *mockNode) {
+	n.metadata = map[string]string{}
+	for k := range r.Header {
+		if lk := strings.ToLower(k); strings.HasPrefix(lk, "x-ms-meta-") {
+			n.metadata[strings.TrimPrefix(lk, "x-ms-meta-")] = r.Header.Get(k)
+		}
+	}
+}
+
+func (s *mockFileService) serveDirectory(w http.ResponseWriter, r *http.Request, p string, comp string) {
+	switch {
+	case comp == "list":
+		if s.lookup(w, p, true) != nil {
+			s.list(w, r, p)
+		}
+	case comp == "listhandles":
+		if s.lookup(w, p, true) != nil {
+			w.WriteHeader(http.StatusOK)
+			fmt.Fprint(w, "<EnumerationResults><Entries></Entries><NextMarker /></EnumerationResults>")
+		}
+	case comp == "forceclosehandles":
+		if s.lookup(w, p, true) != nil {
+			w.Header().Set("x-ms-number-of-handles-closed", "0")
+			w.WriteHeader(http.StatusOK)
+		}
+	case comp == "metadata":
+		if n := s.lookup(w, p, true); n != nil {
+			readMetadata(r, n)
+			s.touch(n)
+			s.writeProperties(w, n)
+			w.WriteHeader(http.StatusOK)
+		}
+	case comp == "properties":
+		if n := s.lookup(w, p, true); n != nil {
+			s.setSMBProperties(r, n)
+			s.touch(n)
+			s.writeProperties(w, n)
+			w.WriteHeader(http.StatusOK)
+		}
+	case r.Method == http.MethodPut:
+		if _, ok := s.nodes[p]; ok {
+			mockError(w, http.StatusConflict, ServiceCodeResourceAlreadyExists)
+			return
+		}
+		if parent, ok := s.nodes[path.Dir(p)]; (!ok || !parent.isDir) && path.Dir(p) != "." {
+			mockError(w, http.StatusNotFound, ServiceCodeParentNotFound)
+			return
+		}
+		n := s.newNode(true)
+		readMetadata(r, n)
+		s.setSMBProperties(r, n)
+		s.nodes[p] = n
+		s.writeProperties(w, n)
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodDelete:
+		if s.lookup(w, p, true) == nil {
+			return
+		}
+		for other := range s.nodes {
+			if other != p && strings.HasPrefix(other, p+"/") {
+				mockError(w, http.StatusConflict, ServiceCodeDirectoryNotEmpty)
+				return
+			}
+		}
+		delete(s.nodes, p)
+		w.WriteHeader(http.StatusAccepted)
+	default: // GET or HEAD properties
+		if n := s.lookup(w, p, true); n != nil {
+			s.writeProperties(w, n)
+			w.WriteHeader(http.StatusOK)
+		}
+	}
+}
+
+func (s *mockFileService) list(w http.ResponseWriter, r *http.Request, p string) {
+	q := r.URL.Query()
+	names := []string{}
+	for other := range s.nodes {
+		if other == "" || path.Dir(other) != p && !(p == "" && path.Dir(other) == ".") {
+			continue
+		}
+		if name := path.Base(other); strings.HasPrefix(name, q.Get("prefix")) && name >= q.Get("marker") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	maxResults := len(names)
+	if m, err := strconv.Atoi(q.Get("maxresults")); err == nil && m < maxResults {
+		maxResults = m
+	}
+	next := ""
+	if maxResults < len(names) {
+		next = names[maxResults]
+		names = names[:maxResults]
+	}
+	b := &bytes.Buffer{}
+	b.WriteString("<EnumerationResults><Entries>")
+	for _, name := range names {
+		n := s.nodes[path.Join(p, name)]
+		b.WriteString(map[bool]string{true: "<Directory><Name>", false: "<File><Name>"}[n.isDir])
+		xml.EscapeText(b, []byte(name))
+		if n.isDir {
+			b.WriteString("</Name></Directory>")
+		} else {
+			fmt.Fprintf(b, "</Name><Properties><Content-Length>%d</Content-Length></Properties></File>", len(n.data))
+		}
+	}
+	fmt.Fprintf(b, "</Entries><NextMarker>%s</NextMarker></EnumerationResults>", next)
+	w.WriteHeader(http.StatusOK)
+	w.Write(b.Bytes())
+}
+
+// parseMockRange parses a "bytes=start-end" header; end is -1 if absent.
+func parseMockRange(value string) (start, end int64, ok bool) {
+	if !strings.HasPrefix(value, "bytes=") {
+		return 0, 0, false
+	}
+	parts := strings.SplitN(strings.TrimPrefix(value, "bytes="), "-", 2)
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	start, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	end = -1
+	if parts[1] != "" {
+		if end, err = strconv.ParseInt(parts[1], 10, 64); err != nil {
+			return 0, 0, false
+		}
+	}
+	return start, end, true
+}
+
+// markWritten adds (or, if clear is true, removes) the inclusive range [start, end] to a node's written ranges.
+func (n *mockNode) markWritten(start, end int64, clear bool) {
+	result := []Range{}
+	for _, r := range n.written {
+		if r.End < start || r.Start > end {
+			result = append(result, r)
+			continue
+		}
+		if r.Start < start {
+			result = append(result, Range{Start: r.Start, End: start - 1})
+		}
+		if r.End > end {
+			result = append(result, Range{Start: end + 1, End: r.End})
+		}
+	}
+	if !clear {
+		result = append(result, Range{Start: start, End: end})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Start < result[j].Start })
+	merged := []Range{}
+	for _, r := range result {
+		if len(merged) > 0 && merged[len(merged)-1].End+1 >= r.Start {
+			if r.End > merged[len(merged)-1].End {
+				merged[len(merged)-1].End = r.End
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	n.written = merged
+}
+
+func (n *mockNode) resize(size int64) {
+	if size < int64(len(n.data)) {
+		n.data = n.data[:size]
+		n.markWritten(size, 1<<62, true)
+	} else {
+		n.data = append(n.data, make([]byte, size-int64(len(n.data)))...)
+	}
+}
+
+func (s *mockFileService) serveFile(w http.ResponseWriter, r *http.Request, p string, comp string) {
+	switch {
+	case comp == "range":
+		n := s.lookup(w, p, false)
+		if n == nil {
+			return
+		}
+		if ParseFileAttributeFlagsString(n.attributes).Has(FileAttributeReadonly) {
+			mockError(w, http.StatusConflict, ServiceCodeReadOnlyAttribute)
+			return
+		}
+		start, end, ok := parseMockRange(r.Header.Get("x-ms-range"))
+		if !ok || end < start || end >= int64(len(n.data)) {
+			mockError(w, http.StatusRequestedRangeNotSatisfiable, ServiceCodeInvalidRange)
+			return
+		}
+		if r.Header.Get("x-ms-write") == "clear" {
+			for i := start; i <= end; i++ {
+				n.data[i] = 0
+			}
+			n.markWritten(start, end, true)
+		} else {
+			var body []byte
+			if source := r.Header.Get("x-ms-copy-source"); source != "" {
+				sourceNode, ok := s.copySource(source)
+				sourceStart, sourceEnd, rangeOK := parseMockRange(r.Header.Get("x-ms-source-range"))
+				if !ok || sourceNode.isDir || !rangeOK || sourceEnd >= int64(len(sourceNode.data)) {
+					mockError(w, http.StatusBadRequest, ServiceCodeInvalidInput)
+					return
+				}
+				body = sourceNode.data[sourceStart : sourceEnd+1]
+			} else {
+				body, _ = ioutil.ReadAll(r.Body)
+			}
+			if int64(len(body)) != end-start+1 {
+				mockError(w, http.StatusBadRequest, ServiceCodeInvalidHeaderValue)
+				return
+			}
+			if md5Header := r.Header.Get("Content-MD5"); md5Header != "" {
+				sum := md5.Sum(body)
+				if md5Header != base64.StdEncoding.EncodeToString(sum[:]) {
+					mockError(w, http.StatusBadRequest, ServiceCodeMd5Mismatch)
+					return
+				}
+			}
+			copy(n.data[start:], body)
+			n.markWritten(start, end, false)
+		}
+		n.writeTime = time.Now().UTC().Format(ISO8601) // Like the service, writing a range updates the last write time
+		s.touch(n)
+		s.writeProperties(w, n)
+		w.WriteHeader(http.StatusCreated)
+	case comp == "rangelist":
+		n := s.lookup(w, p, false)
+		if n == nil {
+			return
+		}
+		start, end := int64(0), int64(len(n.data))-1
+		if rangeHeader := r.Header.Get("x-ms-range"); rangeHeader != "" {
+			start, end, _ = parseMockRange(rangeHeader)
+			if end < 0 || end >= int64(len(n.data)) {
+				end = int64(len(n.data)) - 1
+			}
+		}
+		b := &bytes.Buffer{}
+		b.WriteString("<Ranges>")
+		for _, rg := range n.written {
+			if rg.End < start || rg.Start > end {
+				continue
+			}
+			if rg.Start < start {
+				rg.Start = start
+			}
+			if rg.End > end {
+				rg.End = end
+			}
+			fmt.Fprintf(b, "<Range><Start>%d</Start><End>%d</End></Range>", rg.Start, rg.End)
+		}
+		b.WriteString("</Ranges>")
+		s.writeProperties(w, n)
+		w.Header().Set("x-ms-content-length", strconv.Itoa(len(n.data)))
+		w.WriteHeader(http.StatusOK)
+		w.Write(b.Bytes())
+	case comp == "properties":
+		n := s.lookup(w, p, false)
+		if n == nil {
+			return
+		}
+		if length := r.Header.Get("x-ms-content-length"); length != "" {
+			size, _ := strconv.ParseInt(length, 10, 64)
+			n.resize(size)
+		}
+		s.setHeaders(r, n)
+		s.setSMBProperties(r, n)
+		s.touch(n)
+		s.writeProperties(w, n)
+		w.WriteHeader(http.StatusOK)
+	case comp == "metadata":
+		if n := s.lookup(w, p, false); n != nil {
+			readMetadata(r, n)
+			s.touch(n)
+			s.writeProperties(w, n)
+			w.WriteHeader(http.StatusOK)
+		}
+	case comp == "listhandles":
+		if s.lookup(w, p, false) != nil {
+			w.WriteHeader(http.StatusOK)
+			fmt.Fprint(w, "<EnumerationResults><Entries></Entries><NextMarker /></EnumerationResults>")
+		}
+	case comp == "copy":
+		n := s.lookup(w, p, false)
+		if n == nil {
+			return
+		}
+		if n.copyID != r.URL.Query().Get("copyid") {
+			mockError(w, http.StatusConflict, ServiceCodeType("CopyIdMismatch"))
+			return
+		}
+		if n.copyStatus != string(CopyStatusPending) {
+			mockError(w, http.StatusConflict, ServiceCodeNoPendingCopyOperation)
+			return
+		}
+		n.data, n.written, n.copyStatus = []byte{}, nil, string(CopyStatusAborted)
+		s.touch(n)
+		w.WriteHeader(http.StatusNoContent)
+	case comp == "forceclosehandles":
+		if s.lookup(w, p, false) != nil {
+			w.Header().Set("x-ms-number-of-handles-closed", "0")
+			w.WriteHeader(http.StatusOK)
+		}
+	case r.Method == http.MethodPut:
+		if parent, ok := s.nodes[path.Dir(p)]; (!ok || !parent.isDir) && path.Dir(p) != "." {
+			mockError(w, http.StatusNotFound, ServiceCodeParentNotFound)
+			return
+		}
+		if existing, ok := s.nodes[p]; ok && existing.isDir {
+			mockError(w, http.StatusConflict, ServiceCodeResourceAlreadyExists)
+			return
+		}
+		n := s.newNode(false)
+		if source := r.Header.Get("x-ms-copy-source"); source != "" {
+			// Start Copy copies the data at once, but the copy stays pending until a test completes it with setCopyState.
+			sourceNode, ok := s.copySource(source)
+			if !ok || sourceNode.isDir {
+				mockError(w, http.StatusNotFound, ServiceCodeType("CannotVerifyCopySource"))
+				return
+			}
+			n.data = append([]byte{}, sourceNode.data...)
+			n.written = append([]Range{}, sourceNode.written...)
+			readMetadata(r, n)
+			s.etag++
+			n.copyID = fmt.Sprintf("00000000-0000-0000-0000-%012d", s.etag)
+			n.copyStatus, n.copyProgress = string(CopyStatusPending), fmt.Sprintf("0/%d", len(n.data))
+			s.nodes[p] = n
+			s.writeProperties(w, n)
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		size, _ := strconv.ParseInt(r.Header.Get("x-ms-content-length"), 10, 64)
+		n.data = make([]byte, size)
+		readMetadata(r, n)
+		s.setHeaders(r, n)
+		s.setSMBProperties(r, n)
+		s.nodes[p] = n
+		s.writeProperties(w, n)
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodDelete:
+		if s.lookup(w, p, false) != nil {
+			delete(s.nodes, p)
+			w.WriteHeader(http.StatusAccepted)
+		}
+	case r.Method == http.MethodHead:
+		if n := s.lookup(w, p, false); n != nil {
+			s.writeProperties(w, n)
+			w.Header().Set("Content-Length", strconv.Itoa(len(n.data)))
+			w.WriteHeader(http.StatusOK)
+		}
+	default: // GET downloads
+		n := s.lookup(w, p, false)
+		if n == nil {
+			return
+		}
+		start, end := int64(0), int64(len(n.data))-1
+		status := http.StatusOK
+		if rangeHeader := r.Header.Get("x-ms-range"); rangeHeader != "" {
+			var ok bool
+			start, end, ok = parseMockRange(rangeHeader)
+			if !ok || start >= int64(len(n.data)) {
+				mockError(w, http.StatusRequestedRangeNotSatisfiable, ServiceCodeInvalidRange)
+				return
+			}
+			if end < 0 || end >= int64(len(n.data)) {
+				end = int64(len(n.data)) - 1
+			}
+			status = http.StatusPartialContent
+		}
+		body := n.data[start : end+1]
+		s.writeProperties(w, n)
+		if status == http.StatusPartialContent {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(n.data)))
+			if n.contentMD5 != nil {
+				w.Header().Set("x-ms-content-md5", base64.StdEncoding.EncodeToString(n.contentMD5))
+			}
+			w.Header().Del("Content-MD5")
+			if r.Header.Get("x-ms-range-get-content-md5") == "true" {
+				sum := md5.Sum(body)
+				w.Header().Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+			}
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.WriteHeader(status)
+		w.Write(body)
+	}
+}
+
+// setHeaders sets the HTTP headers of Create File and Set File Properties requests. Like the service, it clears the
+// headers that aren't specified.
+func (s *mockFileService) setHeaders(r *http.Request, n *mockNode) {
+	for _, name := range []string{"Content-Type", "Content-Encoding", "Content-Language", "Cache-Control", "Content-Disposition"} {
+		if value := r.Header.Get("x-ms-" + name); value != "" {
+			n.headers[name] = value
+		} else {
+			delete(n.headers, name)
+		}
+	}
+	n.contentMD5, _ = base64.StdEncoding.DecodeString(r.Header.Get("x-ms-content-md5"))
+	if len(n.contentMD5) == 0 {
+		n.contentMD5 = nil
+	}
+}
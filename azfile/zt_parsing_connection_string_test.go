@@ -0,0 +1,91 @@
+package azfile_test
+
+import (
+	"github.com/Azure/azure-storage-file-go/azfile"
+	chk "gopkg.in/check.v1"
+)
+
+type ParsingConnectionStringSuite struct{}
+
+var _ = chk.Suite(&ParsingConnectionStringSuite{})
+
+const testConnectionStringKey = "dGVzdGtleQ=="
+
+func (s *ParsingConnectionStringSuite) TestParseConnectionStringWithAccountKey(c *chk.C) {
+	cs, err := azfile.ParseConnectionString("DefaultEndpointsProtocol=https;AccountName=myaccount;AccountKey=" + testConnectionStringKey + ";EndpointSuffix=core.chinacloudapi.cn")
+	c.Assert(err, chk.IsNil)
+	c.Assert(cs.AccountName, chk.Equals, "myaccount")
+	c.Assert(cs.FileEndpoint.String(), chk.Equals, "https://myaccount.file.core.chinacloudapi.cn")
+	c.Assert(cs.Credential, chk.NotNil)
+	c.Assert(cs.Credential.AccountName(), chk.Equals, "myaccount")
+	c.Assert(cs.SAS, chk.IsNil)
+
+	// The protocol and suffix have defaults, and the keys are case-insensitive.
+	cs, err = azfile.ParseConnectionString("accountname=myaccount; accountkey=" + testConnectionStringKey + ";")
+	c.Assert(err, chk.IsNil)
+	c.Assert(cs.FileEndpoint.String(), chk.Equals, "https://myaccount.file.core.windows.net")
+}
+
+func (s *ParsingConnectionStringSuite) TestParseConnectionStringWithSASAndFileEndpoint(c *chk.C) {
+	cs, err := azfile.ParseConnectionString("BlobEndpoint=https://myaccount.blob.core.windows.net/;FileEndpoint=https://myaccount.file.core.windows.net/;" +
+		"SharedAccessSignature=sv=2019-02-02&ss=f&srt=sco&sp=rl&se=2030-01-01T00:00:00Z&sig=c2lnbmF0dXJl")
+	c.Assert(err, chk.IsNil)
+	c.Assert(cs.AccountName, chk.Equals, "myaccount")
+	c.Assert(cs.FileEndpoint.String(), chk.Equals, "https://myaccount.file.core.windows.net/")
+	c.Assert(cs.Credential, chk.IsNil)
+	c.Assert(cs.SAS, chk.NotNil)
+	c.Assert(cs.SAS.Permissions(), chk.Equals, "rl")
+	c.Assert(cs.SAS.Signature(), chk.Equals, "c2lnbmF0dXJl")
+
+	u, err := azfile.NewServiceURLFromConnectionString("FileEndpoint=https://myaccount.file.core.windows.net/;SharedAccessSignature=?sv=2019-02-02&sp=rl&sig=c2lnbmF0dXJl",
+		azfile.PipelineOptions{})
+	c.Assert(err, chk.IsNil)
+	sharePath := u.NewShareURL("share").URL()
+	c.Assert(sharePath.Path, chk.Equals, "/share")
+	c.Assert(sharePath.Query().Get("sig"), chk.Equals, "c2lnbmF0dXJl")
+}
+
+func (s *ParsingConnectionStringSuite) TestParseConnectionStringForDevelopmentStorage(c *chk.C) {
+	cs, err := azfile.ParseConnectionString("UseDevelopmentStorage=true")
+	c.Assert(err, chk.IsNil)
+	c.Assert(cs.AccountName, chk.Equals, "devstoreaccount1")
+	c.Assert(cs.FileEndpoint.String(), chk.Equals, "http://127.0.0.1:10004/devstoreaccount1")
+	c.Assert(cs.Credential, chk.NotNil)
+
+	u, err := azfile.NewServiceURLFromConnectionString("UseDevelopmentStorage=true", azfile.PipelineOptions{})
+	c.Assert(err, chk.IsNil)
+	shareURL := u.NewShareURL("share").URL()
+	c.Assert(shareURL.String(), chk.Equals, "http://127.0.0.1:10004/devstoreaccount1/share")
+
+	// The placeholder endpoint follows DevelopmentStorageProxyUri, and gives way to FileEndpoint.
+	cs, err = azfile.ParseConnectionString("UseDevelopmentStorage=true;DevelopmentStorageProxyUri=https://devhost:8080")
+	c.Assert(err, chk.IsNil)
+	c.Assert(cs.FileEndpoint.String(), chk.Equals, "https://devhost:10004/devstoreaccount1")
+	cs, err = azfile.ParseConnectionString("UseDevelopmentStorage=true;FileEndpoint=http://10.1.2.3:7000/devstoreaccount1")
+	c.Assert(err, chk.IsNil)
+	c.Assert(cs.FileEndpoint.String(), chk.Equals, "http://10.1.2.3:7000/devstoreaccount1")
+	c.Assert(cs.Credential.AccountName(), chk.Equals, "devstoreaccount1")
+}
+
+func (s *ParsingConnectionStringSuite) TestParseConnectionStringWithIPEndpoint(c *chk.C) {
+	cs, err := azfile.ParseConnectionString("FileEndpoint=http://10.1.2.3:10004/myaccount;AccountKey=" + testConnectionStringKey)
+	c.Assert(err, chk.IsNil)
+	c.Assert(cs.AccountName, chk.Equals, "myaccount")
+	c.Assert(cs.Credential.AccountName(), chk.Equals, "myaccount")
+}
+
+func (s *ParsingConnectionStringSuite) TestParseConnectionStringNegative(c *chk.C) {
+	for _, invalid := range []string{
+		"",
+		"AccountName=myaccount",
+		"AccountName=myaccount;AccountKey",
+		"AccountName=myaccount;AccountKey=" + testConnectionStringKey + ";SharedAccessSignature=sig=c2lnbmF0dXJl",
+		"AccountKey=" + testConnectionStringKey,
+		"FileEndpoint=/relative;SharedAccessSignature=sig=c2lnbmF0dXJl",
+		"AccountName=myaccount;AccountKey=not base64!",
+		"UseDevelopmentStorage=true;DevelopmentStorageProxyUri=devhost",
+	} {
+		_, err := azfile.ParseConnectionString(invalid)
+		c.Assert(err, chk.NotNil, chk.Commentf("%q", invalid))
+	}
+}
@@ -0,0 +1,215 @@
+package azfile
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+	chk "gopkg.in/check.v1"
+)
+
+type policyCircuitBreakerSuite struct{}
+
+var _ = chk.Suite(&policyCircuitBreakerSuite{})
+
+// testCircuitService is a mock service whose requests fail with ServerBusy while failing is set, and which counts the
+// requests that reach it.
+type testCircuitService struct {
+	*mockFileService
+	failing  int32
+	requests int32
+}
+
+func newTestCircuitService() *testCircuitService {
+	s := &testCircuitService{mockFileService: newMockFileService()}
+	s.addFile("file", []byte("data"))
+	s.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		atomic.AddInt32(&s.requests, 1)
+		if atomic.LoadInt32(&s.failing) != 0 {
+			mockError(w, http.StatusServiceUnavailable, ServiceCodeServerBusy)
+			return true
+		}
+		return false
+	}
+	return s
+}
+
+func (s *testCircuitService) setFailing(failing bool) {
+	v := int32(0)
+	if failing {
+		v = 1
+	}
+	atomic.StoreInt32(&s.failing, v)
+}
+
+func (s *testCircuitService) fileURL(host string, factories ...pipeline.Factory) FileURL {
+	u, _ := url.Parse("https://" + host + "/share/file")
+	return NewFileURL(*u, newTestHandlerPipeline(s, factories...))
+}
+
+// testStateChanges records the state changes of a CircuitBreaker.
+type testStateChanges struct {
+	mutex   sync.Mutex
+	changes []string
+}
+
+func (c *testStateChanges) record(host string, from CircuitState, to CircuitState) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.changes = append(c.changes, fmt.Sprintf("%s: %s -> %s", host, from, to))
+}
+
+func (c *testStateChanges) get() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return append([]string{}, c.changes...)
+}
+
+func (s *policyCircuitBreakerSuite) TestCircuitBreakerOpensAndCloses(c *chk.C) {
+	changes := &testStateChanges{}
+	breaker := NewCircuitBreaker(CircuitBreakerOptions{MinRequests: 4, FailureRatio: 0.5, OpenDuration: 100 * time.Millisecond, OnStateChange: changes.record})
+	service := newTestCircuitService()
+	fileURL := service.fileURL("account.file.core.windows.net", breaker)
+	ctx := context.Background()
+
+	// Half of the 4 requests fail, which opens the circuit.
+	for i := 0; i < 4; i++ {
+		service.setFailing(i >= 2)
+		_, err := fileURL.GetProperties(ctx)
+		c.Assert(err != nil, chk.Equals, i >= 2)
+	}
+	c.Assert(breaker.State("account.file.core.windows.net"), chk.Equals, CircuitStateOpen)
+
+	// The requests fail fast while the circuit is open.
+	_, err := fileURL.GetProperties(ctx)
+	c.Assert(err, chk.FitsTypeOf, &CircuitOpenError{})
+	c.Assert(err.(*CircuitOpenError).Host, chk.Equals, "account.file.core.windows.net")
+	c.Assert(err.(*CircuitOpenError).RetryAt.After(time.Now()), chk.Equals, true)
+	c.Assert(atomic.LoadInt32(&service.requests), chk.Equals, int32(4))
+
+	// Once the host recovers, the probe closes the circuit.
+	service.setFailing(false)
+	time.Sleep(100 * time.Millisecond)
+	c.Assert(breaker.State("account.file.core.windows.net"), chk.Equals, CircuitStateHalfOpen)
+	_, err = fileURL.GetProperties(ctx)
+	c.Assert(err, chk.IsNil)
+	c.Assert(breaker.State("account.file.core.windows.net"), chk.Equals, CircuitStateClosed)
+	c.Assert(changes.get(), chk.DeepEquals, []string{
+		"account.file.core.windows.net: closed -> open",
+		"account.file.core.windows.net: open -> half-open",
+		"account.file.core.windows.net: half-open -> closed",
+	})
+}
+
+func (s *policyCircuitBreakerSuite) TestCircuitBreakerStateChangeCanUseBreaker(c *chk.C) {
+	// OnStateChange is invoked after the breaker is unlocked, so it can query the breaker and send requests through it.
+	var breaker *CircuitBreaker
+	var fileURL FileURL
+	states := []CircuitState{}
+	breaker = NewCircuitBreaker(CircuitBreakerOptions{MinRequests: 1, FailureRatio: 1, OpenDuration: time.Minute,
+		OnStateChange: func(host string, from CircuitState, to CircuitState) {
+			states = append(states, breaker.State(host))
+			_, err := fileURL.GetProperties(context.Background())
+			c.Check(err, chk.FitsTypeOf, &CircuitOpenError{})
+		}})
+	service := newTestCircuitService()
+	service.setFailing(true)
+	fileURL = service.fileURL("account.file.core.windows.net", breaker)
+
+	_, err := fileURL.GetProperties(context.Background())
+	c.Assert(err.(StorageError).ServiceCode(), chk.Equals, ServiceCodeServerBusy)
+	c.Assert(states, chk.DeepEquals, []CircuitState{CircuitStateOpen})
+}
+
+func (s *policyCircuitBreakerSuite) TestCircuitBreakerFailedProbeOpensAgain(c *chk.C) {
+	breaker := NewCircuitBreaker(CircuitBreakerOptions{MinRequests: 1, FailureRatio: 1, OpenDuration: 50 * time.Millisecond})
+	service := newTestCircuitService()
+	service.setFailing(true)
+	fileURL := service.fileURL("account.file.core.windows.net", breaker)
+
+	_, err := fileURL.GetProperties(context.Background())
+	c.Assert(err.(StorageError).ServiceCode(), chk.Equals, ServiceCodeServerBusy)
+	time.Sleep(50 * time.Millisecond)
+	_, err = fileURL.GetProperties(context.Background())
+	c.Assert(err.(StorageError).ServiceCode(), chk.Equals, ServiceCodeServerBusy)
+	c.Assert(breaker.State("account.file.core.windows.net"), chk.Equals, CircuitStateOpen)
+	_, err = fileURL.GetProperties(context.Background())
+	c.Assert(err, chk.FitsTypeOf, &CircuitOpenError{})
+}
+
+func (s *policyCircuitBreakerSuite) TestCircuitBreakerHalfOpenFailsFastWhileProbing(c *chk.C) {
+	breaker := NewCircuitBreaker(CircuitBreakerOptions{MinRequests: 1, FailureRatio: 1, OpenDuration: 10 * time.Millisecond})
+	service := newTestCircuitService()
+	service.setFailing(true)
+	fileURL := service.fileURL("account.file.core.windows.net", breaker)
+	_, err := fileURL.GetProperties(context.Background())
+	c.Assert(err, chk.NotNil)
+	time.Sleep(10 * time.Millisecond)
+
+	// Hold the probe until another request has failed fast.
+	probing, release := make(chan struct{}), make(chan struct{})
+	service.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		close(probing)
+		<-release
+		return false
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := fileURL.GetProperties(context.Background())
+		done <- err
+	}()
+	<-probing
+	_, err = fileURL.GetProperties(context.Background())
+	c.Assert(err, chk.FitsTypeOf, &CircuitOpenError{})
+	c.Assert(err.(*CircuitOpenError).RetryAt.IsZero(), chk.Equals, true)
+	close(release)
+	c.Assert(<-done, chk.IsNil)
+	c.Assert(breaker.State("account.file.core.windows.net"), chk.Equals, CircuitStateClosed)
+}
+
+func (s *policyCircuitBreakerSuite) TestCircuitBreakerStopsRetries(c *chk.C) {
+	breaker := NewCircuitBreaker(CircuitBreakerOptions{MinRequests: 1, FailureRatio: 1})
+	service := newTestCircuitService()
+	service.setFailing(true)
+	fileURL := service.fileURL("account.file.core.windows.net",
+		NewRetryPolicyFactory(RetryOptions{MaxTries: 4, RetryDelay: time.Millisecond, MaxRetryDelay: time.Millisecond}), breaker)
+
+	// The first try opens the circuit, and the retry policy doesn't retry the error of the second.
+	_, err := fileURL.GetProperties(context.Background())
+	c.Assert(err, chk.FitsTypeOf, &CircuitOpenError{})
+	c.Assert(atomic.LoadInt32(&service.requests), chk.Equals, int32(1))
+}
+
+func (s *policyCircuitBreakerSuite) TestCircuitBreakerKeyedByHost(c *chk.C) {
+	breaker := NewCircuitBreaker(CircuitBreakerOptions{MinRequests: 1, FailureRatio: 1})
+	service := newTestCircuitService()
+	service.setFailing(true)
+	_, err := service.fileURL("account.file.core.windows.net", breaker).GetProperties(context.Background())
+	c.Assert(err, chk.NotNil)
+
+	service.setFailing(false)
+	_, err = service.fileURL("account-secondary.file.core.windows.net", breaker).GetProperties(context.Background())
+	c.Assert(err, chk.IsNil)
+	c.Assert(breaker.State("account.file.core.windows.net"), chk.Equals, CircuitStateOpen)
+	c.Assert(breaker.State("account-secondary.file.core.windows.net"), chk.Equals, CircuitStateClosed)
+}
+
+func (s *policyCircuitBreakerSuite) TestCircuitBreakerIgnoresCancelledRequests(c *chk.C) {
+	breaker := NewCircuitBreaker(CircuitBreakerOptions{MinRequests: 1, FailureRatio: 1})
+	service := newTestCircuitService()
+	ctx, cancel := context.WithCancel(context.Background())
+	service.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		cancel()
+		mockError(w, http.StatusServiceUnavailable, ServiceCodeServerBusy)
+		return true
+	}
+
+	_, err := service.fileURL("account.file.core.windows.net", breaker).GetProperties(ctx)
+	c.Assert(err, chk.NotNil)
+	c.Assert(breaker.State("account.file.core.windows.net"), chk.Equals, CircuitStateClosed)
+}
@@ -0,0 +1,222 @@
+package azfile
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	chk "gopkg.in/check.v1"
+)
+
+type policyFaultInjectionSuite struct{}
+
+var _ = chk.Suite(&policyFaultInjectionSuite{})
+
+// testFaults records the faults that the fault injection policy injects.
+type testFaults struct {
+	mutex  sync.Mutex
+	faults []string
+}
+
+func (f *testFaults) record(fault Fault, request *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.faults = append(f.faults, fault.Type.String()+": "+request.Method)
+}
+
+func (f *testFaults) get() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return append([]string{}, f.faults...)
+}
+
+// newTestFaultFileURL returns the URL of the mock service's file "file", whose pipeline injects faults as o specifies
+// and makes up to maxTries tries of each request.
+func newTestFaultFileURL(service *mockFileService, maxTries int32, o FaultInjectionOptions) FileURL {
+	u, _ := url.Parse("https://account.file.core.windows.net/share/file")
+	return NewFileURL(*u, NewPipeline(NewAnonymousCredential(), PipelineOptions{
+		Retry:          RetryOptions{MaxTries: maxTries, RetryDelay: time.Millisecond, MaxRetryDelay: time.Millisecond},
+		FaultInjection: o,
+		HTTPSender:     newTestHandlerSender(service),
+	}))
+}
+
+func (s *policyFaultInjectionSuite) TestServiceErrorsInSequenceAreRetried(c *chk.C) {
+	service := newMockFileService()
+	service.addFile("file", []byte("data"))
+	faults := &testFaults{}
+	fileURL := newTestFaultFileURL(service, 3, FaultInjectionOptions{
+		Faults:  []Fault{{Type: FaultTypeServiceError, StatusCode: http.StatusServiceUnavailable, Sequence: []int32{1, 2}}},
+		OnFault: faults.record,
+	})
+
+	// The first two tries get the faults; the third one reaches the service.
+	_, err := fileURL.GetProperties(context.Background())
+	c.Assert(err, chk.IsNil)
+	c.Assert(faults.get(), chk.DeepEquals, []string{"service error: HEAD", "service error: HEAD"})
+	c.Assert(service.requestCount("HEAD"), chk.Equals, 1)
+
+	_, err = fileURL.GetProperties(context.Background())
+	c.Assert(err, chk.IsNil)
+	c.Assert(faults.get(), chk.HasLen, 2)
+}
+
+func (s *policyFaultInjectionSuite) TestServiceErrorByOperation(c *chk.C) {
+	service := newMockFileService()
+	service.addFile("file", []byte("data"))
+	fileURL := newTestFaultFileURL(service, 1, FaultInjectionOptions{
+		Faults: []Fault{{Type: FaultTypeServiceError, Operations: []string{"PUT range"}, StatusCode: http.StatusConflict,
+			ServiceCode: ServiceCodeFileLockConflict}},
+	})
+	ctx := context.Background()
+
+	_, err := fileURL.UploadRange(ctx, 0, bytes.NewReader([]byte("DATA")), nil)
+	c.Assert(err, chk.NotNil)
+	storageErr, ok := err.(StorageError)
+	c.Assert(ok, chk.Equals, true)
+	c.Assert(storageErr.ServiceCode(), chk.Equals, ServiceCodeFileLockConflict)
+	c.Assert(storageErr.Response().StatusCode, chk.Equals, http.StatusConflict)
+	c.Assert(service.requestCount("PUT"), chk.Equals, 0)
+
+	// The other operations aren't affected.
+	_, err = fileURL.GetProperties(ctx)
+	c.Assert(err, chk.IsNil)
+}
+
+func (s *policyFaultInjectionSuite) TestDefaultServiceCodes(c *chk.C) {
+	request, _ := http.NewRequest(http.MethodGet, "https://account.file.core.windows.net/share/file", nil)
+	response := newFaultResponse(&Fault{}, request)
+	c.Assert(response.StatusCode, chk.Equals, http.StatusInternalServerError)
+	c.Assert(response.Header.Get("x-ms-error-code"), chk.Equals, string(ServiceCodeInternalError))
+	response = newFaultResponse(&Fault{StatusCode: http.StatusServiceUnavailable}, request)
+	c.Assert(response.Header.Get("x-ms-error-code"), chk.Equals, string(ServiceCodeServerBusy))
+	body, _ := ioutil.ReadAll(response.Body)
+	c.Assert(string(body), chk.Matches, ".*<Code>ServerBusy</Code>.*")
+}
+
+func (s *policyFaultInjectionSuite) TestConnectionReset(c *chk.C) {
+	service := newMockFileService()
+	service.addFile("file", []byte("data"))
+	fault := Fault{Type: FaultTypeConnectionReset, Sequence: []int32{1}}
+
+	// A reset connection is retried.
+	_, err := newTestFaultFileURL(service, 2, FaultInjectionOptions{Faults: []Fault{fault}}).GetProperties(context.Background())
+	c.Assert(err, chk.IsNil)
+
+	_, err = newTestFaultFileURL(service, 1, FaultInjectionOptions{Faults: []Fault{fault}}).GetProperties(context.Background())
+	_, ok := err.(net.Error)
+	c.Assert(ok, chk.Equals, true)
+	c.Assert(strings.Contains(err.Error(), "connection reset"), chk.Equals, true)
+}
+
+func (s *policyFaultInjectionSuite) TestTimeout(c *chk.C) {
+	service := newMockFileService()
+	service.addFile("file", []byte("data"))
+	fileURL := newTestFaultFileURL(service, 1, FaultInjectionOptions{Faults: []Fault{{Type: FaultTypeTimeout, Delay: 10 * time.Millisecond}}})
+
+	start := time.Now()
+	_, err := fileURL.GetProperties(context.Background())
+	c.Assert(time.Since(start) >= 10*time.Millisecond, chk.Equals, true)
+	netErr, ok := err.(net.Error)
+	c.Assert(ok, chk.Equals, true)
+	c.Assert(netErr.Timeout(), chk.Equals, true)
+	c.Assert(service.requestCount("HEAD"), chk.Equals, 0)
+
+	// Without a delay, the request is held until its try times out.
+	fileURL = newTestFaultFileURL(service, 1, FaultInjectionOptions{Faults: []Fault{{Type: FaultTypeTimeout}}})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = fileURL.GetProperties(ctx)
+	c.Assert(err, chk.NotNil)
+}
+
+func (s *policyFaultInjectionSuite) TestTruncatedBodyIsRetriedByRetryReader(c *chk.C) {
+	data := []byte("0123456789")
+	service := newMockFileService()
+	service.addFile("file", data)
+	faults := &testFaults{}
+	fileURL := newTestFaultFileURL(service, 1, FaultInjectionOptions{
+		Faults:  []Fault{{Type: FaultTypeTruncatedBody, Operations: []string{"GET"}, TruncateAfter: 4, Sequence: []int32{1}}},
+		OnFault: faults.record,
+	})
+	ctx := context.Background()
+
+	response, err := fileURL.Download(ctx, 0, CountToEnd, false)
+	c.Assert(err, chk.IsNil)
+	body, err := ioutil.ReadAll(response.Body(RetryReaderOptions{MaxRetryRequests: 1}))
+	c.Assert(err, chk.IsNil)
+	c.Assert(body, chk.DeepEquals, data)
+	c.Assert(faults.get(), chk.DeepEquals, []string{"truncated body: GET"})
+	c.Assert(service.requestCount("GET"), chk.Equals, 2)
+
+	// Without retries, the read fails once the truncated body is read.
+	fileURL = newTestFaultFileURL(service, 1, FaultInjectionOptions{Faults: []Fault{{Type: FaultTypeTruncatedBody, TruncateAfter: 4}}})
+	response, err = fileURL.Download(ctx, 0, CountToEnd, false)
+	c.Assert(err, chk.IsNil)
+	body, err = ioutil.ReadAll(response.Body(RetryReaderOptions{}))
+	c.Assert(err, chk.NotNil)
+	c.Assert(body, chk.DeepEquals, data[:4])
+}
+
+func (s *policyFaultInjectionSuite) TestSlowBody(c *chk.C) {
+	service := newMockFileService()
+	service.addFile("file", []byte("data"))
+	fileURL := newTestFaultFileURL(service, 1, FaultInjectionOptions{Faults: []Fault{{Type: FaultTypeSlowBody, Delay: 10 * time.Millisecond}}})
+
+	start := time.Now()
+	response, err := fileURL.Download(context.Background(), 0, CountToEnd, false)
+	c.Assert(err, chk.IsNil)
+	body, err := ioutil.ReadAll(response.Body(RetryReaderOptions{}))
+	c.Assert(err, chk.IsNil)
+	c.Assert(string(body), chk.Equals, "data")
+	c.Assert(time.Since(start) >= 10*time.Millisecond, chk.Equals, true)
+
+	// A read that's delayed stops when its context is done.
+	ctx, cancel := context.WithCancel(context.Background())
+	fileURL = newTestFaultFileURL(service, 1, FaultInjectionOptions{Faults: []Fault{{Type: FaultTypeSlowBody, Delay: time.Hour}}})
+	response, err = fileURL.Download(ctx, 0, CountToEnd, false)
+	c.Assert(err, chk.IsNil)
+	cancel()
+	_, err = ioutil.ReadAll(response.Body(RetryReaderOptions{}))
+	c.Assert(err, chk.Equals, context.Canceled)
+}
+
+func (s *policyFaultInjectionSuite) TestProbabilityIsSeeded(c *chk.C) {
+	service := newMockFileService()
+	service.addFile("file", []byte("data"))
+	picks := func(seed int64) []bool {
+		picked := make([]bool, 100)
+		i := 0
+		fileURL := newTestFaultFileURL(service, 1, FaultInjectionOptions{
+			Faults:  []Fault{{Probability: 0.5}},
+			Seed:    seed,
+			OnFault: func(Fault, *http.Request) { picked[i] = true },
+		})
+		for ; i < len(picked); i++ {
+			fileURL.GetProperties(context.Background())
+		}
+		return picked
+	}
+
+	// The same seed picks the same requests.
+	first := picks(42)
+	c.Assert(picks(42), chk.DeepEquals, first)
+	count := 0
+	for _, picked := range first {
+		if picked {
+			count++
+		}
+	}
+	c.Assert(count > 20 && count < 80, chk.Equals, true)
+}
+
+func (s *policyFaultInjectionSuite) TestFaultTypeString(c *chk.C) {
+	c.Assert(FaultTypeTruncatedBody.String(), chk.Equals, "truncated body")
+	c.Assert(FaultType(9).String(), chk.Equals, "FaultType(9)")
+}
@@ -0,0 +1,52 @@
+//go:build go1.21
+// +build go1.21
+
+package azfile
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	chk "gopkg.in/check.v1"
+)
+
+func (s *policyRequestLogSuite) TestSlogRequestLogger(c *chk.C) {
+	service := newMockFileService()
+	service.addFile("file", []byte("data"))
+	service.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Method == http.MethodDelete {
+			mockError(w, http.StatusInternalServerError, ServiceCodeInternalError)
+			return true
+		}
+		return false
+	}
+	b := &bytes.Buffer{}
+	logger := slog.New(slog.NewJSONHandler(b, &slog.HandlerOptions{Level: slog.LevelWarn}))
+	fileURL := newTestRequestLogFileURL(service, RequestLogOptions{Logger: NewSlogRequestLogger(logger)})
+	ctx := context.Background()
+
+	// The successful try is at the info level, which the handler doesn't enable.
+	_, err := fileURL.GetProperties(ctx)
+	c.Assert(err, chk.IsNil)
+	_, err = fileURL.Delete(ctx)
+	c.Assert(err, chk.NotNil)
+
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	c.Assert(lines, chk.HasLen, 1)
+	entry := map[string]interface{}{}
+	c.Assert(json.Unmarshal([]byte(lines[0]), &entry), chk.IsNil)
+	c.Assert(entry["level"], chk.Equals, "ERROR")
+	c.Assert(entry["msg"], chk.Equals, "azfile request")
+	c.Assert(entry["try"], chk.Equals, float64(1))
+	c.Assert(entry["method"], chk.Equals, http.MethodDelete)
+	c.Assert(entry["status"], chk.Equals, float64(http.StatusInternalServerError))
+	c.Assert(entry["serviceCode"], chk.Equals, string(ServiceCodeInternalError))
+	c.Assert(entry["slow"], chk.Equals, false)
+	c.Assert(strings.Contains(entry["url"].(string), "sig=REDACTED"), chk.Equals, true)
+	_, hasError := entry["error"]
+	c.Assert(hasError, chk.Equals, false)
+}
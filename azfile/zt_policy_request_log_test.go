@@ -0,0 +1,165 @@
+package azfile
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+	chk "gopkg.in/check.v1"
+)
+
+type policyRequestLogSuite struct{}
+
+var _ = chk.Suite(&policyRequestLogSuite{})
+
+// testRequestLogger records the records of the request log policy.
+type testRequestLogger struct {
+	mutex   sync.Mutex
+	records []RequestLogRecord
+}
+
+func (l *testRequestLogger) LogRequest(ctx context.Context, record RequestLogRecord) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.records = append(l.records, record)
+}
+
+func (l *testRequestLogger) get() []RequestLogRecord {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return append([]RequestLogRecord{}, l.records...)
+}
+
+// newTestRequestLogFileURL returns the URL of the file "file" of service, whose pipeline logs its requests as o
+// specifies. The URL has a SAS signature, which the logs must redact.
+func newTestRequestLogFileURL(service *mockFileService, o RequestLogOptions) FileURL {
+	u, _ := url.Parse("https://account.file.core.windows.net/share/file?sv=2019-02-02&sig=secret")
+	return NewFileURL(*u, newTestHandlerPipeline(service, NewUniqueRequestIDPolicyFactory(), NewRequestLogPolicyFactory(o)))
+}
+
+func (s *policyRequestLogSuite) TestRecords(c *chk.C) {
+	service := newMockFileService()
+	service.addFile("file", []byte("data"))
+	logger := &testRequestLogger{}
+	fileURL := newTestRequestLogFileURL(service, RequestLogOptions{Logger: logger})
+	ctx := context.Background()
+
+	_, err := fileURL.UploadRange(ctx, 0, bytes.NewReader([]byte("DATA")), nil)
+	c.Assert(err, chk.IsNil)
+	response, err := fileURL.Download(ctx, 0, CountToEnd, false)
+	c.Assert(err, chk.IsNil)
+	response.Response().Body.Close()
+	service.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		mockError(w, http.StatusNotFound, ServiceCodeResourceNotFound)
+		return true
+	}
+	_, err = fileURL.GetProperties(ctx)
+	c.Assert(err, chk.NotNil)
+
+	records := logger.get()
+	c.Assert(records, chk.HasLen, 3)
+	upload := records[0]
+	c.Assert(upload.Level, chk.Equals, pipeline.LogInfo)
+	c.Assert(upload.Try, chk.Equals, int32(1))
+	c.Assert(upload.Method, chk.Equals, http.MethodPut)
+	c.Assert(strings.Contains(upload.URL, "secret"), chk.Equals, false)
+	c.Assert(strings.Contains(upload.URL, "sig=REDACTED"), chk.Equals, true)
+	c.Assert(upload.StatusCode, chk.Equals, http.StatusCreated)
+	c.Assert(upload.ClientRequestID, chk.Not(chk.Equals), "")
+	c.Assert(upload.BytesSent, chk.Equals, int64(4))
+	c.Assert(upload.Slow, chk.Equals, false)
+	c.Assert(upload.TryDuration > 0, chk.Equals, true)
+	c.Assert(records[1].BytesReceived, chk.Equals, int64(4))
+	c.Assert(records[1].BytesSent, chk.Equals, int64(0))
+
+	// The responder, which is closer to the wire, turns an error response into a StorageError.
+	c.Assert(records[2].Level, chk.Equals, pipeline.LogError)
+	c.Assert(records[2].StatusCode, chk.Equals, http.StatusNotFound)
+	c.Assert(records[2].ServiceCode, chk.Equals, ServiceCodeResourceNotFound)
+	c.Assert(IsNotFound(records[2].Err), chk.Equals, true)
+}
+
+func (s *policyRequestLogSuite) TestErrorsAndSlowTries(c *chk.C) {
+	service := newMockFileService()
+	service.addFile("file", []byte("data"))
+	delay := time.Duration(0)
+	service.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		time.Sleep(delay)
+		if r.Method == http.MethodDelete {
+			w.Header().Set("x-ms-request-id", "request-id")
+			mockError(w, http.StatusInternalServerError, ServiceCodeInternalError)
+			return true
+		}
+		return false
+	}
+	logger := &testRequestLogger{}
+	fileURL := newTestRequestLogFileURL(service, RequestLogOptions{Logger: logger, LogWarningIfTryOverThreshold: 10 * time.Millisecond})
+	ctx := context.Background()
+
+	_, err := fileURL.Delete(ctx)
+	c.Assert(err, chk.NotNil)
+	delay = 20 * time.Millisecond
+	_, err = fileURL.GetProperties(ctx)
+	c.Assert(err, chk.IsNil)
+
+	records := logger.get()
+	c.Assert(records, chk.HasLen, 2)
+	c.Assert(records[0].Level, chk.Equals, pipeline.LogError)
+	c.Assert(records[0].StatusCode, chk.Equals, http.StatusInternalServerError)
+	c.Assert(records[0].ServiceCode, chk.Equals, ServiceCodeInternalError)
+	c.Assert(records[0].RequestID, chk.Equals, "request-id")
+	c.Assert(records[1].Level, chk.Equals, pipeline.LogWarning)
+	c.Assert(records[1].Slow, chk.Equals, true)
+}
+
+func (s *policyRequestLogSuite) TestSampling(c *chk.C) {
+	service := newMockFileService()
+	service.addFile("file", []byte("data"))
+	service.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Method == http.MethodDelete {
+			mockError(w, http.StatusInternalServerError, ServiceCodeInternalError)
+			return true
+		}
+		return false
+	}
+	logger := &testRequestLogger{}
+	fileURL := newTestRequestLogFileURL(service, RequestLogOptions{Logger: logger, SampleEvery: map[pipeline.LogLevel]uint32{pipeline.LogInfo: 3}})
+	ctx := context.Background()
+
+	// 1 in 3 of the successful tries are logged, along with every failed one.
+	for i := 0; i < 7; i++ {
+		_, err := fileURL.GetProperties(ctx)
+		c.Assert(err, chk.IsNil)
+	}
+	for i := 0; i < 2; i++ {
+		_, err := fileURL.Delete(ctx)
+		c.Assert(err, chk.NotNil)
+	}
+	levels := []pipeline.LogLevel{}
+	for _, record := range logger.get() {
+		levels = append(levels, record.Level)
+	}
+	c.Assert(levels, chk.DeepEquals, []pipeline.LogLevel{pipeline.LogInfo, pipeline.LogInfo, pipeline.LogInfo, pipeline.LogError, pipeline.LogError})
+}
+
+func (s *policyRequestLogSuite) TestRedactSigQueryParam(c *chk.C) {
+	for _, rawQuery := range []string{"sig=secret&sv=2019-02-02", "sv=2019-02-02&sig=secret"} {
+		sigFound, redacted := RedactSigQueryParam(rawQuery)
+		c.Assert(sigFound, chk.Equals, true)
+		c.Assert(redacted, chk.Equals, "sig=REDACTED&sv=2019-02-02")
+	}
+	sigFound, _ := RedactSigQueryParam("comp=range&signature=x")
+	c.Assert(sigFound, chk.Equals, false)
+}
+
+func (s *policyRequestLogSuite) TestRequestLoggerFunc(c *chk.C) {
+	var got RequestLogRecord
+	var logger RequestLogger = RequestLoggerFunc(func(ctx context.Context, record RequestLogRecord) { got = record })
+	logger.LogRequest(context.Background(), RequestLogRecord{Try: 2})
+	c.Assert(got.Try, chk.Equals, int32(2))
+}
@@ -4,8 +4,11 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"io"
+	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/Azure/azure-pipeline-go/pipeline"
@@ -124,3 +127,265 @@ func (s *policyRetrySuite) TestExponentialRetry(c *chk.C) {
 	c.Assert(strings.Contains(str, "try=4, Delay=2s"), chk.Equals, true) // Min: 0.512 * 7 = 3.584
 	// TODO add assertion here about minimum time taken
 }
+
+// newTestRetryMockFileURL returns the URL of a file in a mock service, whose pipeline retries with o.
+func newTestRetryMockFileURL(o RetryOptions) (FileURL, *mockFileService) {
+	s := newMockFileService()
+	s.addFile("file", []byte("data"))
+	u, _ := url.Parse("https://account.file.core.windows.net/share/file")
+	return NewFileURL(*u, newTestHandlerPipeline(s, NewRetryPolicyFactory(o))), s
+}
+
+// failTestRequests makes the next `times` requests to mock fail with status and code, and the given response headers.
+func failTestRequests(mock *mockFileService, times int, status int, code ServiceCodeType, headers map[string]string) {
+	mock.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if times == 0 {
+			return false
+		}
+		times--
+		for k, v := range headers {
+			w.Header().Set(k, v)
+		}
+		mockError(w, status, code)
+		return true
+	}
+}
+
+func (s *policyRetrySuite) TestRetryHonorsRetryAfterHint(c *chk.C) {
+	decisions := []RetryDecision{}
+	fileURL, mock := newTestRetryMockFileURL(RetryOptions{Policy: RetryPolicyFixed, RetryDelay: 10 * time.Millisecond, MaxRetryDelay: time.Second,
+		OnRetry: func(d RetryDecision) { decisions = append(decisions, d) }})
+	failTestRequests(mock, 1, http.StatusServiceUnavailable, ServiceCodeServerBusy, map[string]string{"x-ms-retry-after-ms": "300"})
+
+	start := time.Now()
+	_, err := fileURL.GetProperties(context.Background())
+	c.Assert(err, chk.IsNil)
+	c.Assert(time.Since(start) >= 300*time.Millisecond, chk.Equals, true)
+	c.Assert(decisions, chk.HasLen, 2)
+	c.Assert(decisions[0].Try, chk.Equals, int32(1))
+	c.Assert(decisions[0].StatusCode, chk.Equals, http.StatusServiceUnavailable)
+	c.Assert(decisions[0].ServiceCode, chk.Equals, ServiceCodeServerBusy)
+	c.Assert(decisions[0].Err, chk.NotNil)
+	c.Assert(decisions[0].Retry, chk.Equals, true)
+	c.Assert(decisions[0].Reason, chk.Equals, "Retry: Server busy")
+	c.Assert(decisions[0].Delay, chk.Equals, 300*time.Millisecond)
+	c.Assert(decisions[1].StatusCode, chk.Equals, http.StatusOK)
+	c.Assert(decisions[1].Retry, chk.Equals, false)
+	c.Assert(decisions[1].Delay, chk.Equals, time.Duration(0))
+}
+
+func (s *policyRetrySuite) TestRetryAfterHintCappedByMaxRetryDelay(c *chk.C) {
+	decisions := []RetryDecision{}
+	fileURL, mock := newTestRetryMockFileURL(RetryOptions{Policy: RetryPolicyFixed, RetryDelay: 10 * time.Millisecond, MaxRetryDelay: 100 * time.Millisecond,
+		OnRetry: func(d RetryDecision) { decisions = append(decisions, d) }})
+	failTestRequests(mock, 1, http.StatusServiceUnavailable, ServiceCodeServerBusy, map[string]string{"Retry-After": "3600"})
+
+	_, err := fileURL.GetProperties(context.Background())
+	c.Assert(err, chk.IsNil)
+	c.Assert(decisions[0].Delay, chk.Equals, 100*time.Millisecond)
+}
+
+func (s *policyRetrySuite) TestRetryNegativeCancelledWhileWaiting(c *chk.C) {
+	fileURL, mock := newTestRetryMockFileURL(RetryOptions{Policy: RetryPolicyFixed, RetryDelay: time.Hour, MaxRetryDelay: time.Hour})
+	failTestRequests(mock, 1, http.StatusServiceUnavailable, ServiceCodeServerBusy, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := fileURL.GetProperties(ctx)
+	c.Assert(err, chk.Equals, context.DeadlineExceeded)
+	c.Assert(mock.requestCount("HEAD"), chk.Equals, 0) // The failed request isn't recorded, and there's no other
+}
+
+func (s *policyRetrySuite) TestRetryClassifier(c *chk.C) {
+	classifier := func(statusCode int, serviceCode ServiceCodeType, err error) RetryClassification {
+		switch {
+		case serviceCode == ServiceCodeShareBeingDeleted:
+			return RetryClassificationRetry
+		case statusCode == http.StatusInternalServerError:
+			return RetryClassificationNoRetry
+		}
+		return RetryClassificationDefault
+	}
+	tries := 0
+	fileURL, mock := newTestRetryMockFileURL(RetryOptions{Policy: RetryPolicyFixed, RetryDelay: time.Millisecond, MaxRetryDelay: time.Millisecond,
+		Classifier: classifier, OnRetry: func(RetryDecision) { tries++ }})
+
+	// A conflict isn't retried by default, but the classifier retries it.
+	failTestRequests(mock, 2, http.StatusConflict, ServiceCodeShareBeingDeleted, nil)
+	_, err := fileURL.GetProperties(context.Background())
+	c.Assert(err, chk.IsNil)
+	c.Assert(tries, chk.Equals, 3)
+
+	// An internal error is retried by default, but the classifier doesn't retry it.
+	tries = 0
+	failTestRequests(mock, 1, http.StatusInternalServerError, ServiceCodeInternalError, nil)
+	_, err = fileURL.GetProperties(context.Background())
+	c.Assert(err, chk.NotNil)
+	c.Assert(tries, chk.Equals, 1)
+
+	// Everything else follows the built-in rules.
+	tries = 0
+	failTestRequests(mock, 1, http.StatusServiceUnavailable, ServiceCodeServerBusy, nil)
+	_, err = fileURL.GetProperties(context.Background())
+	c.Assert(err, chk.IsNil)
+	c.Assert(tries, chk.Equals, 2)
+}
+
+func (s *policyRetrySuite) TestRetryNegativeTriesExhausted(c *chk.C) {
+	decisions := []RetryDecision{}
+	fileURL, mock := newTestRetryMockFileURL(RetryOptions{MaxTries: 2, RetryDelay: time.Millisecond, MaxRetryDelay: time.Millisecond,
+		OnRetry: func(d RetryDecision) { decisions = append(decisions, d) }})
+	failTestRequests(mock, 2, http.StatusServiceUnavailable, ServiceCodeServerBusy, nil)
+
+	_, err := fileURL.GetProperties(context.Background())
+	c.Assert(err.(StorageError).ServiceCode(), chk.Equals, ServiceCodeServerBusy)
+	c.Assert(decisions, chk.HasLen, 2)
+	c.Assert(decisions[1].Retry, chk.Equals, false) // Retriable, but no tries are left
+	c.Assert(decisions[1].Reason, chk.Equals, "Retry: Server busy")
+}
+
+func (s *policyRetrySuite) TestRetryAfterHintParsing(c *chk.C) {
+	hint := func(header string, value string) (time.Duration, bool) {
+		return retryAfterHint(&http.Response{Header: http.Header{http.CanonicalHeaderKey(header): []string{value}}})
+	}
+	d, ok := hint("x-ms-retry-after-ms", "1500")
+	c.Assert(ok, chk.Equals, true)
+	c.Assert(d, chk.Equals, 1500*time.Millisecond)
+	d, ok = hint("Retry-After", "2")
+	c.Assert(ok, chk.Equals, true)
+	c.Assert(d, chk.Equals, 2*time.Second)
+	d, ok = hint("Retry-After", time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+	c.Assert(ok, chk.Equals, true)
+	c.Assert(d > 50*time.Second && d <= time.Minute, chk.Equals, true)
+	_, ok = hint("Retry-After", "soon")
+	c.Assert(ok, chk.Equals, false)
+	_, ok = retryAfterHint(nil)
+	c.Assert(ok, chk.Equals, false)
+}
+
+const testSecondaryHost = "account-secondary.file.core.windows.net"
+
+// failTestPrimaryRequests makes the requests to the primary fail with ServerBusy, and the requests to the secondary
+// fail with secondaryStatus and secondaryCode, unless secondaryStatus is 0.
+func failTestPrimaryRequests(mock *mockFileService, secondaryStatus int, secondaryCode ServiceCodeType) {
+	mock.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if r.URL.Host != testSecondaryHost {
+			mockError(w, http.StatusServiceUnavailable, ServiceCodeServerBusy)
+			return true
+		}
+		if secondaryStatus != 0 {
+			mockError(w, secondaryStatus, secondaryCode)
+			return true
+		}
+		return false
+	}
+}
+
+// newTestSecondaryRetryMockFileURL returns the URL of a file in a mock service, whose pipeline retries reads against
+// the secondary, and a pointer to the hosts of its tries.
+func newTestSecondaryRetryMockFileURL(maxTries int32) (FileURL, *mockFileService, *[]string) {
+	hosts := []string{}
+	fileURL, mock := newTestRetryMockFileURL(RetryOptions{MaxTries: maxTries, RetryDelay: time.Millisecond, MaxRetryDelay: time.Millisecond,
+		RetryReadsFromSecondaryHost: testSecondaryHost, OnRetry: func(d RetryDecision) { hosts = append(hosts, d.Host) }})
+	return fileURL, mock, &hosts
+}
+
+func (s *policyRetrySuite) TestRetryReadsFromSecondaryHost(c *chk.C) {
+	fileURL, mock, hosts := newTestSecondaryRetryMockFileURL(4)
+	failTestPrimaryRequests(mock, 0, "")
+
+	props, err := fileURL.GetProperties(context.Background())
+	c.Assert(err, chk.IsNil)
+	c.Assert(props.Response().Request.URL.Host, chk.Equals, testSecondaryHost)
+	c.Assert(*hosts, chk.DeepEquals, []string{"account.file.core.windows.net", testSecondaryHost})
+}
+
+func (s *policyRetrySuite) TestRetrySecondaryNotFoundRetriesOnlyPrimary(c *chk.C) {
+	fileURL, mock, hosts := newTestSecondaryRetryMockFileURL(4)
+	failTestPrimaryRequests(mock, http.StatusNotFound, ServiceCodeResourceNotFound)
+
+	// After the secondary's 404, which may be due to replication delay, only the primary is tried.
+	_, err := fileURL.GetProperties(context.Background())
+	c.Assert(err.(StorageError).ServiceCode(), chk.Equals, ServiceCodeServerBusy)
+	c.Assert(*hosts, chk.DeepEquals, []string{"account.file.core.windows.net", testSecondaryHost, "account.file.core.windows.net", "account.file.core.windows.net"})
+}
+
+func (s *policyRetrySuite) TestRetryNegativeSecondaryNotFoundOnLastTry(c *chk.C) {
+	fileURL, mock, hosts := newTestSecondaryRetryMockFileURL(2)
+	failTestPrimaryRequests(mock, http.StatusNotFound, ServiceCodeResourceNotFound)
+
+	// The primary's error, rather than the secondary's 404, is returned.
+	_, err := fileURL.GetProperties(context.Background())
+	c.Assert(err.(StorageError).ServiceCode(), chk.Equals, ServiceCodeServerBusy)
+	c.Assert(*hosts, chk.DeepEquals, []string{"account.file.core.windows.net", testSecondaryHost})
+}
+
+// testClosedBodies tracks whether the bodies of the responses that its policy passes on get closed.
+type testClosedBodies struct {
+	mutex  sync.Mutex
+	bodies []*testClosedBody
+}
+
+type testClosedBody struct {
+	io.ReadCloser
+	closed bool
+}
+
+func (b *testClosedBody) Close() error {
+	b.closed = true
+	return b.ReadCloser.Close()
+}
+
+func (t *testClosedBodies) New(next pipeline.Policy, po *pipeline.PolicyOptions) pipeline.Policy {
+	return pipeline.PolicyFunc(func(ctx context.Context, request pipeline.Request) (pipeline.Response, error) {
+		response, err := next.Do(ctx, request)
+		if response != nil && response.Response() != nil {
+			body := &testClosedBody{ReadCloser: response.Response().Body}
+			response.Response().Body = body
+			t.mutex.Lock()
+			t.bodies = append(t.bodies, body)
+			t.mutex.Unlock()
+		}
+		return response, err
+	})
+}
+
+func (s *policyRetrySuite) TestRetrySecondaryNotFoundBodyIsClosed(c *chk.C) {
+	mock := newMockFileService()
+	mock.addFile("file", []byte("data"))
+	failTestPrimaryRequests(mock, http.StatusNotFound, ServiceCodeResourceNotFound)
+	bodies := &testClosedBodies{}
+	p := pipeline.NewPipeline([]pipeline.Factory{
+		NewRetryPolicyFactory(RetryOptions{MaxTries: 2, RetryDelay: time.Millisecond, MaxRetryDelay: time.Millisecond, RetryReadsFromSecondaryHost: testSecondaryHost}),
+		pipeline.MethodFactoryMarker(),
+		bodies,
+	}, pipeline.Options{HTTPSender: newTestHandlerSender(mock)})
+
+	// Unlike the generated responders, this one turns error statuses into errors without closing the response's body.
+	responder := pipeline.FactoryFunc(func(next pipeline.Policy, po *pipeline.PolicyOptions) pipeline.PolicyFunc {
+		return func(ctx context.Context, request pipeline.Request) (pipeline.Response, error) {
+			response, err := next.Do(ctx, request)
+			if err == nil && response.Response().StatusCode >= http.StatusBadRequest {
+				err = NewResponseError(nil, response.Response(), response.Response().Status)
+			}
+			return response, err
+		}
+	})
+	request, err := pipeline.NewRequest(http.MethodGet, url.URL{Scheme: "https", Host: "account.file.core.windows.net", Path: "/share/file"}, nil)
+	c.Assert(err, chk.IsNil)
+	_, err = p.Do(context.Background(), responder, request)
+	c.Assert(err.(StorageError).ServiceCode(), chk.Equals, ServiceCodeServerBusy)
+	c.Assert(bodies.bodies, chk.HasLen, 2) // The primary's and the secondary's
+	for _, body := range bodies.bodies {
+		c.Assert(body.closed, chk.Equals, true)
+	}
+}
+
+func (s *policyRetrySuite) TestRetryWritesOnlyToPrimary(c *chk.C) {
+	fileURL, mock, hosts := newTestSecondaryRetryMockFileURL(3)
+	failTestPrimaryRequests(mock, 0, "")
+
+	_, err := fileURL.SetMetadata(context.Background(), Metadata{"a": "b"})
+	c.Assert(err.(StorageError).ServiceCode(), chk.Equals, ServiceCodeServerBusy)
+	c.Assert(*hosts, chk.DeepEquals, []string{"account.file.core.windows.net", "account.file.core.windows.net", "account.file.core.windows.net"})
+}
@@ -0,0 +1,130 @@
+package azfile
+
+import (
+	"context"
+	"net/url"
+	"time"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+	chk "gopkg.in/check.v1"
+)
+
+type policyThrottleSuite struct{}
+
+var _ = chk.Suite(&policyThrottleSuite{})
+
+func newTestThrottledFileURL(name string, o ThrottleOptions) (FileURL, *mockFileService) {
+	s := newMockFileService()
+	u, _ := url.Parse("https://account.file.core.windows.net/share/" + name)
+	return NewFileURL(*u, NewPipeline(NewAnonymousCredential(), PipelineOptions{Throttle: o, HTTPSender: newTestHandlerSender(s)})), s
+}
+
+func (s *policyThrottleSuite) TestRateLimiter(c *chk.C) {
+	ctx := context.Background()
+	l := NewRateLimiter(1000)
+	c.Assert(l.Limit(), chk.Equals, int64(1000))
+
+	// The bucket starts full, so the first second's worth of events needn't wait.
+	start := time.Now()
+	c.Assert(l.WaitN(ctx, 1000), chk.IsNil)
+	c.Assert(time.Since(start) < 100*time.Millisecond, chk.Equals, true)
+	c.Assert(l.WaitN(ctx, 300), chk.IsNil)
+	c.Assert(time.Since(start) >= 250*time.Millisecond, chk.Equals, true)
+
+	// Without a limit, and with a nil RateLimiter, nothing waits.
+	l.SetLimit(0)
+	start = time.Now()
+	c.Assert(l.WaitN(ctx, 1000000), chk.IsNil)
+	c.Assert((*RateLimiter)(nil).WaitN(ctx, 1000000), chk.IsNil)
+	c.Assert(time.Since(start) < 100*time.Millisecond, chk.Equals, true)
+}
+
+func (s *policyThrottleSuite) TestRateLimiterNegativeCancelledWait(c *chk.C) {
+	l := NewRateLimiter(10)
+	c.Assert(l.WaitN(context.Background(), 10), chk.IsNil)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	c.Assert(l.WaitN(ctx, 10), chk.Equals, context.DeadlineExceeded)
+}
+
+func (s *policyThrottleSuite) TestThrottleSharedByParallelUploadsAndDownloads(c *chk.C) {
+	limiter := NewRateLimiter(40 * 1024)
+	fileURL, _ := newTestThrottledFileURL("file", ThrottleOptions{Upload: limiter, Download: limiter})
+	_, data := getRandomDataAndReader(56 * 1024)
+
+	// After the first second's worth, the remaining 16KB take 0.4s however many ranges are uploaded in parallel.
+	start := time.Now()
+	err := UploadBufferToAzureFile(context.Background(), data, fileURL, UploadToAzureFileOptions{RangeSize: 4 * 1024, Parallelism: 8})
+	c.Assert(err, chk.IsNil)
+	c.Assert(time.Since(start) >= 350*time.Millisecond, chk.Equals, true)
+
+	// The upload emptied the bucket, which the download shares, so all of its 56KB take 1.4s.
+	start = time.Now()
+	b := make([]byte, len(data))
+	_, err = DownloadAzureFileToBuffer(context.Background(), fileURL, b, DownloadFromAzureFileOptions{RangeSize: 4 * 1024, Parallelism: 8})
+	c.Assert(err, chk.IsNil)
+	c.Assert(b, chk.DeepEquals, data)
+	c.Assert(time.Since(start) >= 1300*time.Millisecond, chk.Equals, true)
+}
+
+func (s *policyThrottleSuite) TestThrottleLimitChangedWhileUploading(c *chk.C) {
+	limiter := NewRateLimiter(1024)
+	fileURL, mock := newTestThrottledFileURL("file", ThrottleOptions{Upload: limiter})
+	_, data := getRandomDataAndReader(16 * 1024)
+
+	// At 1KB per second, the upload would take 15 seconds, but the limit is lifted soon after it starts.
+	done := make(chan error, 1)
+	start := time.Now()
+	go func() {
+		done <- UploadBufferToAzureFile(context.Background(), data, fileURL, UploadToAzureFileOptions{RangeSize: 4 * 1024})
+	}()
+	time.Sleep(100 * time.Millisecond)
+	limiter.SetLimit(0)
+	c.Assert(<-done, chk.IsNil)
+	c.Assert(time.Since(start) < 3*time.Second, chk.Equals, true)
+	c.Assert(mock.file("file").data, chk.DeepEquals, data)
+}
+
+func (s *policyThrottleSuite) TestThrottleRequestsPerOperationType(c *chk.C) {
+	fileURL, mock := newTestThrottledFileURL("file", ThrottleOptions{Requests: map[string]*RateLimiter{"PUT range": NewRateLimiter(10)}})
+	_, data := getRandomDataAndReader(15 * 1024)
+
+	// The first 10 ranges don't wait, and the other 5 take half a second; creating the file isn't limited.
+	start := time.Now()
+	err := UploadBufferToAzureFile(context.Background(), data, fileURL, UploadToAzureFileOptions{RangeSize: 1024, Parallelism: 15})
+	c.Assert(err, chk.IsNil)
+	c.Assert(time.Since(start) >= 450*time.Millisecond, chk.Equals, true)
+	c.Assert(mock.file("file").data, chk.DeepEquals, data)
+
+	// Other operations aren't limited.
+	start = time.Now()
+	for i := 0; i < 20; i++ {
+		_, err = fileURL.GetProperties(context.Background())
+		c.Assert(err, chk.IsNil)
+	}
+	c.Assert(time.Since(start) < 250*time.Millisecond, chk.Equals, true)
+}
+
+func (s *policyThrottleSuite) TestThrottleNegativeCancelledRequest(c *chk.C) {
+	fileURL, mock := newTestThrottledFileURL("file", ThrottleOptions{Requests: map[string]*RateLimiter{"*": NewRateLimiter(1)}})
+	mock.addFile("file", []byte("data"))
+	_, err := fileURL.GetProperties(context.Background())
+	c.Assert(err, chk.IsNil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = fileURL.GetProperties(ctx)
+	c.Assert(err, chk.NotNil)
+	c.Assert(mock.requestCount("HEAD"), chk.Equals, 1)
+}
+
+func (s *policyThrottleSuite) TestThrottledOperationType(c *chk.C) {
+	request := func(method string, rawURL string) pipeline.Request {
+		u, _ := url.Parse(rawURL)
+		r, _ := pipeline.NewRequest(method, *u, nil)
+		return r
+	}
+	c.Assert(throttledOperationType(request("PUT", "https://a.file.core.windows.net/share/file")), chk.Equals, "PUT")
+	c.Assert(throttledOperationType(request("PUT", "https://a.file.core.windows.net/share/file?comp=range")), chk.Equals, "PUT range")
+	c.Assert(throttledOperationType(request("GET", "https://a.file.core.windows.net/share/dir?restype=directory&comp=list")), chk.Equals, "GET list")
+}
@@ -0,0 +1,114 @@
+package azfile
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	chk "gopkg.in/check.v1"
+)
+
+type smbFileInfoSuite struct{}
+
+var _ = chk.Suite(&smbFileInfoSuite{})
+
+// writeTestLocalFile writes a local file with the given mode and modification time, returning it opened for reading.
+func writeTestLocalFile(c *chk.C, name string, data []byte, mode os.FileMode, modTime time.Time) *os.File {
+	c.Assert(ioutil.WriteFile(name, data, 0666), chk.IsNil)
+	c.Assert(os.Chtimes(name, modTime, modTime), chk.IsNil)
+	c.Assert(os.Chmod(name, mode), chk.IsNil)
+	file, err := os.Open(name)
+	c.Assert(err, chk.IsNil)
+	return file
+}
+
+func (s *smbFileInfoSuite) TestNewSMBPropertiesFromFileInfo(c *chk.C) {
+	if runtime.GOOS == "windows" {
+		c.Skip("Windows files have attributes of their own")
+	}
+	dir := c.MkDir()
+	modTime := time.Date(2020, 6, 7, 8, 9, 10, 0, time.UTC)
+	for name, want := range map[string]FileAttributeFlags{"plain": FileAttributeNone, ".hidden": FileAttributeHidden} {
+		for mode, readonly := range map[os.FileMode]bool{0644: false, 0444: true} {
+			file := writeTestLocalFile(c, filepath.Join(dir, name), []byte("data"), mode, modTime)
+			stat, err := file.Stat()
+			c.Assert(err, chk.IsNil)
+			file.Close()
+
+			sp := NewSMBPropertiesFromFileInfo(stat)
+			wantAttributes := want
+			if readonly {
+				wantAttributes = wantAttributes.Add(FileAttributeReadonly)
+			}
+			c.Assert(*sp.FileAttributes, chk.Equals, wantAttributes)
+			c.Assert(sp.FileLastWriteTime.Equal(modTime), chk.Equals, true)
+			c.Assert(sp.FileCreationTime, chk.IsNil)
+			c.Assert(sp.PermissionString, chk.IsNil)
+			c.Assert(os.Remove(filepath.Join(dir, name)), chk.IsNil)
+		}
+	}
+}
+
+func (s *smbFileInfoSuite) TestUploadFilePreservesSMBProperties(c *chk.C) {
+	if runtime.GOOS == "windows" {
+		c.Skip("Windows files have attributes of their own")
+	}
+	modTime := time.Date(2020, 6, 7, 8, 9, 10, 0, time.UTC)
+	for _, size := range []int{0, 3000} {
+		fileURL, mock := newTestMockFileURL("file")
+		_, data := getRandomDataAndReader(size)
+		file := writeTestLocalFile(c, filepath.Join(c.MkDir(), ".config"), data, 0444, modTime)
+		defer file.Close()
+
+		// The file is created without the read-only attribute, which stops ranges being written, and the attributes and last
+		// write time, which writing the ranges updates, are set once they're written.
+		err := UploadFileToAzureFile(context.Background(), file, fileURL, UploadToAzureFileOptions{RangeSize: 1024, PreserveSMBProperties: true,
+			ComputeContentMD5: true, FileHTTPHeaders: FileHTTPHeaders{ContentType: "text/plain"}})
+		c.Assert(err, chk.IsNil)
+
+		props, err := fileURL.GetProperties(context.Background())
+		c.Assert(err, chk.IsNil)
+		adapter := SMBPropertyAdapter{PropertySource: props}
+		c.Assert(adapter.FileAttributes(), chk.Equals, FileAttributeHidden|FileAttributeReadonly)
+		c.Assert(adapter.FileLastWriteTime().Equal(modTime), chk.Equals, true)
+		c.Assert(props.ContentType(), chk.Equals, "text/plain")
+		c.Assert(props.ContentMD5(), chk.NotNil)
+		c.Assert(mock.file("file").data, chk.DeepEquals, data)
+		c.Assert(mock.requestCount("PUT /share/file?comp=properties"), chk.Equals, 1)
+	}
+}
+
+func (s *smbFileInfoSuite) TestUploadFileWithoutPreserveSMBProperties(c *chk.C) {
+	fileURL, mock := newTestMockFileURL("file")
+	file := writeTestLocalFile(c, filepath.Join(c.MkDir(), ".config"), []byte("data"), 0444, time.Date(2020, 6, 7, 8, 9, 10, 0, time.UTC))
+	defer file.Close()
+
+	c.Assert(UploadFileToAzureFile(context.Background(), file, fileURL, UploadToAzureFileOptions{}), chk.IsNil)
+	f := mock.file("file")
+	c.Assert(f.attributes, chk.Equals, "None")
+	c.Assert(mock.requestCount("PUT /share/file?comp=properties"), chk.Equals, 0)
+}
+
+func (s *smbFileInfoSuite) TestDownloadFilePreservesLastWriteTime(c *chk.C) {
+	fileURL, mock := newTestMockFileURL("file")
+	mock.addFile("file", []byte("data"))
+	written := time.Date(2020, 6, 7, 8, 9, 10, 0, time.UTC)
+	_, err := fileURL.SetHTTPHeaders(context.Background(), FileHTTPHeaders{SMBProperties: SMBProperties{FileLastWriteTime: &written}})
+	c.Assert(err, chk.IsNil)
+
+	name := filepath.Join(c.MkDir(), "local")
+	for _, preserve := range []bool{false, true} {
+		file, err := os.Create(name)
+		c.Assert(err, chk.IsNil)
+		_, err = DownloadAzureFileToFile(context.Background(), fileURL, file, DownloadFromAzureFileOptions{PreserveLastWriteTime: preserve})
+		c.Assert(err, chk.IsNil)
+		c.Assert(file.Close(), chk.IsNil)
+
+		stat, err := os.Stat(name)
+		c.Assert(err, chk.IsNil)
+		c.Assert(stat.ModTime().Equal(written), chk.Equals, preserve)
+	}
+}
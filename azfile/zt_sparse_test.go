@@ -0,0 +1,145 @@
+package azfile
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	chk "gopkg.in/check.v1"
+)
+
+type sparseSuite struct{}
+
+var _ = chk.Suite(&sparseSuite{})
+
+// newTestSparseFile creates a file of size bytes that holds random data in populated and zeros elsewhere.
+func newTestSparseFile(size int64, populated []Range) (FileURL, *mockFileService, []byte) {
+	fileURL, mock := newTestMockFileURL("sparse")
+	data := make([]byte, size)
+	for _, r := range populated {
+		_, random := getRandomDataAndReader(int(r.End - r.Start + 1))
+		copy(data[r.Start:], random)
+	}
+	mock.addFile("sparse", data)
+	mock.setWrittenRanges("sparse", populated)
+	return fileURL, mock, data
+}
+
+func (s *sparseSuite) TestSparseDownloadToBuffer(c *chk.C) {
+	populated := []Range{{Start: 0, End: 99}, {Start: 5000, End: 7047}, {Start: 20000, End: 20479}}
+	fileURL, mock, data := newTestSparseFile(32*1024, populated)
+
+	b := bytes.Repeat([]byte{0xff}, len(data))
+	_, err := DownloadAzureFileToBuffer(context.Background(), fileURL, b, DownloadFromAzureFileOptions{RangeSize: 1024, Sparse: true})
+	c.Assert(err, chk.IsNil)
+	c.Assert(b, chk.DeepEquals, data)
+	c.Assert(mock.requestCount("GET /share/sparse?comp=rangelist"), chk.Equals, 1)
+	c.Assert(mock.requestCount("GET /share/sparse?")-mock.requestCount("GET /share/sparse?comp="), chk.Equals, 4) // 1 + 2 + 1 ranges
+}
+
+func (s *sparseSuite) TestSparseDownloadToFile(c *chk.C) {
+	populated := []Range{{Start: 4096, End: 8191}, {Start: 65536, End: 65537}}
+	fileURL, mock, data := newTestSparseFile(128*1024, populated)
+
+	// The local file already holds other data, which must not show through the holes.
+	name := filepath.Join(c.MkDir(), "local")
+	c.Assert(ioutil.WriteFile(name, bytes.Repeat([]byte{0xff}, 256*1024), 0644), chk.IsNil)
+	file, err := os.OpenFile(name, os.O_RDWR, 0644)
+	c.Assert(err, chk.IsNil)
+	defer file.Close()
+
+	_, err = DownloadAzureFileToFile(context.Background(), fileURL, file, DownloadFromAzureFileOptions{Sparse: true})
+	c.Assert(err, chk.IsNil)
+	local, err := ioutil.ReadFile(name)
+	c.Assert(err, chk.IsNil)
+	c.Assert(local, chk.DeepEquals, data)
+	c.Assert(mock.requestCount("GET /share/sparse?")-mock.requestCount("GET /share/sparse?comp="), chk.Equals, 2)
+}
+
+func (s *sparseSuite) TestSparseDownloadOfEmptyRangeList(c *chk.C) {
+	fileURL, mock, data := newTestSparseFile(10*1024, nil)
+	b := bytes.Repeat([]byte{0xff}, len(data))
+	_, err := DownloadAzureFileToBuffer(context.Background(), fileURL, b, DownloadFromAzureFileOptions{Sparse: true})
+	c.Assert(err, chk.IsNil)
+	c.Assert(b, chk.DeepEquals, data)
+	c.Assert(mock.requestCount("GET /share/sparse?")-mock.requestCount("GET /share/sparse?comp="), chk.Equals, 0)
+}
+
+func (s *sparseSuite) TestSplitRanges(c *chk.C) {
+	c.Assert(splitRanges([]Range{{Start: 0, End: 9}, {Start: 20, End: 20}, {Start: 30, End: 33}}, 4), chk.DeepEquals,
+		[]Range{{Start: 0, End: 3}, {Start: 4, End: 7}, {Start: 8, End: 9}, {Start: 20, End: 20}, {Start: 30, End: 33}})
+}
+
+func (s *sparseSuite) TestSparseUploadSkipsZeroRanges(c *chk.C) {
+	fileURL, mock := newTestMockFileURL("sparse")
+	data := make([]byte, 10*1024)
+	copy(data[1024:], "data")   // Range 1
+	copy(data[8*1024-1:], "xy") // Ranges 7 and 8
+	progress := int64(0)
+
+	err := UploadBufferToAzureFile(context.Background(), data, fileURL, UploadToAzureFileOptions{RangeSize: 1024, Sparse: true,
+		Progress: func(bytesTransferred int64) { progress = bytesTransferred }})
+	c.Assert(err, chk.IsNil)
+	f := mock.file("sparse")
+	c.Assert(f.data, chk.DeepEquals, data)
+	c.Assert(f.written, chk.DeepEquals, []Range{{Start: 1024, End: 2047}, {Start: 7 * 1024, End: 9*1024 - 1}})
+	c.Assert(mock.requestCount("PUT /share/sparse?comp=range"), chk.Equals, 3)
+	c.Assert(progress, chk.Equals, int64(len(data)))
+}
+
+func (s *sparseSuite) TestSparseUploadOfSparseLocalFile(c *chk.C) {
+	fileURL, mock := newTestMockFileURL("sparse")
+	name := filepath.Join(c.MkDir(), "local")
+	file, err := os.Create(name)
+	c.Assert(err, chk.IsNil)
+	defer file.Close()
+	c.Assert(file.Truncate(4*1024*1024), chk.IsNil)
+	_, err = file.WriteAt([]byte("data"), 1024*1024)
+	c.Assert(err, chk.IsNil)
+
+	c.Assert(UploadFileToAzureFile(context.Background(), file, fileURL, UploadToAzureFileOptions{RangeSize: 64 * 1024, Sparse: true}), chk.IsNil)
+	local, err := ioutil.ReadFile(name)
+	c.Assert(err, chk.IsNil)
+	c.Assert(mock.file("sparse").data, chk.DeepEquals, local)
+	c.Assert(mock.requestCount("PUT /share/sparse?comp=range"), chk.Equals, 1)
+
+	// Finding holes mustn't move the file's offset.
+	offset, err := file.Seek(0, io.SeekCurrent)
+	c.Assert(err, chk.IsNil)
+	c.Assert(offset, chk.Equals, int64(0))
+}
+
+func (s *sparseSuite) TestSparseUploadClearsRangesWhenResuming(c *chk.C) {
+	fileURL, mock := newTestMockFileURL("sparse")
+	file, data := createTestLocalFile(c, 4*1024)
+	defer file.Close()
+	for i := 3 * 1024; i < len(data); i++ {
+		data[i] = 0
+	}
+	c.Assert(ioutil.WriteFile(file.Name(), data, 0644), chk.IsNil)
+	o := UploadToAzureFileOptions{RangeSize: 1024, Parallelism: 1, Sparse: true, CheckpointPath: filepath.Join(c.MkDir(), "journal")}
+
+	failRangeOnce(mock, http.MethodPut, "2048")
+	c.Assert(UploadFileToAzureFile(context.Background(), file, fileURL, o), chk.NotNil)
+	clears := 0
+	mock.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Header.Get("x-ms-write") == "clear" {
+			clears++
+		}
+		return false
+	}
+	c.Assert(UploadFileToAzureFile(context.Background(), file, fileURL, o), chk.IsNil)
+	c.Assert(mock.file("sparse").data, chk.DeepEquals, data)
+	c.Assert(clears, chk.Equals, 1)
+}
+
+func (s *sparseSuite) TestHoldsData(c *chk.C) {
+	c.Assert(holdsData(make([]byte, 10), 0, nil), chk.Equals, false)
+	c.Assert(holdsData([]byte{0, 0, 1}, 0, nil), chk.Equals, true)
+	c.Assert(holdsData([]byte{0, 0, 1}, 100, []Range{{Start: 0, End: 99}}), chk.Equals, false) // In a hole, so not read
+	c.Assert(holdsData([]byte{0, 0, 1}, 100, []Range{{Start: 102, End: 200}}), chk.Equals, true)
+}
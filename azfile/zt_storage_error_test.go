@@ -0,0 +1,111 @@
+package azfile
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+	chk "gopkg.in/check.v1"
+)
+
+type storageErrorSuite struct{}
+
+var _ = chk.Suite(&storageErrorSuite{})
+
+// newTestErrorFileURL returns the URL of a file of a mock service that responds to every request with status and body.
+func newTestErrorFileURL(status int, code ServiceCodeType, body string) FileURL {
+	service := newMockFileService()
+	service.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if code != "" {
+			w.Header().Set("x-ms-error-code", string(code))
+		}
+		w.WriteHeader(status)
+		fmt.Fprint(w, body)
+		return true
+	}
+	u, _ := url.Parse("https://account.file.core.windows.net/share/file")
+	return NewFileURL(*u, newTestHandlerPipeline(service))
+}
+
+// testStorageError returns the error of a request to a mock service that responds with status and code.
+func testStorageError(status int, code ServiceCodeType) error {
+	_, err := newTestErrorFileURL(status, code, "").Download(context.Background(), 0, CountToEnd, false)
+	return err
+}
+
+func (s *storageErrorSuite) TestPredicates(c *chk.C) {
+	notFound := testStorageError(http.StatusNotFound, ServiceCodeShareNotFound)
+	alreadyExists := testStorageError(http.StatusConflict, ServiceCodeResourceAlreadyExists)
+	throttled := testStorageError(http.StatusServiceUnavailable, ServiceCodeServerBusy)
+	authFailure := testStorageError(http.StatusForbidden, ServiceCodeAuthenticationFailed)
+	lockConflict := testStorageError(http.StatusConflict, ServiceCodeSharingViolation)
+	headNotFound := testStorageError(http.StatusNotFound, "") // Like a HEAD response, without a code
+
+	c.Assert(IsNotFound(notFound), chk.Equals, true)
+	c.Assert(IsNotFound(headNotFound), chk.Equals, true)
+	c.Assert(IsNotFound(alreadyExists), chk.Equals, false)
+	c.Assert(IsAlreadyExists(alreadyExists), chk.Equals, true)
+	c.Assert(IsAlreadyExists(lockConflict), chk.Equals, false)
+	c.Assert(IsConflict(alreadyExists), chk.Equals, true)
+	c.Assert(IsConflict(lockConflict), chk.Equals, true)
+	c.Assert(IsConflict(notFound), chk.Equals, false)
+	c.Assert(IsThrottled(throttled), chk.Equals, true)
+	c.Assert(IsThrottled(notFound), chk.Equals, false)
+	c.Assert(IsAuthFailure(authFailure), chk.Equals, true)
+	c.Assert(IsAuthFailure(testStorageError(http.StatusUnauthorized, "")), chk.Equals, true)
+	c.Assert(IsAuthFailure(throttled), chk.Equals, false)
+	c.Assert(IsLockConflict(lockConflict), chk.Equals, true)
+	c.Assert(IsLockConflict(testStorageError(http.StatusConflict, ServiceCodeFileLockConflict)), chk.Equals, true)
+	c.Assert(IsLockConflict(alreadyExists), chk.Equals, false)
+
+	for _, is := range []func(error) bool{IsNotFound, IsAlreadyExists, IsConflict, IsThrottled, IsAuthFailure, IsLockConflict} {
+		c.Assert(is(nil), chk.Equals, false)
+		c.Assert(is(errors.New("not a storage error")), chk.Equals, false)
+	}
+}
+
+func (s *storageErrorSuite) TestPredicatesSeeThroughWrappedErrors(c *chk.C) {
+	notFound := testStorageError(http.StatusNotFound, ServiceCodeResourceNotFound)
+
+	wrapped := fmt.Errorf("getting the file: %w", fmt.Errorf("downloading: %w", notFound))
+	c.Assert(IsNotFound(wrapped), chk.Equals, true)
+	var stgErr StorageError
+	c.Assert(errors.As(wrapped, &stgErr), chk.Equals, true)
+	c.Assert(stgErr.ServiceCode(), chk.Equals, ServiceCodeResourceNotFound)
+
+	// The pipeline's errors have a Cause instead of an Unwrap method.
+	caused := fmt.Errorf("syncing: %w", pipeline.NewError(notFound, "HTTP request failed"))
+	c.Assert(IsNotFound(caused), chk.Equals, true)
+}
+
+func (s *storageErrorSuite) TestDetails(c *chk.C) {
+	body := "<?xml version=\"1.0\" encoding=\"utf-8\"?>\n<Error>\n  <Code>AuthenticationFailed</Code>\n" +
+		"  <Message>Server failed to authenticate the request.</Message>\n" +
+		"  <AuthenticationErrorDetail>Signature not valid in the specified time frame</AuthenticationErrorDetail>\n</Error>"
+	_, err := newTestErrorFileURL(http.StatusForbidden, ServiceCodeAuthenticationFailed, body).Download(context.Background(), 0, CountToEnd, false)
+	details := map[string]string{
+		"Code":                      "AuthenticationFailed",
+		"AuthenticationErrorDetail": "Signature not valid in the specified time frame",
+	}
+	c.Assert(StorageErrorDetails(err), chk.DeepEquals, details)
+	c.Assert(StorageErrorDetails(fmt.Errorf("downloading: %w", err)), chk.DeepEquals, details)
+	c.Assert(IsAuthFailure(err), chk.Equals, true)
+
+	c.Assert(StorageErrorDetails(testStorageError(http.StatusNotFound, "")), chk.HasLen, 0)
+	c.Assert(StorageErrorDetails(errors.New("not a storage error")), chk.IsNil)
+	c.Assert(StorageErrorDetails(nil), chk.IsNil)
+}
+
+func (s *storageErrorSuite) TestUnwrapsCause(c *chk.C) {
+	// An error response whose body isn't XML is a storage error caused by the XML error.
+	_, err := newTestErrorFileURL(http.StatusInternalServerError, ServiceCodeInternalError, "<<").Download(context.Background(), 0, CountToEnd, false)
+	var syntaxErr *xml.SyntaxError
+	c.Assert(errors.As(err, &syntaxErr), chk.Equals, true)
+	stgErr, ok := err.(StorageError)
+	c.Assert(ok, chk.Equals, true)
+	c.Assert(stgErr.ServiceCode(), chk.Equals, ServiceCodeInternalError)
+}
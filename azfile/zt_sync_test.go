@@ -0,0 +1,194 @@
+package azfile
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	chk "gopkg.in/check.v1"
+)
+
+type syncSuite struct{}
+
+var _ = chk.Suite(&syncSuite{})
+
+func newTestMockDirectoryURL(name string) (DirectoryURL, *mockFileService) {
+	s := newMockFileService()
+	u, _ := url.Parse("https://account.file.core.windows.net/share/" + name)
+	return NewDirectoryURL(*u, newTestHandlerPipeline(s)), s
+}
+
+// writeTestLocalFiles writes files, keyed by '/'-separated path, beneath root.
+func writeTestLocalFiles(c *chk.C, root string, files map[string]string) {
+	for p, content := range files {
+		name := filepath.Join(root, filepath.FromSlash(p))
+		c.Assert(os.MkdirAll(filepath.Dir(name), 0777), chk.IsNil)
+		c.Assert(ioutil.WriteFile(name, []byte(content), 0666), chk.IsNil)
+	}
+}
+
+func (s *syncSuite) TestSyncToAzureUploadsOnlyChangedFiles(c *chk.C) {
+	d, mock := newTestMockDirectoryURL("mirror")
+	local := c.MkDir()
+	writeTestLocalFiles(c, local, map[string]string{"a.txt": "a", "sub/b.txt": "bb", "sub/deeper/c.txt": "ccc"})
+	c.Assert(os.Mkdir(filepath.Join(local, "empty"), 0777), chk.IsNil)
+
+	plan, err := SyncDirectoryToAzureDirectory(context.Background(), local, d, SyncOptions{})
+	c.Assert(err, chk.IsNil)
+	c.Assert(plan, chk.DeepEquals, []SyncAction{
+		{Type: SyncActionCreateDirectory, IsDirectory: true},
+		{Type: SyncActionCreateDirectory, Path: "empty", IsDirectory: true},
+		{Type: SyncActionCreateDirectory, Path: "sub", IsDirectory: true},
+		{Type: SyncActionCreateDirectory, Path: "sub/deeper", IsDirectory: true},
+		{Type: SyncActionUpload, Path: "a.txt", Size: 1},
+		{Type: SyncActionUpload, Path: "sub/b.txt", Size: 2},
+		{Type: SyncActionUpload, Path: "sub/deeper/c.txt", Size: 3},
+	})
+	c.Assert(string(mock.file("mirror/sub/deeper/c.txt").data), chk.Equals, "ccc")
+
+	// Nothing changed, so there's nothing to do.
+	plan, err = SyncDirectoryToAzureDirectory(context.Background(), local, d, SyncOptions{})
+	c.Assert(err, chk.IsNil)
+	c.Assert(plan, chk.HasLen, 0)
+
+	// A file of the same size, but modified later, is uploaded again, as is a file whose size changed.
+	writeTestLocalFiles(c, local, map[string]string{"a.txt": "A", "sub/b.txt": "bbbb"})
+	later := time.Now().Add(time.Hour)
+	c.Assert(os.Chtimes(filepath.Join(local, "a.txt"), later, later), chk.IsNil)
+	plan, err = SyncDirectoryToAzureDirectory(context.Background(), local, d, SyncOptions{})
+	c.Assert(err, chk.IsNil)
+	c.Assert(plan, chk.DeepEquals, []SyncAction{{Type: SyncActionUpload, Path: "a.txt", Size: 1}, {Type: SyncActionUpload, Path: "sub/b.txt", Size: 4}})
+	c.Assert(string(mock.file("mirror/a.txt").data), chk.Equals, "A")
+	c.Assert(string(mock.file("mirror/sub/b.txt").data), chk.Equals, "bbbb")
+}
+
+func (s *syncSuite) TestSyncToAzureDryRunAndDeleteExtra(c *chk.C) {
+	d, mock := newTestMockDirectoryURL("mirror")
+	mock.addFile("mirror/keep.txt", []byte("keep"))
+	mock.addFile("mirror/extra.txt", []byte("extra"))
+	mock.addFile("mirror/old/deep/file", []byte("old"))
+	mock.addFile("mirror/conflict/file", []byte("a directory here"))
+	local := c.MkDir()
+	writeTestLocalFiles(c, local, map[string]string{"keep.txt": "KEEP", "conflict": "a file here"})
+
+	o := SyncOptions{DeleteExtra: true, DryRun: true}
+	want := []SyncAction{
+		{Type: SyncActionDelete, Path: "conflict", IsDirectory: true},
+		{Type: SyncActionDelete, Path: "extra.txt"},
+		{Type: SyncActionDelete, Path: "old", IsDirectory: true},
+		{Type: SyncActionUpload, Path: "conflict", Size: 11},
+		{Type: SyncActionUpload, Path: "keep.txt", Size: 4},
+	}
+	plan, err := SyncDirectoryToAzureDirectory(context.Background(), local, d, o)
+	c.Assert(err, chk.IsNil)
+	c.Assert(plan, chk.DeepEquals, want)
+	c.Assert(mock.requestCount("PUT"), chk.Equals, 0)
+	c.Assert(mock.requestCount("DELETE"), chk.Equals, 0)
+
+	o.DryRun = false
+	plan, err = SyncDirectoryToAzureDirectory(context.Background(), local, d, o)
+	c.Assert(err, chk.IsNil)
+	c.Assert(plan, chk.DeepEquals, want)
+	names := []string{}
+	for name := range mock.nodes {
+		names = append(names, name)
+	}
+	c.Assert(names, chk.HasLen, 4) // The root, "mirror" and its two files
+	c.Assert(string(mock.file("mirror/conflict").data), chk.Equals, "a file here")
+	c.Assert(string(mock.file("mirror/keep.txt").data), chk.Equals, "KEEP")
+}
+
+func (s *syncSuite) TestSyncFromAzure(c *chk.C) {
+	d, mock := newTestMockDirectoryURL("mirror")
+	mock.addFile("mirror/a.txt", []byte("a"))
+	mock.addFile("mirror/sub/b.txt", []byte("bb"))
+	local := filepath.Join(c.MkDir(), "local")
+	writeTestLocalFiles(c, local, map[string]string{"extra/file": "extra"})
+
+	o := SyncOptions{DeleteExtra: true}
+	plan, err := SyncAzureDirectoryToDirectory(context.Background(), d, local, o)
+	c.Assert(err, chk.IsNil)
+	c.Assert(plan, chk.DeepEquals, []SyncAction{
+		{Type: SyncActionDelete, Path: "extra", IsDirectory: true},
+		{Type: SyncActionCreateDirectory, Path: "sub", IsDirectory: true},
+		{Type: SyncActionDownload, Path: "a.txt", Size: 1},
+		{Type: SyncActionDownload, Path: "sub/b.txt", Size: 2},
+	})
+	b, err := ioutil.ReadFile(filepath.Join(local, "sub", "b.txt"))
+	c.Assert(err, chk.IsNil)
+	c.Assert(string(b), chk.Equals, "bb")
+	_, err = os.Stat(filepath.Join(local, "extra"))
+	c.Assert(os.IsNotExist(err), chk.Equals, true)
+
+	// The downloaded files have the Azure files' last write times, so they compare as unchanged.
+	plan, err = SyncAzureDirectoryToDirectory(context.Background(), d, local, o)
+	c.Assert(err, chk.IsNil)
+	c.Assert(plan, chk.HasLen, 0)
+
+	mock.addFile("mirror/a.txt", []byte("A")) // Same size, but written later
+	plan, err = SyncAzureDirectoryToDirectory(context.Background(), d, local, o)
+	c.Assert(err, chk.IsNil)
+	c.Assert(plan, chk.DeepEquals, []SyncAction{{Type: SyncActionDownload, Path: "a.txt", Size: 1}})
+	b, err = ioutil.ReadFile(filepath.Join(local, "a.txt"))
+	c.Assert(err, chk.IsNil)
+	c.Assert(string(b), chk.Equals, "A")
+}
+
+func (s *syncSuite) TestSyncFromAzureCreatesLocalDirectory(c *chk.C) {
+	d, mock := newTestMockDirectoryURL("mirror")
+	mock.addFile("mirror/a.txt", []byte("a"))
+	local := filepath.Join(c.MkDir(), "new")
+
+	plan, err := SyncAzureDirectoryToDirectory(context.Background(), d, local, SyncOptions{})
+	c.Assert(err, chk.IsNil)
+	c.Assert(plan, chk.DeepEquals, []SyncAction{{Type: SyncActionCreateDirectory, IsDirectory: true}, {Type: SyncActionDownload, Path: "a.txt", Size: 1}})
+	b, err := ioutil.ReadFile(filepath.Join(local, "a.txt"))
+	c.Assert(err, chk.IsNil)
+	c.Assert(string(b), chk.Equals, "a")
+}
+
+func (s *syncSuite) TestSyncNegativeFailedActions(c *chk.C) {
+	d, mock := newTestMockDirectoryURL("mirror")
+	mock.addDir("mirror")
+	mock.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Method == http.MethodPut && r.URL.Path == "/share/mirror/bad.txt" {
+			mockError(w, http.StatusForbidden, ServiceCodeAuthenticationFailed)
+			return true
+		}
+		return false
+	}
+	local := c.MkDir()
+	writeTestLocalFiles(c, local, map[string]string{"bad.txt": "bad", "good.txt": "good"})
+
+	plan, err := SyncDirectoryToAzureDirectory(context.Background(), local, d, SyncOptions{})
+	c.Assert(plan, chk.HasLen, 2)
+	c.Assert(err, chk.FitsTypeOf, &SyncError{})
+	failures := err.(*SyncError).Failures
+	c.Assert(failures, chk.HasLen, 1)
+	c.Assert(failures[0].Action, chk.DeepEquals, SyncAction{Type: SyncActionUpload, Path: "bad.txt", Size: 3})
+	c.Assert(string(mock.file("mirror/good.txt").data), chk.Equals, "good")
+}
+
+func (s *syncSuite) TestSyncNegativeMissingLocalSource(c *chk.C) {
+	d, _ := newTestMockDirectoryURL("mirror")
+	_, err := SyncDirectoryToAzureDirectory(context.Background(), filepath.Join(c.MkDir(), "missing"), d, SyncOptions{})
+	c.Assert(os.IsNotExist(err), chk.Equals, true)
+}
+
+func (s *syncSuite) TestSyncNegativeMissingAzureSource(c *chk.C) {
+	d, _ := newTestMockDirectoryURL("missing")
+	root := c.MkDir()
+	writeTestLocalFiles(c, root, map[string]string{"a.txt": "a", "sub/b.txt": "b"})
+
+	// A missing source must not look like an empty one, which DeleteExtra would mirror by deleting everything.
+	actions, err := SyncAzureDirectoryToDirectory(context.Background(), d, root, SyncOptions{DeleteExtra: true})
+	c.Assert(IsNotFound(err), chk.Equals, true)
+	c.Assert(actions, chk.HasLen, 0)
+	data, err := ioutil.ReadFile(filepath.Join(root, "sub", "b.txt"))
+	c.Assert(err, chk.IsNil)
+	c.Assert(string(data), chk.Equals, "b")
+}
@@ -0,0 +1,92 @@
+package azfile
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing/iotest"
+
+	chk "gopkg.in/check.v1"
+)
+
+type uploadStreamSuite struct{}
+
+var _ = chk.Suite(&uploadStreamSuite{})
+
+func newTestMockFileURL(name string) (FileURL, *mockFileService) {
+	s := newMockFileService()
+	u, _ := url.Parse("https://account.file.core.windows.net/share/" + name)
+	return NewFileURL(*u, newTestHandlerPipeline(s)), s
+}
+
+func (s *uploadStreamSuite) TestUploadStreamOfUnknownLength(c *chk.C) {
+	for _, size := range []int{0, 1, 1023, 1024, 1025, 10*1024 + 7} {
+		fileURL, mock := newTestMockFileURL("stream")
+		_, data := getRandomDataAndReader(size)
+		progress := int64(0)
+
+		// OneByteReader makes every read short, as pipes and network bodies do.
+		err := UploadStreamToAzureFile(context.Background(), iotest.OneByteReader(bytes.NewReader(data)), fileURL,
+			UploadToAzureFileOptions{RangeSize: 1024, Parallelism: 3, Metadata: Metadata{"foo": "bar"},
+				Progress: func(bytesTransferred int64) { atomic.StoreInt64(&progress, bytesTransferred) }})
+		c.Assert(err, chk.IsNil)
+
+		f := mock.file("stream")
+		c.Assert(f, chk.NotNil)
+		c.Assert(f.data, chk.DeepEquals, append([]byte{}, data...))
+		c.Assert(f.metadata, chk.DeepEquals, map[string]string{"foo": "bar"})
+		c.Assert(atomic.LoadInt64(&progress), chk.Equals, int64(size))
+		c.Assert(mock.requestCount("PUT /share/stream?comp=range"), chk.Equals, (size+1023)/1024)
+	}
+}
+
+func (s *uploadStreamSuite) TestUploadStreamGrowsGeometrically(c *chk.C) {
+	fileURL, mock := newTestMockFileURL("stream")
+	_, data := getRandomDataAndReader(100*1024 + 1)
+	err := UploadStreamToAzureFile(context.Background(), bytes.NewReader(data), fileURL, UploadToAzureFileOptions{RangeSize: 1024})
+	c.Assert(err, chk.IsNil)
+	c.Assert(mock.file("stream").data, chk.DeepEquals, data)
+
+	// 1, 2, 4, ... 128 ranges, then the final trim.
+	c.Assert(mock.requestCount("PUT /share/stream?comp=properties"), chk.Equals, 9)
+}
+
+type errReader struct{ err error }
+
+func (r errReader) Read([]byte) (int, error) { return 0, r.err }
+
+func (s *uploadStreamSuite) TestUploadStreamNegativeReadError(c *chk.C) {
+	fileURL, _ := newTestMockFileURL("stream")
+	readErr := errors.New("read failed")
+	reader := io.MultiReader(strings.NewReader(strings.Repeat("a", 3000)), errReader{readErr})
+	err := UploadStreamToAzureFile(context.Background(), reader, fileURL, UploadToAzureFileOptions{RangeSize: 1024})
+	c.Assert(err, chk.Equals, readErr)
+}
+
+func (s *uploadStreamSuite) TestUploadStreamNegativeUploadError(c *chk.C) {
+	fileURL, mock := newTestMockFileURL("stream")
+	mock.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if r.URL.Query().Get("comp") == "range" && r.Header.Get("x-ms-range") == "bytes=2048-3071" {
+			mockError(w, http.StatusInternalServerError, ServiceCodeInternalError)
+			return true
+		}
+		return false
+	}
+	_, data := getRandomDataAndReader(64 * 1024)
+	err := UploadStreamToAzureFile(context.Background(), bytes.NewReader(data), fileURL, UploadToAzureFileOptions{RangeSize: 1024})
+	c.Assert(err, chk.NotNil)
+	c.Assert(err.(StorageError).ServiceCode(), chk.Equals, ServiceCodeInternalError)
+}
+
+func (s *uploadStreamSuite) TestUploadStreamNegativeInvalidRangeSize(c *chk.C) {
+	fileURL, mock := newTestMockFileURL("stream")
+	err := UploadStreamToAzureFile(context.Background(), strings.NewReader("a"), fileURL,
+		UploadToAzureFileOptions{RangeSize: FileMaxUploadRangeBytes + 1})
+	c.Assert(err, chk.NotNil)
+	c.Assert(mock.file("stream"), chk.IsNil)
+}
@@ -607,3 +607,90 @@ func (s *DirectoryURLSuite) TestDirListWithShareSAS(c *chk.C) {
 	c.Assert(err, chk.IsNil)
 	c.Assert(lResp.NextMarker.NotDone(), chk.Equals, false)
 }
+
+func (s *DirectoryURLSuite) TestDirListHandlesRecursive(c *chk.C) {
+	fsu := getFSU()
+	shareURL, _ := createNewShare(c, fsu)
+	defer delShare(c, shareURL, azfile.DeleteSnapshotsOptionNone)
+	dirURL, _ := createNewDirectoryFromShare(c, shareURL)
+	createNewFileFromDirectory(c, dirURL, 0)
+
+	resp, err := dirURL.ListHandlesSegment(ctx, azfile.Marker{}, azfile.ListHandlesOptions{Recursive: true})
+	c.Assert(err, chk.IsNil)
+	c.Assert(resp.StatusCode(), chk.Equals, 200)
+	c.Assert(resp.HandleList, chk.HasLen, 0)
+
+	handles, err := shareURL.NewRootDirectoryURL().ListAllHandles(ctx, azfile.ListHandlesOptions{Recursive: true, MaxResults: 1})
+	c.Assert(err, chk.IsNil)
+	c.Assert(handles, chk.HasLen, 0)
+}
+
+func (s *DirectoryURLSuite) TestDirForceCloseAllHandlesRecursive(c *chk.C) {
+	fsu := getFSU()
+	shareURL, _ := createNewShare(c, fsu)
+	defer delShare(c, shareURL, azfile.DeleteSnapshotsOptionNone)
+	dirURL, _ := createNewDirectoryFromShare(c, shareURL)
+	createNewFileFromDirectory(c, dirURL, 0)
+
+	closed, err := dirURL.ForceCloseAllHandles(ctx, azfile.ForceCloseHandlesOptions{Recursive: true})
+	c.Assert(err, chk.IsNil)
+	c.Assert(closed, chk.Equals, int32(0))
+
+	// Closing handles on a file that does not exist fails.
+	_, err = dirURL.NewFileURL("nonexistent").ForceCloseAllHandles(ctx, azfile.ForceCloseHandlesOptions{})
+	c.Assert(err, chk.NotNil)
+}
+
+func (s *DirectoryURLSuite) TestDirDeleteRecursive(c *chk.C) {
+	fsu := getFSU()
+	shareURL, _ := createNewShare(c, fsu)
+	defer delShare(c, shareURL, azfile.DeleteSnapshotsOptionNone)
+	dirURL, _ := createNewDirectoryFromShare(c, shareURL)
+	createNewFileFromDirectory(c, dirURL, 1024)
+	subDirURL, _ := createNewDirectoryFromDirectory(c, dirURL)
+	createNewFileFromDirectory(c, subDirURL, 0)
+	createNewFileFromDirectory(c, subDirURL, 512)
+	subSubDirURL, _ := createNewDirectoryFromDirectory(c, subDirURL)
+	createNewFileFromDirectory(c, subSubDirURL, 0)
+	createNewDirectoryFromDirectory(c, dirURL)
+
+	_, err := dirURL.Delete(ctx)
+	validateStorageError(c, err, azfile.ServiceCodeDirectoryNotEmpty)
+
+	err = dirURL.DeleteRecursive(ctx, azfile.DeleteDirectoryRecursiveOptions{Parallelism: 2, ForceCloseHandles: true})
+	c.Assert(err, chk.IsNil)
+
+	_, err = dirURL.GetProperties(ctx)
+	validateStorageError(c, err, azfile.ServiceCodeResourceNotFound)
+}
+
+func (s *DirectoryURLSuite) TestDirDeleteRecursiveRootDirectory(c *chk.C) {
+	fsu := getFSU()
+	shareURL, _ := createNewShare(c, fsu)
+	defer delShare(c, shareURL, azfile.DeleteSnapshotsOptionNone)
+	rootDirURL := shareURL.NewRootDirectoryURL()
+	createNewFileFromDirectory(c, rootDirURL, 0)
+	dirURL, _ := createNewDirectoryFromShare(c, shareURL)
+	createNewFileFromDirectory(c, dirURL, 0)
+
+	err := rootDirURL.DeleteRecursive(ctx, azfile.DeleteDirectoryRecursiveOptions{})
+	c.Assert(err, chk.IsNil)
+
+	// The root directory is emptied, but still exists.
+	_, err = rootDirURL.GetProperties(ctx)
+	c.Assert(err, chk.IsNil)
+	lResp, err := rootDirURL.ListFilesAndDirectoriesSegment(ctx, azfile.Marker{}, azfile.ListFilesAndDirectoriesOptions{})
+	c.Assert(err, chk.IsNil)
+	c.Assert(lResp.FileItems, chk.HasLen, 0)
+	c.Assert(lResp.DirectoryItems, chk.HasLen, 0)
+}
+
+func (s *DirectoryURLSuite) TestDirDeleteRecursiveNegativeNonExistent(c *chk.C) {
+	fsu := getFSU()
+	shareURL, _ := createNewShare(c, fsu)
+	defer delShare(c, shareURL, azfile.DeleteSnapshotsOptionNone)
+	dirURL, _ := getDirectoryURLFromShare(c, shareURL)
+
+	err := dirURL.DeleteRecursive(ctx, azfile.DeleteDirectoryRecursiveOptions{})
+	validateStorageError(c, err, azfile.ServiceCodeResourceNotFound)
+}
@@ -1539,6 +1539,50 @@ func (s *FileURLSuite) TestUnexpectedEOFRecovery(c *chk.C) {
 	c.Assert(buf, chk.DeepEquals, contentD)
 }
 
+func (s *FileURLSuite) TestFileListHandlesNoHandles(c *chk.C) {
+	fsu := getFSU()
+	shareURL, _ := createNewShare(c, fsu)
+	defer delShare(c, shareURL, azfile.DeleteSnapshotsOptionNone)
+	fileURL, _ := createNewFileFromShare(c, shareURL, 0)
+
+	resp, err := fileURL.ListHandlesSegment(ctx, azfile.Marker{}, azfile.ListHandlesOptions{MaxResults: 10})
+	c.Assert(err, chk.IsNil)
+	c.Assert(resp.StatusCode(), chk.Equals, 200)
+	c.Assert(resp.HandleList, chk.HasLen, 0)
+	c.Assert(resp.NextMarker, chk.Equals, "")
+
+	handles, err := fileURL.ListAllHandles(ctx, azfile.ListHandlesOptions{})
+	c.Assert(err, chk.IsNil)
+	c.Assert(handles, chk.HasLen, 0)
+}
+
+func (s *FileURLSuite) TestFileForceCloseAllHandles(c *chk.C) {
+	fsu := getFSU()
+	shareURL, _ := createNewShare(c, fsu)
+	defer delShare(c, shareURL, azfile.DeleteSnapshotsOptionNone)
+	fileURL, _ := createNewFileFromShare(c, shareURL, 0)
+
+	resp, err := fileURL.ForceCloseHandles(ctx, azfile.HandleIDAll, azfile.Marker{}, azfile.ForceCloseHandlesOptions{})
+	c.Assert(err, chk.IsNil)
+	c.Assert(resp.StatusCode(), chk.Equals, 200)
+	c.Assert(resp.NumberOfHandlesClosed(), chk.Equals, int32(0))
+	c.Assert(resp.Marker(), chk.Equals, "")
+
+	closed, err := fileURL.ForceCloseAllHandles(ctx, azfile.ForceCloseHandlesOptions{})
+	c.Assert(err, chk.IsNil)
+	c.Assert(closed, chk.Equals, int32(0))
+}
+
+func (s *FileURLSuite) TestFileListHandlesNegativeNonExistent(c *chk.C) {
+	fsu := getFSU()
+	shareURL, _ := createNewShare(c, fsu)
+	defer delShare(c, shareURL, azfile.DeleteSnapshotsOptionNone)
+	fileURL, _ := getFileURLFromShare(c, shareURL)
+
+	_, err := fileURL.ListAllHandles(ctx, azfile.ListHandlesOptions{})
+	validateStorageError(c, err, azfile.ServiceCodeResourceNotFound)
+}
+
 // Don't check offset by design.
 // func (s *FileURLSuite) TestFileGetRangeListNegativeInvalidOffset(c *chk.C) {
 // 	fsu := getFSU()